@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vlan creates VLAN links and attaches them to Linux bridges. It is the core of the aos-vlan CNI plugin and can
+// be embedded by orchestrators which manage VLANs without the CNI command wiring.
+package vlan
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// MaxVlanId is the highest VLAN ID which can be assigned to a VLAN link.
+const MaxVlanId = 4094
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// Config is the VLAN configuration.
+type Config struct {
+	// VlanId is the VLAN ID, between 0 and MaxVlanId inclusive.
+	VlanId int `json:"vlanId"`
+	// IfName is the VLAN interface name.
+	IfName string `json:"ifName"`
+	// VlanProtocol is the VLAN protocol: "802.1q" (default) or "802.1ad".
+	VlanProtocol string `json:"vlanProtocol"`
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// ErrNotVlan is returned by ByName for a link of another type.
+var ErrNotVlan = errors.New("not a vlan")
+
+// ErrInvalidVlanId is wrapped by the errors of a VLAN ID which can't be assigned to a VLAN link.
+var ErrInvalidVlanId = errors.New("invalid VLAN ID")
+
+// linkSetMaster attaches the link to the bridge, variable for testing.
+var linkSetMaster = netlink.LinkSetMaster
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// ParseConfig parses the JSON VLAN configuration, validates the VLAN ID and normalizes the VLAN protocol. Unknown
+// fields are ignored, so the configuration may be a part of a larger one, e.g. the aos-vlan network configuration. The
+// VLAN interface name is not required, as the caller may derive it from other fields.
+func ParseConfig(data []byte) (*Config, error) {
+	conf := &Config{}
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+
+	if err := ValidateVlanId(conf.VlanId); err != nil {
+		return nil, err
+	}
+
+	protocol, err := NormalizeVlanProtocol(conf.VlanProtocol)
+	if err != nil {
+		return nil, err
+	}
+
+	conf.VlanProtocol = protocol
+
+	return conf, nil
+}
+
+// ValidateVlanId fails with an ErrInvalidVlanId error if the VLAN ID can't be assigned to a VLAN link.
+func ValidateVlanId(vlanId int) error {
+	if vlanId < 0 || vlanId > MaxVlanId {
+		return fmt.Errorf("%w %d (must be between 0 and %d inclusive)", ErrInvalidVlanId, vlanId, MaxVlanId)
+	}
+
+	return nil
+}
+
+// NormalizeVlanProtocol returns the lowercase VLAN protocol name, 802.1q if not set. Unknown protocols are an error.
+func NormalizeVlanProtocol(protocol string) (string, error) {
+	if protocol == "" {
+		return netlink.VLAN_PROTOCOL_8021Q.String(), nil
+	}
+
+	protocol = strings.ToLower(protocol)
+
+	if netlink.StringToVlanProtocol(protocol) == netlink.VLAN_PROTOCOL_UNKNOWN {
+		return "", fmt.Errorf("invalid VLAN protocol %q (must be \"802.1q\" or \"802.1ad\")", protocol)
+	}
+
+	return protocol, nil
+}
+
+// NewVlan returns the VLAN link of the configuration on the parent link, not added yet.
+func NewVlan(conf *Config, parentIndex int) *netlink.Vlan {
+	return &netlink.Vlan{
+		LinkAttrs: netlink.LinkAttrs{
+			Name:        conf.IfName,
+			ParentIndex: parentIndex,
+		},
+		VlanId:       conf.VlanId,
+		VlanProtocol: netlink.StringToVlanProtocol(conf.VlanProtocol),
+	}
+}
+
+// CreateVlan adds the VLAN link of the configuration on the parent link. If a link with the VLAN name already exists,
+// the error wraps syscall.EEXIST and the VLAN link is returned as well, so the caller can reconcile the existing link
+// with it.
+func CreateVlan(conf *Config, parentIndex int) (*netlink.Vlan, error) {
+	vlan := NewVlan(conf, parentIndex)
+
+	if err := netlink.LinkAdd(vlan); err != nil {
+		if errors.Is(err, syscall.EEXIST) {
+			return vlan, fmt.Errorf("failed to create vlan: %w", err)
+		}
+
+		return nil, fmt.Errorf("failed to create vlan: %w", err)
+	}
+
+	return vlan, nil
+}
+
+// AttachToBridge attaches the link to the bridge and returns the link with the updated attributes. Some kernels
+// silently ignore the enslave, e.g. with VLAN filtering, so it fails if the link is not attached afterwards.
+func AttachToBridge(link, bridge netlink.Link) (netlink.Link, error) {
+	if err := linkSetMaster(link, bridge); err != nil {
+		return nil, fmt.Errorf("failed to connect %q to bridge %s: %w", link.Attrs().Name, bridge.Attrs().Name, err)
+	}
+
+	attached, err := netlink.LinkByIndex(link.Attrs().Index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup %q: %v", link.Attrs().Name, err)
+	}
+
+	if attached.Attrs().MasterIndex != bridge.Attrs().Index {
+		return nil, fmt.Errorf("%q is not attached to bridge %s after connecting (master index %d, bridge index %d)",
+			link.Attrs().Name, bridge.Attrs().Name, attached.Attrs().MasterIndex, bridge.Attrs().Index)
+	}
+
+	return attached, nil
+}
+
+// ByName returns the VLAN link with the name. A link of another type is an ErrNotVlan error, a missing link is a
+// netlink.LinkNotFoundError.
+func ByName(name string) (*netlink.Vlan, error) {
+	l, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not lookup %q: %w", name, err)
+	}
+
+	vlan, ok := l.(*netlink.Vlan)
+	if !ok {
+		return nil, fmt.Errorf("%q already exists but is %w", name, ErrNotVlan)
+	}
+
+	return vlan, nil
+}
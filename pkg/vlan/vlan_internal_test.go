@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vlan
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Vlan", func() {
+	var testNS ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+
+		testNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(testNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(testNS)).To(Succeed())
+	})
+
+	It("vlan creates VLAN link", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}, PeerName: "eth0-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			parent, err := netlink.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+
+			conf := &Config{VlanId: 100, IfName: "vlan100", VlanProtocol: "802.1q"}
+
+			_, err = CreateVlan(conf, parent.Attrs().Index)
+			if errors.Is(err, syscall.EOPNOTSUPP) {
+				Skip("VLAN links are not available")
+			}
+
+			Expect(err).NotTo(HaveOccurred())
+
+			vlan, err := ByName("vlan100")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vlan.VlanId).To(Equal(100))
+			Expect(vlan.ParentIndex).To(Equal(parent.Attrs().Index))
+
+			// The existing link is reported with the VLAN link to reconcile it with
+			vlan, err = CreateVlan(conf, parent.Attrs().Index)
+			Expect(errors.Is(err, syscall.EEXIST)).To(BeTrue())
+			Expect(vlan).To(Equal(NewVlan(conf, parent.Attrs().Index)))
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("vlan verifies bridge attachment", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0"}}
+			Expect(netlink.LinkAdd(br)).To(Succeed())
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "vlan100"}, PeerName: "vlan100-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			link, err := netlink.LinkByName("vlan100")
+			Expect(err).NotTo(HaveOccurred())
+
+			// Enslave reported as successful but not applied
+			linkSetMaster = func(netlink.Link, netlink.Link) error { return nil }
+
+			_, err = AttachToBridge(link, br)
+
+			linkSetMaster = netlink.LinkSetMaster
+
+			Expect(err).To(MatchError(ContainSubstring(`"vlan100" is not attached to bridge br0`)))
+
+			attached, err := AttachToBridge(link, br)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(attached.Attrs().MasterIndex).To(Equal(br.Attrs().Index))
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("vlan looks up VLAN links only", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "vlan100"}, PeerName: "vlan100-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = ByName("vlan100")
+			Expect(err).To(MatchError(`"vlan100" already exists but is not a vlan`))
+			Expect(errors.Is(err, ErrNotVlan)).To(BeTrue())
+
+			_, err = ByName("vlan200")
+			Expect(err).To(MatchError(ContainSubstring(`could not lookup "vlan200"`)))
+			Expect(errors.As(err, new(netlink.LinkNotFoundError))).To(BeTrue())
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("Vlan config", func() {
+	It("vlan parses config", func() {
+		conf, err := ParseConfig([]byte(`{"type": "aos-vlan", "master": "br0", "vlanId": 100, "ifName": "vlan100",
+			"vlanProtocol": "802.1AD"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf).To(Equal(&Config{VlanId: 100, IfName: "vlan100", VlanProtocol: "802.1ad"}))
+
+		conf, err = ParseConfig([]byte(`{"ifName": "vlan0"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.VlanProtocol).To(Equal("802.1q"))
+	})
+
+	It("vlan validates config", func() {
+		for data, expected := range map[string]string{
+			`{"vlanId": 4095, "ifName": "vlan100"}`:       "invalid VLAN ID 4095",
+			`{"vlanId": -1, "ifName": "vlan100"}`:         "invalid VLAN ID -1",
+			`{"ifName": "vlan100", "vlanProtocol": "qq"}`: `invalid VLAN protocol "qq"`,
+			`{"vlanId": "100"}`:                           "failed to parse network configuration",
+		} {
+			_, err := ParseConfig([]byte(data))
+			Expect(err).To(MatchError(ContainSubstring(expected)), data)
+		}
+	})
+
+	It("vlan validates VLAN ID", func() {
+		Expect(ValidateVlanId(0)).To(Succeed())
+		Expect(ValidateVlanId(MaxVlanId)).To(Succeed())
+
+		for _, vlanId := range []int{-1, 4095} {
+			err := ValidateVlanId(vlanId)
+			Expect(err).To(MatchError(fmt.Sprintf("invalid VLAN ID %d (must be between 0 and 4094 inclusive)", vlanId)))
+			Expect(errors.Is(err, ErrInvalidVlanId)).To(BeTrue())
+		}
+	})
+
+	It("vlan normalizes VLAN protocol", func() {
+		for protocol, expected := range map[string]string{"": "802.1q", "802.1AD": "802.1ad", "802.1q": "802.1q"} {
+			normalized, err := NormalizeVlanProtocol(protocol)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(normalized).To(Equal(expected))
+		}
+
+		_, err := NormalizeVlanProtocol("qq")
+		Expect(err).To(MatchError(`invalid VLAN protocol "qq" (must be "802.1q" or "802.1ad")`))
+	})
+})
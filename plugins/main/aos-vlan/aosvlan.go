@@ -19,19 +19,92 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
+	"unicode"
 
+	vlanlib "github.com/aoscloud/aos_cni_vlan/pkg/vlan"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
+	"github.com/containernetworking/plugins/pkg/ns"
 	bv "github.com/containernetworking/plugins/pkg/utils/buildversion"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
 )
 
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// maxIfNameLen is the maximum interface name length accepted by the kernel (IFNAMSIZ - 1).
+const maxIfNameLen = 15
+
+// cniVersionGC is the CNI spec version introducing the GC and STATUS commands. Its result format is the 1.0.0 one.
+const cniVersionGC = "1.1.0"
+
+// Plugin specific error codes, codes from 100 are reserved by the CNI spec for plugins.
+const (
+	// errMasterMissing reports the master bridge is not configured or doesn't exist.
+	errMasterMissing uint = 101
+	// errMasterNotBridge reports the master is not a bridge.
+	errMasterNotBridge uint = 102
+	// errVlanIdOutOfRange reports a VLAN ID outside of the valid range.
+	errVlanIdOutOfRange uint = 103
+	// errMasterDown reports the master bridge is administratively down.
+	errMasterDown uint = 104
+	// errParentDown reports the VLAN parent link is administratively down.
+	errParentDown uint = 105
+)
+
+// Policies applied when a link with the VLAN name already exists.
+const (
+	// nameCollisionAdopt adopts an existing VLAN with the same parent and VLAN ID.
+	nameCollisionAdopt = "adopt"
+	// nameCollisionError fails on any existing link with the VLAN name.
+	nameCollisionError = "error"
+)
+
+// Policies applied when prevResult already has an interface with the VLAN interface name.
+const (
+	// prevResultConflictReplace replaces the prevResult interface with the VLAN interface.
+	prevResultConflictReplace = "replace"
+	// prevResultConflictError fails on the prevResult interface.
+	prevResultConflictError = "error"
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// nameTemplatePlaceholder matches the placeholders of the name template.
+var nameTemplatePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// attachToBridge attaches the link to the bridge, variable for testing.
+var attachToBridge = vlanlib.AttachToBridge
+
+// extraCommands are the CNI commands not dispatched by the skel package of the supported CNI version.
+var extraCommands = map[string]func(args *skel.CmdArgs) error{
+	cniCommandGC:     cmdGC,
+	cniCommandStatus: cmdStatus,
+}
+
+// supportedVersions are the CNI versions supported by the plugin. 1.1.0 isn't known to the vendored CNI library but
+// must be advertised, otherwise runtimes never send GC and STATUS.
+var supportedVersions = version.PluginSupports(append(version.All.SupportedVersions(), cniVersionGC)...)
+
 /***********************************************************************************************************************
  * Types
  **********************************************************************************************************************/
@@ -41,6 +114,247 @@ type pluginConf struct {
 	VlanId int    `json:"vlanId"`
 	Master string `json:"master"`
 	IfName string `json:"ifName"`
+	// RouteScanFamily forces the address family used to scan routes for the master interface: "v4", "v6" or "all".
+	// If not set, IPv4 routes are preferred over IPv6 ones.
+	RouteScanFamily string `json:"routeScanFamily"`
+	// MasterFamily is the address family of the default route the master interface is resolved by: "ipv4", "ipv6"
+	// or "any" (default) preferring IPv4. Can't be combined with routeScanFamily.
+	MasterFamily string `json:"masterFamily"`
+	// MacFile is a path the MAC address of the created VLAN is written to.
+	MacFile string `json:"macFile"`
+	// PushGatewayURL is the Prometheus Pushgateway the command metrics are pushed to.
+	PushGatewayURL string `json:"pushGatewayURL"`
+	// IPv6TrafficClass is the traffic class set on egress IPv6 packets of the VLAN.
+	IPv6TrafficClass *int `json:"ipv6TrafficClass"`
+	// RecreateOnIdChange recreates an existing VLAN link with the same name but a different VLAN ID instead of
+	// failing.
+	RecreateOnIdChange bool `json:"recreateOnIdChange"`
+	// EventFile is a path JSON events describing each command outcome are appended to.
+	EventFile string `json:"eventFile"`
+	// ResultPipe is a named pipe the ADD result is written to in addition to stdout, for streaming consumers. A pipe
+	// without a reader is skipped after a timeout.
+	ResultPipe string `json:"resultPipe"`
+	// ParentType selects the parent link as the single up link of this type ("device", "bond" or "bridge") instead
+	// of the default route interface.
+	ParentType string `json:"parentType"`
+	// Parent names the parent link of the VLAN instead of the default route interface. It is distinct from master,
+	// which is the bridge the VLAN is attached to.
+	Parent string `json:"parent"`
+	// IPAMTimeout bounds a single IPAM delegate invocation.
+	IPAMTimeout duration `json:"ipamTimeout"`
+	// IPAMRetries is the number of times a timed out or "try again later" IPAM delegate invocation is retried.
+	IPAMRetries int `json:"ipamRetries"`
+	// Syslog reports ADD and DEL outcomes to the local syslog.
+	Syslog bool `json:"syslog"`
+	// Journal reports ADD and DEL outcomes to the systemd journal with structured fields, or to stderr if the journal
+	// isn't running.
+	Journal bool `json:"journal"`
+	// LogLevel enables the command log at the level: "debug", "info" (default if logFile is set), "warn" or "error".
+	// The debug level logs the parsed configuration, the parent resolution and the netlink operations.
+	LogLevel string `json:"logLevel"`
+	// LogFile is a path the command log is appended to, stderr by default.
+	LogFile string `json:"logFile"`
+	// NormalizeName replaces characters the kernel rejects in ifName and truncates it to the maximum length instead
+	// of failing.
+	NormalizeName bool `json:"normalizeName"`
+	// ProbeMtu probes the path MTU towards the IPv4 gateway from prevResult and clamps the VLAN MTU to it.
+	ProbeMtu bool `json:"probeMtu"`
+	// NoUp leaves the created VLAN administratively down.
+	NoUp bool `json:"noUp"`
+	// Group is the netdev group the VLAN joins.
+	Group int `json:"group"`
+	// GroupPolicyFile is a path the group and VLAN ID of each VLAN are recorded to for external policy appliers.
+	GroupPolicyFile string `json:"groupPolicyFile"`
+	// InContainer moves the VLAN into the container network namespace instead of attaching it to the master bridge.
+	InContainer bool `json:"inContainer"`
+	// ContainerNS is an alias of inContainer.
+	ContainerNS bool `json:"containerNS"`
+	// RenameOnConflict picks a free interface name in the container namespace if the requested one is taken.
+	RenameOnConflict bool `json:"renameOnConflict"`
+	// IPAMV4 and IPAMV6 are IPAM blocks of separate delegates for IPv4 and IPv6 in dual-stack setups.
+	IPAMV4 json.RawMessage `json:"ipamV4"`
+	IPAMV6 json.RawMessage `json:"ipamV6"`
+	// IPAddresses are static CIDR addresses configured on the VLAN instead of running an IPAM plugin, e.g.
+	// "192.168.1.10/24".
+	IPAddresses []string `json:"ipAddresses"`
+	// Routes are static routes via the VLAN. A gateway must be on one of the ipAddresses subnets.
+	Routes []*types.Route `json:"routes"`
+	// DeleteOnDel deletes the VLAN on DEL. It is the default now and is kept for compatibility.
+	DeleteOnDel bool `json:"deleteOnDel"`
+	// KeepOnDel keeps the VLAN on DEL for setups that remove it manually.
+	KeepOnDel bool `json:"keepOnDel"`
+	// DelBusyRetries is the number of times deleting a busy VLAN is retried, 3 by default.
+	DelBusyRetries *int `json:"delBusyRetries"`
+	// DelBusyInterval is the interval between retries of deleting a busy VLAN, 100ms by default.
+	DelBusyInterval duration `json:"delBusyInterval"`
+	// NetlinkRetries is the number of times a netlink operation creating, configuring or attaching the VLAN is retried
+	// on transient errors such as EBUSY, 3 by default.
+	NetlinkRetries *int `json:"netlinkRetries"`
+	// NetlinkRetryInterval is the interval before the first netlink retry, doubled after each retry, 50ms by default.
+	NetlinkRetryInterval duration `json:"netlinkRetryInterval"`
+	// WaitMasterCarrier is the time to wait for the master bridge carrier before attaching the VLAN, e.g. "5s". ADD
+	// fails with a try again later error if the carrier is still missing.
+	WaitMasterCarrier duration `json:"waitMasterCarrier"`
+	// BringUpMaster brings the VLAN parent link up if it is administratively down. By default ADD fails fast on a down
+	// parent.
+	BringUpMaster bool `json:"bringUpMaster"`
+	// BridgeType is the master bridge type: "linux" (default) or "ovs" for an Open vSwitch bridge the VLAN is added to
+	// with ovs-vsctl as a port tagged with the VLAN ID.
+	BridgeType string `json:"bridgeType"`
+	// VerifyDelete checks the deleted VLAN is actually gone and deletes it once more if it lingers.
+	VerifyDelete bool `json:"verifyDelete"`
+	// StateDir is a directory the VLAN attachment of each container is recorded in.
+	StateDir string `json:"stateDir"`
+	// ReportIfIndex reports in the event and to stderr whether the VLAN ifindex matches the one recorded in stateDir
+	// by the previous ADD, e.g. after the VLAN was recreated on reboot. Requires stateDir.
+	ReportIfIndex bool `json:"reportIfIndex"`
+	// FallbackIfName is used as VLAN name when ifName is taken by a link which is not a VLAN.
+	FallbackIfName string `json:"fallbackIfName"`
+	// Shared attaches an existing VLAN managed by another tool to the master bridge without creating it. DEL only
+	// detaches it from the bridge.
+	Shared bool `json:"shared"`
+	// MasterScanNetns is the network namespace path used for the master route scan and link lookups.
+	MasterScanNetns string `json:"masterScanNetns"`
+	// GloballyUniqueVlanId rejects a vlanId already used by a VLAN link on any parent.
+	GloballyUniqueVlanId bool `json:"globallyUniqueVlanId"`
+	// BpfProgram is the path of a pinned SCHED_CLS bpf program attached to the VLAN ingress clsact hook.
+	BpfProgram string `json:"bpfProgram"`
+	// Vlans configures several VLANs attached to the master bridge by a single invocation instead of vlanId and
+	// ifName.
+	Vlans []vlanEntry `json:"vlans"`
+	// MultiVlanPolicy is the policy applied when some of the vlans can't be added: "atomic" (default) or
+	// "bestEffort".
+	MultiVlanPolicy string `json:"multiVlanPolicy"`
+	// BatchCreate adds the vlans links with pipelined netlink requests over a single socket instead of one request
+	// at a time.
+	BatchCreate bool `json:"batchCreate"`
+	// LooseBinding sets the VLAN loose binding flag, so the VLAN operational state doesn't follow the parent.
+	LooseBinding bool `json:"looseBinding"`
+	// ReorderHeaders sets or clears the VLAN reorder header flag, which the kernel sets by default.
+	ReorderHeaders *bool `json:"reorderHeaders"`
+	// Gvrp sets the VLAN GVRP flag, so the VLAN is registered with the switch.
+	Gvrp bool `json:"gvrp"`
+	// LogicalName is reported as the VLAN interface name in the result. It is purely a reporting alias: the kernel
+	// interface is still named ifName.
+	LogicalName string `json:"logicalName"`
+	// UpAfterMove brings the VLAN up only in the container namespace in inContainer mode, true by default. If false,
+	// the VLAN is brought up in the host namespace as well before the move.
+	UpAfterMove *bool `json:"upAfterMove"`
+	// DscpToPcp maps DSCP values of egress IP packets to the VLAN PCP.
+	DscpToPcp map[int]int `json:"dscpToPcp"`
+	// RpsCpus are the CPUs the VLAN receive queues steer packets to: a "0x" prefixed hex mask, e.g. "0xf", or a CPU
+	// list, e.g. "0-3,6".
+	RpsCpus string `json:"rpsCpus"`
+	// MirrorTo is a capture link the VLAN ingress and egress packets are mirrored to with tc filters.
+	MirrorTo string `json:"mirrorTo"`
+	// IngressQos maps the PCP of ingress frames to the skb priority.
+	IngressQos []qosMapping `json:"ingressQos"`
+	// EgressQos maps the skb priority of egress packets to the PCP.
+	EgressQos []qosMapping `json:"egressQos"`
+	// Bandwidth shapes the VLAN traffic with tbf qdiscs, the ingress traffic through an ifb device.
+	Bandwidth *bandwidthConf `json:"bandwidth"`
+	// Sysctls are set on the VLAN interface once it is up, e.g. "net.ipv6.conf.<ifName>.autoconf": "0". Only the
+	// net.ipv4.conf and net.ipv6.conf sysctls of the VLAN interface are accepted.
+	Sysctls map[string]string `json:"sysctls"`
+	// ReportOffload reports the VLAN tag offload features of the parent in the event and to stderr.
+	ReportOffload bool `json:"reportOffload"`
+	// NameCollisionPolicy is the policy applied when a VLAN with the same parent and VLAN ID already exists at ifName:
+	// "adopt" (default) or "error". A foreign link at ifName is always an error.
+	NameCollisionPolicy string `json:"nameCollisionPolicy"`
+	// RefuseDuringShutdown fails ADD with the "try again later" CNI error while the host is shutting down.
+	RefuseDuringShutdown bool `json:"refuseDuringShutdown"`
+	// RequireModule fails ADD before any change if the 8021q kernel module is not loaded, instead of relying on the
+	// module being autoloaded on the VLAN creation.
+	RequireModule bool `json:"requireModule"`
+	// UniqueBridgeMac fails the VLAN attachment if another port of the master bridge has the VLAN MAC. It is opt-in,
+	// as VLANs inherit the parent MAC and VLANs of the same parent share it.
+	UniqueBridgeMac bool `json:"uniqueBridgeMac"`
+	// PrevResultConflictPolicy is the policy applied when prevResult already has an interface with the VLAN interface
+	// name in the same sandbox: "replace" or "error". By default the VLAN interface is appended as another entry.
+	PrevResultConflictPolicy string `json:"prevResultConflictPolicy"`
+	// ReportTopology adds the VLAN parent and, unless in inContainer mode, the master bridge to the result interfaces
+	// after the VLAN interface. By default the VLAN interface is the only interface the plugin adds.
+	ReportTopology bool `json:"reportTopology"`
+	// EgressSrcMac is the source MAC set by an nftables bridge rule on frames sent out through the VLAN port.
+	EgressSrcMac string `json:"egressSrcMac"`
+	// MssClamp lowers the MSS of TCP SYN packets sent out through the VLAN port with an nftables bridge rule: a fixed
+	// MSS or "pmtu" for the VLAN MTU less the IPv4 or IPv6 and TCP headers.
+	MssClamp *mssClamp `json:"mssClamp"`
+	// CheckGateway pings the gateway through the VLAN on CHECK.
+	CheckGateway bool `json:"checkGateway"`
+	// Gateway is the IPv4 gateway checked with checkGateway, the prevResult gateway by default.
+	Gateway string `json:"gateway"`
+	// IncludeHostMetadata adds the node name and the kernel version to the events. The CNI result is not affected.
+	IncludeHostMetadata bool `json:"includeHostMetadata"`
+	// ResultChecksum adds the SHA-256 of the ADD result printed to stdout to the ADD event, so event consumers can
+	// detect a truncated or corrupted result. The printed result is not affected.
+	ResultChecksum bool `json:"resultChecksum"`
+	// McastRouter is the multicast router role of the VLAN bridge port: 0 disabled, 1 learned from queries (kernel
+	// default) or 2 permanent.
+	McastRouter *int `json:"mcastRouter"`
+	// DelAuditFile is a path a JSON entry is appended to on each DEL, including the ones leaving the VLAN untouched.
+	DelAuditFile string `json:"delAuditFile"`
+	// MasterSubnet selects the parent link as the single link holding an address in this CIDR instead of the default
+	// route link.
+	MasterSubnet string `json:"masterSubnet"`
+	// ReconcileMtu lowers the VLAN MTU to the parent MTU on CHECK instead of failing. CHECK normally doesn't change
+	// anything, so this repair is opt-in.
+	ReconcileMtu bool `json:"reconcileMtu"`
+	// VlanProtocol is the VLAN tag protocol: "802.1q" (default) or "802.1ad" for QinQ service tags.
+	VlanProtocol string `json:"vlanProtocol"`
+	// AutoName derives the VLAN name Linux-style as <parent>.<vlanId>, e.g. eth0.100, if ifName is not set.
+	AutoName bool `json:"autoName"`
+	// NameTemplate derives the VLAN name if ifName is not set by expanding the {vlanId} and {master} placeholders, e.g.
+	// "{master}.{vlanId}".
+	NameTemplate string `json:"nameTemplate"`
+	// Mtu is the VLAN MTU, it must not exceed the parent MTU. The parent MTU is inherited if not set.
+	Mtu int `json:"mtu"`
+	// Mac is the static unicast MAC address of the VLAN, the kernel inherits the parent MAC if not set.
+	Mac string `json:"mac"`
+	// NetlinkExtAck enables netlink extended acks, so the errors include the kernel error message, e.g. the reason of
+	// EINVAL.
+	NetlinkExtAck bool `json:"netlinkExtAck"`
+	// VlanFilterPolicy adds the VLAN ID as the untagged PVID entry of the bridge port for VLAN aware bridges and is
+	// the policy applied if the port already has an entry for the VLAN ID: "strict" fails, "merge" updates the existing
+	// entry. Only the entries added by the plugin are removed on DEL.
+	VlanFilterPolicy string `json:"vlanFilterPolicy"`
+	// BridgeVlan are the VLAN filter entries added to the bridge port if the master bridge has VLAN filtering enabled.
+	// They are removed on DEL.
+	BridgeVlan *bridgeVlanConf `json:"bridgeVlan"`
+	// MasterStrategyOrder lists the parent link resolution strategies tried in order until one yields a usable parent:
+	// "parent" (parentType), "defaultRoute" and "subnet" (masterSubnet). parentType and masterSubnet may be combined
+	// only with this list.
+	MasterStrategyOrder []string `json:"masterStrategyOrder"`
+
+	// normalizedIfName is the configured ifName replaced with normalizeName, logged once the logger is open.
+	normalizedIfName string
+}
+
+// duration is a time.Duration unmarshaled from a duration string such as "500ms" or "10s".
+type duration struct {
+	time.Duration
+}
+
+/***********************************************************************************************************************
+ * Interface
+ **********************************************************************************************************************/
+
+func (d *duration) UnmarshalJSON(data []byte) (err error) {
+	var value string
+
+	if err = json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("invalid duration %s: %v", data, err)
+	}
+
+	if d.Duration, err = time.ParseDuration(value); err != nil {
+		return fmt.Errorf("invalid duration %q: %v", value, err)
+	}
+
+	if d.Duration < 0 {
+		return fmt.Errorf("invalid duration %q: must not be negative", value)
+	}
+
+	return nil
 }
 
 /***********************************************************************************************************************
@@ -59,175 +373,1917 @@ func init() {
  **********************************************************************************************************************/
 
 func main() {
-	skel.PluginMain(cmdAdd, cmdCheck, cmdDel, version.All, bv.BuildString("aos-vlan"))
+	if cmd, ok := extraCommands[os.Getenv("CNI_COMMAND")]; ok {
+		runExtraCommand(cmd)
+		return
+	}
+
+	skel.PluginMain(cmdAdd, cmdCheck, cmdDel, supportedVersions, bv.BuildString("aos-vlan"))
 }
 
 /***********************************************************************************************************************
  * Private
  **********************************************************************************************************************/
 
-func cmdAdd(args *skel.CmdArgs) error {
+// runExtraCommand runs the command with the network configuration from stdin and reports the error as skel does.
+func runExtraCommand(cmd func(args *skel.CmdArgs) error) {
+	stdinData, err := io.ReadAll(os.Stdin)
+	if err == nil {
+		err = cmd(&skel.CmdArgs{Path: os.Getenv("CNI_PATH"), StdinData: stdinData})
+	}
+
+	if err == nil {
+		return
+	}
+
+	var cniErr *types.Error
+
+	if !errors.As(err, &cniErr) {
+		cniErr = types.NewError(types.ErrInternal, err.Error(), "")
+	}
+
+	if err := cniErr.Print(); err != nil {
+		fmt.Fprintf(os.Stderr, "aos-vlan: failed to write error: %v\n", err)
+	}
+
+	os.Exit(1)
+}
+
+func cmdAdd(args *skel.CmdArgs) (err error) {
 	conf, result, err := parseConfig(args.StdinData)
 	if err != nil {
 		return err
 	}
 
-	vlan, vlanInterface, err := createVlan(conf)
+	openLogger(conf)
+	defer closeLogger()
+
+	logParsedConfig("ADD", args.ContainerID, conf)
+
+	defer func() { logCommandResult("ADD", err) }()
+
+	if err := checkNetlinkAccess(); err != nil {
+		return err
+	}
+
+	setNetlinkExtAck(conf)
+
+	if err := applyAutoName(conf); err != nil {
+		return err
+	}
+
+	configIfName := conf.IfName
+
+	applyFallbackIfName(conf)
+
+	event := newPluginEvent("ADD", args, conf)
+
+	defer func(start time.Time) {
+		_ = pushMetrics(conf, "ADD", start, err)
+		_ = emitEvent(conf, event, err)
+		_ = sendSyslog(conf, event, err)
+		_ = sendJournal(conf, event, err)
+	}(time.Now())
+
+	if conf.RefuseDuringShutdown {
+		if err := checkHostShutdown(); err != nil {
+			return err
+		}
+	}
+
+	if conf.RequireModule {
+		if err := checkVlanModule(); err != nil {
+			return err
+		}
+	}
+
+	if err := checkMaxTotalVlans(conf); err != nil {
+		return err
+	}
+
+	if len(conf.Vlans) != 0 {
+		if err := addVlans(conf, args.ContainerID, args.IfName, &result); err != nil {
+			return err
+		}
+
+		streamResult(conf, &result)
+
+		return printResult(conf, event, &result)
+	}
+
+	var (
+		vlan          *netlink.Vlan
+		vlanInterface *current.Interface
+		created       bool
+		linkName      string
+		linkNetns     string
+	)
+
+	if conf.Shared {
+		vlan, vlanInterface, err = adoptSharedVlan(conf)
+	} else {
+		vlan, vlanInterface, created, err = createVlan(conf)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	linkName = vlan.Attrs().Name
+
+	// The VLAN created by this ADD is deleted on any later failure, so a retry does not find it orphaned
+	defer func() {
+		if err != nil && created {
+			err = rollbackVlan(conf, args.ContainerID, linkNetns, linkName, err)
+		}
+	}()
+
+	if conf.ReportOffload {
+		event.VlanOffload = reportVlanOffload(vlan)
+	}
+
+	event.VlanFlags = reportVlanFlags(conf, vlan)
+
+	if conf.InContainer {
+		if vlanInterface, err = moveVlanToContainer(conf, vlan, args.Netns, args.IfName); err != nil {
+			return err
+		}
+
+		linkNetns = args.Netns
+	} else {
+		if err := addVlanToBridge(conf, vlan); err != nil {
+			return err
+		}
+
+		// The port state is diagnostic only and should not fail the command
+		if conf.EventFile != "" {
+			event.BridgePortState, _ = bridgePortState(vlan)
+		}
+	}
+
+	linkName = vlanInterface.Name
+
+	if conf.LogicalName != "" {
+		vlanInterface.Name = conf.LogicalName
+	}
+
+	vlanIndex, err := addResultInterface(conf, &result, vlanInterface)
 	if err != nil {
 		return err
 	}
 
-	if err := addVlanToBridge(conf, vlan); err != nil {
+	// The topology interfaces follow the VLAN interface, so the VLAN index the result IPs reference is not affected
+	if conf.ReportTopology {
+		if err := addTopologyInterfaces(conf, &result, vlan.ParentIndex); err != nil {
+			return err
+		}
+	}
+
+	delegates, err := ipamDelegates(conf, args.StdinData)
+	if err != nil {
 		return err
 	}
 
-	result.Interfaces = append(result.Interfaces, vlanInterface)
+	if len(delegates) != 0 {
+		if err := addIPAMResult(conf, args, delegates, linkName, vlanIndex, &result); err != nil {
+			return err
+		}
+	}
 
-	return types.PrintResult(&result, conf.CNIVersion)
-}
+	if len(conf.IPAddresses) != 0 || len(conf.Routes) != 0 {
+		if err := addStaticResult(conf, args, linkName, vlanIndex, &result); err != nil {
+			return err
+		}
+	}
 
-// This plugin does not implement the delete logic because it should only exist when the master interface exists.
-// Therefore, it should be deleted by the user.
-func cmdDel(args *skel.CmdArgs) error {
-	return nil
+	// The probe is bound to the VLAN, so it runs once the addresses are configured
+	if conf.ProbeMtu {
+		if err := clampMtuToPath(linkNetns, linkName, &result); err != nil {
+			return fmt.Errorf("failed to probe path MTU: %v", err)
+		}
+	}
+
+	if conf.GroupPolicyFile != "" {
+		if err := recordGroupPolicy(conf.GroupPolicyFile, vlan.Attrs().Name,
+			groupPolicyEntry{Group: conf.Group, VlanId: conf.VlanId}); err != nil {
+			return fmt.Errorf("failed to record group policy: %v", err)
+		}
+	}
+
+	if conf.StateDir != "" {
+		state, err := newVlanState(conf, args.ContainerID, vlan, created)
+		if err != nil {
+			return err
+		}
+
+		state.AttachmentIfName = args.IfName
+
+		if conf.ReportIfIndex {
+			event.IfIndex = reportStateIfIndex(conf.StateDir, args.ContainerID, configIfName, vlan)
+		}
+
+		if configIfName != conf.IfName {
+			state.ConfigIfName = configIfName
+		}
+
+		if conf.InContainer {
+			state.ContainerIfName = linkName
+		}
+
+		if err := saveVlanState(conf.StateDir, state); err != nil {
+			return fmt.Errorf("failed to save state: %v", err)
+		}
+	}
+
+	if conf.MacFile != "" {
+		if err := writeFileAtomic(conf.MacFile, []byte(vlanInterface.Mac+"\n")); err != nil {
+			return fmt.Errorf("failed to write MAC file %s: %v", conf.MacFile, err)
+		}
+	}
+
+	streamResult(conf, &result)
+
+	return printResult(conf, event, &result)
 }
 
-func cmdCheck(args *skel.CmdArgs) error {
-	conf, _, err := parseConfig(args.StdinData)
+// cmdDel detaches the VLAN from the master bridge and deletes it unless keepOnDel is set. DEL is idempotent: an
+// already deleted VLAN is not an error. The IPAM allocations are always released.
+func cmdDel(args *skel.CmdArgs) (err error) {
+	conf, prevResult, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	openLogger(conf)
+	defer closeLogger()
+
+	logParsedConfig("DEL", args.ContainerID, conf)
+
+	defer func() { logCommandResult("DEL", err) }()
+
+	if err := checkNetlinkAccess(); err != nil {
+		return err
+	}
+
+	setNetlinkExtAck(conf)
+
+	// The VLAN is removed by the kernel together with its parent, so the remaining resources are still released
+	if err := applyAutoName(conf); err != nil {
+		logWarn("VLAN is assumed to be removed with its parent", "error", err)
+	}
+
+	// The fallback name may be resolved differently than on ADD if the foreign link is gone meanwhile
+	if !applyStateIfName(conf, args.ContainerID) {
+		applyFallbackIfName(conf)
+	}
+
+	event := newPluginEvent("DEL", args, conf)
+
+	defer func(start time.Time) {
+		_ = pushMetrics(conf, "DEL", start, err)
+		_ = emitEvent(conf, event, err)
+		_ = sendSyslog(conf, event, err)
+		_ = sendJournal(conf, event, err)
+	}(time.Now())
+
+	delegates, err := ipamDelegates(conf, args.StdinData)
 	if err != nil {
 		return err
 	}
 
-	vlan, err := vlanByName(conf.IfName)
+	steps := teardownSteps(conf, args, &prevResult, delegates)
+
+	err = runTeardown(steps)
+
+	_ = writeDelAudit(conf, newDelAuditEntry(conf, args, steps), err)
+
+	return err
+}
+
+func cmdCheck(args *skel.CmdArgs) (err error) {
+	conf, prevResult, err := parseConfig(args.StdinData)
 	if err != nil {
 		return err
 	}
 
-	if vlan.VlanId != conf.VlanId {
-		return fmt.Errorf("vlan link %s configured promisc is %d, current value is %d",
-			conf.IfName, conf.VlanId, vlan.VlanId)
+	openLogger(conf)
+	defer closeLogger()
+
+	logParsedConfig("CHECK", args.ContainerID, conf)
+
+	defer func() { logCommandResult("CHECK", err) }()
+
+	if err := checkNetlinkAccess(); err != nil {
+		return err
+	}
+
+	setNetlinkExtAck(conf)
+
+	if err := applyAutoName(conf); err != nil {
+		return err
+	}
+
+	configIfName := conf.IfName
+
+	applyFallbackIfName(conf)
+
+	event := newPluginEvent("CHECK", args, conf)
+
+	defer func(start time.Time) {
+		_ = pushMetrics(conf, "CHECK", start, err)
+		_ = emitEvent(conf, event, err)
+	}(time.Now())
+
+	for _, entryConf := range vlanConfs(conf) {
+		if err := checkVlan(entryConf, args); err != nil {
+			return err
+		}
+	}
+
+	if conf.ReportIfIndex {
+		vlan, err := netlink.LinkByName(conf.IfName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", conf.IfName, err)
+		}
+
+		event.IfIndex = reportStateIfIndex(conf.StateDir, args.ContainerID, configIfName, vlan)
 	}
 
-	if vlan.Flags&net.FlagUp != net.FlagUp {
-		return fmt.Errorf("vlan link %s is down", conf.IfName)
+	// Gateway reachability requires connectivity, so it is checked only on request
+	if conf.CheckGateway {
+		if err := checkGatewayReachable(conf, args, &prevResult); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func addVlanToBridge(conf *pluginConf, vlan *netlink.Vlan) error {
-	br, err := netlink.LinkByName(conf.Master)
+// checkVlan checks the VLAN ID and protocol, the intended link state, the MTU, the master bridge and the configured
+// flags of the VLAN. In inContainer mode the VLAN is checked in the container namespace under the name recorded on ADD,
+// while its parent is looked up in the host namespace.
+func checkVlan(conf *pluginConf, args *skel.CmdArgs) error {
+	if !conf.InContainer {
+		return checkVlanLink(conf, conf.IfName, netlink.LinkByIndex)
+	}
+
+	hostNS, err := ns.GetCurrentNS()
 	if err != nil {
-		return fmt.Errorf("failed to lookup %q: %v", conf.Master, err)
+		return fmt.Errorf("failed to open host netns: %v", err)
 	}
+	defer hostNS.Close()
 
-	// connect host vlan to the bridge
-	if err := netlink.LinkSetMaster(vlan, br); err != nil {
-		return fmt.Errorf("failed to connect %q to bridge %s: %v", vlan.Attrs().Name, br.Attrs().Name, err)
+	parentByIndex := func(index int) (parent netlink.Link, err error) {
+		err = hostNS.Do(func(ns.NetNS) error {
+			parent, err = netlink.LinkByIndex(index)
+			return err
+		})
+
+		return parent, err
 	}
 
-	return nil
+	ifName := stateContainerIfName(conf, args.ContainerID, args.IfName)
+
+	return ns.WithNetNSPath(args.Netns, func(ns.NetNS) error {
+		return checkVlanLink(conf, ifName, parentByIndex)
+	})
 }
 
-func createVlan(conf *pluginConf) (*netlink.Vlan, *current.Interface, error) {
-	mIndex, err := getMasterInterfaceIndex()
+// checkVlanLink checks the VLAN named ifName in the current namespace, parentByIndex looks up its parent.
+func checkVlanLink(conf *pluginConf, ifName string, parentByIndex func(index int) (netlink.Link, error)) error {
+	vlan, err := vlanlib.ByName(ifName)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to lookup master index %v", err)
+		return err
 	}
 
-	vlan := &netlink.Vlan{
-		LinkAttrs: netlink.LinkAttrs{
-			Name:        conf.IfName,
-			ParentIndex: mIndex,
-		},
-		VlanId: conf.VlanId,
+	if vlan.VlanId != conf.VlanId {
+		return fmt.Errorf("vlan link %s configured promisc is %d, current value is %d",
+			ifName, conf.VlanId, vlan.VlanId)
 	}
 
-	if err := netlink.LinkAdd(vlan); err != nil && err != syscall.EEXIST {
-		return nil, nil, fmt.Errorf("failed to create vlan: %v", err)
+	if protocol := netlink.StringToVlanProtocol(conf.VlanProtocol); vlan.VlanProtocol != protocol {
+		return fmt.Errorf("vlan link %s configured protocol is %s, current value is %s",
+			ifName, protocol, vlan.VlanProtocol)
 	}
 
-	if err := netlink.LinkSetUp(vlan); err != nil {
-		return nil, nil, fmt.Errorf("failed to create vlan: %v", err)
+	// The link state intended on ADD is stored in the marker, fall back to the configuration for unmarked links
+	intendedUp := !conf.NoUp
+	if marker, ok := getVlanMarker(vlan); ok {
+		intendedUp = marker.Up
 	}
 
-	// Re-fetch link to read all attributes
-	vlan, err = vlanByName(conf.IfName)
-	if err != nil {
-		return nil, nil, err
+	if intendedUp && vlan.Flags&net.FlagUp != net.FlagUp {
+		return fmt.Errorf("vlan link %s is down", ifName)
 	}
 
-	return vlan, &current.Interface{
-		Name: vlan.Attrs().Name,
-		Mac:  vlan.Attrs().HardwareAddr.String(),
-	}, nil
+	if err := checkVlanMtu(conf, vlan, parentByIndex); err != nil {
+		return err
+	}
+
+	if conf.Mac != "" && vlan.HardwareAddr.String() != conf.Mac {
+		return fmt.Errorf("vlan link %s configured MAC is %s, current value is %s", ifName, conf.Mac,
+			vlan.HardwareAddr)
+	}
+
+	// A moved VLAN is not a bridge port, an Open vSwitch port has the datapath device as master
+	if !conf.InContainer && conf.BridgeType != bridgeTypeOvs {
+		if err := checkVlanMaster(conf, vlan); err != nil {
+			return err
+		}
+	}
+
+	if err := checkVlanFlags(conf, vlan); err != nil {
+		return err
+	}
+
+	return checkVlanQos(conf, vlan)
 }
 
-func vlanByName(name string) (*netlink.Vlan, error) {
-	l, err := netlink.LinkByName(name)
+// checkVlanMaster checks the VLAN is still attached to the master bridge, e.g. it wasn't detached out of band. A missing
+// master or a master of another type is reported with its plugin error code.
+func checkVlanMaster(conf *pluginConf, vlan netlink.Link) error {
+	br, err := masterBridge(conf)
 	if err != nil {
-		return nil, fmt.Errorf("could not lookup %q: %v", name, err)
+		return err
 	}
 
-	vlan, ok := l.(*netlink.Vlan)
-	if !ok {
-		return nil, fmt.Errorf("%q already exists but is not a vlan", name)
+	if vlan.Attrs().MasterIndex != br.Attrs().Index {
+		return fmt.Errorf("vlan link %s is not attached to bridge %s (master index %d, bridge index %d)",
+			vlan.Attrs().Name, br.Attrs().Name, vlan.Attrs().MasterIndex, br.Attrs().Index)
 	}
 
-	return vlan, nil
+	return nil
 }
 
-func parseConfig(bytes []byte) (*pluginConf, current.Result, error) {
-	config := &pluginConf{}
-	if err := json.Unmarshal(bytes, config); err != nil {
-		return nil, current.Result{}, fmt.Errorf("failed to parse network configuration: %v", err)
+// vlanMtu returns the configured VLAN MTU or, if not set, the parent MTU.
+func vlanMtu(conf *pluginConf, vlan *netlink.Vlan) (int, error) {
+	if conf.Mtu != 0 {
+		return conf.Mtu, nil
 	}
 
-	if config.IfName == "" {
-		return nil, current.Result{}, fmt.Errorf(
-			"\"ifName\" field is required. It specifies VLAN interface name.")
+	parent, err := netlink.LinkByIndex(vlan.ParentIndex)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lookup parent link %d: %v", vlan.ParentIndex, err)
 	}
 
-	if config.Master == "" {
-		return nil, current.Result{}, fmt.Errorf(
-			"\"master\" field is required. It specifies the master interface name for VLAN subnetwork.")
-	}
+	return parent.Attrs().MTU, nil
+}
 
-	if config.VlanId < 0 || config.VlanId > 4094 {
-		return nil, current.Result{}, fmt.Errorf("invalid VLAN ID %d (must be between 0 and 4095 inclusive)", config.VlanId)
+// checkVlanMtu checks the VLAN MTU doesn't exceed the parent MTU, which may shrink after the VLAN is created, and
+// matches the configured MTU. With reconcileMtu the VLAN MTU is lowered to the parent one, with probeMtu it may be
+// clamped below the configured one.
+func checkVlanMtu(conf *pluginConf, vlan *netlink.Vlan, parentByIndex func(index int) (netlink.Link, error)) error {
+	parent, err := parentByIndex(vlan.ParentIndex)
+	if err != nil {
+		return fmt.Errorf("failed to lookup parent link %d: %v", vlan.ParentIndex, err)
 	}
 
-	// Parse previous result.
-	var (
-		result *current.Result = &current.Result{}
-		err    error
-	)
+	parentMtu := parent.Attrs().MTU
 
-	if config.RawPrevResult != nil {
-		if err = version.ParsePrevResult(&config.NetConf); err != nil {
-			return nil, current.Result{}, fmt.Errorf("could not parse prevResult: %v", err)
+	if vlan.MTU <= parentMtu {
+		if conf.Mtu != 0 && vlan.MTU != conf.Mtu && !(conf.ProbeMtu && vlan.MTU < conf.Mtu) {
+			return fmt.Errorf("vlan link %s configured MTU is %d, current value is %d", vlan.Name, conf.Mtu, vlan.MTU)
 		}
 
-		result, err = current.NewResultFromResult(config.PrevResult)
-		if err != nil {
-			return nil, current.Result{}, fmt.Errorf("could not convert result to current version: %v", err)
-		}
+		return nil
 	}
 
-	return config, *result, err
+	if !conf.ReconcileMtu {
+		return fmt.Errorf("vlan link %s MTU %d exceeds parent %s MTU %d", vlan.Name, vlan.MTU,
+			parent.Attrs().Name, parentMtu)
+	}
+
+	if err := netlink.LinkSetMTU(vlan, parentMtu); err != nil {
+		return fmt.Errorf("failed to reconcile MTU of %q to %d: %v", vlan.Name, parentMtu, err)
+	}
+
+	logWarn("vlan link MTU lowered to parent MTU", "name", vlan.Name, "mtu", vlan.MTU, "parent", parent.Attrs().Name,
+		"parentMtu", parentMtu)
+
+	return nil
 }
 
-func getMasterInterfaceIndex() (index int, err error) {
-	routes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+// addIPAMResult runs the IPAM delegates, configures the allocated addresses on the link and adds them to the result
+// referencing the result interface at ifIndex.
+func addIPAMResult(conf *pluginConf, args *skel.CmdArgs, delegates []ipamDelegate, linkName string, ifIndex int,
+	result *current.Result,
+) error {
+	ipamResult, err := ipamAdd(conf, delegates)
 	if err != nil {
-		return index, err
+		return err
+	}
+
+	netnsPath := ""
+	if conf.InContainer {
+		netnsPath = args.Netns
 	}
 
-	for _, route := range routes {
-		if route.Dst == nil {
-			return route.LinkIndex, nil
+	if err := applyIPAMResult(netnsPath, linkName, ipamResult); err != nil {
+		if delErr := ipamDel(conf, delegates); delErr != nil {
+			err = fmt.Errorf("%v, rollback failed: %v", err, delErr)
 		}
+
+		return err
 	}
 
-	return index, fmt.Errorf("master index not found")
+	appendInterfaceIPs(result, ifIndex, ipamResult)
+
+	return nil
+}
+
+// appendInterface appends the interface to the result and returns its index the result IPs reference it by.
+func appendInterface(result *current.Result, iface *current.Interface) int {
+	result.Interfaces = append(result.Interfaces, iface)
+
+	return len(result.Interfaces) - 1
+}
+
+// addResultInterface adds the interface to the result and returns its index. An interface of prevResult with the same
+// name and sandbox is handled according to the prevResult conflict policy.
+func addResultInterface(conf *pluginConf, result *current.Result, iface *current.Interface) (int, error) {
+	if conf.PrevResultConflictPolicy != "" {
+		for i, prevIface := range result.Interfaces {
+			if prevIface.Name != iface.Name || prevIface.Sandbox != iface.Sandbox {
+				continue
+			}
+
+			if conf.PrevResultConflictPolicy == prevResultConflictError {
+				return 0, fmt.Errorf("prevResult already has interface %s and prevResult conflict policy is %q",
+					iface.Name, conf.PrevResultConflictPolicy)
+			}
+
+			// The result IPs referencing the replaced interface keep their index
+			result.Interfaces[i] = iface
+
+			return i, nil
+		}
+	}
+
+	return appendInterface(result, iface), nil
+}
+
+// addTopologyInterfaces adds the host interfaces the VLAN is built on to the result: the parent link at parentIndex
+// and, unless in inContainer mode, the master bridge. Interfaces already in the result are not added again.
+func addTopologyInterfaces(conf *pluginConf, result *current.Result, parentIndex int) error {
+	parent, err := netlink.LinkByIndex(parentIndex)
+	if err != nil {
+		return fmt.Errorf("failed to lookup parent link %d: %v", parentIndex, err)
+	}
+
+	links := []netlink.Link{parent}
+
+	if !conf.InContainer {
+		br, err := netlink.LinkByName(conf.Master)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", conf.Master, err)
+		}
+
+		links = append(links, br)
+	}
+
+	for _, link := range links {
+		if hasHostInterface(result, link.Attrs().Name) {
+			continue
+		}
+
+		appendInterface(result, &current.Interface{
+			Name: link.Attrs().Name,
+			Mac:  resultMac(link.Attrs().HardwareAddr),
+		})
+	}
+
+	return nil
+}
+
+func hasHostInterface(result *current.Result, name string) bool {
+	for _, iface := range result.Interfaces {
+		if iface.Name == name && iface.Sandbox == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// appendInterfaceIPs adds the IPAM result to the result with all its IPs referencing the result interface at ifIndex.
+// IPAM delegates know nothing about the result interfaces, so indices they report are overridden.
+func appendInterfaceIPs(result *current.Result, ifIndex int, ipamResult *current.Result) {
+	for _, ip := range ipamResult.IPs {
+		ip.Interface = current.Int(ifIndex)
+	}
+
+	result.IPs = append(result.IPs, ipamResult.IPs...)
+	result.Routes = append(result.Routes, ipamResult.Routes...)
+
+	if len(ipamResult.DNS.Nameservers) != 0 {
+		result.DNS = ipamResult.DNS
+	}
+}
+
+func addVlanToBridge(conf *pluginConf, vlan netlink.Link) error {
+	if conf.BridgeType == bridgeTypeOvs {
+		return addVlanToOvsBridge(conf, vlan)
+	}
+
+	br, err := masterBridge(conf)
+	if err != nil {
+		return err
+	}
+
+	if conf.UniqueBridgeMac {
+		if err := checkBridgeMacUnique(br, vlan); err != nil {
+			return err
+		}
+	}
+
+	if conf.WaitMasterCarrier.Duration != 0 {
+		if err := waitMasterCarrier(conf, br); err != nil {
+			return err
+		}
+	}
+
+	logDebug("attaching vlan link to bridge", "name", vlan.Attrs().Name, "bridge", br.Attrs().Name)
+
+	var link netlink.Link
+
+	// connect host vlan to the bridge
+	if err := netlinkRetry(conf, "attach", func() (err error) {
+		link, err = attachToBridge(vlan, br)
+
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if conf.McastRouter != nil {
+		if err := setBridgePortMcastRouter(vlan, *conf.McastRouter); err != nil {
+			return err
+		}
+	}
+
+	if conf.EgressSrcMac != "" {
+		if err := setEgressSrcMac(vlan.Attrs().Name, conf.EgressSrcMac); err != nil {
+			return err
+		}
+	}
+
+	if conf.MssClamp != nil {
+		if err := setMssClamp(vlan.Attrs().Name, conf.MssClamp, vlan.Attrs().MTU); err != nil {
+			return err
+		}
+	}
+
+	if conf.VlanFilterPolicy != "" {
+		if err := setBridgeVlanFilter(conf, link); err != nil {
+			return err
+		}
+	}
+
+	if conf.BridgeVlan != nil {
+		if err := addBridgeVlans(conf, link); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prefixError prefixes the error message keeping the code of CNI errors.
+func prefixError(err error, prefix string) error {
+	var cniErr *types.Error
+
+	if errors.As(err, &cniErr) {
+		return types.NewError(cniErr.Code, fmt.Sprintf("%s: %s", prefix, cniErr.Msg), cniErr.Details)
+	}
+
+	return fmt.Errorf("%s: %v", prefix, err)
+}
+
+// masterBridge returns the master bridge after checking it is an up bridge.
+func masterBridge(conf *pluginConf) (netlink.Link, error) {
+	br, err := netlink.LinkByName(conf.Master)
+	if err != nil {
+		if errors.As(err, new(netlink.LinkNotFoundError)) {
+			return nil, types.NewError(errMasterMissing, fmt.Sprintf("master bridge %q not found", conf.Master), "")
+		}
+
+		return nil, fmt.Errorf("failed to lookup %q: %v", conf.Master, err)
+	}
+
+	if _, ok := br.(*netlink.Bridge); !ok {
+		return nil, types.NewError(errMasterNotBridge, fmt.Sprintf("master %q is a %s device, not a bridge",
+			conf.Master, br.Type()), "")
+	}
+
+	if br.Attrs().Flags&net.FlagUp == 0 {
+		return nil, types.NewError(errMasterDown, fmt.Sprintf("master bridge %q is down", conf.Master), "")
+	}
+
+	return br, nil
+}
+
+// adoptSharedVlan returns the existing VLAN managed by another tool after checking it matches the configuration.
+func adoptSharedVlan(conf *pluginConf) (*netlink.Vlan, *current.Interface, error) {
+	vlan, err := vlanlib.ByName(conf.IfName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("shared vlan: %v", err)
+	}
+
+	if vlan.VlanId != conf.VlanId {
+		return nil, nil, fmt.Errorf("shared vlan link %s has VLAN ID %d, configured VLAN ID is %d",
+			conf.IfName, vlan.VlanId, conf.VlanId)
+	}
+
+	mIndex, err := resolveParentIndex(conf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to lookup master index %v", err)
+	}
+
+	if vlan.ParentIndex != mIndex {
+		return nil, nil, fmt.Errorf("shared vlan link %s parent index is %d, resolved parent index is %d",
+			conf.IfName, vlan.ParentIndex, mIndex)
+	}
+
+	return vlan, &current.Interface{
+		Name: vlan.Attrs().Name,
+		Mac:  resultMac(vlan.Attrs().HardwareAddr),
+	}, nil
+}
+
+// detachVlan detaches the VLAN from its bridge. A missing link or a link which is not ours is skipped.
+func detachVlan(conf *pluginConf, containerID string) error {
+	link, err := ownVlanByName(conf, containerID, conf.IfName)
+	if err != nil || link == nil {
+		return err
+	}
+
+	if link.Attrs().MasterIndex == 0 {
+		return nil
+	}
+
+	if err := netlink.LinkSetNoMaster(link); err != nil {
+		return fmt.Errorf("failed to detach %q from bridge: %v", conf.IfName, err)
+	}
+
+	return nil
+}
+
+// createVlan creates the VLAN or reuses an existing matching one and reports whether the link was created.
+func createVlan(conf *pluginConf) (vlan *netlink.Vlan, vlanInterface *current.Interface, created bool, err error) {
+	mIndex, err := vlanParentIndex(conf)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	created = true
+
+	logDebug("adding vlan link", "name", conf.IfName, "parentIndex", mIndex, "vlanId", conf.VlanId)
+
+	if err = netlinkRetry(conf, "create", func() (err error) {
+		vlan, err = vlanlib.CreateVlan(coreConfig(conf), mIndex)
+
+		return err
+	}); err != nil {
+		if !errors.Is(err, syscall.EEXIST) {
+			return nil, nil, false, err
+		}
+
+		if created, err = reconcileExistingVlan(conf, vlan); err != nil {
+			return nil, nil, false, err
+		}
+
+		// The adopted link was never added through vlan, so its index is unset and the IFLA_INFO_DATA requests would
+		// fail.
+		if !created {
+			if vlan, err = vlanlib.ByName(conf.IfName); err != nil {
+				return nil, nil, false, err
+			}
+		}
+	}
+
+	if vlan, vlanInterface, err = configureVlan(conf, vlan); err != nil {
+		return nil, nil, false, err
+	}
+
+	return vlan, vlanInterface, created, nil
+}
+
+// newVlanLink validates the parent and returns the VLAN link to be added.
+func newVlanLink(conf *pluginConf) (*netlink.Vlan, error) {
+	mIndex, err := vlanParentIndex(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return vlanlib.NewVlan(coreConfig(conf), mIndex), nil
+}
+
+// vlanParentIndex resolves and validates the parent of the VLAN and returns its index.
+func vlanParentIndex(conf *pluginConf) (int, error) {
+	mIndex, err := resolveParentIndex(conf)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lookup master index %v", err)
+	}
+
+	parent, err := netlink.LinkByIndex(mIndex)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lookup parent link %d: %v", mIndex, err)
+	}
+
+	if err := validateParentLink(parent); err != nil {
+		return 0, err
+	}
+
+	if err := ensureParentUp(conf, parent); err != nil {
+		return 0, err
+	}
+
+	if conf.Mtu > parent.Attrs().MTU {
+		return 0, fmt.Errorf("MTU %d exceeds parent %s MTU %d", conf.Mtu, parent.Attrs().Name, parent.Attrs().MTU)
+	}
+
+	if conf.GloballyUniqueVlanId {
+		if err := checkVlanIdUnique(conf); err != nil {
+			return 0, err
+		}
+	}
+
+	return mIndex, nil
+}
+
+// coreConfig returns the configuration of the VLAN library.
+func coreConfig(conf *pluginConf) *vlanlib.Config {
+	return &vlanlib.Config{
+		VlanId:       conf.VlanId,
+		IfName:       conf.IfName,
+		VlanProtocol: conf.VlanProtocol,
+	}
+}
+
+// ensureParentUp fails if the parent link is administratively down, a VLAN on it would not pass any traffic. With
+// bringUpMaster, the parent is brought up instead.
+func ensureParentUp(conf *pluginConf, parent netlink.Link) error {
+	if parent.Attrs().Flags&net.FlagUp != 0 {
+		return nil
+	}
+
+	if !conf.BringUpMaster {
+		return types.NewError(errParentDown, fmt.Sprintf("parent link %q is down", parent.Attrs().Name), "")
+	}
+
+	logDebug("setting parent link up", "name", parent.Attrs().Name)
+
+	if err := netlink.LinkSetUp(parent); err != nil {
+		return fmt.Errorf("failed to set parent link %q up: %v", parent.Attrs().Name, err)
+	}
+
+	return nil
+}
+
+// configureVlan applies the configured attributes to the added VLAN.
+func configureVlan(conf *pluginConf, vlan *netlink.Vlan) (*netlink.Vlan, *current.Interface, error) {
+	if flags, mask := configuredVlanFlags(conf); mask != 0 {
+		if err := setVlanFlags(vlan, flags, mask); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := setVlanQos(conf, vlan); err != nil {
+		return nil, nil, err
+	}
+
+	if conf.Group != 0 {
+		if err := netlinkRetry(conf, "set group", func() error {
+			return netlink.LinkSetGroup(vlan, conf.Group)
+		}); err != nil {
+			return nil, nil, fmt.Errorf("failed to set group %d on vlan: %v", conf.Group, err)
+		}
+	}
+
+	if err := setVlanMarker(vlan, vlanMarker{Up: !conf.NoUp}); err != nil {
+		return nil, nil, err
+	}
+
+	mtu, err := vlanMtu(conf, vlan)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logDebug("setting vlan link MTU", "name", vlan.Name, "mtu", mtu)
+
+	if err := netlinkRetry(conf, "set MTU", func() error { return netlink.LinkSetMTU(vlan, mtu) }); err != nil {
+		return nil, nil, fmt.Errorf("failed to set MTU %d on vlan: %v", mtu, err)
+	}
+
+	if conf.Mac != "" {
+		if err := setVlanMac(vlan, conf.Mac); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if upInHost(conf) {
+		logDebug("setting vlan link up", "name", vlan.Name)
+
+		if err := netlinkRetry(conf, "set up", func() error { return netlink.LinkSetUp(vlan) }); err != nil {
+			return nil, nil, fmt.Errorf("failed to create vlan: %v", err)
+		}
+	}
+
+	// The interface sysctls are reset on the namespace change, so in inContainer mode they are set after the move
+	if len(conf.Sysctls) != 0 && !conf.InContainer {
+		if err := setVlanSysctls(conf, vlan.Name); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if conf.IPv6TrafficClass != nil {
+		if err := setIPv6TrafficClass(vlan, *conf.IPv6TrafficClass); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if conf.BpfProgram != "" {
+		if err := attachBpfProgram(vlan, conf.BpfProgram); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(conf.DscpToPcp) != 0 {
+		if err := setDscpToPcp(vlan, conf.DscpToPcp); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if conf.MirrorTo != "" {
+		if err := setMirror(vlan, conf.MirrorTo); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if conf.RpsCpus != "" {
+		if err := setRpsCpus(vlan, conf.RpsCpus); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := setVlanBandwidth(conf, vlan); err != nil {
+		return nil, nil, err
+	}
+
+	// Re-fetch link to read all attributes
+	vlan, err = vlanlib.ByName(conf.IfName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return vlan, &current.Interface{
+		Name: vlan.Attrs().Name,
+		Mac:  resultMac(vlan.Attrs().HardwareAddr),
+	}, nil
+}
+
+// applyAutoName derives the VLAN name from the parent name and the VLAN ID if ifName is not set and autoName is
+// enabled.
+func applyAutoName(conf *pluginConf) error {
+	if !conf.AutoName || conf.IfName != "" || len(conf.Vlans) != 0 {
+		return nil
+	}
+
+	mIndex, err := resolveParentIndex(conf)
+	if err != nil {
+		return fmt.Errorf("failed to lookup master index for auto name %v", err)
+	}
+
+	parent, err := netlink.LinkByIndex(mIndex)
+	if err != nil {
+		return fmt.Errorf("failed to lookup parent link %d: %v", mIndex, err)
+	}
+
+	name := fmt.Sprintf("%s.%d", parent.Attrs().Name, conf.VlanId)
+	if len(name) > maxIfNameLen {
+		return fmt.Errorf("auto name %q is longer than %d characters, set \"ifName\"", name, maxIfNameLen)
+	}
+
+	conf.IfName = name
+
+	return nil
+}
+
+// applyFallbackIfName switches the VLAN name to fallbackIfName if ifName is taken by a link which is not a VLAN. A VLAN
+// with a different ID doesn't trigger the fallback.
+func applyFallbackIfName(conf *pluginConf) {
+	if conf.FallbackIfName == "" {
+		return
+	}
+
+	link, err := netlink.LinkByName(conf.IfName)
+	if err != nil {
+		return
+	}
+
+	if _, ok := link.(*netlink.Vlan); !ok {
+		conf.IfName = conf.FallbackIfName
+	}
+}
+
+// reconcileExistingVlan checks an existing link with the VLAN name and reports whether it was recreated. A link which
+// is not a VLAN on the same parent is a foreign device and is never adopted.
+func reconcileExistingVlan(conf *pluginConf, vlan *netlink.Vlan) (recreated bool, err error) {
+	link, err := netlink.LinkByName(conf.IfName)
+	if err != nil {
+		return false, fmt.Errorf("could not lookup %q: %v", conf.IfName, err)
+	}
+
+	existing, ok := link.(*netlink.Vlan)
+	if !ok {
+		return false, fmt.Errorf("%q already exists and is a foreign %s device, not a vlan", conf.IfName, link.Type())
+	}
+
+	if existing.ParentIndex != vlan.ParentIndex {
+		return false, fmt.Errorf("%q already exists and is a foreign vlan on parent index %d, expected %d",
+			conf.IfName, existing.ParentIndex, vlan.ParentIndex)
+	}
+
+	if conf.NameCollisionPolicy == nameCollisionError {
+		return false, fmt.Errorf("vlan link %s already exists with VLAN ID %d and name collision policy is %q",
+			conf.IfName, existing.VlanId, nameCollisionError)
+	}
+
+	if existing.VlanId == conf.VlanId {
+		logDebug("adopting existing vlan link", "name", conf.IfName, "vlanId", existing.VlanId)
+
+		return false, nil
+	}
+
+	if !conf.RecreateOnIdChange {
+		return false, fmt.Errorf("vlan link %s already exists with VLAN ID %d, requested VLAN ID is %d",
+			conf.IfName, existing.VlanId, conf.VlanId)
+	}
+
+	logDebug("recreating vlan link", "name", conf.IfName, "oldVlanId", existing.VlanId, "vlanId", conf.VlanId)
+
+	if err := netlink.LinkDel(existing); err != nil {
+		return false, fmt.Errorf("failed to delete vlan %s with VLAN ID %d: %v", conf.IfName, existing.VlanId, err)
+	}
+
+	if err := netlink.LinkAdd(vlan); err != nil {
+		return false, fmt.Errorf("failed to recreate vlan: %v", err)
+	}
+
+	return true, nil
+}
+
+// upInHost reports whether the VLAN is brought up in the host namespace. In inContainer mode it is brought up after the
+// move unless upAfterMove is disabled, as the move resets the link state anyway.
+func upInHost(conf *pluginConf) bool {
+	if conf.NoUp {
+		return false
+	}
+
+	return !conf.InContainer || (conf.UpAfterMove != nil && !*conf.UpAfterMove)
+}
+
+// checkVlanIdUnique fails if any VLAN link other than the configured one uses the configured VLAN ID.
+func checkVlanIdUnique(conf *pluginConf) error {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return fmt.Errorf("failed to list links: %v", err)
+	}
+
+	for _, link := range links {
+		vlan, ok := link.(*netlink.Vlan)
+		if !ok || vlan.Attrs().Name == conf.IfName {
+			continue
+		}
+
+		if vlan.VlanId == conf.VlanId {
+			return fmt.Errorf("VLAN ID %d is already used by %q", conf.VlanId, vlan.Attrs().Name)
+		}
+	}
+
+	return nil
+}
+
+func validateParentLink(parent netlink.Link) error {
+	if parent.Attrs().Flags&net.FlagLoopback != 0 {
+		return fmt.Errorf("parent link %q is a loopback device", parent.Attrs().Name)
+	}
+
+	if parent.Attrs().EncapType != "ether" {
+		return fmt.Errorf("parent link %q is not an ethernet device (link type %q)",
+			parent.Attrs().Name, parent.Attrs().EncapType)
+	}
+
+	return nil
+}
+
+// parseVlanMac parses the configured VLAN MAC, which must be a unicast Ethernet address.
+func parseVlanMac(s string) (net.HardwareAddr, error) {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAC %q: %v", s, err)
+	}
+
+	if len(mac) != 6 {
+		return nil, fmt.Errorf("invalid MAC %q (must be a 48-bit Ethernet address)", s)
+	}
+
+	if mac[0]&0x01 != 0 {
+		return nil, fmt.Errorf("invalid MAC %q (must be a unicast address)", s)
+	}
+
+	return mac, nil
+}
+
+// setVlanMac sets the configured MAC on the VLAN. The MAC is valid as it is checked by parseConfig.
+func setVlanMac(vlan netlink.Link, s string) error {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return fmt.Errorf("invalid MAC %q: %v", s, err)
+	}
+
+	if err := netlink.LinkSetHardwareAddr(vlan, mac); err != nil {
+		return fmt.Errorf("failed to set MAC %s on vlan: %v", s, err)
+	}
+
+	return nil
+}
+
+// resultMac formats the MAC reported in the CNI result as lowercase colon separated string. Links without a hardware
+// address (reported by some devices as all zeros) get an empty MAC.
+func resultMac(hwAddr net.HardwareAddr) string {
+	for _, b := range hwAddr {
+		if b != 0 {
+			return strings.ToLower(hwAddr.String())
+		}
+	}
+
+	return ""
+}
+
+func parseConfig(bytes []byte) (*pluginConf, current.Result, error) {
+	config := &pluginConf{}
+	if err := json.Unmarshal(bytes, config); err != nil {
+		return nil, current.Result{}, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+
+	config.InContainer = config.InContainer || config.ContainerNS
+
+	if err := validateVlans(config); err != nil {
+		return nil, current.Result{}, err
+	}
+
+	if config.NameTemplate != "" && config.IfName == "" && len(config.Vlans) == 0 {
+		name, err := expandNameTemplate(config)
+		if err != nil {
+			return nil, current.Result{}, err
+		}
+
+		config.IfName = name
+	}
+
+	if config.IfName == "" && len(config.Vlans) == 0 && !config.AutoName {
+		return nil, current.Result{}, fmt.Errorf(
+			"\"ifName\" field is required. It specifies VLAN interface name.")
+	}
+
+	if config.NormalizeName {
+		name := normalizeIfName(config.IfName)
+		if name != config.IfName {
+			config.normalizedIfName = config.IfName
+		}
+
+		config.IfName = name
+	}
+
+	if config.FallbackIfName == config.IfName {
+		config.FallbackIfName = ""
+	}
+
+	switch config.NameCollisionPolicy {
+	case "", nameCollisionAdopt, nameCollisionError:
+
+	default:
+		return nil, current.Result{}, fmt.Errorf("invalid name collision policy %q (must be %q or %q)",
+			config.NameCollisionPolicy, nameCollisionAdopt, nameCollisionError)
+	}
+
+	switch config.PrevResultConflictPolicy {
+	case "", prevResultConflictReplace, prevResultConflictError:
+
+	default:
+		return nil, current.Result{}, fmt.Errorf("invalid prevResult conflict policy %q (must be %q or %q)",
+			config.PrevResultConflictPolicy, prevResultConflictReplace, prevResultConflictError)
+	}
+
+	if config.Shared && config.InContainer {
+		return nil, current.Result{}, fmt.Errorf("\"shared\" and \"inContainer\" modes are mutually exclusive")
+	}
+
+	switch config.VlanFilterPolicy {
+	case "", vlanFilterStrict, vlanFilterMerge:
+
+	default:
+		return nil, current.Result{}, fmt.Errorf("invalid VLAN filter policy %q (must be %q or %q)",
+			config.VlanFilterPolicy, vlanFilterStrict, vlanFilterMerge)
+	}
+
+	if config.VlanFilterPolicy != "" && config.InContainer {
+		return nil, current.Result{}, fmt.Errorf("\"vlanFilterPolicy\" is not supported in \"inContainer\" mode")
+	}
+
+	switch config.BridgeType {
+	case "", bridgeTypeLinux:
+
+	case bridgeTypeOvs:
+		// The bridge port attributes are set for Linux bridges only
+		if config.InContainer || config.McastRouter != nil || config.EgressSrcMac != "" || config.MssClamp != nil ||
+			config.VlanFilterPolicy != "" || config.BridgeVlan != nil || config.WaitMasterCarrier.Duration != 0 ||
+			config.UniqueBridgeMac {
+			return nil, current.Result{}, fmt.Errorf("bridge type %q can't be combined with \"inContainer\", "+
+				"\"mcastRouter\", \"egressSrcMac\", \"mssClamp\", \"vlanFilterPolicy\", \"bridgeVlan\", "+
+				"\"waitMasterCarrier\" and \"uniqueBridgeMac\"", bridgeTypeOvs)
+		}
+
+	default:
+		return nil, current.Result{}, fmt.Errorf("invalid bridge type %q (must be %q or %q)", config.BridgeType,
+			bridgeTypeLinux, bridgeTypeOvs)
+	}
+
+	if config.BridgeVlan != nil {
+		if config.VlanFilterPolicy != "" || config.InContainer {
+			return nil, current.Result{}, fmt.Errorf(
+				"\"bridgeVlan\" can't be combined with \"vlanFilterPolicy\" and \"inContainer\"")
+		}
+
+		if err := validateBridgeVlan(config.BridgeVlan); err != nil {
+			return nil, current.Result{}, err
+		}
+	}
+
+	if config.KeepOnDel && config.DeleteOnDel {
+		return nil, current.Result{}, fmt.Errorf("\"keepOnDel\" and \"deleteOnDel\" are mutually exclusive")
+	}
+
+	if config.McastRouter != nil {
+		if *config.McastRouter < mcastRouterDisabled || *config.McastRouter > mcastRouterPerm {
+			return nil, current.Result{}, fmt.Errorf("invalid multicast router role %d (must be between %d and %d "+
+				"inclusive)", *config.McastRouter, mcastRouterDisabled, mcastRouterPerm)
+		}
+
+		// The role is set on the bridge port, which a moved VLAN is not
+		if config.InContainer {
+			return nil, current.Result{}, fmt.Errorf("\"mcastRouter\" is not supported in \"inContainer\" mode")
+		}
+	}
+
+	if config.Gateway != "" && net.ParseIP(config.Gateway).To4() == nil {
+		return nil, current.Result{}, fmt.Errorf("invalid gateway %q (must be an IPv4 address)", config.Gateway)
+	}
+
+	if config.EgressSrcMac != "" {
+		mac, err := net.ParseMAC(config.EgressSrcMac)
+		if err != nil {
+			return nil, current.Result{}, fmt.Errorf("invalid egress source MAC %q: %v", config.EgressSrcMac, err)
+		}
+
+		// The rule matches the VLAN bridge port, which a moved VLAN is not, and a shared VLAN is used by other
+		// containers
+		if config.Shared || config.InContainer {
+			return nil, current.Result{}, fmt.Errorf(
+				"\"egressSrcMac\" is not supported in \"shared\" and \"inContainer\" modes")
+		}
+
+		config.EgressSrcMac = mac.String()
+	}
+
+	if config.ReportIfIndex && (config.StateDir == "" || config.InContainer) {
+		return nil, current.Result{}, fmt.Errorf(
+			"\"reportIfIndex\" requires \"stateDir\" and is not supported in \"inContainer\" mode")
+	}
+
+	// The rule matches the VLAN bridge port as the egress source MAC rule does
+	if config.MssClamp != nil && (config.Shared || config.InContainer) {
+		return nil, current.Result{}, fmt.Errorf(
+			"\"mssClamp\" is not supported in \"shared\" and \"inContainer\" modes")
+	}
+
+	// The VLAN qdiscs are dropped on the namespace change and a shared VLAN is not configured by the plugin
+	if (config.Bandwidth != nil || config.BpfProgram != "" || len(config.DscpToPcp) != 0 ||
+		config.IPv6TrafficClass != nil || config.MirrorTo != "") && (config.Shared || config.InContainer) {
+		return nil, current.Result{}, fmt.Errorf("\"bandwidth\", \"bpfProgram\", \"dscpToPcp\", " +
+			"\"ipv6TrafficClass\" and \"mirrorTo\" are not supported in \"shared\" and \"inContainer\" modes")
+	}
+
+	if config.MirrorTo != "" && config.MirrorTo == config.IfName {
+		return nil, current.Result{}, fmt.Errorf("VLAN %q can't be mirrored to itself", config.IfName)
+	}
+
+	if config.RpsCpus != "" {
+		mask, err := parseRpsCpus(config.RpsCpus)
+		if err != nil {
+			return nil, current.Result{}, err
+		}
+
+		if config.Shared {
+			return nil, current.Result{}, fmt.Errorf("\"rpsCpus\" is not supported in \"shared\" mode")
+		}
+
+		config.RpsCpus = mask
+	}
+
+	for dscp, pcp := range config.DscpToPcp {
+		if dscp < 0 || dscp > 63 {
+			return nil, current.Result{}, fmt.Errorf("invalid DSCP %d (must be between 0 and 63 inclusive)", dscp)
+		}
+
+		if pcp < 0 || pcp > 7 {
+			return nil, current.Result{}, fmt.Errorf("invalid PCP %d of DSCP %d (must be between 0 and 7 inclusive)",
+				pcp, dscp)
+		}
+	}
+
+	if _, ok := logLevelNames[config.LogLevel]; config.LogLevel != "" && !ok {
+		return nil, current.Result{}, fmt.Errorf("invalid log level %q (must be \"debug\", \"info\", \"warn\" or "+
+			"\"error\")", config.LogLevel)
+	}
+
+	if config.ResultChecksum && config.EventFile == "" {
+		return nil, current.Result{}, fmt.Errorf("\"resultChecksum\" requires \"eventFile\"")
+	}
+
+	if err := validateVlanQos(config); err != nil {
+		return nil, current.Result{}, err
+	}
+
+	if err := validateSysctls(config); err != nil {
+		return nil, current.Result{}, err
+	}
+
+	if err := validateBandwidth(config); err != nil {
+		return nil, current.Result{}, err
+	}
+
+	if err := validateStaticAddresses(config); err != nil {
+		return nil, current.Result{}, err
+	}
+
+	if config.Master == "" && !config.InContainer {
+		return nil, current.Result{}, types.NewError(errMasterMissing,
+			"\"master\" field is required. It specifies the master interface name for VLAN subnetwork.", "")
+	}
+
+	// Catch names the kernel rejects here instead of failing deep inside netlink with ENAMETOOLONG or EINVAL
+	for _, field := range []struct{ key, name string }{
+		{"ifName", config.IfName}, {"master", config.Master}, {"parent", config.Parent},
+	} {
+		if field.name == "" {
+			continue
+		}
+
+		if err := validateIfName(field.name); err != nil {
+			return nil, current.Result{}, ifNameError(field.key, field.name, err)
+		}
+	}
+
+	coreConf, err := vlanlib.ParseConfig(bytes)
+	if err != nil {
+		if errors.Is(err, vlanlib.ErrInvalidVlanId) {
+			return nil, current.Result{}, types.NewError(errVlanIdOutOfRange, err.Error(), "")
+		}
+
+		return nil, current.Result{}, err
+	}
+
+	config.VlanProtocol = coreConf.VlanProtocol
+
+	if config.Mtu != 0 && (config.Mtu < minMtu || config.Mtu > maxLinkMtu) {
+		return nil, current.Result{}, fmt.Errorf("invalid MTU %d (must be between %d and %d inclusive)", config.Mtu,
+			minMtu, maxLinkMtu)
+	}
+
+	if config.Mac != "" {
+		mac, err := parseVlanMac(config.Mac)
+		if err != nil {
+			return nil, current.Result{}, err
+		}
+
+		// A shared VLAN is owned by another tool
+		if config.Shared {
+			return nil, current.Result{}, fmt.Errorf("\"mac\" is not supported in \"shared\" mode")
+		}
+
+		config.Mac = mac.String()
+	}
+
+	if config.IPv6TrafficClass != nil && (*config.IPv6TrafficClass < 0 || *config.IPv6TrafficClass > 255) {
+		return nil, current.Result{}, fmt.Errorf("invalid IPv6 traffic class %d (must be between 0 and 255 inclusive)",
+			*config.IPv6TrafficClass)
+	}
+
+	if config.DelBusyRetries != nil && *config.DelBusyRetries < 0 {
+		return nil, current.Result{}, fmt.Errorf("invalid delete busy retries %d (must not be negative)",
+			*config.DelBusyRetries)
+	}
+
+	if config.Group < 0 {
+		return nil, current.Result{}, fmt.Errorf("invalid group %d (must not be negative)", config.Group)
+	}
+
+	if config.NetlinkRetries != nil && *config.NetlinkRetries < 0 {
+		return nil, current.Result{}, fmt.Errorf("invalid netlink retries %d (must not be negative)",
+			*config.NetlinkRetries)
+	}
+
+	if config.IPAMRetries < 0 {
+		return nil, current.Result{}, fmt.Errorf("invalid IPAM retries %d (must not be negative)", config.IPAMRetries)
+	}
+
+	if err := checkSystemDefaults(config); err != nil {
+		return nil, current.Result{}, err
+	}
+
+	if config.MasterSubnet != "" {
+		if _, _, err := net.ParseCIDR(config.MasterSubnet); err != nil {
+			return nil, current.Result{}, fmt.Errorf("invalid master subnet %q: %v", config.MasterSubnet, err)
+		}
+
+		if config.ParentType != "" && len(config.MasterStrategyOrder) == 0 {
+			return nil, current.Result{}, fmt.Errorf("\"masterSubnet\" and \"parentType\" are mutually exclusive")
+		}
+	}
+
+	if config.Parent != "" {
+		if config.ParentType != "" || config.MasterSubnet != "" || len(config.MasterStrategyOrder) != 0 {
+			return nil, current.Result{}, fmt.Errorf(
+				"\"parent\" can't be combined with \"parentType\", \"masterSubnet\" and \"masterStrategyOrder\"")
+		}
+
+		if config.Parent == config.Master {
+			return nil, current.Result{}, fmt.Errorf("parent %q can't be the master bridge", config.Parent)
+		}
+	}
+
+	switch config.ParentType {
+	case "", "device", "bond", "bridge":
+
+	default:
+		return nil, current.Result{}, fmt.Errorf(
+			"invalid parent type %q (must be \"device\", \"bond\" or \"bridge\")", config.ParentType)
+	}
+
+	if err := validateMasterStrategyOrder(config); err != nil {
+		return nil, current.Result{}, err
+	}
+
+	if config.MasterFamily != "" && config.RouteScanFamily != "" {
+		return nil, current.Result{}, fmt.Errorf("\"masterFamily\" and \"routeScanFamily\" are mutually exclusive")
+	}
+
+	if _, err := masterRouteFamilies(config); err != nil {
+		return nil, current.Result{}, err
+	}
+
+	if err := checkKernelFeatures(config); err != nil {
+		return nil, current.Result{}, err
+	}
+
+	// Parse previous result.
+	var result *current.Result = &current.Result{}
+
+	if config.RawPrevResult != nil {
+		if err = parsePrevResult(&config.NetConf); err != nil {
+			return nil, current.Result{}, fmt.Errorf("could not parse prevResult: %v", err)
+		}
+
+		result, err = current.NewResultFromResult(config.PrevResult)
+		if err != nil {
+			return nil, current.Result{}, fmt.Errorf("could not convert result to current version: %v", err)
+		}
+	}
+
+	return config, *result, err
+}
+
+// parsePrevResult parses the raw prevResult. A 1.1.0 prevResult is parsed as 1.0.0 as both share the result format.
+func parsePrevResult(conf *types.NetConf) error {
+	if conf.CNIVersion != cniVersionGC {
+		return version.ParsePrevResult(conf)
+	}
+
+	if ver, ok := conf.RawPrevResult["cniVersion"]; ok && ver == cniVersionGC {
+		conf.RawPrevResult["cniVersion"] = current.ImplementedSpecVersion
+	}
+
+	legacyConf := *conf
+	legacyConf.CNIVersion = current.ImplementedSpecVersion
+
+	if err := version.ParsePrevResult(&legacyConf); err != nil {
+		return err
+	}
+
+	conf.RawPrevResult, conf.PrevResult = legacyConf.RawPrevResult, legacyConf.PrevResult
+
+	return nil
+}
+
+// getAsConfVersion converts the result to the configured CNI version. A 1.1.0 result is the 1.0.0 one tagged with
+// the 1.1.0 version.
+func getAsConfVersion(conf *pluginConf, result types.Result) (types.Result, error) {
+	if conf.CNIVersion != cniVersionGC {
+		return result.GetAsVersion(conf.CNIVersion)
+	}
+
+	versioned, err := result.GetAsVersion(current.ImplementedSpecVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	tagged := *versioned.(*current.Result)
+	tagged.CNIVersion = cniVersionGC
+
+	return &tagged, nil
+}
+
+func resolveParentIndex(conf *pluginConf) (index int, err error) {
+	defer func() {
+		if err == nil {
+			logDebug("resolved parent link", "vlan", conf.IfName, "parentIndex", index)
+		}
+	}()
+
+	if conf.Parent != "" {
+		return localParentIndex(conf, getParentIndexByName)
+	}
+
+	if len(conf.MasterStrategyOrder) != 0 {
+		return resolveParentIndexByStrategy(conf)
+	}
+
+	if conf.ParentType != "" {
+		return localParentIndex(conf, getParentIndexByType)
+	}
+
+	if conf.MasterSubnet != "" {
+		return localParentIndex(conf, getParentIndexBySubnet)
+	}
+
+	return localParentIndex(conf, getMasterInterfaceIndex)
+}
+
+// localParentIndex runs the parent scan and returns the index of the found link in the current namespace, where the
+// VLAN is created. With masterScanNetns the scan returns an index of another namespace, so the link is looked up there
+// and then again by name in the current one.
+func localParentIndex(conf *pluginConf, scan func(conf *pluginConf) (int, error)) (int, error) {
+	index, err := scan(conf)
+	if err != nil || conf.MasterScanNetns == "" {
+		return index, err
+	}
+
+	handle, err := masterScanHandle(conf)
+	if err != nil {
+		return 0, err
+	}
+	defer handle.Delete()
+
+	scanned, err := handle.LinkByIndex(index)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lookup link %d in master scan netns: %v", index, err)
+	}
+
+	link, err := netlink.LinkByName(scanned.Attrs().Name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lookup parent %q found in master scan netns: %v", scanned.Attrs().Name, err)
+	}
+
+	return link.Attrs().Index, nil
+}
+
+func getParentIndexByName(conf *pluginConf) (int, error) {
+	handle, err := masterScanHandle(conf)
+	if err != nil {
+		return 0, err
+	}
+	defer handle.Delete()
+
+	link, err := handle.LinkByName(conf.Parent)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lookup parent %q: %v", conf.Parent, err)
+	}
+
+	return link.Attrs().Index, nil
+}
+
+func getParentIndexByType(conf *pluginConf) (index int, err error) {
+	handle, err := masterScanHandle(conf)
+	if err != nil {
+		return index, err
+	}
+	defer handle.Delete()
+
+	links, err := handle.LinkList()
+	if err != nil {
+		return index, err
+	}
+
+	var candidates []string
+
+	for _, link := range links {
+		// The bridge the VLAN is attached to can't be its parent
+		if link.Type() != conf.ParentType || link.Attrs().Name == conf.Master ||
+			link.Attrs().Flags&net.FlagUp != net.FlagUp {
+			continue
+		}
+
+		index = link.Attrs().Index
+		candidates = append(candidates, link.Attrs().Name)
+	}
+
+	switch len(candidates) {
+	case 0:
+		return 0, fmt.Errorf("no up link of type %q found", conf.ParentType)
+
+	case 1:
+		return index, nil
+
+	default:
+		return 0, fmt.Errorf("multiple up links of type %q found: %s", conf.ParentType,
+			strings.Join(candidates, ", "))
+	}
+}
+
+func getParentIndexBySubnet(conf *pluginConf) (index int, err error) {
+	_, subnet, err := net.ParseCIDR(conf.MasterSubnet)
+	if err != nil {
+		return index, fmt.Errorf("invalid master subnet %q: %v", conf.MasterSubnet, err)
+	}
+
+	handle, err := masterScanHandle(conf)
+	if err != nil {
+		return index, err
+	}
+	defer handle.Delete()
+
+	addrs, err := handle.AddrList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return index, err
+	}
+
+	candidates := make(map[int]bool)
+
+	for _, addr := range addrs {
+		if subnet.Contains(addr.IP) {
+			candidates[addr.LinkIndex] = true
+		}
+	}
+
+	var names []string
+
+	for linkIndex := range candidates {
+		link, err := handle.LinkByIndex(linkIndex)
+		if err != nil {
+			return 0, fmt.Errorf("failed to lookup link %d: %v", linkIndex, err)
+		}
+
+		// The bridge the VLAN is attached to can't be its parent
+		if link.Attrs().Name == conf.Master {
+			continue
+		}
+
+		index = linkIndex
+		names = append(names, link.Attrs().Name)
+	}
+
+	switch len(names) {
+	case 0:
+		return 0, fmt.Errorf("no link with an address in %s found", subnet)
+
+	case 1:
+		return index, nil
+
+	default:
+		sort.Strings(names)
+
+		return 0, fmt.Errorf("multiple links with an address in %s found: %s", subnet, strings.Join(names, ", "))
+	}
+}
+
+func routeScanFamily(family string) (int, error) {
+	switch family {
+	case "", "v4":
+		return netlink.FAMILY_V4, nil
+
+	case "v6":
+		return netlink.FAMILY_V6, nil
+
+	case "all":
+		return netlink.FAMILY_ALL, nil
+
+	default:
+		return 0, fmt.Errorf("invalid route scan family %q (must be \"v4\", \"v6\" or \"all\")", family)
+	}
+}
+
+// expandNameTemplate returns the VLAN name expanded from the name template.
+func expandNameTemplate(conf *pluginConf) (string, error) {
+	var unknown []string
+
+	name := nameTemplatePlaceholder.ReplaceAllStringFunc(conf.NameTemplate, func(placeholder string) string {
+		switch placeholder {
+		case "{vlanId}":
+			return strconv.Itoa(conf.VlanId)
+
+		case "{master}":
+			return conf.Master
+
+		default:
+			unknown = append(unknown, placeholder)
+
+			return placeholder
+		}
+	})
+
+	if len(unknown) != 0 {
+		return "", fmt.Errorf("unknown placeholder %s in name template %q (must be {vlanId} or {master})", unknown[0],
+			conf.NameTemplate)
+	}
+
+	if err := validateIfName(name); err != nil {
+		return "", fmt.Errorf("invalid name %q expanded from name template %q: %v", name, conf.NameTemplate, err)
+	}
+
+	return name, nil
+}
+
+// ifNameError returns the error of the interface name of the configuration key rejected by validateIfName.
+func ifNameError(key, name string, err error) error {
+	return fmt.Errorf("invalid %q %q: %v (must be at most %d characters without '/', ':' and whitespaces)", key, name,
+		err, maxIfNameLen)
+}
+
+// validateIfName checks the kernel accepts the interface name.
+func validateIfName(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return fmt.Errorf("name is empty or reserved")
+	}
+
+	if len(name) > maxIfNameLen {
+		return fmt.Errorf("name is longer than %d characters", maxIfNameLen)
+	}
+
+	for _, r := range name {
+		if r == '/' || r == ':' || unicode.IsSpace(r) {
+			return fmt.Errorf("name contains illegal character %q", r)
+		}
+	}
+
+	return nil
+}
+
+// normalizeIfName replaces characters the kernel doesn't accept in interface names ('/', ':' and whitespaces) with '_'
+// and truncates the name to maxIfNameLen.
+func normalizeIfName(name string) string {
+	normalized := []rune(strings.Map(func(r rune) rune {
+		if r == '/' || r == ':' || unicode.IsSpace(r) {
+			return '_'
+		}
+
+		return r
+	}, name))
+
+	for len(string(normalized)) > maxIfNameLen {
+		normalized = normalized[:len(normalized)-1]
+	}
+
+	if name := string(normalized); name != "." && name != ".." {
+		return name
+	}
+
+	return strings.Repeat("_", len(normalized))
+}
+
+func getMasterInterfaceIndex(conf *pluginConf) (index int, err error) {
+	families, err := masterRouteFamilies(conf)
+	if err != nil {
+		return index, err
+	}
+
+	handle, err := masterScanHandle(conf)
+	if err != nil {
+		return index, err
+	}
+	defer handle.Delete()
+
+	for _, family := range families {
+		routes, err := handle.RouteList(nil, family)
+		if err != nil {
+			return index, err
+		}
+
+		var defaultRoutes []netlink.Route
+
+		for _, route := range routes {
+			if route.Dst == nil {
+				defaultRoutes = append(defaultRoutes, route)
+			}
+		}
+
+		if len(defaultRoutes) == 0 {
+			continue
+		}
+
+		// Prefer the route with the lowest metric, the link with the lowest index among equal ones
+		sort.Slice(defaultRoutes, func(i, j int) bool {
+			if defaultRoutes[i].Priority != defaultRoutes[j].Priority {
+				return defaultRoutes[i].Priority < defaultRoutes[j].Priority
+			}
+
+			return defaultRoutes[i].LinkIndex < defaultRoutes[j].LinkIndex
+		})
+
+		return defaultRoutes[0].LinkIndex, nil
+	}
+
+	return index, fmt.Errorf("master index not found")
+}
+
+// masterRouteFamilies returns the address families of the default routes scanned for the master in the order of
+// preference. IPv6 routes are scanned if there is no IPv4 default route unless a family is forced.
+func masterRouteFamilies(conf *pluginConf) ([]int, error) {
+	switch conf.MasterFamily {
+	case "ipv4":
+		return []int{netlink.FAMILY_V4}, nil
+
+	case "ipv6":
+		return []int{netlink.FAMILY_V6}, nil
+
+	case "any":
+		return []int{netlink.FAMILY_V4, netlink.FAMILY_V6}, nil
+
+	case "":
+
+	default:
+		return nil, fmt.Errorf("invalid master family %q (must be \"ipv4\", \"ipv6\" or \"any\")", conf.MasterFamily)
+	}
+
+	if conf.RouteScanFamily == "" {
+		return []int{netlink.FAMILY_V4, netlink.FAMILY_V6}, nil
+	}
+
+	family, err := routeScanFamily(conf.RouteScanFamily)
+	if err != nil {
+		return nil, err
+	}
+
+	return []int{family}, nil
+}
+
+// masterScanHandle returns netlink handle for the namespace the master is scanned in: masterScanNetns if set, the
+// current namespace otherwise.
+func masterScanHandle(conf *pluginConf) (*netlink.Handle, error) {
+	if conf.MasterScanNetns == "" {
+		return newNetlinkHandle(netns.None())
+	}
+
+	nsHandle, err := netns.GetFromPath(conf.MasterScanNetns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open master scan netns %q: %v", conf.MasterScanNetns, err)
+	}
+	defer nsHandle.Close()
+
+	handle, err := newNetlinkHandle(nsHandle)
+	if err != nil {
+		return nil, fmt.Errorf("master scan netns %q: %v", conf.MasterScanNetns, err)
+	}
+
+	return handle, nil
+}
+
+func writeFileAtomic(path string, data []byte) (err error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			os.Remove(file.Name())
+		}
+	}()
+
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err = file.Chmod(0o644); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
 }
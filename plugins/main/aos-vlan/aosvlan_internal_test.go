@@ -18,17 +18,26 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
+	vlanlib "github.com/aoscloud/aos_cni_vlan/pkg/vlan"
 	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
 	types040 "github.com/containernetworking/cni/pkg/types/040"
+	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/containernetworking/plugins/pkg/testutils"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
 	"github.com/vishvananda/netns"
 
 	. "github.com/onsi/ginkgo"
@@ -42,9 +51,13 @@ import (
 var _ = Describe("Aos Vlan", func() {
 	const ifName string = "eth0"
 	var originalNS ns.NetNS
+	var tmpDir string
 	var err error
 
 	BeforeEach(func() {
+		tmpDir, err = os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
 		originalNS, err = testutils.NewNS()
 
 		Expect(err).NotTo(HaveOccurred())
@@ -87,32 +100,15 @@ var _ = Describe("Aos Vlan", func() {
 	AfterEach(func() {
 		err := netns.DeleteNamed(filepath.Base(originalNS.Path()))
 		Expect(err).NotTo(HaveOccurred())
+
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
 	})
 
 	It("aos-vlan add/check/delete", func() {
-		conf := `
-			{
-			   "name": "mynet",
-			   "cniVersion": "0.4.0",
-			   "type": "aos-vlan",
-			   "master": "br0",
-			   "vlanId": 100,
-			   "ifName": "aos-vlan"
-		   }`
-
-		args := &skel.CmdArgs{
-			ContainerID: "dummy",
-			Netns:       "dummy",
-			IfName:      "aos-vlan",
-			StdinData:   []byte(conf),
-		}
-
-		err = originalNS.Do(func(ns.NetNS) error {
-			defer GinkgoRecover()
+		args := vlanTestArgs(vlanTestConf(""))
 
-			result, _, err := testutils.CmdAddWithArgs(args, func() (err error) {
-				return cmdAdd(args)
-			})
+		inNetNS(originalNS, func() {
+			result, err := cniAdd(args)
 			Expect(err).NotTo(HaveOccurred())
 
 			r, err := types040.GetResult(result)
@@ -131,40 +127,25 @@ var _ = Describe("Aos Vlan", func() {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(link.Attrs().HardwareAddr).To(Equal(hwaddr))
 
-			_, _, err = testutils.CmdAddWithArgs(args, func() (err error) {
-				return cmdAdd(args)
-			})
+			_, err = cniAdd(args)
 			Expect(err).NotTo(HaveOccurred())
-
-			return err
 		})
-		Expect(err).NotTo(HaveOccurred())
-
-		err = originalNS.Do(func(ns.NetNS) error {
-			defer GinkgoRecover()
 
-			err = testutils.CmdCheckWithArgs(args, func() error {
-				return cmdCheck(args)
-			})
+		inNetNS(originalNS, func() {
+			err = cniCheck(args)
 			Expect(err).NotTo(HaveOccurred())
-
-			return err
 		})
 
-		err = originalNS.Do(func(ns.NetNS) error {
-			defer GinkgoRecover()
-
-			err = testutils.CmdDelWithArgs(args, func() error {
-				return cmdDel(args)
-			})
-			Expect(err).NotTo(HaveOccurred())
+		inNetNS(originalNS, func() {
+			// DEL is idempotent
+			for i := 0; i < 2; i++ {
+				err = cniDel(args)
+				Expect(err).NotTo(HaveOccurred())
+			}
 
 			_, err = netlink.LinkByName("aos-vlan")
-			Expect(err).NotTo(HaveOccurred())
-
-			return err
+			Expect(err).To(BeAssignableToTypeOf(netlink.LinkNotFoundError{}))
 		})
-		Expect(err).NotTo(HaveOccurred())
 	})
 
 	It("aos-vlan master interface name is missing in the configuration", func() {
@@ -178,27 +159,195 @@ var _ = Describe("Aos Vlan", func() {
 			   "ifName": "aos-vlan"
 		   }`
 
-		args := &skel.CmdArgs{
-			ContainerID: "dummy",
-			Netns:       "dummy",
-			IfName:      "aos-vlan",
-			StdinData:   []byte(conf),
+		args := vlanTestArgs(conf)
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("aos-vlan master link is down", func() {
+		args := vlanTestArgs(vlanTestConf(""))
+
+		inNetNS(originalNS, func() {
+			dummy, err := netlink.LinkByName(ifName)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = netlink.LinkSetDown(dummy)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = cniAdd(args)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("aos-vlan master index is resolved with the configured route scan family", func() {
+		inNetNS(originalNS, func() {
+			link, err := netlink.LinkByName(ifName)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, family := range []string{"v4", "all"} {
+				index, err := getMasterInterfaceIndex(&pluginConf{RouteScanFamily: family})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(index).To(Equal(link.Attrs().Index))
+			}
+
+			// There is no IPv6 default route in the test namespace
+			_, err = getMasterInterfaceIndex(&pluginConf{RouteScanFamily: "v6"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("aos-vlan writes MAC file", func() {
+		macFile := filepath.Join(tmpDir, "aos-vlan.mac")
+
+		args := vlanTestArgs(vlanTestConf(fmt.Sprintf(`"macFile": %q`, macFile)))
+
+		inNetNS(originalNS, func() {
+			result, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			r, err := types040.GetResult(result)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(r.Interfaces)).To(Equal(1))
+
+			content, err := os.ReadFile(macFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(r.Interfaces[0].Mac + "\n"))
+		})
+	})
+
+	It("aos-vlan rejects loopback parent", func() {
+		args := vlanTestArgs(vlanTestConf(""))
+
+		inNetNS(originalNS, func() {
+			err = execCmd("ip", "link", "set", "lo", "up")
+			Expect(err).NotTo(HaveOccurred())
+
+			err = execCmd("ip", "route", "replace", "default", "dev", "lo")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err := cniAdd(args)
+			Expect(err).To(MatchError(ContainSubstring("loopback")))
+
+			_, err = netlink.LinkByName("aos-vlan")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("aos-vlan sets IPv6 traffic class", func() {
+		if _, err := exec.LookPath("tc"); err != nil {
+			Skip("tc tool is not available")
 		}
 
-		err = originalNS.Do(func(ns.NetNS) error {
-			defer GinkgoRecover()
+		args := vlanTestArgs(vlanTestConf(`"ipv6TrafficClass": 184`))
 
-			_, _, err := testutils.CmdAddWithArgs(args, func() (err error) {
-				return cmdAdd(args)
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			output, err := exec.Command("tc", "filter", "show", "dev", "aos-vlan", "egress").CombinedOutput()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(output)).To(ContainSubstring("pedit"))
+		})
+	})
+
+	It("aos-vlan duplicate add with different VLAN ID", func() {
+		for _, recreate := range []bool{false, true} {
+			confWithID := func(vlanID int) string {
+				return fmt.Sprintf(`
+					{
+					   "name": "mynet",
+					   "cniVersion": "0.4.0",
+					   "type": "aos-vlan",
+					   "master": "br0",
+					   "vlanId": %d,
+					   "ifName": "aos-vlan",
+					   "recreateOnIdChange": %t
+				   }`, vlanID, recreate)
+			}
+
+			args := vlanTestArgs(confWithID(100))
+
+			inNetNS(originalNS, func() {
+				_, err := cniAdd(args)
+				Expect(err).NotTo(HaveOccurred())
+
+				args.StdinData = []byte(confWithID(200))
+
+				_, err = cniAdd(args)
+
+				vlan, lookupErr := vlanlib.ByName("aos-vlan")
+				Expect(lookupErr).NotTo(HaveOccurred())
+
+				if recreate {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(vlan.VlanId).To(Equal(200))
+				} else {
+					Expect(err).To(MatchError(ContainSubstring("already exists with VLAN ID 100")))
+					Expect(vlan.VlanId).To(Equal(100))
+				}
+
+				Expect(netlink.LinkDel(vlan)).To(Succeed())
 			})
-			Expect(err).To(HaveOccurred())
+		}
+	})
 
-			return err
+	It("aos-vlan reports bridge port state", func() {
+		eventFile := filepath.Join(tmpDir, "events")
+
+		args := vlanTestArgs(vlanTestConf(fmt.Sprintf(`"eventFile": %q`, eventFile)))
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			// STP is disabled on the test bridge, so a fresh port goes straight to forwarding
+			link, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			state, err := bridgePortState(link)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(state).To(Equal("forwarding"))
+
+			content, err := os.ReadFile(eventFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(ContainSubstring(`"bridgePortState":"forwarding"`))
 		})
-		Expect(err).To(HaveOccurred())
 	})
 
-	It("aos-vlan master link is down", func() {
+	It("aos-vlan selects parent by link type", func() {
+		args := vlanTestArgs(vlanTestConf(`"parentType": "bridge"`))
+
+		inNetNS(originalNS, func() {
+			parent, err := createBridge("br1", "22.3.0.1/16")
+			Expect(err).NotTo(HaveOccurred())
+
+			// Down links are not considered
+			err = execCmd("ip", "link", "add", "name", "br2", "type", "bridge")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			vlan, err := vlanlib.ByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vlan.ParentIndex).To(Equal(parent.Attrs().Index))
+
+			err = netlink.LinkDel(vlan)
+			Expect(err).NotTo(HaveOccurred())
+
+			// Selection must be unique
+			err = execCmd("ip", "link", "set", "br2", "up")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = cniAdd(args)
+			Expect(err).To(MatchError(ContainSubstring("multiple up links")))
+		})
+	})
+
+	It("aos-vlan creates link with normalized name", func() {
 		conf := `
 			{
 			   "name": "mynet",
@@ -206,53 +355,2446 @@ var _ = Describe("Aos Vlan", func() {
 			   "type": "aos-vlan",
 			   "master": "br0",
 			   "vlanId": 100,
-			   "ifName": "aos-vlan"
+			   "ifName": "aos vlan:100/with-long-name",
+			   "normalizeName": true
 		   }`
 
-		args := &skel.CmdArgs{
-			ContainerID: "dummy",
-			Netns:       "dummy",
-			IfName:      "aos-vlan",
-			StdinData:   []byte(conf),
+		args := vlanTestArgs(conf)
+
+		inNetNS(originalNS, func() {
+			result, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			r, err := types040.GetResult(result)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(r.Interfaces)).To(Equal(1))
+			Expect(r.Interfaces[0].Name).To(Equal("aos_vlan_100_wi"))
+
+			_, err = vlanlib.ByName("aos_vlan_100_wi")
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	It("aos-vlan clamps MTU to probed path MTU", func() {
+		originalProbe := pathMtuProbe
+		defer func() { pathMtuProbe = originalProbe }()
+
+		pathMtuProbe = func(gateway net.IP, ifName string, size int) bool {
+			return gateway.Equal(net.ParseIP("22.2.0.254")) && ifName == "aos-vlan" && size <= 1400
 		}
 
-		err = originalNS.Do(func(ns.NetNS) error {
-			defer GinkgoRecover()
+		args := vlanTestArgs(vlanTestConf(`
+			"probeMtu": true,
+			"prevResult": {
+			"cniVersion": "0.4.0",
+			"ips": [{"version": "4", "address": "22.2.0.2/16", "gateway": "22.2.0.254"}]
+			}`))
 
-			dummy, err := netlink.LinkByName(ifName)
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
 			Expect(err).NotTo(HaveOccurred())
 
-			err = netlink.LinkSetDown(dummy)
+			link, err := netlink.LinkByName("aos-vlan")
 			Expect(err).NotTo(HaveOccurred())
+			Expect(link.Attrs().MTU).To(Equal(1400))
+		})
+	})
 
-			_, _, err = testutils.CmdAddWithArgs(args, func() (err error) {
-				return cmdAdd(args)
+	It("aos-vlan check passes for down intended link", func() {
+		conf := vlanTestConf(`"noUp": true`)
+
+		args := vlanTestArgs(conf)
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			link, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link.Attrs().Flags & net.FlagUp).To(BeZero())
+
+			marker, ok := getVlanMarker(link)
+			Expect(ok).To(BeTrue())
+			Expect(marker.Up).To(BeFalse())
+
+			// CHECK gets fresh arguments without noUp and relies on the marker
+			args.StdinData = []byte(strings.Replace(conf, `"noUp": true`, `"noUp": false`, 1))
+
+			err = cniCheck(args)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	It("aos-vlan records group policy", func() {
+		policyFile := filepath.Join(tmpDir, "groups.json")
+
+		args := vlanTestArgs(vlanTestConf(fmt.Sprintf(`
+			"group": 5,
+			"groupPolicyFile": %q`, policyFile)))
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			link, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link.Attrs().Group).To(Equal(uint32(5)))
+
+			content, err := os.ReadFile(policyFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(MatchJSON(`{"aos-vlan": {"group": 5, "vlanId": 100}}`))
+		})
+	})
+
+	It("aos-vlan handles conflicting interface name in container namespace", func() {
+		targetNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(netns.DeleteNamed(filepath.Base(targetNS.Path()))).To(Succeed())
+		}()
+
+		err = targetNS.Do(func(ns.NetNS) error {
+			return netlink.LinkAdd(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}})
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, renameOnConflict := range []bool{false, true} {
+			conf := fmt.Sprintf(`
+				{
+				   "name": "mynet",
+				   "cniVersion": "0.4.0",
+				   "type": "aos-vlan",
+				   "vlanId": 100,
+				   "ifName": "aos-vlan",
+				   "inContainer": true,
+				   "renameOnConflict": %t,
+				   "stateDir": %q
+			   }`, renameOnConflict, filepath.Join(tmpDir, "state"))
+
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       targetNS.Path(),
+				IfName:      "eth0",
+				StdinData:   []byte(conf),
+			}
+
+			inNetNS(originalNS, func() {
+				result, err := cniAdd(args)
+
+				if !renameOnConflict {
+					Expect(err).To(MatchError(ContainSubstring(`interface "eth0" already exists`)))
+
+					vlan, err := vlanlib.ByName("aos-vlan")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(netlink.LinkDel(vlan)).To(Succeed())
+
+					return
+				}
+
+				Expect(err).NotTo(HaveOccurred())
+
+				r, err := types040.GetResult(result)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(len(r.Interfaces)).To(Equal(1))
+				Expect(r.Interfaces[0].Name).To(Equal("eth01"))
+				Expect(r.Interfaces[0].Sandbox).To(Equal(targetNS.Path()))
+
+				inNetNS(targetNS, func() {
+					_, err := vlanlib.ByName("eth01")
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				err = cniDel(args)
+				Expect(err).NotTo(HaveOccurred())
+
+				// DEL removes the renamed VLAN and keeps the conflicting interface
+				inNetNS(targetNS, func() {
+					_, err := netlink.LinkByName("eth0")
+					Expect(err).NotTo(HaveOccurred())
+
+					_, err = netlink.LinkByName("eth01")
+					Expect(err).To(HaveOccurred())
+				})
 			})
-			Expect(err).To(HaveOccurred())
+		}
+	})
 
-			return err
+	It("aos-vlan configures dual-stack IPAM addresses", func() {
+		for name, result := range map[string]string{
+			"ipam-v4": `{"cniVersion": "0.4.0", "ips": [{"version": "4", "address": "22.2.1.2/24"}]}`,
+			"ipam-v6": `{"cniVersion": "0.4.0", "ips": [{"version": "6", "address": "fd00::2/64"}]}`,
+		} {
+			err = os.WriteFile(filepath.Join(tmpDir, name), []byte("#!/bin/sh\necho '"+result+"'\n"), 0o755)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		os.Setenv("CNI_PATH", tmpDir)
+		defer os.Unsetenv("CNI_PATH")
+
+		args := vlanTestArgs(vlanTestConf(`
+			"ipamV4": {"type": "ipam-v4"},
+			"ipamV6": {"type": "ipam-v6"}`))
+
+		inNetNS(originalNS, func() {
+			result, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			r, err := types040.GetResult(result)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.IPs).To(HaveLen(2))
+
+			link, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, address := range []string{"22.2.1.2/24", "fd00::2/64"} {
+				addr, err := netlink.ParseAddr(address)
+				Expect(err).NotTo(HaveOccurred())
+
+				addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(addrs).To(ContainElement(WithTransform(func(a netlink.Addr) string {
+					return a.IPNet.String()
+				}, Equal(addr.IPNet.String()))))
+			}
 		})
-		Expect(err).To(HaveOccurred())
 	})
-})
 
-func createBridge(brName string, brIP string) (bridge *netlink.Bridge, err error) {
-	err = execCmd("ip", "link", "add", "name", brName, "type", "bridge")
-	if err != nil {
-		return nil, err
-	}
+	It("aos-vlan records container state", func() {
+		stateDir := filepath.Join(tmpDir, "state")
 
-	err = execCmd("ip", "link", "set", brName, "up")
-	if err != nil {
-		return nil, err
-	}
+		args := vlanTestArgs(vlanTestConf(fmt.Sprintf(`"stateDir": %q`, stateDir)))
 
-	err = execCmd("ip", "addr", "add", "dev", brName, brIP)
-	if err != nil {
-		return nil, err
-	}
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
 
-	return bridgeByName(brName)
+			vlan, err := vlanlib.ByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			states, err := loadContainerState(stateDir, "dummy")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(states).To(Equal(map[string]vlanState{"aos-vlan": {
+				ContainerID: "dummy", IfName: "aos-vlan", Master: "br0", Parent: ifName, VlanId: 100, Created: true,
+				IfIndex: vlan.Index, AttachmentIfName: "aos-vlan",
+			}}))
+
+			err = cniDel(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			states, err = loadContainerState(stateDir, "dummy")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(states).To(BeEmpty())
+
+			// DEL leaves no state and lock files behind
+			entries, err := os.ReadDir(stateDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+		})
+	})
+
+	It("aos-vlan uses fallback name when name is taken by foreign link", func() {
+		args := vlanTestArgs(vlanTestConf(`"fallbackIfName": "aos-vlan-fb"`))
+
+		inNetNS(originalNS, func() {
+			err := netlink.LinkAdd(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			result, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			r, err := types040.GetResult(result)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(r.Interfaces)).To(Equal(1))
+			Expect(r.Interfaces[0].Name).To(Equal("aos-vlan-fb"))
+
+			vlan, err := vlanlib.ByName("aos-vlan-fb")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vlan.VlanId).To(Equal(100))
+
+			err = cniCheck(args)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	It("aos-vlan attaches and detaches shared VLAN", func() {
+		args := vlanTestArgs(vlanTestConf(`
+			"shared": true,
+			"deleteOnDel": true`))
+
+		inNetNS(originalNS, func() {
+			// Shared VLAN must exist
+			_, err := cniAdd(args)
+			Expect(err).To(HaveOccurred())
+
+			parent, err := netlink.LinkByName(ifName)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = netlink.LinkAdd(&netlink.Vlan{
+				LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan", ParentIndex: parent.Attrs().Index},
+				VlanId:    100,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			br, err := bridgeByName("br0")
+			Expect(err).NotTo(HaveOccurred())
+
+			vlan, err := vlanlib.ByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vlan.MasterIndex).To(Equal(br.Attrs().Index))
+
+			err = cniDel(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			vlan, err = vlanlib.ByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vlan.MasterIndex).To(BeZero())
+		})
+	})
+
+	It("aos-vlan rejects VLAN ID used on another parent", func() {
+		conf := `
+			{
+			   "name": "mynet",
+			   "cniVersion": "0.4.0",
+			   "type": "aos-vlan",
+			   "master": "br0",
+			   "vlanId": 100,
+			   "ifName": "aos-vlan",
+			   "globallyUniqueVlanId": %v
+		   }`
+
+		inNetNS(originalNS, func() {
+			other := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth1"}}
+
+			err := netlink.LinkAdd(other)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = netlink.LinkAdd(&netlink.Vlan{
+				LinkAttrs: netlink.LinkAttrs{Name: "other-vlan", ParentIndex: other.Attrs().Index},
+				VlanId:    100,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			args := vlanTestArgs(fmt.Sprintf(conf, true))
+
+			_, err = cniAdd(args)
+			Expect(err).To(HaveOccurred())
+
+			_, err = netlink.LinkByName("aos-vlan")
+			Expect(err).To(HaveOccurred())
+
+			args.StdinData = []byte(fmt.Sprintf(conf, false))
+
+			_, err = cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	It("aos-vlan applies multi-VLAN policy", func() {
+		// VLAN 101 fails as its name is taken by the parent dummy link
+		conf := `
+			{
+			   "name": "mynet",
+			   "cniVersion": "0.4.0",
+			   "type": "aos-vlan",
+			   "master": "br0",
+			   "vlans": [
+			      {"vlanId": 100, "ifName": "aos-vlan0"},
+			      {"vlanId": 101, "ifName": "eth0"},
+			      {"vlanId": 102, "ifName": "aos-vlan2"}
+			   ],
+			   "multiVlanPolicy": "%s",
+			   "deleteOnDel": true
+		   }`
+
+		inNetNS(originalNS, func() {
+			args := vlanTestArgs(fmt.Sprintf(conf, "atomic"))
+
+			_, err := cniAdd(args)
+			Expect(err).To(HaveOccurred())
+
+			// The VLAN created before the failure is rolled back
+			_, err = netlink.LinkByName("aos-vlan0")
+			Expect(err).To(HaveOccurred())
+
+			_, err = netlink.LinkByName("aos-vlan2")
+			Expect(err).To(HaveOccurred())
+
+			args.StdinData = []byte(fmt.Sprintf(conf, "bestEffort"))
+
+			r, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			result, err := current.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Interfaces).To(HaveLen(2))
+			Expect(result.Interfaces[0].Name).To(Equal("aos-vlan0"))
+			Expect(result.Interfaces[1].Name).To(Equal("aos-vlan2"))
+
+			for _, name := range []string{"aos-vlan0", "aos-vlan2"} {
+				vlan, err := vlanlib.ByName(name)
+				Expect(err).NotTo(HaveOccurred())
+
+				br, err := bridgeByName("br0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(vlan.MasterIndex).To(Equal(br.Attrs().Index))
+			}
+
+			err = cniCheck(args)
+			Expect(err).To(HaveOccurred())
+
+			err = cniDel(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, name := range []string{"aos-vlan0", "aos-vlan2"} {
+				_, err = netlink.LinkByName(name)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	It("aos-vlan sets VLAN loose binding flag", func() {
+		args := vlanTestArgs(vlanTestConf(`"looseBinding": true`))
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			vlan, err := vlanlib.ByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			flags, err := getVlanFlags(vlan)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(flags & vlanFlagLooseBinding).To(Equal(uint32(vlanFlagLooseBinding)))
+			// Reorder header is on by default and must not be changed
+			Expect(flags & vlanFlagReorderHdr).To(Equal(uint32(vlanFlagReorderHdr)))
+		})
+	})
+
+	It("aos-vlan re-adds existing VLAN with flags and QoS maps", func() {
+		args := vlanTestArgs(vlanTestConf(`
+			"looseBinding": true,
+			"egressQos": [{"from": 3, "to": 5}]`))
+
+		inNetNS(originalNS, func() {
+			for i := 0; i < 2; i++ {
+				_, err := cniAdd(args)
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			vlan, err := vlanlib.ByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			flags, err := getVlanFlags(vlan)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(flags & vlanFlagLooseBinding).To(Equal(uint32(vlanFlagLooseBinding)))
+
+			egress, err := getVlanQosMap(vlan, nl.IFLA_VLAN_EGRESS_QOS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(egress).To(Equal(map[uint32]uint32{3: 5}))
+		})
+	})
+
+	It("aos-vlan tears down resources in order", func() {
+		ipamResult := `{"cniVersion": "0.4.0", "ips": [{"version": "4", "address": "22.2.1.2/24"}], ` +
+			`"routes": [{"dst": "10.10.0.0/16", "gw": "22.2.1.1"}]}`
+
+		err = os.WriteFile(filepath.Join(tmpDir, "ipam"), []byte("#!/bin/sh\necho '"+ipamResult+"'\n"), 0o755)
+		Expect(err).NotTo(HaveOccurred())
+
+		os.Setenv("CNI_PATH", tmpDir)
+		defer os.Unsetenv("CNI_PATH")
+
+		stateDir := filepath.Join(tmpDir, "state")
+
+		conf := fmt.Sprintf(`
+			{
+			   "name": "mynet",
+			   "cniVersion": "0.4.0",
+			   "type": "aos-vlan",
+			   "master": "br0",
+			   "vlanId": 100,
+			   "ifName": "aos-vlan",
+			   "ipam": {"type": "ipam"},
+			   "deleteOnDel": true,
+			   "stateDir": %q
+			   %%s
+		   }`, stateDir)
+
+		args := vlanTestArgs(fmt.Sprintf(conf, ""))
+
+		var trace []string
+
+		originalTrace := teardownTrace
+		teardownTrace = func(step string) { trace = append(trace, step) }
+
+		defer func() { teardownTrace = originalTrace }()
+
+		inNetNS(originalNS, func() {
+			result, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			prevResult, err := json.Marshal(result)
+			Expect(err).NotTo(HaveOccurred())
+
+			link, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			routes, err := netlink.RouteList(link, netlink.FAMILY_V4)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(routes).To(ContainElement(WithTransform(func(route netlink.Route) string {
+				return route.Dst.String()
+			}, Equal("10.10.0.0/16"))))
+
+			args.StdinData = []byte(fmt.Sprintf(conf, `, "prevResult": `+string(prevResult)))
+
+			err = cniDel(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(trace).To(Equal([]string{"routes", "addresses", "ipam", "bridge", "link", "state"}))
+
+			_, err = netlink.LinkByName("aos-vlan")
+			Expect(err).To(HaveOccurred())
+
+			_, err = os.Stat(filepath.Join(stateDir, "dummy.json"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+			// Repeated DEL tolerates absent resources
+			err = cniDel(args)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	It("aos-vlan reports logical interface name", func() {
+		args := vlanTestArgs(vlanTestConf(`"logicalName": "uplink-storage-network"`))
+
+		inNetNS(originalNS, func() {
+			r, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			result, err := current.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Interfaces).To(HaveLen(1))
+			Expect(result.Interfaces[0].Name).To(Equal("uplink-storage-network"))
+
+			_, err = vlanlib.ByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = netlink.LinkByName("uplink-storage-network")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("aos-vlan brings VLAN up after move to container namespace", func() {
+		targetNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(netns.DeleteNamed(filepath.Base(targetNS.Path()))).To(Succeed())
+		}()
+
+		for i, upAfterMove := range []bool{true, false} {
+			conf := fmt.Sprintf(`
+				{
+				   "name": "mynet",
+				   "cniVersion": "0.4.0",
+				   "type": "aos-vlan",
+				   "vlanId": %d,
+				   "ifName": "aos-vlan",
+				   "inContainer": true,
+				   "upAfterMove": %t
+			   }`, 100+i, upAfterMove)
+
+			args := &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       targetNS.Path(),
+				IfName:      fmt.Sprintf("eth%d", i),
+				StdinData:   []byte(conf),
+			}
+
+			inNetNS(originalNS, func() {
+				updates := make(chan netlink.LinkUpdate, 100)
+				done := make(chan struct{})
+
+				Expect(netlink.LinkSubscribe(updates, done)).To(Succeed())
+
+				_, err := cniAdd(args)
+				Expect(err).NotTo(HaveOccurred())
+
+				upInHost := false
+
+			collect:
+				for {
+					select {
+					case update := <-updates:
+						if update.Attrs().Name == "aos-vlan" && update.Attrs().Flags&net.FlagUp != 0 {
+							upInHost = true
+						}
+
+					case <-time.After(200 * time.Millisecond):
+						break collect
+					}
+				}
+
+				close(done)
+
+				Expect(upInHost).To(Equal(!upAfterMove))
+
+				inNetNS(targetNS, func() {
+					vlan, err := vlanlib.ByName(args.IfName)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(vlan.Flags & net.FlagUp).To(Equal(net.FlagUp))
+				})
+			})
+		}
+	})
+
+	It("aos-vlan maps DSCP to PCP", func() {
+		args := vlanTestArgs(vlanTestConf(`"dscpToPcp": {"46": 5, "10": 1}`))
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			vlan, err := vlanlib.ByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			filters, err := netlink.FilterList(vlan, netlink.HANDLE_MIN_EGRESS)
+			Expect(err).NotTo(HaveOccurred())
+
+			prefs := make(map[uint16]int)
+
+			for _, filter := range filters {
+				if u32, ok := filter.(*netlink.U32); ok && u32.Sel != nil {
+					prefs[u32.Attrs().Priority]++
+				}
+			}
+
+			Expect(prefs[dscpToPcpPrefV4]).To(Equal(2))
+			Expect(prefs[dscpToPcpPrefV6]).To(Equal(2))
+		})
+	})
+	It("aos-vlan applies name collision policy", func() {
+		conf := `
+			{
+			   "name": "mynet",
+			   "cniVersion": "0.4.0",
+			   "type": "aos-vlan",
+			   "master": "br0",
+			   "vlanId": 100,
+			   "ifName": "br0.100",
+			   "nameCollisionPolicy": "%s"
+		   }`
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       "dummy",
+			IfName:      "br0.100",
+		}
+
+		inNetNS(originalNS, func() {
+			parent, err := netlink.LinkByName(ifName)
+			Expect(err).NotTo(HaveOccurred())
+
+			// Foreign device at the templated name is never adopted
+			err = netlink.LinkAdd(&netlink.Macvlan{
+				LinkAttrs: netlink.LinkAttrs{Name: "br0.100", ParentIndex: parent.Attrs().Index},
+				Mode:      netlink.MACVLAN_MODE_BRIDGE,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			args.StdinData = []byte(fmt.Sprintf(conf, nameCollisionAdopt))
+
+			_, err = cniAdd(args)
+			Expect(err).To(MatchError(ContainSubstring(`"br0.100" already exists and is a foreign macvlan device`)))
+
+			link, err := netlink.LinkByName("br0.100")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link.Type()).To(Equal("macvlan"))
+			Expect(netlink.LinkDel(link)).To(Succeed())
+
+			// Matching VLAN is adopted by default and rejected by the error policy
+			_, err = cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			args.StdinData = []byte(fmt.Sprintf(conf, nameCollisionError))
+
+			_, err = cniAdd(args)
+			Expect(err).To(MatchError(ContainSubstring(`name collision policy is "error"`)))
+		})
+	})
+	It("aos-vlan rewrites egress source MAC", func() {
+		if _, err := exec.LookPath("nft"); err != nil {
+			Skip("nft is not available")
+		}
+
+		args := vlanTestArgs(vlanTestConf(`
+			"deleteOnDel": true,
+			"egressSrcMac": "02:AA:BB:CC:DD:EE"`))
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			output, err := exec.Command("nft", "list", "chain", "bridge", nftTable,
+				egressSrcMacChain("aos-vlan")).CombinedOutput()
+			Expect(err).NotTo(HaveOccurred(), string(output))
+			Expect(string(output)).To(ContainSubstring(`oifname "aos-vlan" ether saddr set 02:aa:bb:cc:dd:ee`))
+
+			err = cniDel(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(exec.Command("nft", "list", "chain", "bridge", nftTable,
+				egressSrcMacChain("aos-vlan")).Run()).NotTo(Succeed())
+		})
+	})
+	It("aos-vlan clamps egress TCP MSS", func() {
+		if _, err := exec.LookPath("nft"); err != nil {
+			Skip("nft is not available")
+		}
+
+		args := vlanTestArgs(vlanTestConf(`
+			"mtu": 1400,
+			"mssClamp": "pmtu"`))
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			output, err := exec.Command("nft", "list", "chain", "bridge", nftTable,
+				mssClampChain("aos-vlan")).CombinedOutput()
+			Expect(err).NotTo(HaveOccurred(), string(output))
+			Expect(string(output)).To(ContainSubstring("tcp option maxseg size set 1360"))
+			Expect(string(output)).To(ContainSubstring("tcp option maxseg size set 1340"))
+
+			err = cniDel(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(exec.Command("nft", "list", "chain", "bridge", nftTable,
+				mssClampChain("aos-vlan")).Run()).NotTo(Succeed())
+		})
+	})
+	It("aos-vlan deletes on DEL replayed from the cache", func() {
+		stateDir := filepath.Join(tmpDir, "state")
+
+		conf := fmt.Sprintf(`
+			{
+			   "name": "mynet",
+			   "cniVersion": "1.0.0",
+			   "type": "aos-vlan",
+			   "master": "br0",
+			   "vlanId": 100,
+			   "ifName": "aos-vlan",
+			   "fallbackIfName": "aos-vlan-fb",
+			   "deleteOnDel": true,
+			   "stateDir": %q
+		   }`, stateDir)
+
+		args := vlanTestArgs(conf)
+
+		inNetNS(originalNS, func() {
+			foreign := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}}
+			Expect(netlink.LinkAdd(foreign)).To(Succeed())
+
+			_, output, err := testutils.CmdAddWithArgs(args, func() error {
+				return cmdAdd(args)
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = vlanlib.ByName("aos-vlan-fb")
+			Expect(err).NotTo(HaveOccurred())
+
+			// The foreign link is gone by the time the runtime replays DEL
+			Expect(netlink.LinkDel(foreign)).To(Succeed())
+
+			// Reconstruct DEL the way the runtime does from its cache: the ADD config with the ADD result as
+			// prevResult, the container ID and the interface name only
+			cachedConf := make(map[string]interface{})
+			Expect(json.Unmarshal([]byte(conf), &cachedConf)).To(Succeed())
+
+			cachedResult := make(map[string]interface{})
+			Expect(json.Unmarshal(output, &cachedResult)).To(Succeed())
+
+			cachedConf["prevResult"] = cachedResult
+
+			stdinData, err := json.Marshal(cachedConf)
+			Expect(err).NotTo(HaveOccurred())
+
+			delArgs := &skel.CmdArgs{
+				ContainerID: args.ContainerID,
+				IfName:      args.IfName,
+				StdinData:   stdinData,
+			}
+
+			err = cniDel(delArgs)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = netlink.LinkByName("aos-vlan-fb")
+			Expect(err).To(BeAssignableToTypeOf(netlink.LinkNotFoundError{}))
+
+			states, err := loadContainerState(stateDir, "dummy")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(states).To(BeEmpty())
+		})
+	})
+	It("aos-vlan creates multiple VLANs in batch", func() {
+		// VLAN 101 fails as its name is taken by the parent dummy link
+		conf := `
+			{
+			   "name": "mynet",
+			   "cniVersion": "0.4.0",
+			   "type": "aos-vlan",
+			   "master": "br0",
+			   "vlans": [
+			      {"vlanId": 100, "ifName": "aos-vlan0"},
+			      {"vlanId": 101, "ifName": "eth0"},
+			      {"vlanId": 102, "ifName": "aos-vlan2"}
+			   ],
+			   "looseBinding": true,
+			   "egressQos": [{"from": 3, "to": 5}],
+			   "batchCreate": true,
+			   "multiVlanPolicy": "%s",
+			   "deleteOnDel": true
+		   }`
+
+		inNetNS(originalNS, func() {
+			args := vlanTestArgs(fmt.Sprintf(conf, "atomic"))
+
+			_, err := cniAdd(args)
+			Expect(err).To(MatchError(ContainSubstring("failed to add VLAN 101 (eth0)")))
+
+			// The VLANs created in the batch after the failed one are rolled back as well
+			for _, name := range []string{"aos-vlan0", "aos-vlan2"} {
+				_, err = netlink.LinkByName(name)
+				Expect(err).To(HaveOccurred())
+			}
+
+			args.StdinData = []byte(fmt.Sprintf(conf, "bestEffort"))
+
+			r, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			result, err := current.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Interfaces).To(HaveLen(2))
+
+			br, err := bridgeByName("br0")
+			Expect(err).NotTo(HaveOccurred())
+
+			for i, name := range []string{"aos-vlan0", "aos-vlan2"} {
+				Expect(result.Interfaces[i].Name).To(Equal(name))
+
+				vlan, err := vlanlib.ByName(name)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(vlan.MasterIndex).To(Equal(br.Attrs().Index))
+
+				flags, err := getVlanFlags(vlan)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(flags & vlanFlagLooseBinding).To(Equal(uint32(vlanFlagLooseBinding)))
+
+				egress, err := getVlanQosMap(vlan, nl.IFLA_VLAN_EGRESS_QOS)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(egress).To(Equal(map[uint32]uint32{3: 5}))
+			}
+
+			err = cniDel(args)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+	It("aos-vlan reports drifted VLAN flags", func() {
+		args := vlanTestArgs(vlanTestConf(`
+			"reorderHeaders": false,
+			"looseBinding": true`))
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = cniCheck(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			vlan, err := vlanlib.ByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			// Clear loose binding out-of-band
+			Expect(setVlanFlags(vlan, 0, vlanFlagLooseBinding)).To(Succeed())
+
+			err = cniCheck(args)
+			Expect(err).To(MatchError(
+				"vlan link aos-vlan flag looseBinding configured true, current value is false"))
+		})
+	})
+	It("aos-vlan reconciles VLAN MTU with parent on check", func() {
+		args := vlanTestArgs(vlanTestConf(`"reconcileMtu": true`))
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			parent, err := netlink.LinkByName(ifName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetMTU(parent, 1400)).To(Succeed())
+
+			err = cniCheck(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			vlan, err := vlanlib.ByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vlan.MTU).To(Equal(1400))
+		})
+	})
+	It("aos-vlan derives VLAN name from parent", func() {
+		conf := `
+			{
+			   "name": "mynet",
+			   "cniVersion": "0.4.0",
+			   "type": "aos-vlan",
+			   "master": "br0",
+			   "vlanId": 100,
+			   "autoName": true,
+			   "deleteOnDel": true
+		   }`
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       "dummy",
+			IfName:      "eth0.100",
+			StdinData:   []byte(conf),
+		}
+
+		inNetNS(originalNS, func() {
+			r, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			result, err := current.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Interfaces[0].Name).To(Equal(ifName + ".100"))
+
+			vlan, err := vlanlib.ByName(ifName + ".100")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vlan.VlanId).To(Equal(100))
+
+			err = cniDel(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = netlink.LinkByName(ifName + ".100")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	It("aos-vlan deletes all container VLANs on DEL", func() {
+		conf := `
+			{
+			   "name": "mynet",
+			   "cniVersion": "0.4.0",
+			   "type": "aos-vlan",
+			   "master": "br0",
+			   "vlans": [%s],
+			   "stateDir": %q,
+			   "deleteOnDel": true
+		   }`
+
+		stateDir := filepath.Join(tmpDir, "state")
+		names := []string{"aos-vlan0", "aos-vlan1", "aos-vlan2"}
+
+		args := vlanTestArgs(fmt.Sprintf(conf, `{"vlanId": 100, "ifName": "aos-vlan0"}, `+
+			`{"vlanId": 101, "ifName": "aos-vlan1"}, {"vlanId": 102, "ifName": "aos-vlan2"}`, stateDir))
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			states, err := loadContainerState(stateDir, args.ContainerID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(states).To(HaveLen(3))
+
+			// DEL with a configuration listing only one VLAN removes all VLANs recorded for the container
+			args.StdinData = []byte(fmt.Sprintf(conf, `{"vlanId": 100, "ifName": "aos-vlan0"}`, stateDir))
+
+			for i := 0; i < 2; i++ {
+				err = cniDel(args)
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			for _, name := range names {
+				_, err = netlink.LinkByName(name)
+				Expect(err).To(HaveOccurred(), name)
+			}
+
+			states, err = loadContainerState(stateDir, args.ContainerID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(states).To(BeEmpty())
+		})
+	})
+
+	It("aos-vlan keeps foreign VLAN on multi-VLAN DEL", func() {
+		conf := `
+			{
+			   "name": "mynet",
+			   "cniVersion": "0.4.0",
+			   "type": "aos-vlan",
+			   "master": "br0",
+			   "vlans": [{"vlanId": 100, "ifName": "aos-vlan0"}, {"vlanId": 101, "ifName": "aos-vlan1"}],
+			   "deleteOnDel": true
+		   }`
+
+		args := vlanTestArgs(conf)
+
+		inNetNS(originalNS, func() {
+			parent, err := netlink.LinkByName(ifName)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(netlink.LinkAdd(&netlink.Vlan{
+				LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan1", ParentIndex: parent.Attrs().Index},
+				VlanId:    101,
+			})).To(Succeed())
+
+			// DEL after an ADD which never ran must not delete the VLAN set up by someone else
+			err = cniDel(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = netlink.LinkByName("aos-vlan1")
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	It("aos-vlan keeps VLAN on DEL with keepOnDel", func() {
+		args := vlanTestArgs(vlanTestConf(`"keepOnDel": true`))
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = cniDel(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			br, err := bridgeByName("br0")
+			Expect(err).NotTo(HaveOccurred())
+
+			vlan, err := vlanlib.ByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vlan.MasterIndex).To(Equal(br.Attrs().Index))
+		})
+	})
+	It("aos-vlan sets configured MTU", func() {
+		args := vlanTestArgs(vlanTestConf(`"mtu": 1400`))
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			vlan, err := vlanlib.ByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vlan.MTU).To(Equal(1400))
+
+			err = cniCheck(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(netlink.LinkSetMTU(vlan, 1300)).To(Succeed())
+
+			err = cniCheck(args)
+			Expect(err).To(MatchError("vlan link aos-vlan configured MTU is 1400, current value is 1300"))
+		})
+	})
+	It("aos-vlan inherits MTU from master", func() {
+		conf := `
+			{
+			   "name": "mynet",
+			   "cniVersion": "0.4.0",
+			   "type": "aos-vlan",
+			   "master": "br0",
+			   "vlanId": 100,
+			   "ifName": "aos-vlan"%s
+		   }`
+
+		args := vlanTestArgs(fmt.Sprintf(conf, ""))
+
+		inNetNS(originalNS, func() {
+			parent, err := netlink.LinkByName(ifName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetMTU(parent, 1400)).To(Succeed())
+
+			_, err = cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			vlan, err := vlanlib.ByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vlan.MTU).To(Equal(1400))
+
+			err = cniDel(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			args.StdinData = []byte(fmt.Sprintf(conf, `, "mtu": 1500`))
+
+			_, err = cniAdd(args)
+			Expect(err).To(MatchError(fmt.Sprintf("MTU 1500 exceeds parent %s MTU 1400", ifName)))
+
+			_, err = netlink.LinkByName("aos-vlan")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	It("aos-vlan sets static MAC", func() {
+		conf := vlanTestConf(`"mac": "02:aa:bb:cc:dd:ee"`)
+
+		args := vlanTestArgs(conf)
+
+		inNetNS(originalNS, func() {
+			r, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			result, err := current.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Interfaces[0].Mac).To(Equal("02:aa:bb:cc:dd:ee"))
+
+			vlan, err := vlanlib.ByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vlan.HardwareAddr.String()).To(Equal("02:aa:bb:cc:dd:ee"))
+
+			conf, _, err := parseConfig(args.StdinData)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(checkVlan(conf, args)).To(Succeed())
+
+			Expect(netlink.LinkSetHardwareAddr(vlan, net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01})).To(Succeed())
+			Expect(checkVlan(conf, args)).To(MatchError(
+				"vlan link aos-vlan configured MAC is 02:aa:bb:cc:dd:ee, current value is 02:00:00:00:00:01"))
+
+			err = cniDel(args)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+	It("aos-vlan creates and checks 802.1ad VLAN", func() {
+		args := vlanTestArgs(vlanTestConf(`"vlanProtocol": "802.1ad"`))
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			vlan, err := vlanlib.ByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vlan.VlanProtocol).To(Equal(netlink.VLAN_PROTOCOL_8021AD))
+
+			err = cniCheck(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			// Recreate the VLAN with 802.1Q out-of-band
+			Expect(netlink.LinkDel(vlan)).To(Succeed())
+			Expect(netlink.LinkAdd(&netlink.Vlan{
+				LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan", ParentIndex: vlan.ParentIndex},
+				VlanId:    100,
+			})).To(Succeed())
+
+			vlan, err = vlanlib.ByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetUp(vlan)).To(Succeed())
+
+			err = cniCheck(args)
+			Expect(err).To(MatchError("vlan link aos-vlan configured protocol is 802.1ad, current value is 802.1q"))
+		})
+	})
+	It("aos-vlan moves VLAN into container namespace with containerNS", func() {
+		targetNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(netns.DeleteNamed(filepath.Base(targetNS.Path()))).To(Succeed())
+		}()
+
+		conf := `
+			{
+			   "name": "mynet",
+			   "cniVersion": "0.4.0",
+			   "type": "aos-vlan",
+			   "vlanId": 100,
+			   "ifName": "aos-vlan",
+			   "containerNS": true
+		   }`
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNS.Path(),
+			IfName:      "eth0",
+			StdinData:   []byte(conf),
+		}
+
+		inNetNS(originalNS, func() {
+			r, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			result, err := current.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Interfaces).To(HaveLen(1))
+			Expect(result.Interfaces[0].Name).To(Equal("eth0"))
+			Expect(result.Interfaces[0].Sandbox).To(Equal(targetNS.Path()))
+
+			_, err = netlink.LinkByName("aos-vlan")
+			Expect(err).To(HaveOccurred())
+
+			inNetNS(targetNS, func() {
+				vlan, err := vlanlib.ByName("eth0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(vlan.Flags & net.FlagUp).To(Equal(net.FlagUp))
+			})
+
+			err = cniDel(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			inNetNS(targetNS, func() {
+				_, err := netlink.LinkByName("eth0")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+	It("aos-vlan checks VLAN in container namespace with containerNS", func() {
+		targetNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(netns.DeleteNamed(filepath.Base(targetNS.Path()))).To(Succeed())
+		}()
+
+		conf := `
+			{
+			   "name": "mynet",
+			   "cniVersion": "0.4.0",
+			   "type": "aos-vlan",
+			   "vlanId": 100,
+			   "ifName": "aos-vlan",
+			   "containerNS": true
+		   }`
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       targetNS.Path(),
+			IfName:      "eth0",
+			StdinData:   []byte(conf),
+		}
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = cniCheck(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = targetNS.Do(func(ns.NetNS) error {
+				link, err := netlink.LinkByName("eth0")
+				if err != nil {
+					return err
+				}
+
+				return netlink.LinkSetDown(link)
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = cniCheck(args)
+			Expect(err).To(MatchError("vlan link eth0 is down"))
+
+			Expect(cniDel(args)).To(Succeed())
+		})
+	})
+
+	It("aos-vlan rolls back the created VLAN on failure", func() {
+		args := vlanTestArgs(vlanTestConf(""))
+
+		inNetNS(originalNS, func() {
+			attachToBridge = func(netlink.Link, netlink.Link) (netlink.Link, error) {
+				return nil, errors.New("bridge is gone")
+			}
+			defer func() { attachToBridge = vlanlib.AttachToBridge }()
+
+			_, err := cniAdd(args)
+			Expect(err).To(MatchError(ContainSubstring("bridge is gone")))
+
+			_, err = netlink.LinkByName("aos-vlan")
+			Expect(err).To(BeAssignableToTypeOf(netlink.LinkNotFoundError{}))
+
+			// Pre-existing VLAN adopted by ADD is kept
+			parent, err := netlink.LinkByName(ifName)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(netlink.LinkAdd(&netlink.Vlan{
+				LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan", ParentIndex: parent.Attrs().Index},
+				VlanId:    100,
+			})).To(Succeed())
+
+			_, err = cniAdd(args)
+			Expect(err).To(MatchError(ContainSubstring("bridge is gone")))
+
+			_, err = netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+	It("aos-vlan CHECK fails for VLAN detached from bridge", func() {
+		args := vlanTestArgs(vlanTestConf(""))
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			vlan, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetNoMaster(vlan)).To(Succeed())
+
+			err = cniCheck(args)
+			Expect(err).To(MatchError(ContainSubstring("vlan link aos-vlan is not attached to bridge br0")))
+
+			err = cniDel(args)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+	It("aos-vlan reports requested and effective VLAN flags", func() {
+		conf := `
+			{
+			   "name": "mynet",
+			   "cniVersion": "0.4.0",
+			   "type": "aos-vlan",
+			   "master": "br0",
+			   "vlanId": 100,
+			   "ifName": "aos-vlan",
+			   "looseBinding": true,
+			   "reorderHeaders": false,
+			   "eventFile": "%s"
+		   }`
+
+		eventFile := filepath.Join(tmpDir, "events")
+
+		args := vlanTestArgs(fmt.Sprintf(conf, eventFile))
+
+		Expect(os.Setenv(vlanFlagsDebugEnv, "1")).To(Succeed())
+		defer os.Unsetenv(vlanFlagsDebugEnv)
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			content, err := os.ReadFile(eventFile)
+			Expect(err).NotTo(HaveOccurred())
+
+			var event pluginEvent
+
+			Expect(json.Unmarshal(content, &event)).To(Succeed())
+			Expect(event.VlanFlags).To(Equal([]vlanAttrReport{
+				{Name: "reorderHeaders", Requested: "false", Effective: "false"},
+				{Name: "looseBinding", Requested: "true", Effective: "true"},
+				{Name: "vlanProtocol", Requested: "802.1q", Effective: "802.1q"},
+			}))
+		})
+	})
+	It("aos-vlan validates bridge VLAN entries", func() {
+		for bridgeVlan, expectedErr := range map[string]string{
+			`{"pvid": 4095}`:                     "invalid bridge VLAN ID 4095",
+			`{"tagged": [0]}`:                    "invalid bridge VLAN ID 0",
+			`{"pvid": 10, "untagged": [10]}`:     "duplicate bridge VLAN ID 10",
+			`{"tagged": [20], "untagged": [20]}`: "duplicate bridge VLAN ID 20",
+		} {
+			_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+				"bridgeVlan": ` + bridgeVlan + `}`))
+			Expect(err).To(MatchError(ContainSubstring(expectedErr)), bridgeVlan)
+		}
+
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"bridgeVlan": {"pvid": 10}, "vlanFilterPolicy": "merge"}`))
+		Expect(err).To(MatchError(ContainSubstring(`"bridgeVlan" can't be combined with "vlanFilterPolicy"`)))
+
+		conf, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"bridgeVlan": {"pvid": 10, "tagged": [20, 21], "untagged": [30]}}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.BridgeVlan).To(Equal(&bridgeVlanConf{Pvid: 10, Tagged: []int{20, 21}, Untagged: []int{30}}))
+	})
+	It("aos-vlan sets and checks VLAN QoS maps", func() {
+		args := vlanTestArgs(vlanTestConf(`
+			"ingressQos": [{"from": 5, "to": 3}, {"from": 6, "to": 0}],
+			"egressQos": [{"from": 3, "to": 5}, {"from": 4, "to": 6}]`))
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			vlan, err := vlanlib.ByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			ingress, err := getVlanQosMap(vlan, nl.IFLA_VLAN_INGRESS_QOS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ingress).To(Equal(map[uint32]uint32{5: 3}))
+
+			egress, err := getVlanQosMap(vlan, nl.IFLA_VLAN_EGRESS_QOS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(egress).To(Equal(map[uint32]uint32{3: 5, 4: 6}))
+
+			parsedConf, _, err := parseConfig(args.StdinData)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(checkVlanQos(parsedConf, vlan)).To(Succeed())
+
+			Expect(setVlanEgressQosMap(vlan, map[uint32]uint32{4: 2})).To(Succeed())
+			Expect(checkVlanQos(parsedConf, vlan)).To(MatchError(
+				"vlan link aos-vlan egress QoS map of 4 configured 6, current value is 2"))
+		})
+	})
+
+	It("aos-vlan reports parent and bridge with reportTopology", func() {
+		conf := `
+			{
+			   "name": "mynet",
+			   "cniVersion": "1.0.0",
+			   "type": "aos-vlan",
+			   "master": "br0",
+			   "vlanId": 100,
+			   "ifName": "aos-vlan",
+			   "ipAddresses": ["22.2.100.10/16"],
+			   "reportTopology": true
+		   }`
+
+		args := vlanTestArgs(conf)
+
+		inNetNS(originalNS, func() {
+			r, err := cniAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			result, err := current.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+
+			parent, err := netlink.LinkByName(ifName)
+			Expect(err).NotTo(HaveOccurred())
+
+			br, err := netlink.LinkByName("br0")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result.Interfaces).To(Equal([]*current.Interface{
+				{Name: "aos-vlan", Mac: result.Interfaces[0].Mac},
+				{Name: ifName, Mac: resultMac(parent.Attrs().HardwareAddr)},
+				{Name: "br0", Mac: resultMac(br.Attrs().HardwareAddr)},
+			}))
+
+			Expect(result.IPs).To(HaveLen(1))
+			Expect(*result.IPs[0].Interface).To(Equal(0))
+		})
+	})
+})
+
+var _ = Describe("Aos Vlan veth parent", func() {
+	var originalNS ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+
+		originalNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		inNetNS(originalNS, func() {
+			Expect(createVethParent("eth0", "eth0-peer", "172.17.0.2/16", "172.17.0.1")).To(Succeed())
+
+			_, err := createBridge("br0", "22.2.0.1/16")
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	AfterEach(func() {
+		Expect(originalNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(originalNS)).To(Succeed())
+	})
+
+	It("aos-vlan add/check/delete on veth parent", func() {
+		args := vlanTestArgs(vlanTestConf(""))
+
+		inNetNS(originalNS, func() {
+			_, err := cniAdd(args)
+			if err != nil && strings.Contains(err.Error(), "operation not supported") {
+				Skip("VLAN links are not available")
+			}
+
+			Expect(err).NotTo(HaveOccurred())
+
+			parent, err := netlink.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+
+			br, err := bridgeByName("br0")
+			Expect(err).NotTo(HaveOccurred())
+
+			vlan, err := vlanlib.ByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vlan.VlanId).To(Equal(100))
+			Expect(vlan.ParentIndex).To(Equal(parent.Attrs().Index))
+			Expect(vlan.MasterIndex).To(Equal(br.Attrs().Index))
+
+			Expect(cniCheck(args)).To(Succeed())
+
+			Expect(cniDel(args)).To(Succeed())
+
+			_, err = netlink.LinkByName("aos-vlan")
+			Expect(err).To(BeAssignableToTypeOf(netlink.LinkNotFoundError{}))
+		})
+	})
+
+	// expectRejected expects the existing aos-vlan link to be rejected on both the single-VLAN and batch paths.
+	expectRejected := func(expected string) {
+		existing, err := netlink.LinkByName("aos-vlan")
+		Expect(err).NotTo(HaveOccurred())
+
+		conf := &pluginConf{Master: "br0", VlanId: 100, IfName: "aos-vlan", Parent: "eth0"}
+
+		_, _, _, err = createVlan(conf)
+		Expect(err).To(MatchError(ContainSubstring(expected)))
+
+		links, errs := batchAddVlans([]*pluginConf{conf})
+		Expect(errors.Is(errs[0], syscall.EEXIST)).To(BeTrue(), fmt.Sprint(errs[0]))
+
+		_, _, err = addBatchedVlan(conf, links[0], errs[0])
+		Expect(err).To(MatchError(ContainSubstring(expected)))
+
+		link, err := netlink.LinkByName("aos-vlan")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(link.Attrs().Index).To(Equal(existing.Attrs().Index))
+	}
+
+	It("aos-vlan rejects existing link of another type on EEXIST", func() {
+		inNetNS(originalNS, func() {
+			Expect(netlink.LinkAdd(&netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}, PeerName: "aos-peer",
+			})).To(Succeed())
+
+			expectRejected("is a foreign veth device, not a vlan")
+		})
+	})
+
+	It("aos-vlan rejects existing vlan with another parent or VLAN ID on EEXIST", func() {
+		inNetNS(originalNS, func() {
+			for expected, parentName := range map[string]string{
+				"is a foreign vlan on parent index":                         "eth0-peer",
+				"already exists with VLAN ID 200, requested VLAN ID is 100": "eth0",
+			} {
+				parent, err := netlink.LinkByName(parentName)
+				Expect(err).NotTo(HaveOccurred())
+
+				vlanId := 100
+				if parentName == "eth0" {
+					vlanId = 200
+				}
+
+				if err := netlink.LinkAdd(&netlink.Vlan{
+					LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan", ParentIndex: parent.Attrs().Index}, VlanId: vlanId,
+				}); err != nil {
+					Skip(fmt.Sprintf("Can't create vlan: %v", err))
+				}
+
+				expectRejected(expected)
+
+				existing, err := netlink.LinkByName("aos-vlan")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(netlink.LinkDel(existing)).To(Succeed())
+			}
+		})
+	})
+
+	It("aos-vlan check returns CNI errors with plugin codes for the master", func() {
+		args := vlanTestArgs(vlanTestConf(""))
+
+		errorCode := func(err error) uint {
+			var cniErr *types.Error
+
+			Expect(errors.As(err, &cniErr)).To(BeTrue(), err.Error())
+
+			return cniErr.Code
+		}
+
+		err := originalNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			_, err := cniAdd(args)
+			if err != nil && strings.Contains(err.Error(), "operation not supported") {
+				Skip("VLAN links are not available")
+			}
+
+			Expect(err).NotTo(HaveOccurred())
+
+			br, err := netlink.LinkByName("br0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkDel(br)).To(Succeed())
+
+			err = cniCheck(args)
+			Expect(errorCode(err)).To(Equal(errMasterMissing))
+
+			err = netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "br0"}, PeerName: "br0-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = cniCheck(args)
+			Expect(errorCode(err)).To(Equal(errMasterNotBridge))
+
+			return cniDel(args)
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("Aos Vlan helpers", func() {
+	It("aos-vlan route scan family", func() {
+		for family, expected := range map[string]int{
+			"":    netlink.FAMILY_V4,
+			"v4":  netlink.FAMILY_V4,
+			"v6":  netlink.FAMILY_V6,
+			"all": netlink.FAMILY_ALL,
+		} {
+			value, err := routeScanFamily(family)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal(expected))
+		}
+
+		_, err := routeScanFamily("ipx")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("aos-vlan IPv6 traffic class is out of range", func() {
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "ipv6TrafficClass": 256}`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("aos-vlan IPv6 traffic class is not supported in shared and inContainer modes", func() {
+		for _, mode := range []string{"shared", "inContainer"} {
+			_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "` + mode +
+				`": true, "ipv6TrafficClass": 184}`))
+			Expect(err).To(MatchError(ContainSubstring(`"ipv6TrafficClass"`)), mode)
+		}
+	})
+
+	It("aos-vlan normalizes interface names", func() {
+		for name, expected := range map[string]string{
+			"aos-vlan":                   "aos-vlan",
+			"aos vlan/100":               "aos_vlan_100",
+			"aos:vlan\t100":              "aos_vlan_100",
+			"aos-vlan-very-long-name":    "aos-vlan-very-l",
+			"..":                         "__",
+			"vlan-ünicode-näme-tooolong": "vlan-ünicode-n",
+		} {
+			Expect(normalizeIfName(name)).To(Equal(expected), name)
+		}
+	})
+
+	It("aos-vlan validates static MAC", func() {
+		for mac, valid := range map[string]bool{
+			"0A:BC:DE:F0:12:34":       true,
+			"02:00:00:00:00:01":       true,
+			"01:00:5e:00:00:01":       false,
+			"ff:ff:ff:ff:ff:ff":       false,
+			"0a:bc:de:f0:12":          false,
+			"00:00:00:00:fe:80:00:00": false,
+		} {
+			_, _, err := parseConfig([]byte(fmt.Sprintf(
+				`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "mac": %q}`, mac)))
+			if valid {
+				Expect(err).NotTo(HaveOccurred(), mac)
+			} else {
+				Expect(err).To(HaveOccurred(), mac)
+			}
+		}
+
+		conf, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"mac": "0A:BC:DE:F0:12:34"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.Mac).To(Equal("0a:bc:de:f0:12:34"))
+	})
+
+	It("aos-vlan normalizes result MAC", func() {
+		Expect(resultMac(net.HardwareAddr{0x0A, 0xBC, 0xDE, 0xF0, 0x12, 0x34})).To(Equal("0a:bc:de:f0:12:34"))
+		Expect(resultMac(net.HardwareAddr{0, 0, 0, 0, 0, 0})).To(BeEmpty())
+		Expect(resultMac(nil)).To(BeEmpty())
+	})
+
+	It("aos-vlan scans master in masterScanNetns", func() {
+		scanNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(scanNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(scanNS)).To(Succeed())
+		}()
+
+		var scanIndex int
+
+		inNetNS(scanNS, func() {
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "scan0"}, PeerName: "scan1"})
+			Expect(err).NotTo(HaveOccurred())
+
+			link, err := netlink.LinkByName("scan0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetUp(link)).To(Succeed())
+
+			addr, err := netlink.ParseAddr("10.2.0.2/24")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.AddrAdd(link, addr)).To(Succeed())
+
+			Expect(netlink.RouteAdd(&netlink.Route{
+				LinkIndex: link.Attrs().Index,
+				Gw:        net.ParseIP("10.2.0.1"),
+			})).To(Succeed())
+
+			scanIndex = link.Attrs().Index
+		})
+
+		emptyNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(emptyNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(emptyNS)).To(Succeed())
+		}()
+
+		inNetNS(emptyNS, func() {
+			_, err := getMasterInterfaceIndex(&pluginConf{})
+			Expect(err).To(HaveOccurred())
+
+			index, err := getMasterInterfaceIndex(&pluginConf{MasterScanNetns: scanNS.Path()})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(scanIndex))
+		})
+	})
+
+	It("aos-vlan resolves parent scanned in masterScanNetns in the current namespace", func() {
+		indices := make(map[string]map[string]int)
+
+		// The links are added in reverse order, so the scan index refers to another link in the current namespace
+		addLinks := func(netNS ns.NetNS, names ...string) {
+			indices[netNS.Path()] = make(map[string]int)
+
+			inNetNS(netNS, func() {
+				for _, name := range names {
+					Expect(netlink.LinkAdd(&netlink.Veth{
+						LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: name + "p",
+					})).To(Succeed())
+
+					link, err := netlink.LinkByName(name)
+					Expect(err).NotTo(HaveOccurred())
+
+					indices[netNS.Path()][name] = link.Attrs().Index
+				}
+			})
+		}
+
+		scanNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(scanNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(scanNS)).To(Succeed())
+		}()
+
+		localNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(localNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(localNS)).To(Succeed())
+		}()
+
+		addLinks(scanNS, "pad0", "scan0")
+		addLinks(localNS, "scan0", "pad0")
+
+		Expect(indices[scanNS.Path()]["scan0"]).To(Equal(indices[localNS.Path()]["pad0"]))
+
+		// The only up veth in the scan namespace is the parent found by type
+		err = scanNS.Do(func(ns.NetNS) error {
+			link, err := netlink.LinkByName("scan0")
+			if err != nil {
+				return err
+			}
+
+			return netlink.LinkSetUp(link)
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		inNetNS(localNS, func() {
+			index, err := resolveParentIndex(&pluginConf{Parent: "scan0", MasterScanNetns: scanNS.Path()})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(indices[localNS.Path()]["scan0"]))
+
+			index, err = resolveParentIndex(&pluginConf{
+				ParentType: "veth", MasterScanNetns: scanNS.Path(), MasterStrategyOrder: []string{masterStrategyParent},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(indices[localNS.Path()]["scan0"]))
+
+			link, err := netlink.LinkByName("scan0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkDel(link)).To(Succeed())
+
+			_, err = resolveParentIndex(&pluginConf{Parent: "scan0", MasterScanNetns: scanNS.Path()})
+			Expect(err).To(MatchError(ContainSubstring(`failed to lookup parent "scan0" found in master scan netns`)))
+		})
+	})
+
+	It("aos-vlan resolves explicit parent", func() {
+		scanNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(scanNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(scanNS)).To(Succeed())
+		}()
+
+		inNetNS(scanNS, func() {
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "uplink0"}, PeerName: "uplink1"})
+			Expect(err).NotTo(HaveOccurred())
+
+			link, err := netlink.LinkByName("uplink1")
+			Expect(err).NotTo(HaveOccurred())
+
+			// Parent is used as is, without a default route
+			index, err := resolveParentIndex(&pluginConf{Parent: "uplink1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(link.Attrs().Index))
+
+			_, err = resolveParentIndex(&pluginConf{Parent: "uplink2"})
+			Expect(err).To(HaveOccurred())
+		})
+
+		_, _, err = parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "parent": "eth0",
+			"parentType": "device"}`))
+		Expect(err).To(MatchError(
+			`"parent" can't be combined with "parentType", "masterSubnet" and "masterStrategyOrder"`))
+
+		_, _, err = parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "parent": "br0"}`))
+		Expect(err).To(MatchError(`parent "br0" can't be the master bridge`))
+	})
+
+	It("aos-vlan result IPs reference their interfaces", func() {
+		parseIP := func(cidr string) *current.IPConfig {
+			ip, ipNet, err := net.ParseCIDR(cidr)
+			Expect(err).NotTo(HaveOccurred())
+
+			ipNet.IP = ip
+
+			return &current.IPConfig{Address: *ipNet, Interface: current.Int(7)}
+		}
+
+		// Result passed by the previous plugin in the chain
+		result := current.Result{
+			Interfaces: []*current.Interface{{Name: "eth0"}},
+			IPs:        []*current.IPConfig{{Interface: current.Int(0)}},
+		}
+
+		firstIndex := appendInterface(&result, &current.Interface{Name: "aos-vlan0"})
+		appendInterfaceIPs(&result, firstIndex, &current.Result{
+			IPs: []*current.IPConfig{parseIP("10.0.0.2/24"), parseIP("fd00::2/64")},
+		})
+
+		secondIndex := appendInterface(&result, &current.Interface{Name: "aos-vlan1"})
+		appendInterfaceIPs(&result, secondIndex, &current.Result{
+			IPs: []*current.IPConfig{parseIP("10.1.0.2/24")},
+		})
+
+		Expect(result.IPs).To(HaveLen(4))
+
+		expected := []string{"eth0", "aos-vlan0", "aos-vlan0", "aos-vlan1"}
+
+		for i, ip := range result.IPs {
+			Expect(ip.Interface).NotTo(BeNil())
+			Expect(*ip.Interface).To(BeNumerically("<", len(result.Interfaces)))
+			Expect(result.Interfaces[*ip.Interface].Name).To(Equal(expected[i]))
+		}
+	})
+
+	It("aos-vlan validates DSCP to PCP map", func() {
+		for _, dscpToPcp := range []string{`{"64": 1}`, `{"-1": 1}`, `{"46": 8}`, `{"46": -1}`} {
+			_, _, err := parseConfig([]byte(`{"name": "mynet", "type": "aos-vlan", "master": "br0", "vlanId": 100, ` +
+				`"ifName": "aos-vlan", "dscpToPcp": ` + dscpToPcp + `}`))
+			Expect(err).To(HaveOccurred(), dscpToPcp)
+		}
+	})
+	It("aos-vlan validates name collision policy", func() {
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"nameCollisionPolicy": "replace"}`))
+		Expect(err).To(MatchError(ContainSubstring(`invalid name collision policy "replace"`)))
+
+		conf, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"nameCollisionPolicy": "error"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.NameCollisionPolicy).To(Equal(nameCollisionError))
+	})
+	It("aos-vlan validates egress source MAC", func() {
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"egressSrcMac": "02:aa:bb"}`))
+		Expect(err).To(MatchError(ContainSubstring(`invalid egress source MAC "02:aa:bb"`)))
+
+		_, _, err = parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "shared": true,
+			"egressSrcMac": "02:aa:bb:cc:dd:ee"}`))
+		Expect(err).To(MatchError(ContainSubstring(`"egressSrcMac" is not supported`)))
+
+		conf, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"egressSrcMac": "02:AA:BB:CC:DD:EE"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.EgressSrcMac).To(Equal("02:aa:bb:cc:dd:ee"))
+	})
+	It("aos-vlan validates checked gateway", func() {
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "checkGateway": true,
+			"gateway": "fd00::1"}`))
+		Expect(err).To(MatchError(`invalid gateway "fd00::1" (must be an IPv4 address)`))
+	})
+	It("aos-vlan validates multicast router role", func() {
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "mcastRouter": 3}`))
+		Expect(err).To(MatchError(ContainSubstring("invalid multicast router role 3")))
+
+		conf, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "mcastRouter": 0}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*conf.McastRouter).To(Equal(mcastRouterDisabled))
+	})
+	It("aos-vlan selects master by subnet", func() {
+		scanNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(scanNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(scanNS)).To(Succeed())
+		}()
+
+		inNetNS(scanNS, func() {
+			indices := make(map[string]int)
+
+			for name, cidr := range map[string]string{"eth0": "10.3.0.2/24", "eth1": "10.4.0.2/24"} {
+				err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: name + "p"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(addTestAddr(name, cidr)).To(Succeed())
+
+				link, err := netlink.LinkByName(name)
+				Expect(err).NotTo(HaveOccurred())
+
+				indices[name] = link.Attrs().Index
+			}
+
+			index, err := resolveParentIndex(&pluginConf{MasterSubnet: "10.4.0.0/16"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(indices["eth1"]))
+
+			index, err = resolveParentIndex(&pluginConf{MasterSubnet: "10.3.0.0/24"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(indices["eth0"]))
+
+			_, err = resolveParentIndex(&pluginConf{MasterSubnet: "10.0.0.0/8"})
+			Expect(err).To(MatchError("multiple links with an address in 10.0.0.0/8 found: eth0, eth1"))
+
+			_, err = resolveParentIndex(&pluginConf{MasterSubnet: "10.0.0.0/8", Master: "eth0"})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = resolveParentIndex(&pluginConf{MasterSubnet: "192.168.0.0/16"})
+			Expect(err).To(MatchError("no link with an address in 192.168.0.0/16 found"))
+		})
+
+		_, _, err = parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"masterSubnet": "10.4.0.0/16", "parentType": "device"}`))
+		Expect(err).To(MatchError(`"masterSubnet" and "parentType" are mutually exclusive`))
+	})
+	It("aos-vlan collects configured VLAN flags", func() {
+		reorderHeaders := false
+
+		flags, mask := configuredVlanFlags(&pluginConf{})
+		Expect(flags).To(BeZero())
+		Expect(mask).To(BeZero())
+
+		flags, mask = configuredVlanFlags(&pluginConf{ReorderHeaders: &reorderHeaders, Gvrp: true})
+		Expect(flags).To(Equal(uint32(vlanFlagGvrp)))
+		Expect(mask).To(Equal(uint32(vlanFlagReorderHdr | vlanFlagGvrp)))
+	})
+	It("aos-vlan validates auto name length", func() {
+		scanNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(scanNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(scanNS)).To(Succeed())
+		}()
+
+		inNetNS(scanNS, func() {
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-parent12"}, PeerName: "peer"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(addTestAddr("aos-parent12", "10.5.0.2/24")).To(Succeed())
+
+			conf := &pluginConf{VlanId: 100, AutoName: true, MasterSubnet: "10.5.0.0/24"}
+			Expect(applyAutoName(conf)).To(MatchError(ContainSubstring(
+				`auto name "aos-parent12.100" is longer than 15 characters`)))
+
+			conf.VlanId = 10
+			Expect(applyAutoName(conf)).To(Succeed())
+			Expect(conf.IfName).To(Equal("aos-parent12.10"))
+		})
+
+		conf, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "autoName": true}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.IfName).To(BeEmpty())
+	})
+	It("aos-vlan rejects keepOnDel with deleteOnDel", func() {
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "keepOnDel": true,
+			"deleteOnDel": true}`))
+		Expect(err).To(MatchError(`"keepOnDel" and "deleteOnDel" are mutually exclusive`))
+	})
+	It("aos-vlan rejects invalid MTU", func() {
+		for _, mtu := range []int{-1, 67, 65536} {
+			_, _, err := parseConfig([]byte(fmt.Sprintf(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+				"mtu": %d}`, mtu)))
+			Expect(err).To(MatchError(fmt.Sprintf("invalid MTU %d (must be between 68 and 65535 inclusive)", mtu)))
+		}
+
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "mtu": 9000}`))
+		Expect(err).NotTo(HaveOccurred())
+	})
+	It("aos-vlan rejects invalid VLAN filter policy", func() {
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"vlanFilterPolicy": "replace"}`))
+		Expect(err).To(MatchError(`invalid VLAN filter policy "replace" (must be "strict" or "merge")`))
+
+		_, _, err = parseConfig([]byte(`{"vlanId": 100, "ifName": "aos-vlan", "inContainer": true,
+			"vlanFilterPolicy": "merge"}`))
+		Expect(err).To(MatchError(`"vlanFilterPolicy" is not supported in "inContainer" mode`))
+	})
+	It("aos-vlan resolves master by default route family", func() {
+		scanNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(scanNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(scanNS)).To(Succeed())
+		}()
+
+		inNetNS(scanNS, func() {
+			indices := make(map[string]int)
+
+			for name, cidr := range map[string]string{"eth0": "fd00:0:0:1::2/64", "eth1": "fd00:0:0:2::2/64"} {
+				err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: name + "p"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(addTestAddr(name, cidr)).To(Succeed())
+
+				link, err := netlink.LinkByName(name)
+				Expect(err).NotTo(HaveOccurred())
+
+				indices[name] = link.Attrs().Index
+			}
+
+			// IPv6 only host with several default routes
+			Expect(netlink.RouteAdd(&netlink.Route{
+				LinkIndex: indices["eth0"], Gw: net.ParseIP("fd00:0:0:1::1"), Priority: 100,
+			})).To(Succeed())
+			Expect(netlink.RouteAdd(&netlink.Route{
+				LinkIndex: indices["eth1"], Gw: net.ParseIP("fd00:0:0:2::1"), Priority: 50,
+			})).To(Succeed())
+
+			for _, family := range []string{"", "any", "ipv6"} {
+				index, err := getMasterInterfaceIndex(&pluginConf{MasterFamily: family})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(index).To(Equal(indices["eth1"]), family)
+			}
+
+			_, err := getMasterInterfaceIndex(&pluginConf{MasterFamily: "ipv4"})
+			Expect(err).To(MatchError("master index not found"))
+
+			// IPv4 default route is preferred
+			Expect(addTestAddr("eth0", "10.3.0.2/24")).To(Succeed())
+			Expect(netlink.RouteAdd(&netlink.Route{
+				LinkIndex: indices["eth0"], Gw: net.ParseIP("10.3.0.1"),
+			})).To(Succeed())
+
+			for _, family := range []string{"", "any", "ipv4"} {
+				index, err := getMasterInterfaceIndex(&pluginConf{MasterFamily: family})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(index).To(Equal(indices["eth0"]), family)
+			}
+
+			index, err := getMasterInterfaceIndex(&pluginConf{MasterFamily: "ipv6"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(indices["eth1"]))
+		})
+
+		_, _, err = parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "masterFamily": "ipx"}`))
+		Expect(err).To(MatchError(`invalid master family "ipx" (must be "ipv4", "ipv6" or "any")`))
+
+		_, _, err = parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "masterFamily": "ipv6",
+			"routeScanFamily": "v6"}`))
+		Expect(err).To(MatchError(`"masterFamily" and "routeScanFamily" are mutually exclusive`))
+	})
+	It("aos-vlan applies prevResult conflict policy", func() {
+		confData := func(policy string) []byte {
+			return []byte(fmt.Sprintf(`{
+			   "cniVersion": "1.0.0", "name": "mynet", "type": "aos-vlan", "master": "br0", "vlanId": 100,
+			   "ifName": "aos-vlan", "prevResultConflictPolicy": "%s",
+			   "prevResult": {
+				  "cniVersion": "1.0.0",
+				  "interfaces": [{"name": "eth0"}, {"name": "aos-vlan", "mac": "02:00:00:00:00:01"}],
+				  "ips": [{"address": "10.0.0.2/24", "interface": 1}]
+			   }
+			}`, policy))
+		}
+
+		vlanInterface := &current.Interface{Name: "aos-vlan", Mac: "02:00:00:00:00:02"}
+
+		conf, result, err := parseConfig(confData(prevResultConflictReplace))
+		Expect(err).NotTo(HaveOccurred())
+
+		index, err := addResultInterface(conf, &result, vlanInterface)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(index).To(Equal(1))
+		Expect(result.Interfaces).To(HaveLen(2))
+		Expect(result.Interfaces[1].Mac).To(Equal("02:00:00:00:00:02"))
+		Expect(*result.IPs[0].Interface).To(Equal(1))
+
+		conf, result, err = parseConfig(confData(prevResultConflictError))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = addResultInterface(conf, &result, vlanInterface)
+		Expect(err).To(MatchError(`prevResult already has interface aos-vlan and prevResult conflict policy is "error"`))
+
+		// The interface in another sandbox is not a conflict
+		_, err = addResultInterface(conf, &result, &current.Interface{Name: "aos-vlan", Sandbox: "/var/run/netns/c1"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Interfaces).To(HaveLen(3))
+
+		conf, result, err = parseConfig(confData(""))
+		Expect(err).NotTo(HaveOccurred())
+
+		index, err = addResultInterface(conf, &result, vlanInterface)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(index).To(Equal(2))
+
+		_, _, err = parseConfig(confData("merge"))
+		Expect(err).To(MatchError(ContainSubstring(`invalid prevResult conflict policy "merge"`)))
+	})
+	It("aos-vlan supports CNI version 1.1.0", func() {
+		Expect(supportedVersions.SupportedVersions()).To(ContainElements("1.0.0", "1.1.0"))
+
+		conf, result, err := parseConfig([]byte(`{
+		   "cniVersion": "1.1.0", "name": "mynet", "type": "aos-vlan", "master": "br0", "vlanId": 100,
+		   "ifName": "aos-vlan",
+		   "prevResult": {"cniVersion": "1.1.0", "interfaces": [{"name": "eth0"}]}
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.CNIVersion).To(Equal("1.1.0"))
+		Expect(result.Interfaces).To(HaveLen(1))
+
+		versioned, err := getAsConfVersion(conf, &result)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versioned.Version()).To(Equal("1.1.0"))
+		Expect(result.CNIVersion).To(Equal("1.0.0"))
+	})
+	It("aos-vlan validates result checksum", func() {
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"resultChecksum": true}`))
+		Expect(err).To(MatchError(`"resultChecksum" requires "eventFile"`))
+
+		conf, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"resultChecksum": true, "eventFile": "/run/aos-vlan/events"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.ResultChecksum).To(BeTrue())
+	})
+	It("aos-vlan expands name template", func() {
+		conf, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "nameTemplate": "{master}.{vlanId}"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.IfName).To(Equal("br0.100"))
+
+		// ifName takes precedence
+		conf, _, err = parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"nameTemplate": "{master}.{vlanId}"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.IfName).To(Equal("aos-vlan"))
+
+		for template, expectedErr := range map[string]string{
+			"vlan-{id}": `unknown placeholder {id} in name template "vlan-{id}"`,
+			"{master}-long-name-{vlanId}": `invalid name "br0-long-name-100" expanded from name template ` +
+				`"{master}-long-name-{vlanId}": name is longer than 15 characters`,
+			"{master}:{vlanId}": `invalid name "br0:100" expanded from name template "{master}:{vlanId}": ` +
+				`name contains illegal character ':'`,
+		} {
+			_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "nameTemplate": "` + template + `"}`))
+			Expect(err).To(MatchError(ContainSubstring(expectedErr)), template)
+		}
+	})
+	It("aos-vlan validates interface names", func() {
+		for _, name := range []string{"a", "aos-vlan", "aos-vlan-15char", "aos.vlan_100", "vlan-ünicode"} {
+			for _, key := range []string{"ifName", "master", "parent", "vlans"} {
+				conf := `{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "` + key + `": "` + name + `"}`
+				if key == "master" {
+					conf = `{"vlanId": 100, "ifName": "aos-vlan", "master": "` + name + `"}`
+				}
+
+				if key == "vlans" {
+					conf = `{"master": "br0", "vlans": [{"vlanId": 100, "ifName": "` + name + `"}]}`
+				}
+
+				_, _, err := parseConfig([]byte(conf))
+				Expect(err).NotTo(HaveOccurred(), key+": "+name)
+			}
+		}
+
+		for name, expectedErr := range map[string]string{
+			"aos-vlan-16chars": "name is longer than 15 characters",
+			"vlan-ünicode-nám": "name is longer than 15 characters",
+			"aos/vlan":         `name contains illegal character '/'`,
+			"aos:vlan":         `name contains illegal character ':'`,
+			"aos vlan":         `name contains illegal character ' '`,
+			`aos\tvlan`:        `name contains illegal character '\t'`,
+			"..":               "name is empty or reserved",
+		} {
+			for _, key := range []string{"ifName", "master", "parent", "vlans"} {
+				conf := `{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "` + key + `": "` + name + `"}`
+				if key == "master" {
+					conf = `{"vlanId": 100, "ifName": "aos-vlan", "master": "` + name + `"}`
+				}
+
+				if key == "vlans" {
+					conf = `{"master": "br0", "vlans": [{"vlanId": 100, "ifName": "` + name + `"}]}`
+				}
+
+				// The names of the "vlans" entries are checked as the "ifName" field
+				errKey := key
+				if key == "vlans" {
+					errKey = "ifName"
+				}
+
+				_, _, err := parseConfig([]byte(conf))
+				Expect(err).To(MatchError(ContainSubstring(`invalid "`+errKey+`" "`)), key+": "+name)
+				Expect(err).To(MatchError(ContainSubstring(expectedErr)), key+": "+name)
+			}
+		}
+	})
+	It("aos-vlan returns CNI errors with plugin codes", func() {
+		errorCode := func(err error) uint {
+			var cniErr *types.Error
+
+			Expect(errors.As(err, &cniErr)).To(BeTrue(), err.Error())
+
+			return cniErr.Code
+		}
+
+		_, _, err := parseConfig([]byte(`{"vlanId": 100, "ifName": "aos-vlan"}`))
+		Expect(errorCode(err)).To(Equal(errMasterMissing))
+
+		_, _, err = parseConfig([]byte(`{"master": "br0", "vlanId": 4095, "ifName": "aos-vlan"}`))
+		Expect(errorCode(err)).To(Equal(errVlanIdOutOfRange))
+
+		_, _, err = parseConfig([]byte(`{"master": "br0", "vlans": [{"vlanId": 5000, "ifName": "aos-vlan0"}]}`))
+		Expect(errorCode(err)).To(Equal(errVlanIdOutOfRange))
+
+		err = prefixError(types.NewError(errMasterDown, `master bridge "br0" is down`, ""), "failed to add VLAN 100")
+		Expect(errorCode(err)).To(Equal(errMasterDown))
+		Expect(err).To(MatchError(`failed to add VLAN 100: master bridge "br0" is down`))
+
+		testNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(testNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		}()
+
+		inNetNS(testNS, func() {
+			_, err := masterBridge(&pluginConf{Master: "br0"})
+			Expect(errorCode(err)).To(Equal(errMasterMissing))
+
+			err = netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-veth"}, PeerName: "aos-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = masterBridge(&pluginConf{Master: "aos-veth"})
+			Expect(errorCode(err)).To(Equal(errMasterNotBridge))
+			Expect(err).To(MatchError(`master "aos-veth" is a veth device, not a bridge`))
+
+			Expect(netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0"}})).To(Succeed())
+
+			_, err = masterBridge(&pluginConf{Master: "br0"})
+			Expect(errorCode(err)).To(Equal(errMasterDown))
+
+			Expect(netlink.LinkSetUp(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0"}})).To(Succeed())
+
+			br, err := masterBridge(&pluginConf{Master: "br0"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(br.Attrs().Name).To(Equal("br0"))
+		})
+	})
+	It("aos-vlan fails on a down parent link unless bringUpMaster is set", func() {
+		testNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(testNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		}()
+
+		inNetNS(testNS, func() {
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-parent"}, PeerName: "aos-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			parent, err := netlink.LinkByName("aos-parent")
+			Expect(err).NotTo(HaveOccurred())
+
+			err = ensureParentUp(&pluginConf{}, parent)
+			Expect(err).To(MatchError(`parent link "aos-parent" is down`))
+			Expect(err.(*types.Error).Code).To(Equal(errParentDown))
+
+			Expect(ensureParentUp(&pluginConf{BringUpMaster: true}, parent)).To(Succeed())
+
+			parent, err = netlink.LinkByName("aos-parent")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parent.Attrs().Flags & net.FlagUp).To(Equal(net.FlagUp))
+
+			Expect(ensureParentUp(&pluginConf{}, parent)).To(Succeed())
+		})
+	})
+	It("aos-vlan validates VLAN QoS maps", func() {
+		for qos, expectedErr := range map[string]string{
+			`"ingressQos": [{"from": 8, "to": 1}]`:      "invalid ingress QoS PCP 8",
+			`"egressQos": [{"from": 1, "to": 8}]`:       "invalid egress QoS PCP 8",
+			`"egressQos": [{"from": -1, "to": 1}]`:      "failed to parse network configuration",
+			`"egressQos": [{}], "dscpToPcp": {"46": 5}`: `"egressQos" and "dscpToPcp" are mutually exclusive`,
+		} {
+			_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", ` + qos + `}`))
+			Expect(err).To(MatchError(ContainSubstring(expectedErr)), qos)
+		}
+
+		conf, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"ingressQos": [{"from": 7, "to": 100}], "egressQos": [{"from": 100, "to": 7}]}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(qosMap(conf.IngressQos)).To(Equal(map[uint32]uint32{7: 100}))
+		Expect(qosMap(conf.EgressQos)).To(Equal(map[uint32]uint32{100: 7}))
+	})
+
+	It("aos-vlan adds topology interfaces once", func() {
+		testNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(testNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		}()
+
+		inNetNS(testNS, func() {
+			Expect(netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0"}})).To(Succeed())
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}, PeerName: "eth0-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			parent, err := netlink.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+
+			result := &current.Result{Interfaces: []*current.Interface{
+				{Name: "eth0", Sandbox: "/var/run/netns/other"},
+				{Name: "aos-vlan"},
+			}}
+
+			conf := &pluginConf{Master: "br0"}
+
+			Expect(addTopologyInterfaces(conf, result, parent.Attrs().Index)).To(Succeed())
+			Expect(addTopologyInterfaces(conf, result, parent.Attrs().Index)).To(Succeed())
+
+			names := []string{}
+			for _, iface := range result.Interfaces {
+				names = append(names, iface.Name)
+			}
+
+			Expect(names).To(Equal([]string{"eth0", "aos-vlan", "eth0", "br0"}))
+			Expect(result.Interfaces[2].Mac).To(Equal(resultMac(parent.Attrs().HardwareAddr)))
+			Expect(result.Interfaces[2].Sandbox).To(BeEmpty())
+
+			// The bridge is not reported for a VLAN moved to the container
+			result = &current.Result{}
+
+			Expect(addTopologyInterfaces(&pluginConf{Master: "br0", InContainer: true}, result,
+				parent.Attrs().Index)).To(Succeed())
+			Expect(result.Interfaces).To(HaveLen(1))
+		})
+	})
+})
+
+func createBridge(brName string, brIP string) (bridge *netlink.Bridge, err error) {
+	err = execCmd("ip", "link", "add", "name", brName, "type", "bridge")
+	if err != nil {
+		return nil, err
+	}
+
+	err = execCmd("ip", "link", "set", brName, "up")
+	if err != nil {
+		return nil, err
+	}
+
+	err = execCmd("ip", "addr", "add", "dev", brName, brIP)
+	if err != nil {
+		return nil, err
+	}
+
+	return bridgeByName(brName)
+}
+
+// createVethParent creates a veth pair as the VLAN parent, so the plugin flow runs without special interfaces: the
+// parent gets the address and the default route via the gateway.
+func createVethParent(name, peerName, addr, gateway string) error {
+	if err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: peerName}); err != nil {
+		return fmt.Errorf("failed to add veth %q: %v", name, err)
+	}
+
+	for _, linkName := range []string{peerName, name} {
+		if err := execCmd("ip", "link", "set", linkName, "up"); err != nil {
+			return err
+		}
+	}
+
+	if err := execCmd("ip", "addr", "add", "dev", name, addr); err != nil {
+		return err
+	}
+
+	return execCmd("ip", "route", "add", "default", "via", gateway, "dev", name)
+}
+
+// vlanTestConf returns the network configuration of VLAN 100 named aos-vlan on br0 with the extra JSON fields.
+func vlanTestConf(fields string) string {
+	conf := `{"name": "mynet", "cniVersion": "0.4.0", "type": "aos-vlan", "master": "br0", "vlanId": 100, ` +
+		`"ifName": "aos-vlan"`
+	if fields != "" {
+		conf += ", " + fields
+	}
+
+	return conf + "}"
+}
+
+// vlanTestArgs returns the CNI arguments of the aos-vlan interface of the dummy container with the configuration.
+func vlanTestArgs(conf string) *skel.CmdArgs {
+	return &skel.CmdArgs{
+		ContainerID: "dummy",
+		Netns:       "dummy",
+		IfName:      "aos-vlan",
+		StdinData:   []byte(conf),
+	}
+}
+
+// inNetNS runs the test in the network namespace.
+func inNetNS(netNS ns.NetNS, test func()) {
+	err := netNS.Do(func(ns.NetNS) error {
+		defer GinkgoRecover()
+
+		test()
+
+		return nil
+	})
+	Expect(err).NotTo(HaveOccurred())
+}
+
+// cniAdd runs ADD with the CNI arguments and returns its result.
+func cniAdd(args *skel.CmdArgs) (types.Result, error) {
+	result, _, err := testutils.CmdAddWithArgs(args, func() error {
+		return cmdAdd(args)
+	})
+
+	return result, err
+}
+
+// cniCheck runs CHECK with the CNI arguments.
+func cniCheck(args *skel.CmdArgs) error {
+	return testutils.CmdCheckWithArgs(args, func() error {
+		return cmdCheck(args)
+	})
+}
+
+// cniDel runs DEL with the CNI arguments.
+func cniDel(args *skel.CmdArgs) error {
+	return testutils.CmdDelWithArgs(args, func() error {
+		return cmdDel(args)
+	})
 }
 
 func execCmd(bin string, args ...string) (err error) {
@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// delAuditEntry is a JSON line appended to the DEL audit file on each DEL, including the ones leaving the VLAN
+// untouched.
+type delAuditEntry struct {
+	Time          string   `json:"time"`
+	ContainerID   string   `json:"containerID"`
+	Netns         string   `json:"netns"`
+	IfName        string   `json:"ifName"`
+	Steps         []string `json:"steps"`
+	LinkUntouched bool     `json:"linkUntouched"`
+	Error         string   `json:"error,omitempty"`
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func newDelAuditEntry(conf *pluginConf, args *skel.CmdArgs, steps []teardownStep) *delAuditEntry {
+	entry := &delAuditEntry{
+		Time:          time.Now().UTC().Format(time.RFC3339Nano),
+		ContainerID:   args.ContainerID,
+		Netns:         args.Netns,
+		IfName:        conf.IfName,
+		Steps:         teardownStepNames(steps),
+		LinkUntouched: true,
+	}
+
+	for _, step := range steps {
+		if step.name == "bridge" || step.name == "link" {
+			entry.LinkUntouched = false
+		}
+	}
+
+	return entry
+}
+
+// writeDelAudit appends the entry to the DEL audit file. Errors are returned for diagnostic purposes only and must
+// never fail the CNI command.
+func writeDelAudit(conf *pluginConf, entry *delAuditEntry, cmdErr error) error {
+	if conf.DelAuditFile == "" {
+		return nil
+	}
+
+	if cmdErr != nil {
+		entry.Error = cmdErr.Error()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal DEL audit entry: %v", err)
+	}
+
+	file, err := os.OpenFile(conf.DelAuditFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open DEL audit file %s: %v", conf.DelAuditFile, err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write DEL audit file %s: %v", conf.DelAuditFile, err)
+	}
+
+	return nil
+}
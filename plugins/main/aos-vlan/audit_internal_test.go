@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan DEL audit", func() {
+	var (
+		tmpDir string
+		testNS ns.NetNS
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		tmpDir, err = os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
+		testNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(testNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("aos-vlan writes DEL audit entry", func() {
+		auditFile := filepath.Join(tmpDir, "del-audit")
+
+		conf := fmt.Sprintf(`
+			{
+			   "name": "mynet",
+			   "cniVersion": "0.4.0",
+			   "type": "aos-vlan",
+			   "master": "br0",
+			   "vlanId": 100,
+			   "ifName": "aos-vlan",
+			   "keepOnDel": true,
+			   "delAuditFile": %q
+		   }`, auditFile)
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       "/var/run/netns/dummy",
+			IfName:      "aos-vlan",
+			StdinData:   []byte(conf),
+		}
+
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			// The VLAN doesn't exist and is kept with keepOnDel, still DEL is audited
+			for i := 0; i < 2; i++ {
+				err := testutils.CmdDelWithArgs(args, func() error {
+					return cmdDel(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		content, err := os.ReadFile(auditFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		Expect(lines).To(HaveLen(2))
+
+		var entry delAuditEntry
+
+		Expect(json.Unmarshal([]byte(lines[1]), &entry)).To(Succeed())
+		Expect(entry.Time).NotTo(BeEmpty())
+
+		entry.Time = ""
+
+		Expect(entry).To(Equal(delAuditEntry{
+			ContainerID: "dummy", Netns: "/var/run/netns/dummy", IfName: "aos-vlan",
+			Steps: []string{"routes", "addresses", "ipam"}, LinkUntouched: true,
+		}))
+	})
+
+	It("aos-vlan audits link changes", func() {
+		entry := newDelAuditEntry(&pluginConf{IfName: "aos-vlan", DeleteOnDel: true}, &skel.CmdArgs{},
+			teardownSteps(&pluginConf{IfName: "aos-vlan", DeleteOnDel: true}, &skel.CmdArgs{}, nil, nil))
+
+		Expect(entry.Steps).To(Equal([]string{"routes", "addresses", "ipam", "bridge", "link"}))
+		Expect(entry.LinkUntouched).To(BeFalse())
+	})
+})
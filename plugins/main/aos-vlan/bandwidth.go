@@ -0,0 +1,267 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// bandwidthIngressPref is the preference of the ingress filter redirecting the VLAN traffic to the ifb device.
+const bandwidthIngressPref = 40
+
+// bandwidthLatency is the maximum time a packet may wait in the tbf qdisc, in milliseconds.
+const bandwidthLatency = 25
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// bandwidthConf is the VLAN traffic shaping configuration. Ingress is the traffic received on the VLAN, egress is the
+// traffic sent through it. Rates are in bits per second, bursts in bytes.
+type bandwidthConf struct {
+	IngressRate  uint64 `json:"ingressRate"`
+	IngressBurst uint64 `json:"ingressBurst"`
+	EgressRate   uint64 `json:"egressRate"`
+	EgressBurst  uint64 `json:"egressBurst"`
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// validateBandwidth checks each bandwidth rate is set together with its burst and the bursts fit the tbf qdisc.
+func validateBandwidth(conf *pluginConf) error {
+	if conf.Bandwidth == nil {
+		return nil
+	}
+
+	if (conf.Bandwidth.IngressRate == 0) != (conf.Bandwidth.IngressBurst == 0) {
+		return fmt.Errorf("\"ingressRate\" and \"ingressBurst\" must be set together")
+	}
+
+	if (conf.Bandwidth.EgressRate == 0) != (conf.Bandwidth.EgressBurst == 0) {
+		return fmt.Errorf("\"egressRate\" and \"egressBurst\" must be set together")
+	}
+
+	if conf.Bandwidth.IngressBurst > math.MaxInt32 || conf.Bandwidth.EgressBurst > math.MaxInt32 {
+		return fmt.Errorf("invalid bandwidth burst (must not exceed %d bytes)", math.MaxInt32)
+	}
+
+	return nil
+}
+
+// setVlanBandwidth shapes the VLAN egress with a tbf root qdisc and the VLAN ingress with a tbf root qdisc of an ifb
+// device the ingress traffic is redirected to.
+func setVlanBandwidth(conf *pluginConf, link netlink.Link) error {
+	if conf.Bandwidth == nil {
+		return nil
+	}
+
+	if conf.Bandwidth.EgressRate != 0 {
+		logDebug("shaping vlan egress", "name", link.Attrs().Name, "rate", conf.Bandwidth.EgressRate,
+			"burst", conf.Bandwidth.EgressBurst)
+
+		if err := replaceTbfQdisc(link, conf.Bandwidth.EgressRate, conf.Bandwidth.EgressBurst); err != nil {
+			return fmt.Errorf("failed to shape egress of %q: %v", link.Attrs().Name, err)
+		}
+	}
+
+	if conf.Bandwidth.IngressRate != 0 {
+		logDebug("shaping vlan ingress", "name", link.Attrs().Name, "rate", conf.Bandwidth.IngressRate,
+			"burst", conf.Bandwidth.IngressBurst)
+
+		if err := shapeIngress(link, conf.Bandwidth.IngressRate, conf.Bandwidth.IngressBurst); err != nil {
+			return fmt.Errorf("failed to shape ingress of %q: %v", link.Attrs().Name, err)
+		}
+	}
+
+	return nil
+}
+
+// shapeIngress redirects the ingress traffic of the link to its ifb device shaped by a tbf root qdisc.
+func shapeIngress(link netlink.Link, rate, burst uint64) error {
+	ifb, err := addIfb(link)
+	if err != nil {
+		return err
+	}
+
+	if err := replaceTbfQdisc(ifb, rate, burst); err != nil {
+		return err
+	}
+
+	if err := addClsactQdisc(link); err != nil {
+		return err
+	}
+
+	// The filter is added without a handle, remove the one of the previous ADD
+	if err := deleteFilters(link, netlink.HANDLE_MIN_INGRESS, bandwidthIngressPref); err != nil {
+		return fmt.Errorf("failed to remove ingress redirect filter: %v", err)
+	}
+
+	filter := &netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: link.Attrs().Index,
+			Parent:    netlink.HANDLE_MIN_INGRESS,
+			Priority:  bandwidthIngressPref,
+			Protocol:  unix.ETH_P_ALL,
+		},
+		Sel: &nl.TcU32Sel{
+			Flags: nl.TC_U32_TERMINAL,
+			Keys:  []nl.TcU32Key{{Mask: 0, Val: 0}},
+		},
+		Actions: []netlink.Action{netlink.NewMirredAction(ifb.Attrs().Index)},
+	}
+
+	if err := netlink.FilterAdd(filter); err != nil {
+		return fmt.Errorf("failed to add ingress redirect filter: %v", err)
+	}
+
+	return nil
+}
+
+// addIfb creates the ifb device of the link, if it doesn't exist, and brings it up.
+func addIfb(link netlink.Link) (netlink.Link, error) {
+	name := ifbName(link.Attrs().Name)
+
+	err := netlink.LinkAdd(&netlink.Ifb{LinkAttrs: netlink.LinkAttrs{
+		Name: name,
+		MTU:  link.Attrs().MTU,
+	}})
+	if err != nil && !errors.Is(err, syscall.EEXIST) {
+		return nil, fmt.Errorf("failed to add ifb %q: %v", name, err)
+	}
+
+	ifb, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup %q: %v", name, err)
+	}
+
+	if ifb.Type() != "ifb" {
+		return nil, fmt.Errorf("%q already exists and is a %s device, not an ifb", name, ifb.Type())
+	}
+
+	if err := netlink.LinkSetUp(ifb); err != nil {
+		return nil, fmt.Errorf("failed to set %q up: %v", name, err)
+	}
+
+	return ifb, nil
+}
+
+// replaceTbfQdisc sets the tbf root qdisc limiting the link to the rate in bits per second with the burst in bytes.
+func replaceTbfQdisc(link netlink.Link, rate, burst uint64) error {
+	rateInBytes := rate / 8
+	if rateInBytes == 0 {
+		rateInBytes = 1
+	}
+
+	buffer := netlink.Xmittime(rateInBytes, uint32(burst))
+	limit := uint32(float64(rateInBytes)*bandwidthLatency/1000) + uint32(burst)
+
+	qdisc := &netlink.Tbf{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(1, 0),
+			Parent:    netlink.HANDLE_ROOT,
+		},
+		Rate:   rateInBytes,
+		Limit:  limit,
+		Buffer: buffer,
+	}
+
+	if err := netlink.QdiscReplace(qdisc); err != nil {
+		return fmt.Errorf("failed to set tbf qdisc on %q: %v", link.Attrs().Name, err)
+	}
+
+	return nil
+}
+
+// teardownBandwidth removes the VLAN tbf qdisc, the ingress redirect filter and the ifb device. Missing resources are
+// not an error.
+func teardownBandwidth(conf *pluginConf) error {
+	if err := deleteIfb(ifbName(conf.IfName)); err != nil {
+		return err
+	}
+
+	link, err := netlink.LinkByName(conf.IfName)
+	if err != nil {
+		if errors.As(err, new(netlink.LinkNotFoundError)) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to lookup %q: %v", conf.IfName, err)
+	}
+
+	if err := deleteFilters(link, netlink.HANDLE_MIN_INGRESS, bandwidthIngressPref); err != nil {
+		return fmt.Errorf("failed to remove ingress redirect filter from %q: %v", conf.IfName, err)
+	}
+
+	qdisc := &netlink.Tbf{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(1, 0),
+			Parent:    netlink.HANDLE_ROOT,
+		},
+	}
+
+	if err := netlink.QdiscDel(qdisc); err != nil && !errors.Is(err, syscall.ENOENT) &&
+		!errors.Is(err, syscall.EINVAL) {
+		return fmt.Errorf("failed to remove tbf qdisc from %q: %v", conf.IfName, err)
+	}
+
+	// The bandwidth step follows the "tc" step, which leaves the clsact qdisc to it
+	return deleteClsactQdisc(link)
+}
+
+// deleteIfb deletes the ifb device, a missing device or a device of another type is left intact.
+func deleteIfb(name string) error {
+	ifb, err := netlink.LinkByName(name)
+	if err != nil {
+		if errors.As(err, new(netlink.LinkNotFoundError)) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to lookup %q: %v", name, err)
+	}
+
+	if ifb.Type() != "ifb" {
+		return nil
+	}
+
+	if err := netlink.LinkDel(ifb); err != nil && !errors.Is(err, syscall.ENODEV) {
+		return fmt.Errorf("failed to delete ifb %q: %v", name, err)
+	}
+
+	return nil
+}
+
+// ifbName returns the name of the ifb device of the VLAN, derived from the VLAN name to fit the interface name limit.
+func ifbName(ifName string) string {
+	return fmt.Sprintf("ifb%x", sha256.Sum256([]byte(ifName)))[:15]
+}
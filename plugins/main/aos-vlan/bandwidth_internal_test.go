@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan bandwidth", func() {
+	var testNS ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+
+		testNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(testNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(testNS)).To(Succeed())
+	})
+
+	It("aos-vlan shapes VLAN traffic and tears the shaping down", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}, PeerName: "aos-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			link, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			conf := &pluginConf{IfName: "aos-vlan", Bandwidth: &bandwidthConf{
+				IngressRate: 8000000, IngressBurst: 10000, EgressRate: 16000000, EgressBurst: 20000,
+			}}
+
+			err = setVlanBandwidth(conf, link)
+			if errors.Is(err, syscall.EOPNOTSUPP) {
+				Skip("ifb devices are not available")
+			}
+
+			Expect(err).NotTo(HaveOccurred())
+
+			// Repeated ADD replaces the shaping
+			Expect(setVlanBandwidth(conf, link)).To(Succeed())
+
+			tbfRate := func(link netlink.Link) uint64 {
+				qdiscs, err := netlink.QdiscList(link)
+				Expect(err).NotTo(HaveOccurred())
+
+				for _, qdisc := range qdiscs {
+					if tbf, ok := qdisc.(*netlink.Tbf); ok && qdisc.Attrs().Parent == netlink.HANDLE_ROOT {
+						return tbf.Rate
+					}
+				}
+
+				return 0
+			}
+
+			Expect(tbfRate(link)).To(Equal(uint64(2000000)))
+
+			ifb, err := netlink.LinkByName(ifbName("aos-vlan"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ifb.Type()).To(Equal("ifb"))
+			Expect(tbfRate(ifb)).To(Equal(uint64(1000000)))
+
+			filters, err := netlink.FilterList(link, netlink.HANDLE_MIN_INGRESS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(filters).To(HaveLen(1))
+			Expect(filters[0].Attrs().Priority).To(Equal(uint16(bandwidthIngressPref)))
+
+			Expect(teardownBandwidth(conf)).To(Succeed())
+			Expect(teardownBandwidth(conf)).To(Succeed())
+
+			Expect(tbfRate(link)).To(BeZero())
+
+			_, err = netlink.LinkByName(ifbName("aos-vlan"))
+			Expect(err).To(BeAssignableToTypeOf(netlink.LinkNotFoundError{}))
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("aos-vlan validates bandwidth", func() {
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"bandwidth": {"egressRate": 1000000}}`))
+		Expect(err).To(MatchError("\"egressRate\" and \"egressBurst\" must be set together"))
+
+		_, _, err = parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "shared": true,
+			"bandwidth": {"ingressRate": 1000000, "ingressBurst": 1000}}`))
+		Expect(err).To(MatchError(ContainSubstring(`"bandwidth", "bpfProgram"`)))
+
+		conf, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"bandwidth": {"ingressRate": 1000000, "ingressBurst": 1000}}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.Bandwidth).To(Equal(&bandwidthConf{IngressRate: 1000000, IngressBurst: 1000}))
+	})
+
+	It("aos-vlan derives ifb name", func() {
+		Expect(ifbName("aos-vlan")).To(HaveLen(15))
+		Expect(ifbName("aos-vlan")).NotTo(Equal(ifbName("aos-vlan2")))
+	})
+})
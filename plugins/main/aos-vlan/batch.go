@@ -0,0 +1,257 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+
+	vlanlib "github.com/aoscloud/aos_cni_vlan/pkg/vlan"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// vlanBatchSize is the maximum number of requests sent before the acks are read, so the acks fit the socket receive
+// buffer.
+const vlanBatchSize = 64
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// batchAddVlans adds the VLAN links of the multi-VLAN configuration with pipelined requests and returns the links and
+// per-VLAN errors. A link which already exists gets syscall.EEXIST, so it can go the regular reconcile path.
+func batchAddVlans(confs []*pluginConf) (links []*netlink.Vlan, errs []error) {
+	links, errs = make([]*netlink.Vlan, len(confs)), make([]error, len(confs))
+
+	var (
+		vlans   []*netlink.Vlan
+		indices []int
+	)
+
+	for i, entryConf := range confs {
+		vlan, err := newVlanLink(entryConf)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		links[i] = vlan
+		vlans = append(vlans, vlan)
+		indices = append(indices, i)
+	}
+
+	for j, err := range addVlanLinks(vlans) {
+		if err != nil && !errors.Is(err, syscall.EEXIST) {
+			err = fmt.Errorf("failed to create vlan: %v", err)
+		}
+
+		if err == nil {
+			// The pipelined requests don't report the index of the added link back, it is needed by the IFLA_INFO_DATA
+			// requests of configureVlan.
+			if links[indices[j]], err = vlanlib.ByName(vlans[j].Name); err != nil {
+				err = fmt.Errorf("failed to lookup added vlan: %v", err)
+			}
+		}
+
+		errs[indices[j]] = err
+	}
+
+	return links, errs
+}
+
+// addBatchedVlan configures the VLAN added by batchAddVlans and attaches it to the master bridge. The VLAN is deleted
+// if it can't be configured.
+func addBatchedVlan(
+	conf *pluginConf, link *netlink.Vlan, addErr error,
+) (vlanInterface *current.Interface, created bool, err error) {
+	if errors.Is(addErr, syscall.EEXIST) {
+		return addVlan(conf)
+	}
+
+	if addErr != nil {
+		return nil, false, addErr
+	}
+
+	vlan, vlanInterface, err := configureVlan(conf, link)
+	if err != nil {
+		if delErr := deleteLinkWithRetry(conf, link); delErr != nil {
+			err = fmt.Errorf("%v, rollback failed: %v", err, delErr)
+		}
+
+		return nil, false, err
+	}
+
+	return attachVlan(conf, vlan, vlanInterface, true)
+}
+
+// addVlanLinks adds the VLAN links over a single netlink socket. The requests are sent without waiting for the acks,
+// which are matched back to the VLANs by sequence number.
+func addVlanLinks(vlans []*netlink.Vlan) []error {
+	errs := make([]error, len(vlans))
+
+	s, err := nl.GetNetlinkSocketAt(netns.None(), netns.None(), unix.NETLINK_ROUTE)
+	if err != nil {
+		for i := range errs {
+			errs[i] = fmt.Errorf("failed to open netlink socket: %v", err)
+		}
+
+		return errs
+	}
+	defer s.Close()
+
+	if nl.EnableErrorMessageReporting {
+		if err := s.SetExtAck(true); err != nil {
+			for i := range errs {
+				errs[i] = fmt.Errorf("failed to enable extended ack: %v", err)
+			}
+
+			return errs
+		}
+	}
+
+	for start := 0; start < len(vlans); start += vlanBatchSize {
+		end := start + vlanBatchSize
+		if end > len(vlans) {
+			end = len(vlans)
+		}
+
+		addVlanLinkBatch(s, vlans[start:end], errs[start:end])
+	}
+
+	return errs
+}
+
+func addVlanLinkBatch(s *nl.NetlinkSocket, vlans []*netlink.Vlan, errs []error) {
+	pending := make(map[uint32]int)
+
+	for i, vlan := range vlans {
+		req := newVlanLinkRequest(vlan)
+
+		if err := s.Send(req); err != nil {
+			errs[i] = fmt.Errorf("failed to send request: %v", err)
+			continue
+		}
+
+		pending[req.Seq] = i
+	}
+
+	for len(pending) != 0 {
+		msgs, _, err := s.Receive()
+		if err != nil {
+			for _, i := range pending {
+				errs[i] = fmt.Errorf("failed to receive ack: %v", err)
+			}
+
+			return
+		}
+
+		for _, msg := range msgs {
+			i, ok := pending[msg.Header.Seq]
+			if !ok || msg.Header.Type != unix.NLMSG_ERROR || len(msg.Data) < 4 {
+				continue
+			}
+
+			delete(pending, msg.Header.Seq)
+
+			errs[i] = ackError(msg)
+		}
+	}
+}
+
+// ackError returns the error of the netlink ack annotated with the extended ack message if present, as netlink does.
+func ackError(msg syscall.NetlinkMessage) error {
+	errno := -int32(nl.NativeEndian().Uint32(msg.Data[:4]))
+	if errno == 0 {
+		return nil
+	}
+
+	err := error(syscall.Errno(errno))
+
+	if msg.Header.Flags&unix.NLM_F_ACK_TLVS == 0 {
+		return err
+	}
+
+	// The echoed request is followed by the extended ack attributes, only its header is echoed if capped
+	tlvs := msg.Data[4:]
+	if len(tlvs) < syscall.SizeofNlMsghdr {
+		return err
+	}
+
+	echoLen := syscall.SizeofNlMsghdr
+	if msg.Header.Flags&unix.NLM_F_CAPPED == 0 {
+		echoLen = nlmAlign(int(nl.NativeEndian().Uint32(tlvs[:4])))
+	}
+
+	if echoLen > len(tlvs) {
+		return err
+	}
+
+	attrs, parseErr := nl.ParseRouteAttr(tlvs[echoLen:])
+	if parseErr != nil {
+		return err
+	}
+
+	for _, attr := range attrs {
+		if attr.Attr.Type == nl.NLMSGERR_ATTR_MSG {
+			return fmt.Errorf("%w: %s", err, strings.TrimRight(string(attr.Value), "\x00"))
+		}
+	}
+
+	return err
+}
+
+func nlmAlign(length int) int {
+	return (length + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+}
+
+// newVlanLinkRequest returns the RTM_NEWLINK request of the VLAN link as netlink.LinkAdd builds it.
+func newVlanLinkRequest(vlan *netlink.Vlan) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL|unix.NLM_F_ACK)
+
+	req.AddData(nl.NewIfInfomsg(unix.AF_UNSPEC))
+	req.AddData(nl.NewRtAttr(unix.IFLA_IFNAME, nl.ZeroTerminated(vlan.Name)))
+	req.AddData(nl.NewRtAttr(unix.IFLA_LINK, nl.Uint32Attr(uint32(vlan.ParentIndex))))
+
+	linkInfo := nl.NewRtAttr(unix.IFLA_LINKINFO, nil)
+	linkInfo.AddRtAttr(nl.IFLA_INFO_KIND, nl.NonZeroTerminated(vlan.Type()))
+
+	data := linkInfo.AddRtAttr(nl.IFLA_INFO_DATA, nil)
+	data.AddRtAttr(nl.IFLA_VLAN_ID, nl.Uint16Attr(uint16(vlan.VlanId)))
+
+	if vlan.VlanProtocol != netlink.VLAN_PROTOCOL_UNKNOWN {
+		protocol := make([]byte, 2)
+		binary.BigEndian.PutUint16(protocol, uint16(vlan.VlanProtocol))
+
+		data.AddRtAttr(nl.IFLA_VLAN_PROTOCOL, protocol)
+	}
+
+	req.AddData(linkInfo)
+
+	return req
+}
@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const benchmarkVlanCount = 50
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan batch", func() {
+	var testNS ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+
+		testNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(testNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(testNS)).To(Succeed())
+	})
+
+	It("aos-vlan attributes batched creation errors", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			Expect(netlink.LinkAdd(&netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: "eth0"}, PeerName: "eth1",
+			})).To(Succeed())
+
+			parent, err := netlink.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+
+			vlans := []*netlink.Vlan{
+				{LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan0", ParentIndex: parent.Attrs().Index}, VlanId: 100},
+				{LinkAttrs: netlink.LinkAttrs{Name: "eth1", ParentIndex: parent.Attrs().Index}, VlanId: 101},
+				{LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan2", ParentIndex: 12345}, VlanId: 102},
+			}
+
+			errs := addVlanLinks(vlans)
+			Expect(errs).To(HaveLen(3))
+
+			if errs[0] != nil {
+				Expect(errors.Is(errs[0], syscall.EOPNOTSUPP)).To(BeTrue(), errs[0].Error())
+			}
+
+			Expect(errors.Is(errs[1], syscall.EEXIST)).To(BeTrue(), fmt.Sprint(errs[1]))
+			Expect(errs[2]).To(HaveOccurred())
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+/***********************************************************************************************************************
+ * Benchmarks
+ **********************************************************************************************************************/
+
+func BenchmarkVlanCreation(b *testing.B) {
+	for _, batched := range []bool{false, true} {
+		name := "sequential"
+		if batched {
+			name = "batched"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			benchmarkVlanCreation(b, batched)
+		})
+	}
+}
+
+func benchmarkVlanCreation(b *testing.B, batched bool) {
+	testNS, err := testutils.NewNS()
+	if err != nil {
+		b.Fatalf("Can't create namespace: %v", err)
+	}
+
+	defer func() {
+		_ = testNS.Close()
+		_ = testutils.UnmountNS(testNS)
+	}()
+
+	if err := testNS.Do(func(ns.NetNS) error {
+		parent := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}}
+		if err := netlink.LinkAdd(parent); err != nil {
+			b.Skipf("Can't create parent: %v", err)
+		}
+
+		vlans := make([]*netlink.Vlan, benchmarkVlanCount)
+
+		for i := range vlans {
+			vlans[i] = &netlink.Vlan{
+				LinkAttrs: netlink.LinkAttrs{Name: fmt.Sprintf("aos-vlan%d", i), ParentIndex: parent.Attrs().Index},
+				VlanId:    100 + i,
+			}
+		}
+
+		b.ResetTimer()
+
+		for n := 0; n < b.N; n++ {
+			if batched {
+				for _, err := range addVlanLinks(vlans) {
+					if err != nil {
+						return err
+					}
+				}
+			} else {
+				for _, vlan := range vlans {
+					if err := netlink.LinkAdd(vlan); err != nil {
+						return err
+					}
+				}
+			}
+
+			b.StopTimer()
+
+			for _, vlan := range vlans {
+				if err := netlink.LinkDel(vlan); err != nil {
+					return err
+				}
+			}
+
+			b.StartTimer()
+		}
+
+		return nil
+	}); err != nil {
+		b.Fatalf("Can't create VLANs: %v", err)
+	}
+}
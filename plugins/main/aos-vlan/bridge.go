@@ -0,0 +1,417 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Bridge port STP states as defined in linux/if_bridge.h.
+const (
+	bridgePortStateDisabled = iota
+	bridgePortStateListening
+	bridgePortStateLearning
+	bridgePortStateForwarding
+	bridgePortStateBlocking
+)
+
+// Bridge port multicast router roles as defined in linux/if_bridge.h.
+const (
+	mcastRouterDisabled = iota
+	mcastRouterTempQuery
+	mcastRouterPerm
+)
+
+// Policies applied when the bridge port already has a VLAN filter entry for the VLAN ID.
+const (
+	// vlanFilterStrict fails on an existing entry.
+	vlanFilterStrict = "strict"
+	// vlanFilterMerge updates the flags of the existing entry, it is not removed on DEL.
+	vlanFilterMerge = "merge"
+)
+
+// masterCarrierPollInterval is the interval the master bridge carrier is polled at by waitMasterCarrier.
+const masterCarrierPollInterval = 100 * time.Millisecond
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// bridgeVlanConf are the VLAN filter entries of the VLAN bridge port for VLAN aware bridges.
+type bridgeVlanConf struct {
+	// Pvid is the port VLAN ID untagged ingress frames are assigned to, added as an untagged entry.
+	Pvid int `json:"pvid"`
+	// Tagged are the VLAN IDs forwarded tagged through the port.
+	Tagged []int `json:"tagged"`
+	// Untagged are the VLAN IDs forwarded untagged through the port.
+	Untagged []int `json:"untagged"`
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+var bridgePortStateNames = map[uint8]string{
+	bridgePortStateDisabled:   "disabled",
+	bridgePortStateListening:  "listening",
+	bridgePortStateLearning:   "learning",
+	bridgePortStateForwarding: "forwarding",
+	bridgePortStateBlocking:   "blocking",
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// bridgePortAttrs returns the IFLA_PROTINFO attributes reported by the bridge for the port link.
+func bridgePortAttrs(link netlink.Link) (map[uint16][]byte, error) {
+	req := nl.NewNetlinkRequest(unix.RTM_GETLINK, unix.NLM_F_DUMP)
+	req.AddData(nl.NewIfInfomsg(unix.AF_BRIDGE))
+
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range msgs {
+		ans := nl.DeserializeIfInfomsg(m)
+		if int(ans.Index) != link.Attrs().Index {
+			continue
+		}
+
+		attrs, err := nl.ParseRouteAttr(m[ans.Len():])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, attr := range attrs {
+			if attr.Attr.Type != unix.IFLA_PROTINFO|unix.NLA_F_NESTED {
+				continue
+			}
+
+			infos, err := nl.ParseRouteAttr(attr.Value)
+			if err != nil {
+				return nil, err
+			}
+
+			portAttrs := make(map[uint16][]byte)
+
+			for _, info := range infos {
+				portAttrs[info.Attr.Type] = info.Value
+			}
+
+			return portAttrs, nil
+		}
+	}
+
+	return nil, fmt.Errorf("link %q is not a bridge port", link.Attrs().Name)
+}
+
+func bridgePortState(link netlink.Link) (string, error) {
+	portAttrs, err := bridgePortAttrs(link)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := portAttrs[nl.IFLA_BRPORT_STATE]
+	if !ok || len(value) == 0 {
+		return "", fmt.Errorf("bridge port state of %q is not reported", link.Attrs().Name)
+	}
+
+	name, ok := bridgePortStateNames[value[0]]
+	if !ok {
+		return "", fmt.Errorf("unknown bridge port state %d of %q", value[0], link.Attrs().Name)
+	}
+
+	return name, nil
+}
+
+// setBridgePortMcastRouter sets the multicast router role of the bridge port link.
+func setBridgePortMcastRouter(link netlink.Link, role int) error {
+	req := nl.NewNetlinkRequest(unix.RTM_SETLINK, unix.NLM_F_ACK)
+
+	msg := nl.NewIfInfomsg(unix.AF_BRIDGE)
+	msg.Index = int32(link.Attrs().Index)
+	req.AddData(msg)
+
+	protinfo := nl.NewRtAttr(unix.IFLA_PROTINFO|unix.NLA_F_NESTED, nil)
+	protinfo.AddRtAttr(unix.IFLA_BRPORT_MULTICAST_ROUTER, nl.Uint8Attr(uint8(role)))
+	req.AddData(protinfo)
+
+	if _, err := req.Execute(unix.NETLINK_ROUTE, 0); err != nil {
+		return fmt.Errorf("failed to set multicast router role %d of %q: %v", role, link.Attrs().Name, err)
+	}
+
+	return nil
+}
+
+// checkBridgeMacUnique fails if a port of the bridge other than the link has the link MAC.
+func checkBridgeMacUnique(br, link netlink.Link) error {
+	mac := link.Attrs().HardwareAddr
+	if len(mac) == 0 {
+		return nil
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return fmt.Errorf("failed to list links: %v", err)
+	}
+
+	for _, port := range links {
+		if port.Attrs().MasterIndex != br.Attrs().Index || port.Attrs().Index == link.Attrs().Index {
+			continue
+		}
+
+		if bytes.Equal(port.Attrs().HardwareAddr, mac) {
+			return fmt.Errorf("MAC %s of %q is already used by bridge %s port %q", mac, link.Attrs().Name,
+				br.Attrs().Name, port.Attrs().Name)
+		}
+	}
+
+	return nil
+}
+
+// addBridgeVlanFilter adds the VLAN ID as the untagged PVID entry of the bridge port link, so the VLAN aware bridge
+// forwards the VLAN traffic in the VLAN ID. An existing entry is handled according to the VLAN filter policy. Returns
+// whether the entry was added and should be removed on DEL.
+func addBridgeVlanFilter(conf *pluginConf, link netlink.Link) (added bool, err error) {
+	entries, err := netlink.BridgeVlanList()
+	if err != nil {
+		return false, fmt.Errorf("failed to list bridge VLAN entries: %v", err)
+	}
+
+	exists := false
+
+	for _, entry := range entries[int32(link.Attrs().Index)] {
+		if int(entry.Vid) == conf.VlanId {
+			exists = true
+			break
+		}
+	}
+
+	if exists && conf.VlanFilterPolicy == vlanFilterStrict {
+		return false, fmt.Errorf("bridge port %s already has VLAN %d entry and VLAN filter policy is %q",
+			link.Attrs().Name, conf.VlanId, conf.VlanFilterPolicy)
+	}
+
+	if err := netlink.BridgeVlanAdd(link, uint16(conf.VlanId), true, true, false, false); err != nil {
+		return false, fmt.Errorf("failed to add VLAN %d entry to bridge port %s: %v", conf.VlanId,
+			link.Attrs().Name, err)
+	}
+
+	return !exists, nil
+}
+
+// setBridgeVlanFilter adds the bridge port VLAN filter entry and records it in the VLAN marker if it was added.
+func setBridgeVlanFilter(conf *pluginConf, link netlink.Link) error {
+	added, err := addBridgeVlanFilter(conf, link)
+	if err != nil || !added {
+		return err
+	}
+
+	marker, ok := getVlanMarker(link)
+	if !ok {
+		marker.Up = !conf.NoUp
+	}
+
+	marker.PortVlan = conf.VlanId
+
+	return setVlanMarker(link, marker)
+}
+
+// removeBridgeVlanFilter removes the bridge port VLAN filter entry recorded in the VLAN marker. Entries existing before
+// ADD are kept.
+func removeBridgeVlanFilter(conf *pluginConf) error {
+	link, err := netlink.LinkByName(conf.IfName)
+	if err != nil {
+		if errors.As(err, new(netlink.LinkNotFoundError)) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to lookup %q: %v", conf.IfName, err)
+	}
+
+	marker, ok := getVlanMarker(link)
+	if !ok || marker.PortVlan == 0 {
+		return nil
+	}
+
+	if link.Attrs().MasterIndex != 0 {
+		if err := netlink.BridgeVlanDel(link, uint16(marker.PortVlan), true, true, false, false); err != nil &&
+			!errors.Is(err, syscall.ENOENT) {
+			return fmt.Errorf("failed to remove VLAN %d entry from bridge port %s: %v", marker.PortVlan,
+				conf.IfName, err)
+		}
+	}
+
+	marker.PortVlan = 0
+
+	return setVlanMarker(link, marker)
+}
+
+// validateBridgeVlan checks the bridge VLAN entries are valid VLAN IDs, each configured once.
+func validateBridgeVlan(bridgeVlan *bridgeVlanConf) error {
+	vids := make(map[int]bool)
+
+	for _, vid := range bridgeVlanIds(bridgeVlan) {
+		if vid < 1 || vid > 4094 {
+			return fmt.Errorf("invalid bridge VLAN ID %d (must be between 1 and 4094 inclusive)", vid)
+		}
+
+		if vids[vid] {
+			return fmt.Errorf("duplicate bridge VLAN ID %d", vid)
+		}
+
+		vids[vid] = true
+	}
+
+	return nil
+}
+
+// bridgeVlanIds returns all VLAN IDs of the bridge VLAN entries.
+func bridgeVlanIds(bridgeVlan *bridgeVlanConf) (vids []int) {
+	if bridgeVlan.Pvid != 0 {
+		vids = append(vids, bridgeVlan.Pvid)
+	}
+
+	vids = append(vids, bridgeVlan.Tagged...)
+
+	return append(vids, bridgeVlan.Untagged...)
+}
+
+// bridgeVlanFiltering returns whether VLAN filtering is enabled on the bridge.
+func bridgeVlanFiltering(brIndex int) (bool, error) {
+	link, err := netlink.LinkByIndex(brIndex)
+	if err != nil {
+		return false, fmt.Errorf("failed to lookup bridge %d: %v", brIndex, err)
+	}
+
+	br, ok := link.(*netlink.Bridge)
+	if !ok {
+		return false, fmt.Errorf("%q is not a bridge", link.Attrs().Name)
+	}
+
+	return br.VlanFiltering != nil && *br.VlanFiltering, nil
+}
+
+// addBridgeVlans adds the configured VLAN filter entries to the bridge port link. Bridges without VLAN filtering are
+// skipped.
+func addBridgeVlans(conf *pluginConf, link netlink.Link) error {
+	filtering, err := bridgeVlanFiltering(link.Attrs().MasterIndex)
+	if err != nil || !filtering {
+		return err
+	}
+
+	if conf.BridgeVlan.Pvid != 0 {
+		if err := bridgeVlanAdd(link, conf.BridgeVlan.Pvid, true, true); err != nil {
+			return err
+		}
+	}
+
+	for _, vid := range conf.BridgeVlan.Tagged {
+		if err := bridgeVlanAdd(link, vid, false, false); err != nil {
+			return err
+		}
+	}
+
+	for _, vid := range conf.BridgeVlan.Untagged {
+		if err := bridgeVlanAdd(link, vid, false, true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func bridgeVlanAdd(link netlink.Link, vid int, pvid, untagged bool) error {
+	logDebug("adding bridge VLAN entry", "port", link.Attrs().Name, "vid", vid, "pvid", pvid, "untagged", untagged)
+
+	if err := netlink.BridgeVlanAdd(link, uint16(vid), pvid, untagged, false, false); err != nil {
+		return fmt.Errorf("failed to add VLAN %d entry to bridge port %s: %v", vid, link.Attrs().Name, err)
+	}
+
+	return nil
+}
+
+// removeBridgeVlans removes the configured VLAN filter entries from the bridge port. It is needed only if the port
+// outlives DEL, entries of a deleted port are removed by the kernel.
+func removeBridgeVlans(conf *pluginConf) error {
+	link, err := netlink.LinkByName(conf.IfName)
+	if err != nil {
+		if errors.As(err, new(netlink.LinkNotFoundError)) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to lookup %q: %v", conf.IfName, err)
+	}
+
+	if link.Attrs().MasterIndex == 0 {
+		return nil
+	}
+
+	filtering, err := bridgeVlanFiltering(link.Attrs().MasterIndex)
+	if err != nil || !filtering {
+		return err
+	}
+
+	for _, vid := range bridgeVlanIds(conf.BridgeVlan) {
+		if err := netlink.BridgeVlanDel(link, uint16(vid), false, false, false, false); err != nil &&
+			!errors.Is(err, syscall.ENOENT) {
+			return fmt.Errorf("failed to remove VLAN %d entry from bridge port %s: %v", vid, conf.IfName, err)
+		}
+	}
+
+	return nil
+}
+
+// waitMasterCarrier waits up to the waitMasterCarrier timeout for the master bridge carrier. On timeout a try again
+// later error is returned, so the runtime retries ADD instead of the VLAN being attached to a bridge without carrier.
+func waitMasterCarrier(conf *pluginConf, br netlink.Link) error {
+	deadline := time.Now().Add(conf.WaitMasterCarrier.Duration)
+
+	for {
+		link, err := netlink.LinkByIndex(br.Attrs().Index)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", br.Attrs().Name, err)
+		}
+
+		if link.Attrs().RawFlags&unix.IFF_LOWER_UP != 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return types.NewError(types.ErrTryAgainLater, fmt.Sprintf("master bridge %s has no carrier",
+				br.Attrs().Name), fmt.Sprintf("no carrier after %v", conf.WaitMasterCarrier.Duration))
+		}
+
+		time.Sleep(masterCarrierPollInterval)
+	}
+}
@@ -0,0 +1,394 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan bridge port", func() {
+	var testNS ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+
+		testNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(testNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(testNS)).To(Succeed())
+	})
+
+	It("aos-vlan sets bridge port multicast router role", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0"}}
+			Expect(netlink.LinkAdd(br)).To(Succeed())
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}, PeerName: "aos-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			port, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetMaster(port, br)).To(Succeed())
+
+			portAttrs, err := bridgePortAttrs(port)
+			Expect(err).NotTo(HaveOccurred())
+
+			if _, ok := portAttrs[unix.IFLA_BRPORT_MULTICAST_ROUTER]; !ok {
+				Skip("bridge multicast snooping is not available")
+			}
+
+			for _, role := range []int{mcastRouterPerm, mcastRouterDisabled} {
+				Expect(setBridgePortMcastRouter(port, role)).To(Succeed())
+
+				portAttrs, err := bridgePortAttrs(port)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(portAttrs[unix.IFLA_BRPORT_MULTICAST_ROUTER]).To(Equal([]byte{uint8(role)}))
+			}
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("aos-vlan rejects master which is not a bridge", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			for _, name := range []string{"aos-master", "aos-vlan"} {
+				err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: name + "-peer"})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			link, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			err = addVlanToBridge(&pluginConf{Master: "aos-master"}, link)
+			Expect(err).To(MatchError(`master "aos-master" is a veth device, not a bridge`))
+
+			link, err = netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link.Attrs().MasterIndex).To(BeZero())
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("aos-vlan checks VLAN is attached to master bridge", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0"}}
+			Expect(netlink.LinkAdd(br)).To(Succeed())
+			Expect(netlink.LinkSetUp(br)).To(Succeed())
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}, PeerName: "aos-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			link, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			conf := &pluginConf{Master: "br0"}
+
+			Expect(addVlanToBridge(conf, link)).To(Succeed())
+
+			link, err = netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(checkVlanMaster(conf, link)).To(Succeed())
+
+			// Detached out of band
+			Expect(netlink.LinkSetNoMaster(link)).To(Succeed())
+
+			link, err = netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(checkVlanMaster(conf, link)).To(MatchError(MatchRegexp(
+				`^vlan link aos-vlan is not attached to bridge br0 \(master index 0, bridge index \d+\)$`)))
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("aos-vlan rejects MAC used by another bridge port", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0"}}
+			Expect(netlink.LinkAdd(br)).To(Succeed())
+			Expect(netlink.LinkSetUp(br)).To(Succeed())
+
+			mac, err := net.ParseMAC("02:00:00:00:00:01")
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, name := range []string{"aos-port", "aos-vlan"} {
+				err := netlink.LinkAdd(&netlink.Veth{
+					LinkAttrs: netlink.LinkAttrs{Name: name, HardwareAddr: mac}, PeerName: name + "-peer",
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			port, err := netlink.LinkByName("aos-port")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetMaster(port, br)).To(Succeed())
+
+			link, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			err = addVlanToBridge(&pluginConf{Master: "br0", UniqueBridgeMac: true}, link)
+			Expect(err).To(MatchError(`MAC 02:00:00:00:00:01 of "aos-vlan" is already used by bridge br0 port ` +
+				`"aos-port"`))
+
+			Expect(addVlanToBridge(&pluginConf{Master: "br0"}, link)).To(Succeed())
+
+			// The VLAN attached by the previous ADD is not a collision
+			Expect(netlink.LinkSetHardwareAddr(port, net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02})).To(Succeed())
+			Expect(addVlanToBridge(&pluginConf{Master: "br0", UniqueBridgeMac: true}, link)).To(Succeed())
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("aos-vlan applies VLAN filter policy to pre-existing port entries", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			vlanFiltering := true
+
+			br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0"}, VlanFiltering: &vlanFiltering}
+
+			err := netlink.LinkAdd(br)
+			if errors.Is(err, syscall.EOPNOTSUPP) {
+				Skip("bridge VLAN filtering is not available")
+			}
+
+			Expect(err).NotTo(HaveOccurred())
+
+			err = netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}, PeerName: "aos-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			port, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetMaster(port, br)).To(Succeed())
+
+			portVlans := func() (vids []int) {
+				entries, err := netlink.BridgeVlanList()
+				Expect(err).NotTo(HaveOccurred())
+
+				for _, entry := range entries[int32(port.Attrs().Index)] {
+					vids = append(vids, int(entry.Vid))
+				}
+
+				return vids
+			}
+
+			// Pre-existing entry
+			Expect(netlink.BridgeVlanAdd(port, 100, false, false, false, false)).To(Succeed())
+
+			conf := &pluginConf{IfName: "aos-vlan", VlanId: 100, VlanFilterPolicy: vlanFilterStrict}
+
+			Expect(setBridgeVlanFilter(conf, port)).To(MatchError(
+				`bridge port aos-vlan already has VLAN 100 entry and VLAN filter policy is "strict"`))
+
+			conf.VlanFilterPolicy = vlanFilterMerge
+
+			Expect(setBridgeVlanFilter(conf, port)).To(Succeed())
+			Expect(removeBridgeVlanFilter(conf)).To(Succeed())
+			Expect(portVlans()).To(ContainElement(100))
+
+			// Entry added by the plugin
+			conf = &pluginConf{IfName: "aos-vlan", VlanId: 200, VlanFilterPolicy: vlanFilterStrict}
+
+			Expect(setBridgeVlanFilter(conf, port)).To(Succeed())
+			Expect(portVlans()).To(ContainElement(200))
+
+			port, err = netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			marker, ok := getVlanMarker(port)
+			Expect(ok).To(BeTrue())
+			Expect(marker.PortVlan).To(Equal(200))
+
+			Expect(removeBridgeVlanFilter(conf)).To(Succeed())
+			Expect(portVlans()).NotTo(ContainElement(200))
+			Expect(portVlans()).To(ContainElement(100))
+
+			// Removing again is not an error
+			Expect(removeBridgeVlanFilter(conf)).To(Succeed())
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+	It("aos-vlan waits for the master bridge carrier", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0"}}
+			Expect(netlink.LinkAdd(br)).To(Succeed())
+			Expect(netlink.LinkSetUp(br)).To(Succeed())
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-port"}, PeerName: "aos-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			port, err := netlink.LinkByName("aos-port")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetMaster(port, br)).To(Succeed())
+
+			// Bridge with ports has no carrier until any of its ports has carrier
+			conf := &pluginConf{Master: "br0", WaitMasterCarrier: duration{200 * time.Millisecond}}
+
+			err = waitMasterCarrier(conf, br)
+
+			var cniErr *types.Error
+
+			Expect(errors.As(err, &cniErr)).To(BeTrue())
+			Expect(cniErr.Code).To(Equal(types.ErrTryAgainLater))
+			Expect(cniErr.Msg).To(Equal("master bridge br0 has no carrier"))
+
+			// Carrier comes up with the port
+			done := make(chan error, 1)
+
+			go func() {
+				time.Sleep(300 * time.Millisecond)
+
+				done <- testNS.Do(func(ns.NetNS) error {
+					for _, name := range []string{"aos-port", "aos-peer"} {
+						if err := netlink.LinkSetUp(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: name}}); err != nil {
+							return err
+						}
+					}
+
+					return nil
+				})
+			}()
+
+			conf.WaitMasterCarrier = duration{5 * time.Second}
+
+			Expect(waitMasterCarrier(conf, br)).To(Succeed())
+			Expect(<-done).To(Succeed())
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+	It("aos-vlan adds bridge VLAN entries to the port", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			conf := &pluginConf{
+				IfName:     "aos-vlan",
+				BridgeVlan: &bridgeVlanConf{Pvid: 10, Tagged: []int{20, 21}, Untagged: []int{30}},
+			}
+
+			portVlans := func(port netlink.Link) map[int]uint16 {
+				entries, err := netlink.BridgeVlanList()
+				Expect(err).NotTo(HaveOccurred())
+
+				vids := make(map[int]uint16)
+
+				for _, entry := range entries[int32(port.Attrs().Index)] {
+					vids[int(entry.Vid)] = entry.Flags
+				}
+
+				return vids
+			}
+
+			// Bridge without VLAN filtering is skipped
+			Expect(netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0"}})).To(Succeed())
+
+			port := addBridgePort("br0", "aos-vlan", "aos-peer")
+
+			Expect(addBridgeVlans(conf, port)).To(Succeed())
+			Expect(portVlans(port)).NotTo(HaveKey(20))
+			Expect(removeBridgeVlans(conf)).To(Succeed())
+
+			vlanFiltering := true
+
+			err := netlink.LinkAdd(&netlink.Bridge{
+				LinkAttrs: netlink.LinkAttrs{Name: "br1"}, VlanFiltering: &vlanFiltering,
+			})
+			if errors.Is(err, syscall.EOPNOTSUPP) {
+				Skip("bridge VLAN filtering is not available")
+			}
+
+			Expect(err).NotTo(HaveOccurred())
+
+			conf.IfName = "aos-vlan1"
+			port = addBridgePort("br1", "aos-vlan1", "aos-peer1")
+
+			Expect(addBridgeVlans(conf, port)).To(Succeed())
+
+			vids := portVlans(port)
+			Expect(vids[10]).To(Equal(uint16(nl.BRIDGE_VLAN_INFO_PVID | nl.BRIDGE_VLAN_INFO_UNTAGGED)))
+			Expect(vids[20]).To(BeZero())
+			Expect(vids).To(HaveKey(21))
+			Expect(vids[30]).To(Equal(uint16(nl.BRIDGE_VLAN_INFO_UNTAGGED)))
+
+			Expect(removeBridgeVlans(conf)).To(Succeed())
+
+			vids = portVlans(port)
+			for _, vid := range []int{10, 20, 21, 30} {
+				Expect(vids).NotTo(HaveKey(vid))
+			}
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+// addBridgePort adds the veth pair and attaches its first link to the bridge.
+func addBridgePort(bridge, name, peerName string) netlink.Link {
+	br, err := netlink.LinkByName(bridge)
+	Expect(err).NotTo(HaveOccurred())
+
+	err = netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: peerName})
+	Expect(err).NotTo(HaveOccurred())
+
+	port, err := netlink.LinkByName(name)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(netlink.LinkSetMaster(port, br)).To(Succeed())
+
+	port, err = netlink.LinkByName(name)
+	Expect(err).NotTo(HaveOccurred())
+
+	return port
+}
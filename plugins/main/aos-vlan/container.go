@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const maxConflictRenames = 100
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// moveVlanToContainer moves the VLAN into the container network namespace and renames it to ifName there.
+func moveVlanToContainer(conf *pluginConf, vlan *netlink.Vlan, netnsPath, ifName string) (*current.Interface, error) {
+	netns, err := ns.GetNS(netnsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netns %q: %v", netnsPath, err)
+	}
+	defer netns.Close()
+
+	if ifName, err = containerIfName(conf, netns, vlan.Attrs().Name, ifName); err != nil {
+		return nil, err
+	}
+
+	logDebug("moving vlan link to container", "name", vlan.Attrs().Name, "netns", netnsPath, "ifName", ifName)
+
+	if err := netlink.LinkSetNsFd(vlan, int(netns.Fd())); err != nil {
+		return nil, fmt.Errorf("failed to move %q to netns %q: %v", vlan.Attrs().Name, netnsPath, err)
+	}
+
+	vlanInterface := &current.Interface{Name: ifName, Sandbox: netns.Path()}
+
+	if err := netns.Do(func(ns.NetNS) error {
+		link, err := netlink.LinkByName(vlan.Attrs().Name)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q in netns: %v", vlan.Attrs().Name, err)
+		}
+
+		if link.Attrs().Name != ifName {
+			if err := netlink.LinkSetName(link, ifName); err != nil {
+				return fmt.Errorf("failed to rename %q to %q: %v", link.Attrs().Name, ifName, err)
+			}
+		}
+
+		if !conf.NoUp {
+			if err := netlink.LinkSetUp(link); err != nil {
+				return fmt.Errorf("failed to set %q up: %v", ifName, err)
+			}
+		}
+
+		if len(conf.Sysctls) != 0 {
+			if err := setVlanSysctls(conf, ifName); err != nil {
+				return err
+			}
+		}
+
+		vlanInterface.Mac = resultMac(link.Attrs().HardwareAddr)
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return vlanInterface, nil
+}
+
+// containerIfName returns the name the VLAN gets in the container namespace. If ifName is taken, it fails or, with
+// renameOnConflict, picks a free name derived from ifName.
+func containerIfName(conf *pluginConf, netns ns.NetNS, hostName, ifName string) (name string, err error) {
+	err = netns.Do(func(ns.NetNS) error {
+		// The VLAN is moved with its host name, so it must be free as well unless it is the target name
+		if hostName != ifName && linkExists(hostName) {
+			return fmt.Errorf("interface %q already exists in container namespace", hostName)
+		}
+
+		if !linkExists(ifName) {
+			name = ifName
+			return nil
+		}
+
+		if !conf.RenameOnConflict {
+			return fmt.Errorf("interface %q already exists in container namespace", ifName)
+		}
+
+		for i := 1; i <= maxConflictRenames; i++ {
+			suffix := strconv.Itoa(i)
+
+			candidate := ifName
+			if len(candidate)+len(suffix) > maxIfNameLen {
+				candidate = candidate[:maxIfNameLen-len(suffix)]
+			}
+
+			if candidate += suffix; candidate != hostName && !linkExists(candidate) {
+				name = candidate
+				return nil
+			}
+		}
+
+		return fmt.Errorf("no free interface name derived from %q in container namespace", ifName)
+	})
+
+	return name, err
+}
+
+func linkExists(name string) bool {
+	_, err := netlink.LinkByName(name)
+
+	return err == nil
+}
@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	vlanlib "github.com/aoscloud/aos_cni_vlan/pkg/vlan"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/vishvananda/netlink"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// systemDefaults are environment-level settings applied to every network configuration, so operators can lock down
+// the plugin centrally.
+type systemDefaults struct {
+	// AllowedVlanProtocols restricts vlanProtocol to this list, any protocol is allowed if empty.
+	AllowedVlanProtocols []string `json:"allowedVlanProtocols"`
+	// MaxTotalVlans limits the number of VLAN links of the host network namespace, unlimited if zero.
+	MaxTotalVlans int `json:"maxTotalVlans"`
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// systemDefaultsFile is the system defaults file, variable for testing.
+var systemDefaultsFile = "/etc/aos-vlan/defaults.json"
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// loadSystemDefaults reads the system defaults file. A missing file means no environment-level settings.
+func loadSystemDefaults() (*systemDefaults, error) {
+	defaults := &systemDefaults{}
+
+	data, err := os.ReadFile(systemDefaultsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return defaults, nil
+		}
+
+		return nil, fmt.Errorf("failed to read system defaults: %v", err)
+	}
+
+	if err := json.Unmarshal(data, defaults); err != nil {
+		return nil, fmt.Errorf("failed to parse system defaults %s: %v", systemDefaultsFile, err)
+	}
+
+	if defaults.MaxTotalVlans < 0 {
+		return nil, fmt.Errorf("invalid maxTotalVlans %d in %s", defaults.MaxTotalVlans, systemDefaultsFile)
+	}
+
+	return defaults, nil
+}
+
+// checkSystemDefaults enforces the system defaults on the configuration.
+func checkSystemDefaults(conf *pluginConf) error {
+	defaults, err := loadSystemDefaults()
+	if err != nil {
+		return err
+	}
+
+	return checkVlanProtocolAllowed(defaults, conf.VlanProtocol)
+}
+
+// checkVlanProtocolAllowed fails if the system defaults don't allow the VLAN protocol.
+func checkVlanProtocolAllowed(defaults *systemDefaults, protocol string) error {
+	if len(defaults.AllowedVlanProtocols) == 0 {
+		return nil
+	}
+
+	for _, allowed := range defaults.AllowedVlanProtocols {
+		if normalized, _ := vlanlib.NormalizeVlanProtocol(allowed); normalized == protocol {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("VLAN protocol %q is not allowed by %s (allowed: %v)", protocol, systemDefaultsFile,
+		defaults.AllowedVlanProtocols)
+}
+
+// checkMaxTotalVlans fails with the "try again later" CNI error if creating the configured VLANs would exceed the
+// host-wide VLAN limit of the system defaults. VLANs which already exist, e.g. shared ones, don't count as new.
+func checkMaxTotalVlans(conf *pluginConf) error {
+	defaults, err := loadSystemDefaults()
+	if err != nil {
+		return err
+	}
+
+	if defaults.MaxTotalVlans == 0 {
+		return nil
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return fmt.Errorf("failed to list links: %v", err)
+	}
+
+	existing := make(map[string]bool)
+
+	for _, link := range links {
+		if _, ok := link.(*netlink.Vlan); ok {
+			existing[link.Attrs().Name] = true
+		}
+	}
+
+	names := []string{conf.IfName}
+
+	if len(conf.Vlans) != 0 {
+		names = names[:0]
+
+		for _, entry := range conf.Vlans {
+			names = append(names, entry.IfName)
+		}
+	}
+
+	requested := 0
+
+	for _, name := range names {
+		if !existing[name] {
+			requested++
+		}
+	}
+
+	if requested == 0 || len(existing)+requested <= defaults.MaxTotalVlans {
+		return nil
+	}
+
+	return types.NewError(types.ErrTryAgainLater, "maximum total VLAN count reached",
+		fmt.Sprintf("%d VLANs exist, %d requested, %s allows %d", len(existing), requested, systemDefaultsFile,
+			defaults.MaxTotalVlans))
+}
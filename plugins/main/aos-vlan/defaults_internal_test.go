@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan system defaults", func() {
+	var (
+		tmpDir                     string
+		originalSystemDefaultsFile string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		tmpDir, err = os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
+		originalSystemDefaultsFile = systemDefaultsFile
+		systemDefaultsFile = filepath.Join(tmpDir, "defaults.json")
+	})
+
+	AfterEach(func() {
+		systemDefaultsFile = originalSystemDefaultsFile
+
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("aos-vlan allows any VLAN protocol without system defaults", func() {
+		conf, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"vlanProtocol": "802.1AD"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.VlanProtocol).To(Equal("802.1ad"))
+
+		_, _, err = parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"vlanProtocol": "802.1x"}`))
+		Expect(err).To(MatchError(ContainSubstring(`invalid VLAN protocol "802.1x"`)))
+	})
+
+	It("aos-vlan rejects VLAN protocols not allowed by system defaults", func() {
+		Expect(os.WriteFile(systemDefaultsFile, []byte(`{"allowedVlanProtocols": ["802.1Q"]}`), 0o644)).To(Succeed())
+
+		conf, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.VlanProtocol).To(Equal("802.1q"))
+
+		_, _, err = parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"vlanProtocol": "802.1ad"}`))
+		Expect(err).To(MatchError(ContainSubstring(`VLAN protocol "802.1ad" is not allowed`)))
+	})
+
+	It("aos-vlan fails on invalid system defaults", func() {
+		Expect(os.WriteFile(systemDefaultsFile, []byte(`{"allowedVlanProtocols": "802.1q"}`), 0o644)).To(Succeed())
+
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan"}`))
+		Expect(err).To(MatchError(ContainSubstring("failed to parse system defaults")))
+	})
+
+	It("aos-vlan fails on negative maxTotalVlans", func() {
+		Expect(os.WriteFile(systemDefaultsFile, []byte(`{"maxTotalVlans": -1}`), 0o644)).To(Succeed())
+
+		conf, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan"}`))
+		Expect(err).To(MatchError(ContainSubstring("invalid maxTotalVlans -1")))
+		Expect(conf).To(BeNil())
+	})
+
+	It("aos-vlan refuses VLANs beyond maxTotalVlans", func() {
+		testNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(testNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		}()
+
+		Expect(os.WriteFile(systemDefaultsFile, []byte(`{"maxTotalVlans": 2}`), 0o644)).To(Succeed())
+
+		err = testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			expectRefused := func(conf *pluginConf) {
+				err := checkMaxTotalVlans(conf)
+				Expect(err).To(HaveOccurred())
+
+				cniErr, ok := err.(*types.Error)
+				Expect(ok).To(BeTrue())
+				Expect(cniErr.Code).To(BeEquivalentTo(types.ErrTryAgainLater))
+				Expect(cniErr.Msg).To(Equal("maximum total VLAN count reached"))
+			}
+
+			Expect(checkMaxTotalVlans(&pluginConf{Vlans: []vlanEntry{
+				{VlanId: 100, IfName: "aos-vlan1"}, {VlanId: 101, IfName: "aos-vlan2"},
+			}})).To(Succeed())
+			expectRefused(&pluginConf{Vlans: []vlanEntry{
+				{VlanId: 100, IfName: "aos-vlan1"}, {VlanId: 101, IfName: "aos-vlan2"}, {VlanId: 102, IfName: "aos-vlan3"},
+			}})
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-trunk"}, PeerName: "aos-trunk-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			trunk, err := netlink.LinkByName("aos-trunk")
+			Expect(err).NotTo(HaveOccurred())
+
+			for i, name := range []string{"aos-vlan1", "aos-vlan2"} {
+				Expect(checkMaxTotalVlans(&pluginConf{IfName: name})).To(Succeed())
+
+				err := netlink.LinkAdd(&netlink.Vlan{
+					LinkAttrs: netlink.LinkAttrs{Name: name, ParentIndex: trunk.Attrs().Index}, VlanId: 100 + i,
+				})
+				if err != nil {
+					Skip("VLAN links are not supported: " + err.Error())
+				}
+			}
+
+			expectRefused(&pluginConf{IfName: "aos-vlan3"})
+
+			// Existing VLAN doesn't count as new
+			Expect(checkMaxTotalVlans(&pluginConf{IfName: "aos-vlan2"})).To(Succeed())
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+
+	vlanlib "github.com/aoscloud/aos_cni_vlan/pkg/vlan"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	defaultDelBusyRetries  = 3
+	defaultDelBusyInterval = 100 * time.Millisecond
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// linkDel deletes the link, variable for testing.
+var linkDel = netlink.LinkDel
+
+// linkByIndex looks the link up by index, variable for testing.
+var linkByIndex = netlink.LinkByIndex
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// deleteVlan deletes the VLAN created by cmdAdd. A missing link or container namespace is not an error.
+func deleteVlan(conf *pluginConf, args *skel.CmdArgs) error {
+	if !conf.InContainer {
+		return deleteLinkByName(conf, args.ContainerID, conf.IfName)
+	}
+
+	ifName := stateContainerIfName(conf, args.ContainerID, args.IfName)
+
+	err := ns.WithNetNSPath(args.Netns, func(ns.NetNS) error {
+		return deleteLinkByName(conf, args.ContainerID, ifName)
+	})
+	if errors.As(err, new(ns.NSPathNotExistErr)) {
+		return nil
+	}
+
+	return err
+}
+
+// deleteLinkByName deletes the VLAN set up by the plugin. A missing link or a link which is not ours is skipped.
+func deleteLinkByName(conf *pluginConf, containerID, name string) error {
+	vlan, err := ownVlanByName(conf, containerID, name)
+	if err != nil || vlan == nil {
+		return err
+	}
+
+	return deleteLinkWithRetry(conf, vlan)
+}
+
+// ownVlanByName returns the VLAN with the name if it carries the plugin marker, matches the VLAN recorded in the
+// container state or is the configured shared VLAN. Returns nil for a missing link, a link of another type or a foreign
+// VLAN.
+func ownVlanByName(conf *pluginConf, containerID, name string) (*netlink.Vlan, error) {
+	vlan, err := vlanlib.ByName(name)
+	if err != nil {
+		if errors.As(err, new(netlink.LinkNotFoundError)) {
+			return nil, nil
+		}
+
+		if errors.Is(err, vlanlib.ErrNotVlan) {
+			logDebug("skipping link which is not a vlan", "name", name)
+
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if _, ok := getVlanMarker(vlan); !ok && !isRecordedVlan(conf, containerID, vlan) &&
+		!(conf.Shared && vlan.VlanId == conf.VlanId) {
+		logDebug("skipping vlan which was not set up by the plugin", "name", name)
+
+		return nil, nil
+	}
+
+	return vlan, nil
+}
+
+// deleteLinkWithRetry deletes the link retrying while it is busy. With verifyDelete the link is looked up after the
+// deletion and, if it is still there, deleted once more.
+func deleteLinkWithRetry(conf *pluginConf, link netlink.Link) error {
+	if err := deleteBusyLink(conf, link); err != nil {
+		return err
+	}
+
+	if !conf.VerifyDelete {
+		return nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		exists, err := linkStillExists(link)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return nil
+		}
+
+		if attempt >= 1 {
+			return fmt.Errorf("%q still exists after delete", link.Attrs().Name)
+		}
+
+		if err := deleteBusyLink(conf, link); err != nil {
+			return err
+		}
+	}
+}
+
+func deleteBusyLink(conf *pluginConf, link netlink.Link) error {
+	retries, interval := defaultDelBusyRetries, defaultDelBusyInterval
+
+	if conf.DelBusyRetries != nil {
+		retries = *conf.DelBusyRetries
+	}
+
+	if conf.DelBusyInterval.Duration != 0 {
+		interval = conf.DelBusyInterval.Duration
+	}
+
+	logDebug("deleting link", "name", link.Attrs().Name)
+
+	for attempt := 0; ; attempt++ {
+		err := linkDel(link)
+		if err == nil {
+			return nil
+		}
+
+		if !errors.Is(err, syscall.EBUSY) || attempt >= retries {
+			return fmt.Errorf("failed to delete %q: %v", link.Attrs().Name, err)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+func linkStillExists(link netlink.Link) (bool, error) {
+	if _, err := linkByIndex(link.Attrs().Index); err != nil {
+		if errors.As(err, new(netlink.LinkNotFoundError)) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("failed to lookup %q: %v", link.Attrs().Name, err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,194 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan delete", func() {
+	var (
+		originalLinkDel     func(link netlink.Link) error
+		originalLinkByIndex func(index int) (netlink.Link, error)
+	)
+
+	link := &netlink.Vlan{LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}, VlanId: 100}
+	retries := 2
+	conf := &pluginConf{DelBusyRetries: &retries, DelBusyInterval: duration{time.Millisecond}}
+
+	BeforeEach(func() {
+		originalLinkDel = linkDel
+		originalLinkByIndex = linkByIndex
+	})
+
+	AfterEach(func() {
+		linkDel = originalLinkDel
+		linkByIndex = originalLinkByIndex
+	})
+
+	// fakeLinkDel fails with the errors in order and succeeds afterwards.
+	fakeLinkDel := func(calls *int, errs ...error) func(link netlink.Link) error {
+		return func(link netlink.Link) error {
+			*calls++
+
+			if *calls <= len(errs) {
+				return errs[*calls-1]
+			}
+
+			return nil
+		}
+	}
+
+	// fakeLinkByIndex reports the link as existing for the given number of lookups.
+	fakeLinkByIndex := func(lookups *int, lingers int) func(index int) (netlink.Link, error) {
+		return func(index int) (netlink.Link, error) {
+			*lookups++
+
+			if *lookups <= lingers {
+				return link, nil
+			}
+
+			return nil, netlink.LinkNotFoundError{}
+		}
+	}
+
+	It("retries deleting busy link", func() {
+		calls := 0
+		linkDel = fakeLinkDel(&calls, syscall.EBUSY)
+
+		Expect(deleteLinkWithRetry(conf, link)).To(Succeed())
+		Expect(calls).To(Equal(2))
+	})
+
+	It("gives up when link stays busy", func() {
+		calls := 0
+		linkDel = fakeLinkDel(&calls, syscall.EBUSY, syscall.EBUSY, syscall.EBUSY)
+
+		Expect(deleteLinkWithRetry(conf, link)).To(MatchError(ContainSubstring("device or resource busy")))
+		Expect(calls).To(Equal(3))
+	})
+
+	It("doesn't retry other errors", func() {
+		calls := 0
+		linkDel = fakeLinkDel(&calls, syscall.EPERM)
+
+		Expect(deleteLinkWithRetry(conf, link)).NotTo(Succeed())
+		Expect(calls).To(Equal(1))
+	})
+
+	It("deletes lingering link once more", func() {
+		calls, lookups := 0, 0
+		linkDel = fakeLinkDel(&calls)
+		linkByIndex = fakeLinkByIndex(&lookups, 1)
+
+		Expect(deleteLinkWithRetry(&pluginConf{VerifyDelete: true}, link)).To(Succeed())
+		Expect(calls).To(Equal(2))
+		Expect(lookups).To(Equal(2))
+	})
+
+	It("fails when link lingers after delete", func() {
+		calls, lookups := 0, 0
+		linkDel = fakeLinkDel(&calls)
+		linkByIndex = fakeLinkByIndex(&lookups, 2)
+
+		Expect(deleteLinkWithRetry(&pluginConf{VerifyDelete: true}, link)).To(
+			MatchError(`"aos-vlan" still exists after delete`))
+		Expect(calls).To(Equal(2))
+	})
+
+	It("doesn't verify delete by default", func() {
+		calls, lookups := 0, 0
+		linkDel = fakeLinkDel(&calls)
+		linkByIndex = fakeLinkByIndex(&lookups, 1)
+
+		Expect(deleteLinkWithRetry(conf, link)).To(Succeed())
+		Expect(calls).To(Equal(1))
+		Expect(lookups).To(Equal(0))
+	})
+
+	It("skips links which are not vlans", func() {
+		testNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(testNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		}()
+
+		err = testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			Expect(netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0"}})).To(Succeed())
+			Expect(netlink.LinkAdd(&netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}, PeerName: "aos-peer",
+			})).To(Succeed())
+
+			veth, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			bridge, err := netlink.LinkByName("br0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetMaster(veth, bridge)).To(Succeed())
+
+			conf := &pluginConf{IfName: "aos-vlan"}
+
+			Expect(detachVlan(conf, "dummy")).To(Succeed())
+			Expect(deleteLinkByName(conf, "dummy", "aos-vlan")).To(Succeed())
+
+			veth, err = netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(veth.Attrs().MasterIndex).To(Equal(bridge.Attrs().Index))
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("recognizes vlans recorded in the state", func() {
+		stateDir, err := os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
+		defer os.RemoveAll(stateDir)
+
+		conf := &pluginConf{IfName: "aos-vlan", StateDir: stateDir}
+
+		Expect(isRecordedVlan(conf, "dummy", link)).To(BeFalse())
+
+		Expect(saveVlanState(stateDir, vlanState{ContainerID: "dummy", IfName: "aos-vlan", VlanId: 100})).To(Succeed())
+
+		Expect(isRecordedVlan(conf, "dummy", link)).To(BeTrue())
+		Expect(isRecordedVlan(conf, "other", link)).To(BeFalse())
+		Expect(isRecordedVlan(conf, "dummy", &netlink.Vlan{
+			LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}, VlanId: 200,
+		})).To(BeFalse())
+		Expect(isRecordedVlan(&pluginConf{IfName: "aos-vlan"}, "dummy", link)).To(BeFalse())
+	})
+})
@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// pluginEvent is a JSON line appended to the configured event file after each CNI command.
+type pluginEvent struct {
+	Command         string           `json:"command"`
+	ContainerID     string           `json:"containerID"`
+	IfName          string           `json:"ifName"`
+	Master          string           `json:"master"`
+	VlanId          int              `json:"vlanId"`
+	Error           string           `json:"error,omitempty"`
+	BridgePortState string           `json:"bridgePortState,omitempty"`
+	VlanOffload     *vlanOffload     `json:"vlanOffload,omitempty"`
+	VlanFlags       []vlanAttrReport `json:"vlanFlags,omitempty"`
+	Host            *hostMetadata    `json:"host,omitempty"`
+	IfIndex         *ifIndexReport   `json:"ifIndex,omitempty"`
+	ResultChecksum  string           `json:"resultChecksum,omitempty"`
+}
+
+// hostMetadata identifies the host the command ran on for fleet observability.
+type hostMetadata struct {
+	NodeName      string `json:"nodeName"`
+	KernelVersion string `json:"kernelVersion"`
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func newPluginEvent(command string, args *skel.CmdArgs, conf *pluginConf) *pluginEvent {
+	event := &pluginEvent{
+		Command:     command,
+		ContainerID: args.ContainerID,
+		IfName:      conf.IfName,
+		Master:      conf.Master,
+		VlanId:      conf.VlanId,
+	}
+
+	if conf.IncludeHostMetadata {
+		event.Host = getHostMetadata()
+	}
+
+	return event
+}
+
+// getHostMetadata returns the host metadata. The metadata is best effort, unavailable values are left empty.
+func getHostMetadata() *hostMetadata {
+	metadata := &hostMetadata{}
+
+	metadata.NodeName, _ = os.Hostname()
+	metadata.KernelVersion, _ = kernelRelease()
+
+	return metadata
+}
+
+// printResult prints the result in the configured CNI version. With resultChecksum, the SHA-256 of the exact printed
+// bytes is added to the event.
+func printResult(conf *pluginConf, event *pluginEvent, result types.Result) error {
+	versionedResult, err := getAsConfVersion(conf, result)
+	if err != nil {
+		return err
+	}
+
+	if !conf.ResultChecksum {
+		return versionedResult.Print()
+	}
+
+	var data bytes.Buffer
+
+	if err := versionedResult.PrintTo(&data); err != nil {
+		return err
+	}
+
+	event.ResultChecksum = fmt.Sprintf("sha256:%x", sha256.Sum256(data.Bytes()))
+
+	_, err = os.Stdout.Write(data.Bytes())
+
+	return err
+}
+
+// emitEvent appends the event to the event file. Errors are returned for diagnostic purposes only and must never fail
+// the CNI command.
+func emitEvent(conf *pluginConf, event *pluginEvent, cmdErr error) error {
+	if conf == nil || conf.EventFile == "" {
+		return nil
+	}
+
+	if cmdErr != nil {
+		event.Error = cmdErr.Error()
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+
+	file, err := os.OpenFile(conf.EventFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open event file %s: %v", conf.EventFile, err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write event file %s: %v", conf.EventFile, err)
+	}
+
+	return nil
+}
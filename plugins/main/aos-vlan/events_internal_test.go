@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan events", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		var err error
+
+		tmpDir, err = os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("appends events to the event file", func() {
+		conf := &pluginConf{
+			Master:    "br0",
+			VlanId:    100,
+			IfName:    "aos-vlan",
+			EventFile: filepath.Join(tmpDir, "events"),
+		}
+
+		args := &skel.CmdArgs{ContainerID: "dummy"}
+
+		addEvent := newPluginEvent("ADD", args, conf)
+		addEvent.BridgePortState = "forwarding"
+
+		Expect(emitEvent(conf, addEvent, nil)).To(Succeed())
+		Expect(emitEvent(conf, newPluginEvent("CHECK", args, conf), errors.New("vlan link is down"))).To(Succeed())
+
+		content, err := os.ReadFile(conf.EventFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		Expect(lines).To(HaveLen(2))
+
+		var event pluginEvent
+
+		Expect(json.Unmarshal([]byte(lines[0]), &event)).To(Succeed())
+		Expect(event).To(Equal(pluginEvent{
+			Command: "ADD", ContainerID: "dummy", IfName: "aos-vlan", Master: "br0", VlanId: 100,
+			BridgePortState: "forwarding",
+		}))
+
+		Expect(json.Unmarshal([]byte(lines[1]), &event)).To(Succeed())
+		Expect(event.Command).To(Equal("CHECK"))
+		Expect(event.Error).To(Equal("vlan link is down"))
+	})
+
+	It("includes host metadata in events", func() {
+		originalKernelRelease := kernelRelease
+		defer func() { kernelRelease = originalKernelRelease }()
+
+		kernelRelease = func() (string, error) { return "5.15.0-aos", nil }
+
+		hostname, err := os.Hostname()
+		Expect(err).NotTo(HaveOccurred())
+
+		conf := &pluginConf{
+			Master:              "br0",
+			VlanId:              100,
+			IfName:              "aos-vlan",
+			EventFile:           filepath.Join(tmpDir, "events"),
+			IncludeHostMetadata: true,
+		}
+
+		Expect(emitEvent(conf, newPluginEvent("ADD", &skel.CmdArgs{ContainerID: "dummy"}, conf), nil)).To(Succeed())
+
+		content, err := os.ReadFile(conf.EventFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		var event map[string]interface{}
+
+		Expect(json.Unmarshal(content, &event)).To(Succeed())
+		Expect(event["host"]).To(Equal(map[string]interface{}{
+			"nodeName": hostname, "kernelVersion": "5.15.0-aos",
+		}))
+
+		conf.IncludeHostMetadata = false
+
+		Expect(newPluginEvent("ADD", &skel.CmdArgs{ContainerID: "dummy"}, conf).Host).To(BeNil())
+	})
+	It("adds the result checksum to the event", func() {
+		conf := &pluginConf{
+			NetConf:        types.NetConf{CNIVersion: "1.0.0"},
+			IfName:         "aos-vlan",
+			EventFile:      filepath.Join(tmpDir, "events"),
+			ResultChecksum: true,
+		}
+
+		result := &current.Result{
+			CNIVersion: "1.0.0",
+			Interfaces: []*current.Interface{{Name: "aos-vlan", Mac: "02:00:00:00:00:01"}},
+		}
+
+		stdout, err := os.Create(filepath.Join(tmpDir, "stdout"))
+		Expect(err).NotTo(HaveOccurred())
+		defer stdout.Close()
+
+		originalStdout := os.Stdout
+		os.Stdout = stdout
+
+		event := newPluginEvent("ADD", &skel.CmdArgs{ContainerID: "dummy"}, conf)
+		err = printResult(conf, event, result)
+
+		os.Stdout = originalStdout
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(emitEvent(conf, event, nil)).To(Succeed())
+
+		printed, err := os.ReadFile(stdout.Name())
+		Expect(err).NotTo(HaveOccurred())
+
+		var printedResult current.Result
+
+		Expect(json.Unmarshal(printed, &printedResult)).To(Succeed())
+		Expect(printedResult.Interfaces).To(Equal(result.Interfaces))
+
+		content, err := os.ReadFile(conf.EventFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(json.Unmarshal(content, event)).To(Succeed())
+		Expect(event.ResultChecksum).To(Equal(fmt.Sprintf("sha256:%x", sha256.Sum256(printed))))
+	})
+})
@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"golang.org/x/sys/unix"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	gatewayCheckTimeout = time.Second
+	gatewayPingSize     = 64
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// gatewayPing reports whether the gateway replies to an ICMP echo sent through the interface, variable for testing.
+var gatewayPing = icmpPing
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// checkGatewayReachable pings the configured gateway, or the IPv4 gateway of the previous result, through the VLAN.
+func checkGatewayReachable(conf *pluginConf, args *skel.CmdArgs, result *current.Result) error {
+	gateway := net.ParseIP(conf.Gateway).To4()
+	if gateway == nil {
+		gateway = ipv4Gateway(result)
+	}
+
+	if gateway == nil {
+		return fmt.Errorf("no gateway to check: \"gateway\" is not set and prevResult has no IPv4 gateway")
+	}
+
+	ping := func(ifName string) error {
+		if !gatewayPing(gateway, ifName) {
+			return fmt.Errorf("gateway %s is not reachable via %q", gateway, ifName)
+		}
+
+		return nil
+	}
+
+	if !conf.InContainer {
+		return ping(conf.IfName)
+	}
+
+	ifName := stateContainerIfName(conf, args.ContainerID, args.IfName)
+
+	return ns.WithNetNSPath(args.Netns, func(ns.NetNS) error {
+		return ping(ifName)
+	})
+}
+
+// icmpPing sends an ICMP echo request bound to the interface and waits for the reply.
+func icmpPing(gateway net.IP, ifName string) bool {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_ICMP)
+	if err != nil {
+		return false
+	}
+	defer unix.Close(fd)
+
+	if err := unix.BindToDevice(fd, ifName); err != nil {
+		return false
+	}
+
+	timeout := unix.NsecToTimeval(gatewayCheckTimeout.Nanoseconds())
+
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &timeout); err != nil {
+		return false
+	}
+
+	id, seq := uint16(os.Getpid()), uint16(1)
+	addr := &unix.SockaddrInet4{}
+
+	copy(addr.Addr[:], gateway.To4())
+
+	if err := unix.Sendto(fd, icmpEcho(id, seq, gatewayPingSize), 0, addr); err != nil {
+		return false
+	}
+
+	return waitEchoReply(fd, id, seq, gatewayPingSize+ipv4HeaderLen, gatewayCheckTimeout)
+}
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan gateway", func() {
+	var hostNS, gatewayNS ns.NetNS
+
+	args := &skel.CmdArgs{ContainerID: "dummy", Netns: "dummy", IfName: "aos-vlan"}
+
+	BeforeEach(func() {
+		var err error
+
+		hostNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		gatewayNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		err = hostNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}, PeerName: "aos-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(addTestAddr("aos-vlan", "10.1.1.1/24")).To(Succeed())
+
+			peer, err := netlink.LinkByName("aos-peer")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkSetNsFd(peer, int(gatewayNS.Fd()))).To(Succeed())
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = gatewayNS.Do(func(ns.NetNS) error {
+			return addTestAddr("aos-peer", "10.1.1.2/24")
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		// Packets are dropped until the veth carrier is up
+		err = hostNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			Eventually(func() netlink.LinkOperState {
+				link, err := netlink.LinkByName("aos-vlan")
+				Expect(err).NotTo(HaveOccurred())
+
+				return link.Attrs().OperState
+			}, time.Second, 10*time.Millisecond).Should(Equal(netlink.LinkOperState(netlink.OperUp)))
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		for _, testNS := range []ns.NetNS{hostNS, gatewayNS} {
+			Expect(testNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		}
+	})
+
+	It("aos-vlan checks gateway reachability", func() {
+		result := &current.Result{IPs: []*current.IPConfig{{
+			Address: net.IPNet{IP: net.ParseIP("10.1.1.1"), Mask: net.CIDRMask(24, 32)},
+			Gateway: net.ParseIP("10.1.1.2"),
+		}}}
+
+		err := hostNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			conf := &pluginConf{IfName: "aos-vlan", CheckGateway: true}
+
+			Expect(checkGatewayReachable(conf, args, result)).To(Succeed())
+
+			conf.Gateway = "10.1.1.3"
+
+			Expect(checkGatewayReachable(conf, args, result)).To(
+				MatchError(`gateway 10.1.1.3 is not reachable via "aos-vlan"`))
+
+			Expect(checkGatewayReachable(&pluginConf{IfName: "aos-vlan"}, args, &current.Result{})).To(
+				MatchError(ContainSubstring("no gateway to check")))
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func addTestAddr(ifName, cidr string) error {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return err
+	}
+
+	addr, err := netlink.ParseAddr(cidr)
+	if err != nil {
+		return err
+	}
+
+	if err := netlink.AddrAdd(link, addr); err != nil {
+		return err
+	}
+
+	return netlink.LinkSetUp(link)
+}
@@ -0,0 +1,263 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const cniCommandGC = "GC"
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// gcConf is the part of the GC network configuration listing the attachments still in use.
+type gcConf struct {
+	ValidAttachments []gcAttachment `json:"cni.dev/valid-attachments"`
+}
+
+type gcAttachment struct {
+	ContainerID string `json:"containerID"`
+	IfName      string `json:"ifname"`
+}
+
+// validAttachments are the attachments still in use by the container ID and CNI ifName pair.
+type validAttachments struct {
+	attachments  map[gcAttachment]bool
+	containerIDs map[string]bool
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// cmdGC removes the VLANs of the attachments which are not valid and forwards GC to the IPAM delegates, which release
+// the addresses of these attachments themselves.
+func cmdGC(args *skel.CmdArgs) error {
+	conf, _, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	delegates, err := ipamDelegates(conf, args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	if err := gcVlans(conf, args.StdinData); err != nil {
+		return err
+	}
+
+	if err := ipamGC(conf, delegates); err != nil {
+		return fmt.Errorf("IPAM GC failed: %v", err)
+	}
+
+	return nil
+}
+
+// gcVlans removes the VLANs of the attachments recorded in stateDir that are not valid. An attachment is identified by
+// the container ID and the CNI ifName, so a stale attachment of a reused container ID is collected as well. Only VLANs
+// created and marked by the plugin, not used by a valid attachment, are deleted. Without stateDir there is nothing to
+// collect.
+func gcVlans(conf *pluginConf, stdinData []byte) error {
+	if conf.StateDir == "" {
+		return nil
+	}
+
+	var gc gcConf
+
+	if err := json.Unmarshal(stdinData, &gc); err != nil {
+		return fmt.Errorf("failed to parse valid attachments: %v", err)
+	}
+
+	unlock, err := lockGC(conf.StateDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	containerIDs, err := stateContainerIDs(conf.StateDir)
+	if err != nil {
+		return err
+	}
+
+	valid := newValidAttachments(gc.ValidAttachments)
+
+	// A VLAN adopted by a valid attachment is kept even if an orphaned attachment created it
+	containerStates := make(map[string]map[string]vlanState)
+	inUse := make(map[string]bool)
+
+	for _, containerID := range containerIDs {
+		states, err := loadContainerState(conf.StateDir, containerID)
+		if err != nil {
+			return err
+		}
+
+		containerStates[containerID] = states
+
+		for _, state := range states {
+			if valid.contains(containerID, state) {
+				inUse[state.IfName] = true
+			}
+		}
+	}
+
+	var errs []string
+
+	for _, containerID := range containerIDs {
+		if err := gcContainer(conf, containerID, containerStates[containerID], valid, inUse); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", containerID, err))
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("GC failed: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// gcContainer deletes the orphaned VLANs of the container attachments which are not valid and their state.
+func gcContainer(
+	conf *pluginConf, containerID string, states map[string]vlanState, valid validAttachments, inUse map[string]bool,
+) error {
+	for _, state := range states {
+		if valid.contains(containerID, state) {
+			continue
+		}
+
+		if state.Created && !inUse[state.IfName] {
+			if err := deleteOrphanVlan(conf, state); err != nil {
+				return err
+			}
+		}
+
+		if err := removeVlanState(conf.StateDir, containerID, state.IfName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newValidAttachments returns the valid attachments of the GC configuration.
+func newValidAttachments(attachments []gcAttachment) validAttachments {
+	valid := validAttachments{attachments: make(map[gcAttachment]bool), containerIDs: make(map[string]bool)}
+
+	for _, attachment := range attachments {
+		valid.attachments[attachment] = true
+		valid.containerIDs[attachment.ContainerID] = true
+	}
+
+	return valid
+}
+
+// contains reports whether the attachment of the container state is valid. States recorded without the CNI ifName
+// match any valid attachment of the container.
+func (valid validAttachments) contains(containerID string, state vlanState) bool {
+	if state.AttachmentIfName == "" {
+		return valid.containerIDs[containerID]
+	}
+
+	return valid.attachments[gcAttachment{ContainerID: containerID, IfName: state.AttachmentIfName}]
+}
+
+// deleteOrphanVlan deletes the VLAN of the state if it is still the VLAN the plugin created: marked, with the recorded
+// VLAN ID and parent.
+func deleteOrphanVlan(conf *pluginConf, state vlanState) error {
+	link, err := netlink.LinkByName(state.IfName)
+	if err != nil {
+		if errors.As(err, new(netlink.LinkNotFoundError)) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to lookup %q: %v", state.IfName, err)
+	}
+
+	vlan, ok := link.(*netlink.Vlan)
+	if !ok {
+		return nil
+	}
+
+	if _, ok := getVlanMarker(vlan); !ok || vlan.VlanId != state.VlanId {
+		return nil
+	}
+
+	parent, err := netlink.LinkByIndex(vlan.ParentIndex)
+	if err != nil || parent.Attrs().Name != state.Parent {
+		return nil
+	}
+
+	if vlan.MasterIndex != 0 {
+		if err := netlink.LinkSetNoMaster(vlan); err != nil {
+			return fmt.Errorf("failed to detach %q from bridge: %v", state.IfName, err)
+		}
+	}
+
+	return deleteLinkWithRetry(conf, vlan)
+}
+
+// stateContainerIDs returns the IDs of the containers with a state file.
+func stateContainerIDs(stateDir string) (containerIDs []string, err error) {
+	paths, err := filepath.Glob(filepath.Join(stateDir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list state files: %v", err)
+	}
+
+	for _, path := range paths {
+		containerIDs = append(containerIDs, strings.TrimSuffix(filepath.Base(path), ".json"))
+	}
+
+	return containerIDs, nil
+}
+
+// lockGC serializes concurrent GC runs over the state dir.
+func lockGC(stateDir string) (unlock func(), err error) {
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create state dir: %v", err)
+	}
+
+	lock, err := os.OpenFile(filepath.Join(stateDir, ".gc.lock"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GC lock file: %v", err)
+	}
+
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to lock GC: %v", err)
+	}
+
+	return func() {
+		_ = unix.Flock(int(lock.Fd()), unix.LOCK_UN)
+		lock.Close()
+	}, nil
+}
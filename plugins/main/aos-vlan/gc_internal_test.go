@@ -0,0 +1,187 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan GC", func() {
+	var (
+		testNS   ns.NetNS
+		stateDir string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		testNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		tmpDir, err := os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
+		stateDir = filepath.Join(tmpDir, "state")
+	})
+
+	AfterEach(func() {
+		Expect(testNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		Expect(os.RemoveAll(filepath.Dir(stateDir))).To(Succeed())
+	})
+
+	It("aos-vlan collects VLANs of containers without valid attachments", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			Expect(netlink.LinkAdd(&netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: "eth0"}, PeerName: "eth1",
+			})).To(Succeed())
+
+			parent, err := netlink.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+
+			for i, name := range []string{"aos-vlan0", "aos-vlan1", "aos-vlan2", "aos-vlan3"} {
+				err := netlink.LinkAdd(&netlink.Vlan{
+					LinkAttrs: netlink.LinkAttrs{Name: name, ParentIndex: parent.Attrs().Index}, VlanId: 100 + i,
+				})
+				if errors.Is(err, syscall.EOPNOTSUPP) {
+					Skip("8021q is not available")
+				}
+
+				Expect(err).NotTo(HaveOccurred())
+
+				// aos-vlan1 is not created by the plugin
+				if name != "aos-vlan1" {
+					link, err := netlink.LinkByName(name)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(setVlanMarker(link, vlanMarker{Up: true})).To(Succeed())
+				}
+			}
+
+			for _, state := range []vlanState{
+				{ContainerID: "orphan", IfName: "aos-vlan0", Parent: "eth0", VlanId: 100, Created: true},
+				{ContainerID: "orphan", IfName: "aos-vlan1", Parent: "eth0", VlanId: 101, Created: true},
+				{ContainerID: "orphan", IfName: "aos-vlan2", Parent: "eth0", VlanId: 102, Created: true},
+				{ContainerID: "valid", IfName: "aos-vlan2", Parent: "eth0", VlanId: 102},
+				{ContainerID: "valid", IfName: "aos-vlan3", Parent: "eth0", VlanId: 103, Created: true},
+			} {
+				Expect(saveVlanState(stateDir, state)).To(Succeed())
+			}
+
+			args := &skel.CmdArgs{StdinData: []byte(fmt.Sprintf(`{
+				"name": "mynet", "cniVersion": "1.1.0", "type": "aos-vlan", "master": "br0", "vlanId": 100,
+				"ifName": "aos-vlan", "stateDir": %q,
+				"cni.dev/valid-attachments": [{"containerID": "valid", "ifname": "eth0"}]}`, stateDir))}
+
+			// GC is idempotent
+			for i := 0; i < 2; i++ {
+				Expect(cmdGC(args)).To(Succeed())
+			}
+
+			_, err = netlink.LinkByName("aos-vlan0")
+			Expect(err).To(BeAssignableToTypeOf(netlink.LinkNotFoundError{}))
+
+			// Foreign and in use VLANs are kept
+			for _, name := range []string{"aos-vlan1", "aos-vlan2", "aos-vlan3"} {
+				_, err = netlink.LinkByName(name)
+				Expect(err).NotTo(HaveOccurred(), name)
+			}
+
+			containerIDs, err := stateContainerIDs(stateDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(containerIDs).To(Equal([]string{"valid"}))
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("aos-vlan collects state of containers without valid attachments", func() {
+		for _, state := range []vlanState{
+			{ContainerID: "orphan", IfName: "aos-vlan0", Parent: "eth0", VlanId: 100, Created: true},
+			{ContainerID: "valid", IfName: "aos-vlan1", Parent: "eth0", VlanId: 101, Created: true},
+		} {
+			Expect(saveVlanState(stateDir, state)).To(Succeed())
+		}
+
+		conf := `{"name": "mynet", "cniVersion": "1.1.0", "type": "aos-vlan", "master": "br0", "vlanId": 100,
+			"ifName": "aos-vlan", "stateDir": %q, "cni.dev/valid-attachments": %s}`
+
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			Expect(cmdGC(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(conf, stateDir,
+				`[{"containerID": "valid", "ifname": "eth0"}]`))})).To(Succeed())
+
+			containerIDs, err := stateContainerIDs(stateDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(containerIDs).To(Equal([]string{"valid"}))
+
+			Expect(cmdGC(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(conf, stateDir, `[]`))})).To(Succeed())
+
+			containerIDs, err = stateContainerIDs(stateDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(containerIDs).To(BeEmpty())
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("aos-vlan collects stale attachment of reused container ID", func() {
+		for _, state := range []vlanState{
+			{ContainerID: "reused", IfName: "aos-vlan0", Parent: "eth0", VlanId: 100, AttachmentIfName: "eth1"},
+			{ContainerID: "reused", IfName: "aos-vlan1", Parent: "eth0", VlanId: 101, AttachmentIfName: "eth0"},
+		} {
+			Expect(saveVlanState(stateDir, state)).To(Succeed())
+		}
+
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			Expect(cmdGC(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(`{"name": "mynet", "cniVersion": "1.1.0",
+				"type": "aos-vlan", "master": "br0", "vlanId": 100, "ifName": "aos-vlan", "stateDir": %q,
+				"cni.dev/valid-attachments": [{"containerID": "reused", "ifname": "eth0"}]}`, stateDir))})).To(Succeed())
+
+			states, err := loadContainerState(stateDir, "reused")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(states).To(HaveLen(1))
+			Expect(states).To(HaveKey("aos-vlan1"))
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
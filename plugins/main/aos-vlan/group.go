@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// groupPolicyEntry describes the netdev group a VLAN joined for external per group policy appliers.
+type groupPolicyEntry struct {
+	Group  int `json:"group"`
+	VlanId int `json:"vlanId"`
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// recordGroupPolicy records the VLAN group in the group policy file keyed by interface name. The file is shared by
+// concurrent plugin invocations, so it is updated under an exclusive lock and replaced atomically.
+func recordGroupPolicy(path, ifName string, entry groupPolicyEntry) error {
+	return updateJSONFile(path, func(entries map[string]groupPolicyEntry) {
+		entries[ifName] = entry
+	})
+}
+
+// removeGroupPolicy removes the VLAN entry from the group policy file. A missing file or entry is not an error.
+func removeGroupPolicy(path, ifName string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	return updateJSONFile(path, func(entries map[string]groupPolicyEntry) {
+		delete(entries, ifName)
+	})
+}
+
+// updateJSONFile updates the JSON map stored in the file under an exclusive lock. The file and its lock file are
+// removed when the map becomes empty.
+func updateJSONFile[T any](path string, update func(entries map[string]T)) error {
+	lock, err := lockFile(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	defer unix.Flock(int(lock.Fd()), unix.LOCK_UN)
+
+	entries := make(map[string]T)
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	if len(data) != 0 {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+	}
+
+	update(entries)
+
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %v", path, err)
+		}
+
+		// Removed under the lock, lockFile of the waiting invocations retries on the new lock file
+		if err := os.Remove(lock.Name()); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove lock file: %v", err)
+		}
+
+		return nil
+	}
+
+	if data, err = json.MarshalIndent(entries, "", "  "); err != nil {
+		return fmt.Errorf("failed to marshal %s: %v", path, err)
+	}
+
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// lockFile opens and exclusively locks the lock file. The lock file may be removed by the previous holder, so the
+// lock is retried until the locked file is the one at the path.
+func lockFile(path string) (*os.File, error) {
+	for {
+		lock, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open lock file: %v", err)
+		}
+
+		if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+			lock.Close()
+			return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+		}
+
+		var lockStat, pathStat unix.Stat_t
+
+		if err := unix.Fstat(int(lock.Fd()), &lockStat); err != nil {
+			lock.Close()
+			return nil, fmt.Errorf("failed to stat lock file: %v", err)
+		}
+
+		err = unix.Stat(path, &pathStat)
+		if err == nil && pathStat.Dev == lockStat.Dev && pathStat.Ino == lockStat.Ino {
+			return lock, nil
+		}
+
+		lock.Close()
+
+		if err != nil && !errors.Is(err, unix.ENOENT) {
+			return nil, fmt.Errorf("failed to stat lock file: %v", err)
+		}
+	}
+}
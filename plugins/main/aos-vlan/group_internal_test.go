@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan group policy", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		var err error
+
+		tmpDir, err = os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("records group of each VLAN", func() {
+		policyFile := filepath.Join(tmpDir, "groups.json")
+
+		Expect(recordGroupPolicy(policyFile, "vlan100", groupPolicyEntry{Group: 5, VlanId: 100})).To(Succeed())
+		Expect(recordGroupPolicy(policyFile, "vlan200", groupPolicyEntry{Group: 6, VlanId: 200})).To(Succeed())
+		Expect(recordGroupPolicy(policyFile, "vlan100", groupPolicyEntry{Group: 7, VlanId: 100})).To(Succeed())
+
+		content, err := os.ReadFile(policyFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		var entries map[string]groupPolicyEntry
+
+		Expect(json.Unmarshal(content, &entries)).To(Succeed())
+		Expect(entries).To(Equal(map[string]groupPolicyEntry{
+			"vlan100": {Group: 7, VlanId: 100},
+			"vlan200": {Group: 6, VlanId: 200},
+		}))
+	})
+
+	It("removes group of deleted VLAN", func() {
+		policyFile := filepath.Join(tmpDir, "groups.json")
+
+		Expect(removeGroupPolicy(policyFile, "vlan100")).To(Succeed())
+		Expect(policyFile + ".lock").NotTo(BeAnExistingFile())
+
+		Expect(recordGroupPolicy(policyFile, "vlan100", groupPolicyEntry{Group: 5, VlanId: 100})).To(Succeed())
+		Expect(recordGroupPolicy(policyFile, "vlan200", groupPolicyEntry{Group: 6, VlanId: 200})).To(Succeed())
+
+		Expect(removeGroupPolicy(policyFile, "vlan100")).To(Succeed())
+
+		content, err := os.ReadFile(policyFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		var entries map[string]groupPolicyEntry
+
+		Expect(json.Unmarshal(content, &entries)).To(Succeed())
+		Expect(entries).To(Equal(map[string]groupPolicyEntry{"vlan200": {Group: 6, VlanId: 200}}))
+
+		Expect(removeGroupPolicy(policyFile, "vlan200")).To(Succeed())
+		Expect(policyFile).NotTo(BeAnExistingFile())
+		Expect(policyFile + ".lock").NotTo(BeAnExistingFile())
+	})
+})
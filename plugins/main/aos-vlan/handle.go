@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// newHandleAt creates the netlink handle, variable for testing.
+var newHandleAt = netlink.NewHandleAt
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// newNetlinkHandle creates a netlink handle in the namespace, netns.None() for the current one. Socket creation blocked
+// by the runtime seccomp profile or missing capabilities gets an error explaining the requirements.
+func newNetlinkHandle(nsHandle netns.NsHandle) (*netlink.Handle, error) {
+	handle, err := newHandleAt(nsHandle)
+	if err != nil {
+		return nil, netlinkAccessError(err)
+	}
+
+	return handle, nil
+}
+
+// checkNetlinkAccess fails early if netlink sockets can't be created, otherwise the following netlink operations fail
+// with confusing errors.
+func checkNetlinkAccess() error {
+	handle, err := newNetlinkHandle(netns.None())
+	if err != nil {
+		return err
+	}
+
+	handle.Delete()
+
+	return nil
+}
+
+// setNetlinkExtAck enables extended acks on the netlink sockets, so the netlink errors include the kernel error
+// message. Extended acks are left disabled on kernels not supporting them, otherwise all requests would fail.
+func setNetlinkExtAck(conf *pluginConf) {
+	nl.EnableErrorMessageReporting = false
+
+	if !conf.NetlinkExtAck {
+		return
+	}
+
+	s, err := nl.GetNetlinkSocketAt(netns.None(), netns.None(), unix.NETLINK_ROUTE)
+	if err != nil {
+		return
+	}
+	defer s.Close()
+
+	if err := s.SetExtAck(true); err != nil {
+		logDebug("netlink extended ack is not supported", "error", err)
+		return
+	}
+
+	nl.EnableErrorMessageReporting = true
+}
+
+func netlinkAccessError(err error) error {
+	switch {
+	case errors.Is(err, syscall.ENOSYS):
+		return fmt.Errorf("netlink socket creation is blocked (%v): the seccomp profile of the runtime must allow "+
+			"socket, bind, sendto, recvfrom and setsockopt syscalls for AF_NETLINK", err)
+
+	case errors.Is(err, syscall.EPERM), errors.Is(err, syscall.EACCES):
+		return fmt.Errorf("netlink socket creation is not permitted (%v): the plugin requires CAP_NET_ADMIN and "+
+			"a seccomp profile allowing AF_NETLINK sockets", err)
+
+	default:
+		return fmt.Errorf("failed to create netlink handle: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"github.com/vishvananda/netns"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan netlink handle", func() {
+	var originalNewHandleAt func(ns netns.NsHandle, nlFamilies ...int) (*netlink.Handle, error)
+
+	BeforeEach(func() {
+		originalNewHandleAt = newHandleAt
+	})
+
+	AfterEach(func() {
+		newHandleAt = originalNewHandleAt
+		nl.EnableErrorMessageReporting = false
+	})
+
+	// fakeNewHandleAt fails the handle creation with the error.
+	fakeNewHandleAt := func(err error) func(ns netns.NsHandle, nlFamilies ...int) (*netlink.Handle, error) {
+		return func(ns netns.NsHandle, nlFamilies ...int) (*netlink.Handle, error) {
+			return nil, err
+		}
+	}
+
+	It("aos-vlan explains blocked netlink sockets", func() {
+		newHandleAt = fakeNewHandleAt(syscall.ENOSYS)
+
+		Expect(checkNetlinkAccess()).To(MatchError(ContainSubstring(
+			"seccomp profile of the runtime must allow socket, bind, sendto, recvfrom and setsockopt")))
+
+		for _, errno := range []syscall.Errno{syscall.EPERM, syscall.EACCES} {
+			newHandleAt = fakeNewHandleAt(errno)
+
+			Expect(checkNetlinkAccess()).To(MatchError(ContainSubstring("requires CAP_NET_ADMIN")))
+		}
+
+		newHandleAt = fakeNewHandleAt(syscall.EMFILE)
+
+		Expect(checkNetlinkAccess()).To(MatchError("failed to create netlink handle: too many open files"))
+	})
+
+	It("aos-vlan reports restricted netlink access", func() {
+		// Reproducible only when running under a restrictive seccomp profile or without capabilities
+		err := checkNetlinkAccess()
+		if err == nil {
+			Skip("netlink sockets are not restricted")
+		}
+
+		Expect(err).To(MatchError(ContainSubstring("netlink socket creation is")))
+	})
+
+	It("aos-vlan reports extended ack messages", func() {
+		testNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(testNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		}()
+
+		err = testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			Expect(netlink.LinkAdd(&netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: "eth0"}, PeerName: "eth1",
+			})).To(Succeed())
+
+			for _, name := range []string{"br0", "br1"} {
+				Expect(netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: name}})).To(Succeed())
+			}
+
+			parent, err := netlink.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+
+			br0, err := netlink.LinkByName("br0")
+			Expect(err).NotTo(HaveOccurred())
+
+			br1, err := netlink.LinkByName("br1")
+			Expect(err).NotTo(HaveOccurred())
+
+			vlan := &netlink.Vlan{
+				LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan", ParentIndex: parent.Attrs().Index}, VlanId: 5000,
+			}
+
+			setNetlinkExtAck(&pluginConf{})
+
+			Expect(netlink.LinkSetMaster(br1, br0)).To(MatchError(syscall.ELOOP))
+
+			setNetlinkExtAck(&pluginConf{NetlinkExtAck: true})
+
+			if !nl.EnableErrorMessageReporting {
+				Skip("netlink extended ack is not supported")
+			}
+
+			Expect(netlink.LinkSetMaster(br1, br0)).To(MatchError(ContainSubstring(
+				"Can not enslave a bridge to a bridge")))
+
+			// The kernel rejects the VLAN ID, or the VLAN type if 8021q is not available
+			for _, err := range []error{netlink.LinkAdd(vlan), addVlanLinks([]*netlink.Vlan{vlan})[0]} {
+				if errors.Is(err, syscall.EOPNOTSUPP) {
+					Expect(err).To(MatchError(ContainSubstring("Unknown device type")))
+				} else {
+					Expect(err).To(MatchError(ContainSubstring("Invalid VLAN id")))
+				}
+			}
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
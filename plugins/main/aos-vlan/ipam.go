@@ -0,0 +1,353 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const defaultIPAMTimeout = 30 * time.Second
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// ipamDelegate is an IPAM plugin invocation with the network configuration passed to it.
+type ipamDelegate struct {
+	plugin  string
+	netconf []byte
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// ipamDelegates returns the configured IPAM delegates: the "ipam" block first, followed by the ipamV4 and ipamV6 pair
+// for dual-stack setups. The "ipam" block is skipped if it has no type. Each delegate gets the plugin network
+// configuration with its own block as "ipam".
+func ipamDelegates(conf *pluginConf, stdinData []byte) (delegates []ipamDelegate, err error) {
+	var netconf map[string]json.RawMessage
+
+	if err := json.Unmarshal(stdinData, &netconf); err != nil {
+		return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+
+	var blocks []json.RawMessage
+
+	if conf.IPAM.Type != "" {
+		blocks = append(blocks, netconf["ipam"])
+	}
+
+	blocks = append(blocks, conf.IPAMV4, conf.IPAMV6)
+
+	delete(netconf, "ipamV4")
+	delete(netconf, "ipamV6")
+	delete(netconf, "prevResult")
+
+	for _, block := range blocks {
+		if len(block) == 0 {
+			continue
+		}
+
+		var ipam types.IPAM
+
+		if err := json.Unmarshal(block, &ipam); err != nil {
+			return nil, fmt.Errorf("invalid IPAM block: %v", err)
+		}
+
+		if ipam.Type == "" {
+			return nil, fmt.Errorf("IPAM block %s has no type", block)
+		}
+
+		netconf["ipam"] = block
+
+		data, err := json.Marshal(netconf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal IPAM network configuration: %v", err)
+		}
+
+		delegates = append(delegates, ipamDelegate{plugin: ipam.Type, netconf: data})
+	}
+
+	return delegates, nil
+}
+
+// ipamAdd invokes all delegates and merges their results. If a delegate fails, the addresses allocated by the
+// previous ones are released.
+func ipamAdd(conf *pluginConf, delegates []ipamDelegate) (*current.Result, error) {
+	merged := &current.Result{CNIVersion: current.ImplementedSpecVersion}
+
+	for i, delegate := range delegates {
+		result, err := ipamExecAdd(conf, delegate)
+		if err == nil {
+			err = mergeIPAMResult(merged, result)
+		}
+
+		if err != nil {
+			if delErr := ipamDel(conf, delegates[:i+1]); delErr != nil {
+				err = fmt.Errorf("%v, rollback failed: %v", err, delErr)
+			}
+
+			return nil, err
+		}
+	}
+
+	return merged, nil
+}
+
+// ipamDel invokes DEL on all delegates, continuing on failures.
+func ipamDel(conf *pluginConf, delegates []ipamDelegate) error {
+	var errs []string
+
+	for _, delegate := range delegates {
+		if err := ipamExecDel(conf, delegate); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// ipamGC invokes GC on all delegates, continuing on failures. The delegates get the valid attachments with the network
+// configuration.
+func ipamGC(conf *pluginConf, delegates []ipamDelegate) error {
+	var errs []string
+
+	for _, delegate := range delegates {
+		if err := ipamExecGC(conf, delegate); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func mergeIPAMResult(merged *current.Result, result types.Result) error {
+	ipamResult, err := current.NewResultFromResult(result)
+	if err != nil {
+		return fmt.Errorf("could not convert IPAM result to current version: %v", err)
+	}
+
+	if len(ipamResult.IPs) == 0 {
+		return errors.New("IPAM plugin returned missing IP config")
+	}
+
+	merged.IPs = append(merged.IPs, ipamResult.IPs...)
+	merged.Routes = append(merged.Routes, ipamResult.Routes...)
+
+	if len(merged.DNS.Nameservers) == 0 {
+		merged.DNS = ipamResult.DNS
+	}
+
+	return nil
+}
+
+// applyIPAMResult configures the IPAM addresses and routes on the link, inside the network namespace if netnsPath is
+// set.
+func applyIPAMResult(netnsPath, ifName string, result *current.Result) error {
+	apply := func() error {
+		link, err := netlink.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+		}
+
+		for _, ip := range result.IPs {
+			addr := &netlink.Addr{IPNet: &net.IPNet{IP: ip.Address.IP, Mask: ip.Address.Mask}}
+
+			if err := netlink.AddrAdd(link, addr); err != nil && !errors.Is(err, syscall.EEXIST) {
+				return fmt.Errorf("failed to add address %s to %q: %v", addr.IPNet, ifName, err)
+			}
+		}
+
+		for _, route := range result.Routes {
+			if err := netlink.RouteReplace(&netlink.Route{
+				LinkIndex: link.Attrs().Index,
+				Dst:       &net.IPNet{IP: route.Dst.IP, Mask: route.Dst.Mask},
+				Gw:        route.GW,
+			}); err != nil {
+				return fmt.Errorf("failed to add route %s to %q: %v", route.Dst.String(), ifName, err)
+			}
+		}
+
+		return nil
+	}
+
+	if netnsPath == "" {
+		return apply()
+	}
+
+	return ns.WithNetNSPath(netnsPath, func(ns.NetNS) error { return apply() })
+}
+
+// removeIPAMRoutes removes the result routes via the link. A missing link, namespace or route is not an error.
+func removeIPAMRoutes(netnsPath, ifName string, result *current.Result) error {
+	return withResultLink(netnsPath, ifName, func(link netlink.Link) error {
+		for _, route := range result.Routes {
+			if err := netlink.RouteDel(&netlink.Route{
+				LinkIndex: link.Attrs().Index,
+				Dst:       &net.IPNet{IP: route.Dst.IP, Mask: route.Dst.Mask},
+				Gw:        route.GW,
+			}); err != nil && !errors.Is(err, syscall.ESRCH) {
+				return fmt.Errorf("failed to remove route %s from %q: %v", route.Dst.String(), ifName, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// removeIPAMAddresses removes the addresses of the result interface resultName from the link. A missing link, namespace
+// or address is not an error.
+func removeIPAMAddresses(netnsPath, ifName, resultName string, result *current.Result) error {
+	return withResultLink(netnsPath, ifName, func(link netlink.Link) error {
+		for _, ip := range result.IPs {
+			if ip.Interface == nil || *ip.Interface < 0 || *ip.Interface >= len(result.Interfaces) ||
+				result.Interfaces[*ip.Interface].Name != resultName {
+				continue
+			}
+
+			addr := &netlink.Addr{IPNet: &net.IPNet{IP: ip.Address.IP, Mask: ip.Address.Mask}}
+
+			if err := netlink.AddrDel(link, addr); err != nil && !errors.Is(err, syscall.EADDRNOTAVAIL) {
+				return fmt.Errorf("failed to remove address %s from %q: %v", addr.IPNet, ifName, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// withResultLink runs the function for the link in the namespace, the current one if netnsPath is empty. A missing link
+// or namespace is not an error.
+func withResultLink(netnsPath, ifName string, run func(link netlink.Link) error) error {
+	apply := func() error {
+		link, err := netlink.LinkByName(ifName)
+		if err != nil {
+			if errors.As(err, new(netlink.LinkNotFoundError)) {
+				return nil
+			}
+
+			return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+		}
+
+		return run(link)
+	}
+
+	if netnsPath == "" {
+		return apply()
+	}
+
+	err := ns.WithNetNSPath(netnsPath, func(ns.NetNS) error { return apply() })
+	if errors.As(err, new(ns.NSPathNotExistErr)) {
+		return nil
+	}
+
+	return err
+}
+
+// ipamExecAdd invokes the IPAM delegate ADD bounded by ipamTimeout and retried up to ipamRetries times on transient
+// failures.
+func ipamExecAdd(conf *pluginConf, delegate ipamDelegate) (result types.Result, err error) {
+	err = ipamRetry(conf, delegate, func(ctx context.Context) (err error) {
+		result, err = invoke.DelegateAdd(ctx, delegate.plugin, delegate.netconf, nil)
+		return err
+	})
+
+	return result, err
+}
+
+// ipamExecDel invokes the IPAM delegate DEL bounded by ipamTimeout and retried up to ipamRetries times on transient
+// failures.
+func ipamExecDel(conf *pluginConf, delegate ipamDelegate) error {
+	return ipamRetry(conf, delegate, func(ctx context.Context) error {
+		return invoke.DelegateDel(ctx, delegate.plugin, delegate.netconf, nil)
+	})
+}
+
+// ipamExecGC invokes the IPAM delegate GC bounded by ipamTimeout and retried up to ipamRetries times on transient
+// failures. The vendored CNI library has no GC delegation, so the delegate is executed with the GC command directly.
+func ipamExecGC(conf *pluginConf, delegate ipamDelegate) error {
+	return ipamRetry(conf, delegate, func(ctx context.Context) error {
+		pluginPath, err := invoke.FindInPath(delegate.plugin, filepath.SplitList(os.Getenv("CNI_PATH")))
+		if err != nil {
+			return err
+		}
+
+		return invoke.ExecPluginWithoutResult(ctx, pluginPath, delegate.netconf,
+			&invoke.DelegateArgs{Command: cniCommandGC}, nil)
+	})
+}
+
+func ipamRetry(conf *pluginConf, delegate ipamDelegate, call func(ctx context.Context) error) error {
+	timeout := conf.IPAMTimeout.Duration
+	if timeout == 0 {
+		timeout = defaultIPAMTimeout
+	}
+
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := call(ctx)
+		timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+
+		if timedOut {
+			err = fmt.Errorf("IPAM plugin %q timed out after %s: %v", delegate.plugin, timeout, err)
+		}
+
+		if attempt >= conf.IPAMRetries || !(timedOut || isTryAgainLater(err)) {
+			return err
+		}
+	}
+}
+
+func isTryAgainLater(err error) bool {
+	var cniErr *types.Error
+
+	return errors.As(err, &cniErr) && cniErr.Code == types.ErrTryAgainLater
+}
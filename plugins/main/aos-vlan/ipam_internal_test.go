@@ -0,0 +1,272 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan IPAM", func() {
+	var (
+		pluginDir string
+		countFile string
+		logFile   string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		pluginDir, err = os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
+		countFile = filepath.Join(pluginDir, "count")
+		logFile = filepath.Join(pluginDir, "log")
+
+		os.Setenv("CNI_PATH", pluginDir)
+		os.Setenv("CNI_CONTAINERID", "dummy")
+		os.Setenv("CNI_NETNS", "dummy")
+		os.Setenv("CNI_IFNAME", "aos-vlan")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(pluginDir)).To(Succeed())
+
+		for _, env := range []string{"CNI_PATH", "CNI_CONTAINERID", "CNI_NETNS", "CNI_IFNAME"} {
+			os.Unsetenv(env)
+		}
+	})
+
+	// createSlowIPAM creates an IPAM stub which hangs on the first slowCalls invocations.
+	createSlowIPAM := func(slowCalls int) {
+		script := fmt.Sprintf(`#!/bin/sh
+count=$(cat %[1]s 2>/dev/null || echo 0)
+count=$((count + 1))
+echo $count > %[1]s
+if [ $count -le %[2]d ]; then exec sleep 10; fi
+echo '{"cniVersion": "0.4.0", "ips": [{"version": "4", "address": "10.1.0.2/24"}]}'
+`, countFile, slowCalls)
+
+		Expect(os.WriteFile(filepath.Join(pluginDir, "slow-ipam"), []byte(script), 0o755)).To(Succeed())
+	}
+
+	// createIPAM creates an IPAM stub which logs its commands and returns the address or fails if it is empty.
+	createIPAM := func(name, version, address string) {
+		result := `{"code": 999, "msg": "no addresses left"}`
+		if address != "" {
+			result = fmt.Sprintf(`{"cniVersion": "0.4.0", "ips": [{"version": %q, "address": %q}]}`, version, address)
+		}
+
+		script := fmt.Sprintf(`#!/bin/sh
+echo "$CNI_COMMAND %[1]s" >> %[2]s
+echo '%[3]s'
+if [ -z "%[4]s" ] && [ "$CNI_COMMAND" = "ADD" ]; then exit 1; fi
+`, name, logFile, result, address)
+
+		Expect(os.WriteFile(filepath.Join(pluginDir, name), []byte(script), 0o755)).To(Succeed())
+	}
+
+	calls := func() int {
+		content, err := os.ReadFile(countFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		count, err := strconv.Atoi(strings.TrimSpace(string(content)))
+		Expect(err).NotTo(HaveOccurred())
+
+		return count
+	}
+
+	commands := func() []string {
+		content, err := os.ReadFile(logFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		return strings.Split(strings.TrimSpace(string(content)), "\n")
+	}
+
+	parseDualStackConfig := func() (*pluginConf, []ipamDelegate) {
+		stdinData := []byte(`{"cniVersion": "0.4.0", "name": "mynet", "master": "br0", "vlanId": 100,
+			"ifName": "aos-vlan", "ipamV4": {"type": "ipam-v4"}, "ipamV6": {"type": "ipam-v6"}}`)
+
+		conf, _, err := parseConfig(stdinData)
+		Expect(err).NotTo(HaveOccurred())
+
+		delegates, err := ipamDelegates(conf, stdinData)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(delegates).To(HaveLen(2))
+
+		return conf, delegates
+	}
+
+	It("retries timed out IPAM invocations", func() {
+		createSlowIPAM(1)
+
+		conf, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"ipamTimeout": "200ms", "ipamRetries": 1}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		result, err := ipamExecAdd(conf, ipamDelegate{
+			plugin:  "slow-ipam",
+			netconf: []byte(`{"cniVersion": "0.4.0", "name": "mynet", "ipam": {"type": "slow-ipam"}}`),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(calls()).To(Equal(2))
+
+		ipamResult, err := current.NewResultFromResult(result)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ipamResult.IPs).To(HaveLen(1))
+		Expect(ipamResult.IPs[0].Address.String()).To(Equal("10.1.0.2/24"))
+	})
+
+	It("fails when IPAM retries are exhausted", func() {
+		createSlowIPAM(2)
+
+		conf, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"ipamTimeout": "200ms", "ipamRetries": 1}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		start := time.Now()
+
+		_, err = ipamExecAdd(conf, ipamDelegate{
+			plugin:  "slow-ipam",
+			netconf: []byte(`{"cniVersion": "0.4.0", "name": "mynet", "ipam": {"type": "slow-ipam"}}`),
+		})
+		Expect(err).To(MatchError(ContainSubstring("timed out")))
+		Expect(calls()).To(Equal(2))
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+	})
+
+	It("rejects invalid IPAM timeout", func() {
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"ipamTimeout": "soon"}`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("passes own IPAM block to each dual-stack delegate", func() {
+		_, delegates := parseDualStackConfig()
+
+		Expect(delegates[0].plugin).To(Equal("ipam-v4"))
+		Expect(delegates[0].netconf).To(MatchJSON(`{"cniVersion": "0.4.0", "name": "mynet", "master": "br0",
+			"vlanId": 100, "ifName": "aos-vlan", "ipam": {"type": "ipam-v4"}}`))
+		Expect(delegates[1].plugin).To(Equal("ipam-v6"))
+		Expect(delegates[1].netconf).To(MatchJSON(`{"cniVersion": "0.4.0", "name": "mynet", "master": "br0",
+			"vlanId": 100, "ifName": "aos-vlan", "ipam": {"type": "ipam-v6"}}`))
+	})
+
+	It("delegates the ipam block first", func() {
+		stdinData := []byte(`{"cniVersion": "0.4.0", "name": "mynet", "master": "br0", "vlanId": 100,
+			"ifName": "aos-vlan", "ipam": {"type": "ipam-main"}, "ipamV4": {"type": "ipam-v4"}}`)
+
+		conf, _, err := parseConfig(stdinData)
+		Expect(err).NotTo(HaveOccurred())
+
+		delegates, err := ipamDelegates(conf, stdinData)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(delegates).To(HaveLen(2))
+
+		Expect(delegates[0].plugin).To(Equal("ipam-main"))
+		Expect(delegates[0].netconf).To(MatchJSON(`{"cniVersion": "0.4.0", "name": "mynet", "master": "br0",
+			"vlanId": 100, "ifName": "aos-vlan", "ipam": {"type": "ipam-main"}}`))
+		Expect(delegates[1].plugin).To(Equal("ipam-v4"))
+		Expect(delegates[1].netconf).To(MatchJSON(`{"cniVersion": "0.4.0", "name": "mynet", "master": "br0",
+			"vlanId": 100, "ifName": "aos-vlan", "ipam": {"type": "ipam-v4"}}`))
+	})
+
+	It("skips the ipam block without type", func() {
+		stdinData := []byte(`{"cniVersion": "0.4.0", "name": "mynet", "master": "br0", "vlanId": 100,
+			"ifName": "aos-vlan", "ipam": {}}`)
+
+		conf, _, err := parseConfig(stdinData)
+		Expect(err).NotTo(HaveOccurred())
+
+		delegates, err := ipamDelegates(conf, stdinData)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(delegates).To(BeEmpty())
+	})
+
+	It("runs the ipam block on ADD and DEL", func() {
+		createIPAM("ipam-main", "4", "10.1.0.2/24")
+
+		stdinData := []byte(`{"cniVersion": "0.4.0", "name": "mynet", "master": "br0", "vlanId": 100,
+			"ifName": "aos-vlan", "ipam": {"type": "ipam-main"}}`)
+
+		conf, _, err := parseConfig(stdinData)
+		Expect(err).NotTo(HaveOccurred())
+
+		delegates, err := ipamDelegates(conf, stdinData)
+		Expect(err).NotTo(HaveOccurred())
+
+		result, err := ipamAdd(conf, delegates)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IPs).To(HaveLen(1))
+		Expect(result.IPs[0].Address.String()).To(Equal("10.1.0.2/24"))
+
+		Expect(ipamDel(conf, delegates)).To(Succeed())
+		Expect(commands()).To(Equal([]string{"ADD ipam-main", "DEL ipam-main"}))
+	})
+
+	It("merges dual-stack IPAM results", func() {
+		createIPAM("ipam-v4", "4", "10.1.0.2/24")
+		createIPAM("ipam-v6", "6", "fd00::2/64")
+
+		conf, delegates := parseDualStackConfig()
+
+		result, err := ipamAdd(conf, delegates)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IPs).To(HaveLen(2))
+		Expect(result.IPs[0].Address.String()).To(Equal("10.1.0.2/24"))
+		Expect(result.IPs[1].Address.String()).To(Equal("fd00::2/64"))
+
+		Expect(ipamDel(conf, delegates)).To(Succeed())
+		Expect(commands()).To(Equal([]string{"ADD ipam-v4", "ADD ipam-v6", "DEL ipam-v4", "DEL ipam-v6"}))
+	})
+
+	It("forwards GC to each dual-stack delegate", func() {
+		createIPAM("ipam-v4", "4", "10.1.0.2/24")
+		createIPAM("ipam-v6", "6", "fd00::2/64")
+
+		Expect(cmdGC(&skel.CmdArgs{StdinData: []byte(`{"cniVersion": "1.1.0", "name": "mynet", "master": "br0",
+			"vlanId": 100, "ifName": "aos-vlan", "ipamV4": {"type": "ipam-v4"}, "ipamV6": {"type": "ipam-v6"},
+			"cni.dev/valid-attachments": [{"containerID": "dummy", "ifname": "eth0"}]}`)})).To(Succeed())
+		Expect(commands()).To(Equal([]string{"GC ipam-v4", "GC ipam-v6"}))
+	})
+
+	It("rolls back dual-stack IPAM on partial failure", func() {
+		createIPAM("ipam-v4", "4", "10.1.0.2/24")
+		createIPAM("ipam-v6", "6", "")
+
+		conf, delegates := parseDualStackConfig()
+
+		_, err := ipamAdd(conf, delegates)
+		Expect(err).To(MatchError(ContainSubstring("no addresses left")))
+		Expect(commands()).To(Equal([]string{"ADD ipam-v4", "ADD ipam-v6", "DEL ipam-v4", "DEL ipam-v6"}))
+	})
+})
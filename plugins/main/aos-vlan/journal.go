@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	journalPriorityErr  = 3
+	journalPriorityInfo = 6
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// journalSocketPath is the systemd journal native protocol socket, variable for testing.
+var journalSocketPath = "/run/systemd/journal/socket"
+
+// journalFallback receives the summary if the journal isn't running, variable for testing.
+var journalFallback io.Writer = os.Stderr
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// sendJournal reports the CNI command outcome to the systemd journal. Errors are returned for diagnostic purposes only
+// and must never fail the CNI command.
+func sendJournal(conf *pluginConf, event *pluginEvent, cmdErr error) error {
+	if conf == nil || !conf.Journal {
+		return nil
+	}
+
+	msg := commandSummary(event, cmdErr)
+
+	priority := journalPriorityInfo
+	if cmdErr != nil {
+		priority = journalPriorityErr
+	}
+
+	conn, err := net.DialTimeout("unixgram", journalSocketPath, syslogConnectTimeout)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			fmt.Fprintf(journalFallback, "%s: %s\n", syslogTag, msg)
+
+			return nil
+		}
+
+		return fmt.Errorf("failed to connect journal: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(syslogConnectTimeout)); err != nil {
+		return fmt.Errorf("failed to set journal deadline: %v", err)
+	}
+
+	var entry bytes.Buffer
+
+	appendJournalField(&entry, "MESSAGE", msg)
+	appendJournalField(&entry, "PRIORITY", strconv.Itoa(priority))
+	appendJournalField(&entry, "SYSLOG_IDENTIFIER", syslogTag)
+	appendJournalField(&entry, "CNI_COMMAND", event.Command)
+	appendJournalField(&entry, "CONTAINER_ID", event.ContainerID)
+	appendJournalField(&entry, "INTERFACE", event.IfName)
+	appendJournalField(&entry, "MASTER", event.Master)
+	appendJournalField(&entry, "VLAN_ID", strconv.Itoa(event.VlanId))
+
+	if _, err := conn.Write(entry.Bytes()); err != nil {
+		return fmt.Errorf("failed to write journal: %v", err)
+	}
+
+	return nil
+}
+
+// appendJournalField appends the field in the journal native protocol format. Values containing a newline are
+// serialized with the explicit little-endian 64-bit length instead of the KEY=value form.
+func appendJournalField(entry *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(entry, "%s=%s\n", key, value)
+
+		return
+	}
+
+	entry.WriteString(key + "\n")
+	_ = binary.Write(entry, binary.LittleEndian, uint64(len(value)))
+	entry.WriteString(value + "\n")
+}
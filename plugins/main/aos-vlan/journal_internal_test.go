@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan journal", func() {
+	var (
+		tmpDir           string
+		listener         *net.UnixConn
+		originalPath     string
+		originalFallback = journalFallback
+	)
+
+	conf := &pluginConf{Master: "br0", VlanId: 100, IfName: "aos-vlan", Journal: true}
+	args := &skel.CmdArgs{ContainerID: "dummy"}
+
+	BeforeEach(func() {
+		var err error
+
+		tmpDir, err = os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
+		originalPath = journalSocketPath
+		journalSocketPath = filepath.Join(tmpDir, "socket")
+
+		listener, err = net.ListenUnixgram("unixgram", &net.UnixAddr{Name: journalSocketPath, Net: "unixgram"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		listener.Close()
+
+		journalSocketPath = originalPath
+		journalFallback = originalFallback
+
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	receive := func() string {
+		buf := make([]byte, 1024)
+
+		Expect(listener.SetReadDeadline(time.Now().Add(time.Second))).To(Succeed())
+
+		n, err := listener.Read(buf)
+		Expect(err).NotTo(HaveOccurred())
+
+		return string(buf[:n])
+	}
+
+	It("sends structured fields on success", func() {
+		Expect(sendJournal(conf, newPluginEvent("ADD", args, conf), nil)).To(Succeed())
+
+		entry := receive()
+		Expect(entry).To(ContainSubstring("MESSAGE=ADD aos-vlan vlanId 100 master br0 container dummy: success\n"))
+		Expect(entry).To(ContainSubstring("PRIORITY=6\n"))
+		Expect(entry).To(ContainSubstring("VLAN_ID=100\n"))
+		Expect(entry).To(ContainSubstring("MASTER=br0\n"))
+		Expect(entry).To(ContainSubstring("CONTAINER_ID=dummy\n"))
+		Expect(entry).To(ContainSubstring("CNI_COMMAND=ADD\n"))
+	})
+
+	It("sends multiline error with explicit length", func() {
+		Expect(sendJournal(conf, newPluginEvent("DEL", args, conf), errors.New("link busy\nretry later"))).To(Succeed())
+
+		msg := "DEL aos-vlan vlanId 100 master br0 container dummy: link busy\nretry later"
+
+		var field bytes.Buffer
+
+		field.WriteString("MESSAGE\n")
+		Expect(binary.Write(&field, binary.LittleEndian, uint64(len(msg)))).To(Succeed())
+		field.WriteString(msg + "\n")
+
+		entry := receive()
+		Expect(entry).To(HavePrefix(field.String()))
+		Expect(entry).To(ContainSubstring("PRIORITY=3\n"))
+		Expect(entry).To(ContainSubstring("CNI_COMMAND=DEL\n"))
+	})
+
+	It("falls back to stderr without journal", func() {
+		listener.Close()
+		Expect(os.Remove(journalSocketPath)).To(Succeed())
+
+		var fallback bytes.Buffer
+
+		journalFallback = &fallback
+
+		Expect(sendJournal(conf, newPluginEvent("ADD", args, conf), nil)).To(Succeed())
+		Expect(fallback.String()).To(Equal("aos-vlan: ADD aos-vlan vlanId 100 master br0 container dummy: success\n"))
+	})
+})
@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// vlanModule is the kernel module providing 802.1Q VLAN links.
+const vlanModule = "8021q"
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// kernelFeature is a kernel feature a configuration field depends on and the modules providing it.
+type kernelFeature struct {
+	name    string
+	modules []string
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// Paths and release used to probe the kernel modules, variables for testing.
+var (
+	sysModulePath     = "/sys/module"
+	procModulesPath   = "/proc/modules"
+	kernelModulesPath = "/lib/modules"
+	kernelRelease     = unameRelease
+)
+
+var (
+	featureClsact           = kernelFeature{name: "clsact qdisc", modules: []string{"sch_ingress"}}
+	featureBpfClassifier    = kernelFeature{name: "bpf classifier", modules: []string{"cls_bpf"}}
+	featureDscpToPcp        = kernelFeature{name: "DSCP to PCP mapping", modules: []string{"cls_u32", "act_skbedit"}}
+	featureMirror           = kernelFeature{name: "mirroring", modules: []string{"cls_u32", "act_mirred"}}
+	featureIPv6TrafficClass = kernelFeature{
+		name: "IPv6 traffic class rewriting", modules: []string{"cls_matchall", "act_pedit"},
+	}
+)
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// checkKernelFeatures checks the kernel features the configuration depends on.
+func checkKernelFeatures(conf *pluginConf) error {
+	var features []kernelFeature
+
+	if conf.IPv6TrafficClass != nil {
+		features = append(features, featureClsact, featureIPv6TrafficClass)
+	}
+
+	if conf.BpfProgram != "" {
+		features = append(features, featureClsact, featureBpfClassifier)
+	}
+
+	if len(conf.DscpToPcp) != 0 {
+		features = append(features, featureClsact, featureDscpToPcp)
+	}
+
+	if conf.MirrorTo != "" {
+		features = append(features, featureClsact, featureMirror)
+	}
+
+	for _, feature := range features {
+		if err := checkKernelFeature(feature); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkKernelFeature fails if a module providing the feature is known to be unavailable. A feature which can't be
+// probed, e.g. if the modules directory is not accessible in a container, is considered supported.
+func checkKernelFeature(feature kernelFeature) error {
+	for _, module := range feature.modules {
+		if available, known := moduleAvailable(module); known && !available {
+			return fmt.Errorf("kernel does not support %s: module %s is not available", feature.name, module)
+		}
+	}
+
+	return nil
+}
+
+// moduleAvailable reports whether the module is loaded, built in or loadable, and whether it could be determined.
+func moduleAvailable(module string) (available, known bool) {
+	module = normalizeModuleName(module)
+
+	if _, err := os.Stat(filepath.Join(sysModulePath, module)); err == nil {
+		return true, true
+	}
+
+	release, err := kernelRelease()
+	if err != nil {
+		return false, false
+	}
+
+	for _, list := range []string{"modules.builtin", "modules.dep"} {
+		data, err := os.ReadFile(filepath.Join(kernelModulesPath, release, list))
+		if err != nil {
+			return false, false
+		}
+
+		if moduleListed(data, module) {
+			return true, true
+		}
+	}
+
+	return false, true
+}
+
+// checkVlanModule fails if the 8021q module is neither loaded nor built in, so VLAN creation doesn't depend on the
+// module being autoloaded.
+func checkVlanModule() error {
+	if moduleLoaded(vlanModule) {
+		return nil
+	}
+
+	return fmt.Errorf("kernel module %s is not loaded", vlanModule)
+}
+
+// moduleLoaded reports whether the module is loaded or built in. Unlike moduleAvailable, a loadable module which is not
+// loaded yet is not accepted.
+func moduleLoaded(module string) bool {
+	module = normalizeModuleName(module)
+
+	if _, err := os.Stat(filepath.Join(sysModulePath, module)); err == nil {
+		return true
+	}
+
+	if data, err := os.ReadFile(procModulesPath); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+
+		for scanner.Scan() {
+			if name, _, _ := strings.Cut(scanner.Text(), " "); name == module {
+				return true
+			}
+		}
+	}
+
+	// Built-in modules without parameters are listed neither in /sys/module nor in /proc/modules
+	release, err := kernelRelease()
+	if err != nil {
+		return false
+	}
+
+	data, err := os.ReadFile(filepath.Join(kernelModulesPath, release, "modules.builtin"))
+	if err != nil {
+		return false
+	}
+
+	return moduleListed(data, module)
+}
+
+// moduleListed checks the modules.builtin or modules.dep list for the module. Both have a module path per line,
+// modules.dep followed by ':' and the dependencies.
+func moduleListed(data []byte, module string) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		path, _, _ := strings.Cut(scanner.Text(), ":")
+
+		name, _, _ := strings.Cut(filepath.Base(path), ".ko")
+		if normalizeModuleName(name) == module {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeModuleName replaces '-' with '_' as the kernel treats them interchangeably in module names.
+func normalizeModuleName(name string) string {
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+func unameRelease() (string, error) {
+	var uname unix.Utsname
+
+	if err := unix.Uname(&uname); err != nil {
+		return "", err
+	}
+
+	return unix.ByteSliceToString(uname.Release[:]), nil
+}
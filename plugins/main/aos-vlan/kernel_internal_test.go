@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan kernel", func() {
+	var (
+		tmpDir                string
+		originalSysModulePath string
+		originalProcModules   string
+		originalModulesPath   string
+		originalRelease       func() (string, error)
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		tmpDir, err = os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
+		originalSysModulePath, originalModulesPath, originalRelease = sysModulePath, kernelModulesPath, kernelRelease
+		originalProcModules = procModulesPath
+
+		sysModulePath = filepath.Join(tmpDir, "sys")
+		procModulesPath = filepath.Join(tmpDir, "proc-modules")
+		kernelModulesPath = filepath.Join(tmpDir, "modules")
+		kernelRelease = func() (string, error) { return "test", nil }
+
+		Expect(os.MkdirAll(filepath.Join(sysModulePath, "sch_ingress"), 0o755)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(kernelModulesPath, "test"), 0o755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(kernelModulesPath, "test", "modules.builtin"),
+			[]byte("kernel/net/sched/cls_matchall.ko\n"), 0o644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(kernelModulesPath, "test", "modules.dep"),
+			[]byte("kernel/net/sched/act_pedit.ko.xz:\nkernel/net/8021q/8021q.ko.xz: kernel/net/802/garp.ko.xz\n"),
+			0o644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		sysModulePath, kernelModulesPath, kernelRelease = originalSysModulePath, originalModulesPath, originalRelease
+		procModulesPath = originalProcModules
+
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("aos-vlan probes kernel modules", func() {
+		for module, expected := range map[string]bool{
+			"sch_ingress":  true,
+			"cls_matchall": true,
+			"act-pedit":    true,
+			"8021q":        true,
+			"garp":         false,
+			"cls_bpf":      false,
+		} {
+			available, known := moduleAvailable(module)
+			Expect(known).To(BeTrue(), module)
+			Expect(available).To(Equal(expected), module)
+		}
+	})
+
+	It("aos-vlan reports unsupported kernel feature", func() {
+		trafficClass := 10
+
+		Expect(checkKernelFeatures(&pluginConf{IPv6TrafficClass: &trafficClass})).To(Succeed())
+
+		_, _, err := parseConfig([]byte(`{
+			"name": "mynet",
+			"type": "aos-vlan",
+			"master": "br0",
+			"vlanId": 100,
+			"ifName": "aos-vlan",
+			"bpfProgram": "/sys/fs/bpf/prog"
+		}`))
+		Expect(err).To(MatchError("kernel does not support bpf classifier: module cls_bpf is not available"))
+	})
+
+	It("aos-vlan requires loaded 8021q module", func() {
+		// 8021q is loadable, but not loaded
+		Expect(checkVlanModule()).To(MatchError("kernel module 8021q is not loaded"))
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       "dummy",
+			IfName:      "aos-vlan",
+			StdinData: []byte(`{"name": "mynet", "cniVersion": "0.4.0", "type": "aos-vlan", "master": "br0",
+				"vlanId": 100, "ifName": "aos-vlan", "requireModule": true}`),
+		}
+
+		Expect(cmdAdd(args)).To(MatchError("kernel module 8021q is not loaded"))
+
+		Expect(os.WriteFile(procModulesPath, []byte("garp 16384 1 8021q, Live 0x0000000000000000\n"+
+			"8021q 40960 0 - Live 0x0000000000000000\n"), 0o644)).To(Succeed())
+		Expect(checkVlanModule()).To(Succeed())
+
+		Expect(os.Remove(procModulesPath)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(sysModulePath, "8021q"), 0o755)).To(Succeed())
+		Expect(checkVlanModule()).To(Succeed())
+	})
+
+	It("aos-vlan accepts built-in 8021q module", func() {
+		Expect(os.WriteFile(filepath.Join(kernelModulesPath, "test", "modules.builtin"),
+			[]byte("kernel/net/8021q/8021q.ko\n"), 0o644)).To(Succeed())
+
+		Expect(checkVlanModule()).To(Succeed())
+	})
+
+	It("aos-vlan considers features supported if modules can't be probed", func() {
+		kernelModulesPath = filepath.Join(tmpDir, "missing")
+
+		available, known := moduleAvailable("cls_bpf")
+		Expect(known).To(BeFalse())
+		Expect(available).To(BeFalse())
+
+		Expect(checkKernelFeatures(&pluginConf{BpfProgram: "/sys/fs/bpf/prog"})).To(Succeed())
+	})
+})
@@ -0,0 +1,209 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Log levels in increasing severity. logLevelOff disables logging.
+const (
+	logLevelDebug = iota
+	logLevelInfo
+	logLevelWarn
+	logLevelError
+	logLevelOff
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// pluginLogger writes leveled logfmt lines. It never writes to stdout, which carries the CNI result.
+type pluginLogger struct {
+	out   io.Writer
+	file  *os.File
+	level int
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+var logLevelNames = map[string]int{
+	"debug": logLevelDebug,
+	"info":  logLevelInfo,
+	"warn":  logLevelWarn,
+	"error": logLevelError,
+}
+
+// logger is the command logger, disabled until openLogger configures it.
+var logger = &pluginLogger{level: logLevelOff}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// openLogger configures the command logger. Logging is enabled by logLevel or logFile, "info" is the default level. A
+// log file that can't be opened falls back to stderr, logging must never fail the CNI command.
+func openLogger(conf *pluginConf) {
+	closeLogger()
+
+	if conf.LogLevel == "" && conf.LogFile == "" {
+		return
+	}
+
+	level := logLevelInfo
+
+	if conf.LogLevel != "" {
+		level = logLevelNames[conf.LogLevel]
+	}
+
+	newLogger := &pluginLogger{out: os.Stderr, level: level}
+
+	if conf.LogFile != "" {
+		file, err := os.OpenFile(conf.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "aos-vlan: failed to open log file %s: %v, logging to stderr\n", conf.LogFile, err)
+		} else {
+			newLogger.out, newLogger.file = file, file
+		}
+	}
+
+	logger = newLogger
+}
+
+// closeLogger closes the log file and disables the command logger.
+func closeLogger() {
+	if logger.file != nil {
+		logger.file.Close()
+	}
+
+	logger = &pluginLogger{level: logLevelOff}
+}
+
+func logDebug(msg string, keyvals ...interface{}) {
+	logger.log(logLevelDebug, msg, keyvals...)
+}
+
+func logInfo(msg string, keyvals ...interface{}) {
+	logger.log(logLevelInfo, msg, keyvals...)
+}
+
+func logWarn(msg string, keyvals ...interface{}) {
+	logger.log(logLevelWarn, msg, keyvals...)
+}
+
+func logError(msg string, keyvals ...interface{}) {
+	logger.log(logLevelError, msg, keyvals...)
+}
+
+// logParsedConfig logs the parsed configuration and the adjustments made by parseConfig before the logger was open.
+func logParsedConfig(command, containerID string, conf *pluginConf) {
+	logDebug("parsed configuration", "command", command, "container", containerID, "config", conf)
+
+	if conf.normalizedIfName != "" {
+		logWarn("interface name normalized", "ifName", conf.normalizedIfName, "normalized", conf.IfName)
+	}
+}
+
+// logCommandResult logs the CNI command outcome, as an error if it failed.
+func logCommandResult(command string, cmdErr error) {
+	if cmdErr != nil {
+		logError("command failed", "command", command, "error", cmdErr)
+		return
+	}
+
+	logInfo("command succeeded", "command", command)
+}
+
+// log writes the message with the key value pairs if the level is enabled. Write errors are ignored.
+func (l *pluginLogger) log(level int, msg string, keyvals ...interface{}) {
+	if level < l.level {
+		return
+	}
+
+	var line strings.Builder
+
+	fmt.Fprintf(&line, "time=%s level=%s msg=%s", time.Now().UTC().Format(time.RFC3339Nano), levelName(level),
+		logValue(msg))
+
+	for i := 0; i < len(keyvals); i += 2 {
+		var value interface{} = "MISSING"
+
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+
+		fmt.Fprintf(&line, " %v=%s", keyvals[i], logValue(value))
+	}
+
+	line.WriteByte('\n')
+
+	_, _ = io.WriteString(l.out, line.String())
+}
+
+func levelName(level int) string {
+	for name, value := range logLevelNames {
+		if value == level {
+			return name
+		}
+	}
+
+	return "unknown"
+}
+
+// logValue formats the log value, quoting it if needed. Values other than strings, errors and numbers are logged as
+// JSON.
+func logValue(value interface{}) string {
+	var text string
+
+	switch v := value.(type) {
+	case string:
+		text = v
+
+	case error:
+		text = v.Error()
+
+	case int, int64, uint, uint16, uint32, bool:
+		return fmt.Sprint(v)
+
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			data = []byte(fmt.Sprintf("%+v", v))
+		}
+
+		text = string(data)
+	}
+
+	if text == "" || strings.ContainsAny(text, " \t\n\"=") {
+		return fmt.Sprintf("%q", text)
+	}
+
+	return text
+}
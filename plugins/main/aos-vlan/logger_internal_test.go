@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan logger", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		var err error
+
+		tmpDir, err = os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		closeLogger()
+
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	readLog := func(path string) []string {
+		content, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+
+		return strings.Split(strings.TrimSpace(string(content)), "\n")
+	}
+
+	It("writes leveled log lines to the log file", func() {
+		conf := &pluginConf{LogLevel: "debug", LogFile: filepath.Join(tmpDir, "log")}
+
+		openLogger(conf)
+
+		logDebug("adding vlan link", "name", "aos-vlan", "vlanId", 100)
+		logCommandResult("ADD", errors.New("failed to create vlan: file exists"))
+		logDebug("parsed configuration", "config", &pluginConf{IfName: "aos-vlan"})
+
+		closeLogger()
+
+		lines := readLog(conf.LogFile)
+		Expect(lines).To(HaveLen(3))
+		Expect(lines[0]).To(MatchRegexp(`^time=\S+ level=debug msg="adding vlan link" name=aos-vlan vlanId=100$`))
+		Expect(lines[1]).To(HaveSuffix(
+			`level=error msg="command failed" command=ADD error="failed to create vlan: file exists"`))
+		Expect(lines[2]).To(ContainSubstring(`\"ifName\":\"aos-vlan\"`))
+
+		// Messages below the level are dropped
+		conf.LogLevel = "info"
+
+		openLogger(conf)
+
+		logDebug("adding vlan link", "name", "aos-vlan")
+		logCommandResult("DEL", nil)
+
+		closeLogger()
+
+		lines = readLog(conf.LogFile)
+		Expect(lines).To(HaveLen(4))
+		Expect(lines[3]).To(HaveSuffix(`level=info msg="command succeeded" command=DEL`))
+
+		// Logging is disabled by default
+		openLogger(&pluginConf{})
+
+		logCommandResult("CHECK", nil)
+
+		Expect(readLog(conf.LogFile)).To(HaveLen(4))
+	})
+
+	It("logs interface name normalized by parseConfig", func() {
+		conf, _, err := parseConfig([]byte(fmt.Sprintf(`{"master": "br0", "vlanId": 100, "ifName": "aos vlan",
+			"normalizeName": true, "logLevel": "warn", "logFile": %q}`, filepath.Join(tmpDir, "log"))))
+		Expect(err).NotTo(HaveOccurred())
+
+		openLogger(conf)
+
+		logParsedConfig("ADD", "dummy", conf)
+
+		closeLogger()
+
+		lines := readLog(conf.LogFile)
+		Expect(lines).To(HaveLen(1))
+		Expect(lines[0]).To(HaveSuffix(fmt.Sprintf(`level=warn msg="interface name normalized" ifName="aos vlan" `+
+			`normalized=%s`, conf.IfName)))
+	})
+
+	It("validates log level", func() {
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "logLevel": "trace"}`))
+		Expect(err).To(MatchError(ContainSubstring(`invalid log level "trace"`)))
+
+		conf, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"logLevel": "debug", "logFile": "/var/log/aos-vlan.log"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.LogLevel).To(Equal("debug"))
+	})
+})
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// markerPrefix starts the interface alias of links created by the plugin.
+const markerPrefix = "aos-vlan:"
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// vlanMarker is the plugin state persisted in the VLAN interface alias, so commands invoked with fresh arguments
+// (e.g. CHECK) know how the link was set up.
+type vlanMarker struct {
+	Up bool `json:"up"`
+	// PortVlan is the bridge port VLAN filter entry added by the plugin.
+	PortVlan int `json:"portVlan,omitempty"`
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func setVlanMarker(link netlink.Link, marker vlanMarker) error {
+	data, err := json.Marshal(marker)
+	if err != nil {
+		return fmt.Errorf("failed to marshal marker: %v", err)
+	}
+
+	if err := netlink.LinkSetAlias(link, markerPrefix+string(data)); err != nil {
+		return fmt.Errorf("failed to set alias of %q: %v", link.Attrs().Name, err)
+	}
+
+	return nil
+}
+
+// getVlanMarker returns the marker of the link and false if the link wasn't marked by the plugin.
+func getVlanMarker(link netlink.Link) (marker vlanMarker, ok bool) {
+	if !strings.HasPrefix(link.Attrs().Alias, markerPrefix) {
+		return marker, false
+	}
+
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(link.Attrs().Alias, markerPrefix)), &marker); err != nil {
+		return marker, false
+	}
+
+	return marker, true
+}
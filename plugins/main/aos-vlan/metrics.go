@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	metricsJobName     = "aos-vlan"
+	pushGatewayTimeout = 2 * time.Second
+)
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// pushMetrics pushes the outcome of a CNI command to the Prometheus Pushgateway configured by pushGatewayURL. Each
+// command has its own group, so the push replaces only the last outcome of the same command.
+// Errors are returned for diagnostic purposes only and must never fail the CNI command.
+func pushMetrics(conf *pluginConf, command string, start time.Time, cmdErr error) error {
+	if conf == nil || conf.PushGatewayURL == "" {
+		return nil
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	pushURL := fmt.Sprintf("%s/metrics/job/%s/%s/%s/%s", strings.TrimSuffix(conf.PushGatewayURL, "/"),
+		url.PathEscape(metricsJobName), groupingLabel("host", host), groupingLabel("master", conf.Master),
+		groupingLabel("command", command))
+
+	client := &http.Client{Timeout: pushGatewayTimeout}
+
+	resp, err := client.Post(pushURL, "text/plain; version=0.0.4",
+		bytes.NewReader(formatMetrics(time.Now(), time.Since(start), cmdErr)))
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to push metrics: unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// groupingLabel returns the grouping key path segments of the label. The Pushgateway rejects an empty path segment,
+// so an empty value, e.g. of an unset master, is sent base64 encoded.
+func groupingLabel(name, value string) string {
+	if value == "" {
+		return name + "@base64/="
+	}
+
+	return name + "/" + url.PathEscape(value)
+}
+
+// formatMetrics returns the last outcome of the command as gauges: whether it succeeded, when it finished and how long
+// it took.
+func formatMetrics(end time.Time, duration time.Duration, cmdErr error) []byte {
+	var buf bytes.Buffer
+
+	success := 1
+	if cmdErr != nil {
+		success = 0
+	}
+
+	fmt.Fprintf(&buf, "# TYPE aos_vlan_command_last_success gauge\n")
+	fmt.Fprintf(&buf, "aos_vlan_command_last_success %d\n", success)
+	fmt.Fprintf(&buf, "# TYPE aos_vlan_command_last_timestamp_seconds gauge\n")
+	fmt.Fprintf(&buf, "aos_vlan_command_last_timestamp_seconds %d\n", end.Unix())
+	fmt.Fprintf(&buf, "# TYPE aos_vlan_command_duration_seconds gauge\n")
+	fmt.Fprintf(&buf, "aos_vlan_command_duration_seconds %f\n", duration.Seconds())
+
+	return buf.Bytes()
+}
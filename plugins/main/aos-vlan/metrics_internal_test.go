@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan metrics", func() {
+	It("pushes command metrics to the push gateway", func() {
+		var (
+			method, path string
+			body         []byte
+		)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			method, path = r.Method, r.URL.Path
+
+			var err error
+
+			body, err = io.ReadAll(r.Body)
+			Expect(err).NotTo(HaveOccurred())
+		}))
+		defer server.Close()
+
+		host, err := os.Hostname()
+		Expect(err).NotTo(HaveOccurred())
+
+		conf := &pluginConf{Master: "br0", PushGatewayURL: server.URL + "/"}
+
+		Expect(pushMetrics(conf, "ADD", time.Now(), nil)).To(Succeed())
+		Expect(method).To(Equal(http.MethodPost))
+		Expect(path).To(Equal("/metrics/job/aos-vlan/host/" + host + "/master/br0/command/ADD"))
+		Expect(string(body)).To(ContainSubstring("aos_vlan_command_last_success 1\n"))
+		Expect(string(body)).To(ContainSubstring("aos_vlan_command_last_timestamp_seconds "))
+		Expect(string(body)).To(ContainSubstring("aos_vlan_command_duration_seconds "))
+
+		Expect(pushMetrics(conf, "CHECK", time.Now(), errors.New("failed"))).To(Succeed())
+		Expect(path).To(Equal("/metrics/job/aos-vlan/host/" + host + "/master/br0/command/CHECK"))
+		Expect(string(body)).To(ContainSubstring("aos_vlan_command_last_success 0\n"))
+
+		// An unset master is sent base64 encoded
+		Expect(pushMetrics(&pluginConf{PushGatewayURL: server.URL}, "DEL", time.Now(), nil)).To(Succeed())
+		Expect(path).To(Equal("/metrics/job/aos-vlan/host/" + host + "/master@base64/=/command/DEL"))
+	})
+
+	It("reports push gateway failures", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		Expect(pushMetrics(&pluginConf{Master: "br0", PushGatewayURL: server.URL}, "ADD", time.Now(), nil)).
+			NotTo(Succeed())
+	})
+
+	It("skips push when push gateway is not configured", func() {
+		Expect(pushMetrics(&pluginConf{Master: "br0"}, "ADD", time.Now(), nil)).To(Succeed())
+	})
+})
@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	mssClampPmtu = "pmtu"
+	// minMss is the Linux TCP_MIN_MSS.
+	minMss = 88
+	// maxMss is the MSS of the largest IPv4 packet.
+	maxMss = 65495
+	// ipv4TcpOverhead and ipv6TcpOverhead are the IP and TCP header sizes subtracted from the MTU.
+	ipv4TcpOverhead = 40
+	ipv6TcpOverhead = 60
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// mssClamp is the TCP MSS clamp unmarshaled from a fixed MSS or "pmtu", which clamps the MSS to the VLAN MTU.
+type mssClamp struct {
+	Size int
+	Pmtu bool
+}
+
+/***********************************************************************************************************************
+ * Interface
+ **********************************************************************************************************************/
+
+func (m *mssClamp) UnmarshalJSON(data []byte) error {
+	var value string
+
+	if err := json.Unmarshal(data, &value); err == nil {
+		if value != mssClampPmtu {
+			return fmt.Errorf("invalid MSS clamp %q (must be a number or %q)", value, mssClampPmtu)
+		}
+
+		m.Pmtu = true
+
+		return nil
+	}
+
+	if err := json.Unmarshal(data, &m.Size); err != nil {
+		return fmt.Errorf("invalid MSS clamp %s (must be a number or %q)", data, mssClampPmtu)
+	}
+
+	if m.Size < minMss || m.Size > maxMss {
+		return fmt.Errorf("invalid MSS clamp %d (must be between %d and %d inclusive)", m.Size, minMss, maxMss)
+	}
+
+	return nil
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// mssClampChain returns the name of the chain clamping the TCP MSS of the VLAN.
+func mssClampChain(ifName string) string {
+	return "mss-clamp-" + ifName
+}
+
+// mssClampSizes returns the IPv4 and IPv6 MSS the SYN packets sent out through the VLAN are clamped to.
+func mssClampSizes(clamp *mssClamp, mtu int) (ipv4Mss, ipv6Mss int) {
+	if !clamp.Pmtu {
+		return clamp.Size, clamp.Size
+	}
+
+	return mtu - ipv4TcpOverhead, mtu - ipv6TcpOverhead
+}
+
+// setMssClamp lowers the MSS option of TCP SYN packets the bridge sends out through the VLAN port. The MSS is only
+// lowered, never raised. As with the egress source MAC, each VLAN has its own postrouting chain.
+func setMssClamp(ifName string, clamp *mssClamp, mtu int) error {
+	chain := mssClampChain(ifName)
+	ipv4Mss, ipv6Mss := mssClampSizes(clamp, mtu)
+
+	rule := func(etherType string, mss int) string {
+		size := strconv.Itoa(mss)
+
+		return fmt.Sprintf("add rule bridge %s %s oifname \"%s\" ether type %s "+
+			"tcp flags & (syn|rst) == syn tcp option maxseg size > %s tcp option maxseg size set %s",
+			nftTable, chain, ifName, etherType, size, size)
+	}
+
+	if err := runTool("nft", fmt.Sprintf("add table bridge %s; "+
+		"add chain bridge %s %s { type filter hook postrouting priority 0 ; }; "+
+		"flush chain bridge %s %s; %s; %s",
+		nftTable, nftTable, chain, nftTable, chain, rule("ip", ipv4Mss), rule("ip6", ipv6Mss))); err != nil {
+		return fmt.Errorf("failed to set MSS clamp of %q: %v", ifName, err)
+	}
+
+	return nil
+}
+
+// removeMssClamp deletes the MSS clamp chain of the VLAN, if any.
+func removeMssClamp(conf *pluginConf) error {
+	if err := removeNftChain(mssClampChain(conf.IfName)); err != nil {
+		return fmt.Errorf("failed to remove MSS clamp of %q: %v", conf.IfName, err)
+	}
+
+	return nil
+}
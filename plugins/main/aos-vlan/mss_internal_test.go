@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan MSS clamp", func() {
+	It("parses fixed MSS and pmtu", func() {
+		conf, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "mssClamp": 1400}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.MssClamp).To(Equal(&mssClamp{Size: 1400}))
+
+		conf, _, err = parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "mssClamp": "pmtu"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.MssClamp).To(Equal(&mssClamp{Pmtu: true}))
+
+		conf, _, err = parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.MssClamp).To(BeNil())
+	})
+
+	It("rejects invalid MSS clamp", func() {
+		for _, clamp := range []string{`0`, `87`, `65496`, `"auto"`, `1400.5`, `true`} {
+			_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "mssClamp": ` +
+				clamp + `}`))
+			Expect(err).To(HaveOccurred(), clamp)
+		}
+
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "shared": true,
+			"mssClamp": "pmtu"}`))
+		Expect(err).To(MatchError(`"mssClamp" is not supported in "shared" and "inContainer" modes`))
+	})
+
+	It("derives clamp sizes from MTU", func() {
+		ipv4Mss, ipv6Mss := mssClampSizes(&mssClamp{Pmtu: true}, 1500)
+		Expect(ipv4Mss).To(Equal(1460))
+		Expect(ipv6Mss).To(Equal(1440))
+
+		ipv4Mss, ipv6Mss = mssClampSizes(&mssClamp{Size: 1200}, 1500)
+		Expect(ipv4Mss).To(Equal(1200))
+		Expect(ipv6Mss).To(Equal(1200))
+	})
+})
@@ -0,0 +1,210 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	minMtu          = 68
+	maxLinkMtu      = 65535
+	ipv4HeaderLen   = 20
+	icmpHeaderLen   = 8
+	icmpEchoRequest = 8
+	icmpEchoReply   = 0
+	mtuProbeTimeout = time.Second
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// pathMtuProbe reports whether an IPv4 packet of the given size with DF set reaches the gateway via the interface,
+// variable for testing.
+var pathMtuProbe = icmpProbe
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// clampMtuToPath lowers the VLAN MTU to the path MTU towards the IPv4 gateway of the result. The VLAN is looked up in
+// the namespace, the current one if netnsPath is empty.
+func clampMtuToPath(netnsPath, ifName string, result *current.Result) error {
+	gateway := ipv4Gateway(result)
+	if gateway == nil {
+		return nil
+	}
+
+	return withResultLink(netnsPath, ifName, func(link netlink.Link) error {
+		pathMtu := discoverPathMtu(gateway, ifName, link.Attrs().MTU)
+		if pathMtu >= link.Attrs().MTU {
+			return nil
+		}
+
+		if err := netlink.LinkSetMTU(link, pathMtu); err != nil {
+			return fmt.Errorf("failed to set MTU %d on %q: %v", pathMtu, ifName, err)
+		}
+
+		return nil
+	})
+}
+
+func ipv4Gateway(result *current.Result) net.IP {
+	for _, ip := range result.IPs {
+		if gateway := ip.Gateway.To4(); gateway != nil {
+			return gateway
+		}
+	}
+
+	return nil
+}
+
+// discoverPathMtu finds the largest packet size up to maxMtu that reaches the gateway via the interface. An unreachable
+// gateway tells nothing about the path, so maxMtu is kept.
+func discoverPathMtu(gateway net.IP, ifName string, maxMtu int) int {
+	if pathMtuProbe(gateway, ifName, maxMtu) {
+		return maxMtu
+	}
+
+	if !pathMtuProbe(gateway, ifName, minMtu) {
+		logDebug("gateway is not reachable, MTU is not clamped", "gateway", gateway, "ifName", ifName)
+
+		return maxMtu
+	}
+
+	low, high := minMtu, maxMtu
+
+	for high-low > 1 {
+		size := (low + high) / 2
+
+		if pathMtuProbe(gateway, ifName, size) {
+			low = size
+		} else {
+			high = size
+		}
+	}
+
+	return low
+}
+
+// icmpProbe sends an ICMP echo request of the given IP packet size with DF set via the interface and waits for the
+// reply.
+func icmpProbe(gateway net.IP, ifName string, size int) bool {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_ICMP)
+	if err != nil {
+		return false
+	}
+	defer unix.Close(fd)
+
+	if err := unix.BindToDevice(fd, ifName); err != nil {
+		return false
+	}
+
+	// Set DF and ignore the cached path MTU so oversized probes are sent and dropped on the path
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE); err != nil {
+		return false
+	}
+
+	timeout := unix.NsecToTimeval(mtuProbeTimeout.Nanoseconds())
+
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &timeout); err != nil {
+		return false
+	}
+
+	id, seq := uint16(os.Getpid()), uint16(size)
+	addr := &unix.SockaddrInet4{}
+
+	copy(addr.Addr[:], gateway.To4())
+
+	if err := unix.Sendto(fd, icmpEcho(id, seq, size-ipv4HeaderLen), 0, addr); err != nil {
+		return false
+	}
+
+	return waitEchoReply(fd, id, seq, size+ipv4HeaderLen, mtuProbeTimeout)
+}
+
+// waitEchoReply reads the raw ICMP socket until the echo reply with the given ID and sequence arrives or the timeout
+// expires.
+func waitEchoReply(fd int, id, seq uint16, bufSize int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, bufSize)
+
+	for time.Now().Before(deadline) {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return false
+		}
+
+		// Raw socket delivers the IP header followed by the ICMP message
+		if n < ipv4HeaderLen {
+			continue
+		}
+
+		headerLen := int(buf[0]&0x0f) * 4
+		reply := buf[headerLen:n]
+
+		if len(reply) >= icmpHeaderLen && reply[0] == icmpEchoReply &&
+			binary.BigEndian.Uint16(reply[4:]) == id && binary.BigEndian.Uint16(reply[6:]) == seq {
+			return true
+		}
+	}
+
+	return false
+}
+
+func icmpEcho(id, seq uint16, size int) []byte {
+	msg := make([]byte, size)
+
+	msg[0] = icmpEchoRequest
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	binary.BigEndian.PutUint16(msg[2:], icmpChecksum(msg))
+
+	return msg
+}
+
+func icmpChecksum(msg []byte) uint16 {
+	var sum uint32
+
+	for i := 0; i+1 < len(msg); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(msg[i:]))
+	}
+
+	if len(msg)%2 == 1 {
+		sum += uint32(msg[len(msg)-1]) << 8
+	}
+
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+
+	return ^uint16(sum)
+}
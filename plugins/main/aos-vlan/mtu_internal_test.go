@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan path MTU", func() {
+	var originalProbe func(gateway net.IP, ifName string, size int) bool
+
+	BeforeEach(func() {
+		originalProbe = pathMtuProbe
+	})
+
+	AfterEach(func() {
+		pathMtuProbe = originalProbe
+	})
+
+	It("discovers smaller path MTU", func() {
+		pathMtuProbe = func(gateway net.IP, ifName string, size int) bool { return size <= 1400 }
+
+		Expect(discoverPathMtu(net.ParseIP("10.1.0.1"), "aos-vlan", 1500)).To(Equal(1400))
+	})
+
+	It("keeps link MTU when path carries it", func() {
+		pathMtuProbe = func(gateway net.IP, ifName string, size int) bool { return true }
+
+		Expect(discoverPathMtu(net.ParseIP("10.1.0.1"), "aos-vlan", 1500)).To(Equal(1500))
+	})
+
+	It("keeps link MTU when gateway is unreachable", func() {
+		pathMtuProbe = func(gateway net.IP, ifName string, size int) bool { return false }
+
+		Expect(discoverPathMtu(net.ParseIP("10.1.0.1"), "aos-vlan", 1500)).To(Equal(1500))
+	})
+
+	It("probes via the interface", func() {
+		var ifNames []string
+
+		pathMtuProbe = func(gateway net.IP, ifName string, size int) bool {
+			ifNames = append(ifNames, ifName)
+
+			return size <= 1400
+		}
+
+		discoverPathMtu(net.ParseIP("10.1.0.1"), "aos-vlan", 1500)
+		Expect(ifNames).NotTo(BeEmpty())
+		Expect(ifNames).To(HaveEach("aos-vlan"))
+	})
+
+	It("builds valid ICMP echo request", func() {
+		msg := icmpEcho(1, 2, 64)
+		Expect(msg).To(HaveLen(64))
+		Expect(icmpChecksum(msg)).To(BeZero())
+	})
+})
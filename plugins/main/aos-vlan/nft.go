@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// nftTable is the bridge family nftables table holding the aos-vlan chains.
+const nftTable = "aos-vlan"
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// egressSrcMacChain returns the name of the chain rewriting the source MAC of the VLAN.
+func egressSrcMacChain(ifName string) string {
+	return "egress-src-mac-" + ifName
+}
+
+// setEgressSrcMac rewrites the source MAC of frames the bridge sends out through the VLAN port. Each VLAN has its own
+// postrouting chain, so the rule is removed by deleting the chain without looking up rule handles.
+func setEgressSrcMac(ifName, mac string) error {
+	chain := egressSrcMacChain(ifName)
+
+	if err := runTool("nft", fmt.Sprintf("add table bridge %s; "+
+		"add chain bridge %s %s { type filter hook postrouting priority 0 ; }; "+
+		"flush chain bridge %s %s; "+
+		"add rule bridge %s %s oifname \"%s\" ether saddr set %s",
+		nftTable, nftTable, chain, nftTable, chain, nftTable, chain, ifName, mac)); err != nil {
+		return fmt.Errorf("failed to set egress source MAC of %q: %v", ifName, err)
+	}
+
+	return nil
+}
+
+// removeEgressSrcMac deletes the source MAC rewrite chain of the VLAN, if any.
+func removeEgressSrcMac(conf *pluginConf) error {
+	if err := removeNftChain(egressSrcMacChain(conf.IfName)); err != nil {
+		return fmt.Errorf("failed to remove egress source MAC of %q: %v", conf.IfName, err)
+	}
+
+	return nil
+}
+
+// removeNftChain deletes the chain of the aos-vlan table, if any.
+func removeNftChain(chain string) error {
+	// Listing fails if the chain or the table doesn't exist
+	if err := runTool("nft", "list", "chain", "bridge", nftTable, chain); err != nil {
+		return nil
+	}
+
+	return runTool("nft", fmt.Sprintf("flush chain bridge %s %s; delete chain bridge %s %s",
+		nftTable, chain, nftTable, chain))
+}
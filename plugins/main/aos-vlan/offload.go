@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Names of the netdev features for VLAN tag offload as reported by ethtool.
+const (
+	featureTxVlanInsert = "tx-vlan-hw-insert"
+	featureRxVlanParse  = "rx-vlan-hw-parse"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// vlanOffload is the VLAN tag offload state of the parent link.
+type vlanOffload struct {
+	Parent   string `json:"parent"`
+	TxInsert bool   `json:"txInsert"`
+	RxParse  bool   `json:"rxParse"`
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// reportVlanOffload logs the VLAN tag offload state of the VLAN parent and returns it for the event. It is diagnostic
+// only, so nil is returned if the state can't be queried.
+func reportVlanOffload(vlan *netlink.Vlan) *vlanOffload {
+	parent, err := netlink.LinkByIndex(vlan.ParentIndex)
+	if err != nil {
+		logWarn("VLAN offload is not reported", "parentIndex", vlan.ParentIndex, "error", err)
+		return nil
+	}
+
+	offload, err := parentVlanOffload(parent)
+	if err != nil {
+		logWarn("VLAN offload is not reported", "parent", parent.Attrs().Name, "error", err)
+		return nil
+	}
+
+	logDebug("parent VLAN offload", "parent", offload.Parent, featureTxVlanInsert, offload.TxInsert,
+		featureRxVlanParse, offload.RxParse)
+
+	return offload
+}
+
+// parentVlanOffload queries the active VLAN tag offload features of the link via ethtool netlink.
+func parentVlanOffload(link netlink.Link) (*vlanOffload, error) {
+	features, err := activeFeatures(link)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get features of %q: %v", link.Attrs().Name, err)
+	}
+
+	return &vlanOffload{
+		Parent:   link.Attrs().Name,
+		TxInsert: features[featureTxVlanInsert],
+		RxParse:  features[featureRxVlanParse],
+	}, nil
+}
+
+// activeFeatures returns the active netdev features of the link by name.
+func activeFeatures(link netlink.Link) (map[string]bool, error) {
+	family, err := netlink.GenlFamilyGet(unix.ETHTOOL_GENL_NAME)
+	if err != nil {
+		return nil, fmt.Errorf("ethtool netlink is not available: %v", err)
+	}
+
+	req := nl.NewNetlinkRequest(int(family.ID), unix.NLM_F_ACK)
+	req.AddData(&nl.Genlmsg{Command: unix.ETHTOOL_MSG_FEATURES_GET, Version: unix.ETHTOOL_GENL_VERSION})
+
+	// Without the compact bitsets flag the features are reported with their names
+	header := nl.NewRtAttr(unix.ETHTOOL_A_FEATURES_HEADER|unix.NLA_F_NESTED, nil)
+	header.AddRtAttr(unix.ETHTOOL_A_HEADER_DEV_INDEX, nl.Uint32Attr(uint32(link.Attrs().Index)))
+	req.AddData(header)
+
+	msgs, err := req.Execute(unix.NETLINK_GENERIC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	features := make(map[string]bool)
+
+	for _, msg := range msgs {
+		attrs, err := nl.ParseRouteAttr(msg[nl.SizeofGenlmsg:])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, attr := range attrs {
+			if attr.Attr.Type&^unix.NLA_F_NESTED != unix.ETHTOOL_A_FEATURES_ACTIVE {
+				continue
+			}
+
+			if err := parseFeatureBitset(attr.Value, features); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return features, nil
+}
+
+// parseFeatureBitset parses a verbose ethtool bitset into the bits by name. A bitset without mask lists only the set
+// bits, otherwise the bits of the mask are listed with a value flag if set.
+func parseFeatureBitset(data []byte, features map[string]bool) error {
+	bitset, err := nl.ParseRouteAttr(data)
+	if err != nil {
+		return err
+	}
+
+	noMask := false
+
+	for _, attr := range bitset {
+		if attr.Attr.Type == unix.ETHTOOL_A_BITSET_NOMASK {
+			noMask = true
+		}
+	}
+
+	for _, bits := range bitset {
+		if bits.Attr.Type&^unix.NLA_F_NESTED != unix.ETHTOOL_A_BITSET_BITS {
+			continue
+		}
+
+		bitAttrs, err := nl.ParseRouteAttr(bits.Value)
+		if err != nil {
+			return err
+		}
+
+		for _, bit := range bitAttrs {
+			if bit.Attr.Type&^unix.NLA_F_NESTED != unix.ETHTOOL_A_BITSET_BITS_BIT {
+				continue
+			}
+
+			fields, err := nl.ParseRouteAttr(bit.Value)
+			if err != nil {
+				return err
+			}
+
+			name, value := "", noMask
+
+			for _, field := range fields {
+				switch field.Attr.Type {
+				case unix.ETHTOOL_A_BITSET_BIT_NAME:
+					name = string(bytesBeforeNul(field.Value))
+
+				case unix.ETHTOOL_A_BITSET_BIT_VALUE:
+					value = true
+				}
+			}
+
+			if name != "" {
+				features[name] = value
+			}
+		}
+	}
+
+	return nil
+}
+
+func bytesBeforeNul(data []byte) []byte {
+	for i, b := range data {
+		if b == 0 {
+			return data[:i]
+		}
+	}
+
+	return data
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan offload", func() {
+	It("aos-vlan reports parent VLAN offload", func() {
+		testNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(testNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		}()
+
+		err = testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			if _, err := netlink.GenlFamilyGet(unix.ETHTOOL_GENL_NAME); err != nil {
+				Skip("ethtool netlink is not available: " + err.Error())
+			}
+
+			// veth supports VLAN tag offload and enables it by default
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}, PeerName: "eth1"})
+			Expect(err).NotTo(HaveOccurred())
+
+			link, err := netlink.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+
+			offload, err := parentVlanOffload(link)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(offload).To(Equal(&vlanOffload{Parent: "eth0", TxInsert: true, RxParse: true}))
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("aos-vlan parses ethtool feature bitsets", func() {
+		bits := nl.NewRtAttr(unix.ETHTOOL_A_BITSET_BITS|unix.NLA_F_NESTED, nil)
+
+		for name, value := range map[string]bool{featureTxVlanInsert: true, featureRxVlanParse: false} {
+			bit := bits.AddRtAttr(unix.ETHTOOL_A_BITSET_BITS_BIT|unix.NLA_F_NESTED, nil)
+			bit.AddRtAttr(unix.ETHTOOL_A_BITSET_BIT_NAME, nl.ZeroTerminated(name))
+
+			if value {
+				bit.AddRtAttr(unix.ETHTOOL_A_BITSET_BIT_VALUE, []byte{})
+			}
+		}
+
+		features := make(map[string]bool)
+
+		Expect(parseFeatureBitset(bits.Serialize(), features)).To(Succeed())
+		Expect(features).To(Equal(map[string]bool{featureTxVlanInsert: true, featureRxVlanParse: false}))
+
+		// Bitset without mask lists only the set bits
+		noMask := nl.NewRtAttr(unix.ETHTOOL_A_BITSET_NOMASK, []byte{})
+		features = make(map[string]bool)
+
+		Expect(parseFeatureBitset(append(noMask.Serialize(), bits.Serialize()...), features)).To(Succeed())
+		Expect(features).To(Equal(map[string]bool{featureTxVlanInsert: true, featureRxVlanParse: true}))
+	})
+})
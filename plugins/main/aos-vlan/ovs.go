@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+
+	"github.com/vishvananda/netlink"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Master bridge types.
+const (
+	bridgeTypeLinux = "linux"
+	bridgeTypeOvs   = "ovs"
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// ovsVsctl is the Open vSwitch configuration tool, variable for testing.
+var ovsVsctl = "ovs-vsctl"
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// addVlanToOvsBridge adds the VLAN as a port of the Open vSwitch master bridge tagged with the VLAN ID. An existing
+// port is kept.
+func addVlanToOvsBridge(conf *pluginConf, vlan netlink.Link) error {
+	args := []string{"--may-exist", "add-port", conf.Master, vlan.Attrs().Name}
+
+	if conf.VlanId != 0 {
+		args = append(args, "tag="+strconv.Itoa(conf.VlanId))
+	}
+
+	logDebug("adding vlan link to OVS bridge", "name", vlan.Attrs().Name, "bridge", conf.Master)
+
+	if err := runOvsVsctl(args...); err != nil {
+		return fmt.Errorf("failed to add %q to OVS bridge %s: %v", vlan.Attrs().Name, conf.Master, err)
+	}
+
+	return nil
+}
+
+// removeVlanFromOvsBridge removes the VLAN port from the Open vSwitch master bridge. The port record outlives the
+// deleted link, so it is removed even if the VLAN is already gone.
+func removeVlanFromOvsBridge(conf *pluginConf) error {
+	if err := runOvsVsctl("--if-exists", "del-port", conf.Master, conf.IfName); err != nil {
+		return fmt.Errorf("failed to remove %q from OVS bridge %s: %v", conf.IfName, conf.Master, err)
+	}
+
+	return nil
+}
+
+func runOvsVsctl(args ...string) error {
+	if _, err := exec.LookPath(ovsVsctl); err != nil {
+		return fmt.Errorf("bridge type %q requires %s: %v", bridgeTypeOvs, ovsVsctl, err)
+	}
+
+	return runTool(ovsVsctl, args...)
+}
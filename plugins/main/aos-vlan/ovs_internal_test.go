@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan OVS bridge", func() {
+	var (
+		tmpDir           string
+		originalOvsVsctl string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		tmpDir, err = os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
+		originalOvsVsctl = ovsVsctl
+		ovsVsctl = filepath.Join(tmpDir, "ovs-vsctl")
+
+		Expect(os.WriteFile(ovsVsctl, []byte("#!/bin/sh\necho \"$@\" >> "+filepath.Join(tmpDir, "calls")+"\n"),
+			0o755)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		ovsVsctl = originalOvsVsctl
+
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("aos-vlan adds and removes the OVS bridge port", func() {
+		conf := &pluginConf{Master: "ovsbr0", VlanId: 100, IfName: "aos-vlan", BridgeType: bridgeTypeOvs}
+
+		Expect(addVlanToBridge(conf, &netlink.Vlan{LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}})).To(Succeed())
+		Expect(removeVlanFromOvsBridge(conf)).To(Succeed())
+
+		calls, err := os.ReadFile(filepath.Join(tmpDir, "calls"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(strings.Split(strings.TrimSpace(string(calls)), "\n")).To(Equal([]string{
+			"--may-exist add-port ovsbr0 aos-vlan tag=100",
+			"--if-exists del-port ovsbr0 aos-vlan",
+		}))
+	})
+
+	It("aos-vlan reports missing ovs-vsctl", func() {
+		ovsVsctl = filepath.Join(tmpDir, "missing")
+
+		err := removeVlanFromOvsBridge(&pluginConf{Master: "ovsbr0", IfName: "aos-vlan"})
+		Expect(err).To(MatchError(ContainSubstring(`bridge type "ovs" requires ` + ovsVsctl)))
+	})
+
+	It("aos-vlan validates bridge type", func() {
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"bridgeType": "macvtap"}`))
+		Expect(err).To(MatchError(ContainSubstring(`invalid bridge type "macvtap"`)))
+
+		_, _, err = parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"bridgeType": "ovs", "bridgeVlan": {"pvid": 10}}`))
+		Expect(err).To(MatchError(ContainSubstring(`bridge type "ovs" can't be combined with`)))
+
+		_, _, err = parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"bridgeType": "ovs", "mssClamp": 1400}`))
+		Expect(err).To(MatchError(ContainSubstring(`bridge type "ovs" can't be combined with`)))
+
+		conf, _, err := parseConfig([]byte(`{"master": "ovsbr0", "vlanId": 100, "ifName": "aos-vlan",
+			"bridgeType": "ovs"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.BridgeType).To(Equal(bridgeTypeOvs))
+	})
+})
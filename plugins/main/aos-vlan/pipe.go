@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const resultPipePollInterval = 10 * time.Millisecond
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// resultPipeTimeout bounds waiting for the result pipe reader and writing to it, variable for testing.
+var resultPipeTimeout = time.Second
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// streamResult writes the ADD result to the result pipe. The pipe is an additional consumer, failing to write to it
+// is logged and doesn't fail the CNI command.
+func streamResult(conf *pluginConf, result types.Result) {
+	if err := writeResultPipe(conf, result); err != nil {
+		logWarn("failed to write result pipe", "error", err)
+	}
+}
+
+// writeResultPipe writes the result JSON in the configured CNI version to the result pipe. It never blocks longer than
+// resultPipeTimeout: the pipe is opened non-blocking and the open is retried until a reader appears.
+func writeResultPipe(conf *pluginConf, result types.Result) error {
+	if conf.ResultPipe == "" {
+		return nil
+	}
+
+	versioned, err := getAsConfVersion(conf, result)
+	if err != nil {
+		return fmt.Errorf("failed to convert result for pipe %s: %v", conf.ResultPipe, err)
+	}
+
+	data, err := json.Marshal(versioned)
+	if err != nil {
+		return fmt.Errorf("failed to marshal result for pipe %s: %v", conf.ResultPipe, err)
+	}
+
+	info, err := os.Stat(conf.ResultPipe)
+	if err != nil {
+		return fmt.Errorf("failed to stat result pipe %s: %v", conf.ResultPipe, err)
+	}
+
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		return fmt.Errorf("result pipe %s is not a named pipe", conf.ResultPipe)
+	}
+
+	deadline := time.Now().Add(resultPipeTimeout)
+
+	pipe, err := openResultPipe(conf.ResultPipe, deadline)
+	if err != nil {
+		return err
+	}
+	defer pipe.Close()
+
+	if err := pipe.SetWriteDeadline(deadline); err != nil {
+		return fmt.Errorf("failed to set result pipe %s deadline: %v", conf.ResultPipe, err)
+	}
+
+	if _, err := pipe.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write result pipe %s: %v", conf.ResultPipe, err)
+	}
+
+	return nil
+}
+
+// openResultPipe opens the pipe for writing. Opening a pipe without a reader non-blocking fails with ENXIO, so the open
+// is retried until the deadline.
+func openResultPipe(path string, deadline time.Time) (*os.File, error) {
+	for {
+		pipe, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		if err == nil {
+			return pipe, nil
+		}
+
+		if !errors.Is(err, syscall.ENXIO) {
+			return nil, fmt.Errorf("failed to open result pipe %s: %v", path, err)
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("result pipe %s has no reader after %s", path, resultPipeTimeout)
+		}
+
+		time.Sleep(resultPipePollInterval)
+	}
+}
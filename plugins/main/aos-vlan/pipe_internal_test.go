@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan result pipe", func() {
+	var (
+		tmpDir   string
+		pipePath string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		tmpDir, err = os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
+		pipePath = filepath.Join(tmpDir, "result")
+		Expect(syscall.Mkfifo(pipePath, 0o600)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("aos-vlan writes result to pipe", func() {
+		received := make(chan string, 1)
+
+		go func() {
+			defer GinkgoRecover()
+
+			pipe, err := os.Open(pipePath)
+			Expect(err).NotTo(HaveOccurred())
+			defer pipe.Close()
+
+			line, err := bufio.NewReader(pipe).ReadString('\n')
+			Expect(err).NotTo(HaveOccurred())
+
+			received <- line
+		}()
+
+		conf := &pluginConf{ResultPipe: pipePath}
+		conf.CNIVersion = "1.0.0"
+
+		result := &current.Result{
+			CNIVersion: "1.0.0",
+			Interfaces: []*current.Interface{{Name: "aos-vlan", Mac: "02:00:00:00:00:01"}},
+		}
+
+		Expect(writeResultPipe(conf, result)).To(Succeed())
+
+		var line string
+		Eventually(received, 2*time.Second).Should(Receive(&line))
+
+		pipeResult := &current.Result{}
+		Expect(json.Unmarshal([]byte(line), pipeResult)).To(Succeed())
+		Expect(pipeResult).To(Equal(result))
+	})
+
+	It("aos-vlan doesn't hang on result pipe without reader", func() {
+		originalTimeout := resultPipeTimeout
+		resultPipeTimeout = 100 * time.Millisecond
+
+		defer func() { resultPipeTimeout = originalTimeout }()
+
+		conf := &pluginConf{ResultPipe: pipePath}
+		conf.CNIVersion = "1.0.0"
+
+		start := time.Now()
+
+		err := writeResultPipe(conf, &current.Result{CNIVersion: "1.0.0"})
+		Expect(err).To(MatchError(ContainSubstring("has no reader")))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+
+	It("aos-vlan rejects result pipe which is not a named pipe", func() {
+		path := filepath.Join(tmpDir, "file")
+		Expect(os.WriteFile(path, nil, 0o600)).To(Succeed())
+
+		conf := &pluginConf{ResultPipe: path}
+		conf.CNIVersion = "1.0.0"
+
+		err := writeResultPipe(conf, &current.Result{CNIVersion: "1.0.0"})
+		Expect(err).To(MatchError(ContainSubstring("is not a named pipe")))
+	})
+})
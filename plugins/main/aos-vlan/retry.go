@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	defaultNetlinkRetries       = 3
+	defaultNetlinkRetryInterval = 50 * time.Millisecond
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// retrySleep waits between netlink retries, variable for testing.
+var retrySleep = time.Sleep
+
+// transientNetlinkErrors are the errors of netlink operations which may succeed when retried. Any other error, e.g.
+// EEXIST or EINVAL, is permanent.
+var transientNetlinkErrors = []syscall.Errno{syscall.EBUSY, syscall.EAGAIN, syscall.EINTR, syscall.ENOBUFS}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// netlinkRetry runs the mutating netlink operation retrying it up to netlinkRetries times on transient errors. The
+// interval between retries starts at netlinkRetryInterval and doubles after each retry.
+func netlinkRetry(conf *pluginConf, op string, call func() error) error {
+	retries, interval := defaultNetlinkRetries, defaultNetlinkRetryInterval
+
+	if conf.NetlinkRetries != nil {
+		retries = *conf.NetlinkRetries
+	}
+
+	if conf.NetlinkRetryInterval.Duration != 0 {
+		interval = conf.NetlinkRetryInterval.Duration
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := call()
+		if err == nil || attempt >= retries || !isTransientNetlinkError(err) {
+			return err
+		}
+
+		logDebug("retrying netlink operation", "op", op, "attempt", attempt+1, "interval", interval.String(),
+			"error", err)
+
+		retrySleep(interval)
+
+		interval *= 2
+	}
+}
+
+func isTransientNetlinkError(err error) bool {
+	for _, errno := range transientNetlinkErrors {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+
+	return false
+}
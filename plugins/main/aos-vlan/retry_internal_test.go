@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+
+	vlanlib "github.com/aoscloud/aos_cni_vlan/pkg/vlan"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan netlink retry", func() {
+	var sleeps []time.Duration
+
+	BeforeEach(func() {
+		sleeps = nil
+		retrySleep = func(interval time.Duration) { sleeps = append(sleeps, interval) }
+	})
+
+	AfterEach(func() {
+		retrySleep = time.Sleep
+	})
+
+	// failing returns the operation failing with the errors in order and succeeding afterwards.
+	failing := func(calls *int, errs ...error) func() error {
+		return func() error {
+			*calls++
+
+			if *calls <= len(errs) {
+				return errs[*calls-1]
+			}
+
+			return nil
+		}
+	}
+
+	It("aos-vlan retries transient netlink errors with backoff", func() {
+		calls := 0
+
+		Expect(netlinkRetry(&pluginConf{}, "create", failing(&calls, syscall.EBUSY,
+			fmt.Errorf("failed to create vlan: %w", syscall.EAGAIN)))).To(Succeed())
+		Expect(calls).To(Equal(3))
+		Expect(sleeps).To(Equal([]time.Duration{defaultNetlinkRetryInterval, 2 * defaultNetlinkRetryInterval}))
+	})
+
+	It("aos-vlan doesn't retry permanent netlink errors", func() {
+		for _, permanent := range []error{syscall.EEXIST, syscall.EINVAL, errors.New("not attached")} {
+			calls := 0
+
+			Expect(netlinkRetry(&pluginConf{}, "create", failing(&calls, permanent))).To(MatchError(permanent))
+			Expect(calls).To(Equal(1))
+		}
+
+		Expect(sleeps).To(BeEmpty())
+	})
+
+	It("aos-vlan gives up after netlink retries", func() {
+		retries, calls := 1, 0
+		conf := &pluginConf{NetlinkRetries: &retries, NetlinkRetryInterval: duration{Duration: time.Second}}
+
+		err := netlinkRetry(conf, "attach", failing(&calls, syscall.EBUSY, syscall.EBUSY, syscall.EBUSY))
+		Expect(err).To(MatchError(syscall.EBUSY))
+		Expect(calls).To(Equal(2))
+		Expect(sleeps).To(Equal([]time.Duration{time.Second}))
+
+		retries, calls = 0, 0
+
+		Expect(netlinkRetry(conf, "attach", failing(&calls, syscall.EBUSY))).To(MatchError(syscall.EBUSY))
+		Expect(calls).To(Equal(1))
+	})
+
+	It("aos-vlan retries busy bridge attachment", func() {
+		testNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(testNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		}()
+
+		err = testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0"}}
+			Expect(netlink.LinkAdd(br)).To(Succeed())
+			Expect(netlink.LinkSetUp(br)).To(Succeed())
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}, PeerName: "aos-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			link, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			calls := 0
+
+			attachToBridge = func(link, bridge netlink.Link) (netlink.Link, error) {
+				if calls++; calls == 1 {
+					return nil, fmt.Errorf("failed to connect %q to bridge: %w", link.Attrs().Name, syscall.EBUSY)
+				}
+
+				return vlanlib.AttachToBridge(link, bridge)
+			}
+			defer func() { attachToBridge = vlanlib.AttachToBridge }()
+
+			Expect(addVlanToBridge(&pluginConf{Master: "br0"}, link)).To(Succeed())
+			Expect(calls).To(Equal(2))
+
+			link, err = netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link.Attrs().MasterIndex).To(Equal(br.Attrs().Index))
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("aos-vlan validates netlink retries", func() {
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "netlinkRetries": -1}`))
+		Expect(err).To(MatchError("invalid netlink retries -1 (must not be negative)"))
+	})
+})
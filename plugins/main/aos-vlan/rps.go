@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// cpuMaskGroupDigits is the number of hex digits of the comma separated 32-bit groups of a sysfs CPU mask.
+const cpuMaskGroupDigits = 8
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// sysClassNetPath is the sysfs network devices directory, variable for testing.
+var sysClassNetPath = "/sys/class/net"
+
+// hostCpuCount returns the number of the host CPUs, variable for testing.
+var hostCpuCount = runtime.NumCPU
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// parseRpsCpus parses the RPS CPUs given as a "0x" prefixed hex mask, e.g. "0xf", or a CPU list, e.g. "0-3,6", and
+// returns the sysfs CPU mask. All CPUs must exist on the host.
+func parseRpsCpus(s string) (string, error) {
+	mask := new(big.Int)
+
+	if hexMask := strings.TrimPrefix(strings.ToLower(s), "0x"); hexMask != strings.ToLower(s) {
+		if _, ok := mask.SetString(strings.ReplaceAll(hexMask, ",", ""), 16); !ok || mask.Sign() < 0 {
+			return "", fmt.Errorf("invalid RPS CPU mask %q", s)
+		}
+	} else if err := parseCpuList(s, mask); err != nil {
+		return "", fmt.Errorf("invalid RPS CPU list %q: %v", s, err)
+	}
+
+	if cpus := hostCpuCount(); mask.BitLen() > cpus {
+		return "", fmt.Errorf("invalid RPS CPUs %q: CPU %d exceeds host CPU count %d", s, mask.BitLen()-1, cpus)
+	}
+
+	return formatCpuMask(mask), nil
+}
+
+// parseCpuList sets the mask bits of the comma separated CPUs and CPU ranges.
+func parseCpuList(s string, mask *big.Int) error {
+	for _, item := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(item), "-")
+
+		from, err := strconv.ParseUint(first, 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid CPU %q", first)
+		}
+
+		to := from
+
+		if isRange {
+			if to, err = strconv.ParseUint(last, 10, 16); err != nil || to < from {
+				return fmt.Errorf("invalid CPU range %q", item)
+			}
+		}
+
+		for cpu := from; cpu <= to; cpu++ {
+			mask.SetBit(mask, int(cpu), 1)
+		}
+	}
+
+	return nil
+}
+
+// formatCpuMask formats the mask as the hex digits in comma separated 32-bit groups sysfs expects.
+func formatCpuMask(mask *big.Int) string {
+	digits := mask.Text(16)
+
+	var groups []string
+
+	for len(digits) > cpuMaskGroupDigits {
+		groups = append([]string{digits[len(digits)-cpuMaskGroupDigits:]}, groups...)
+		digits = digits[:len(digits)-cpuMaskGroupDigits]
+	}
+
+	return strings.Join(append([]string{digits}, groups...), ",")
+}
+
+// setRpsCpus writes the CPU mask to the rps_cpus of each receive queue of the link.
+func setRpsCpus(link netlink.Link, mask string) error {
+	ifName := link.Attrs().Name
+
+	devicePath, err := linkSysfsPath(link)
+	if err != nil {
+		return err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(devicePath, "queues", "rx-*", "rps_cpus"))
+	if err != nil {
+		return fmt.Errorf("failed to list receive queues of %q: %v", ifName, err)
+	}
+
+	if len(paths) == 0 {
+		return fmt.Errorf("no receive queues of %q found", ifName)
+	}
+
+	for _, path := range paths {
+		if err := os.WriteFile(path, []byte(mask), 0o644); err != nil {
+			return fmt.Errorf("failed to set RPS CPUs of %q: %v", ifName, err)
+		}
+	}
+
+	return nil
+}
+
+// linkSysfsPath returns the sysfs directory of the link. sysfs shows the devices of the network namespace it was
+// mounted in, which differs from the current one when the plugin runs in another mount namespace, e.g. in the
+// container network namespace. The device index and address are compared to make sure the directory belongs to the
+// link and not to a namesake of another namespace.
+func linkSysfsPath(link netlink.Link) (string, error) {
+	ifName := link.Attrs().Name
+
+	// Re-fetch link as the address might have been changed since it was read
+	link, err := netlink.LinkByIndex(link.Attrs().Index)
+	if err != nil {
+		return "", fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+
+	devicePath := filepath.Join(sysClassNetPath, ifName)
+
+	data, err := os.ReadFile(filepath.Join(devicePath, "ifindex"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("link %q not found in %s, sysfs belongs to another network namespace",
+				ifName, sysClassNetPath)
+		}
+
+		return "", fmt.Errorf("failed to read sysfs index of %q: %v", ifName, err)
+	}
+
+	index, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return "", fmt.Errorf("invalid sysfs index of %q: %v", ifName, err)
+	}
+
+	address := ""
+
+	if data, err = os.ReadFile(filepath.Join(devicePath, "address")); err == nil {
+		address = strings.TrimSpace(string(data))
+	}
+
+	if index != link.Attrs().Index || address != link.Attrs().HardwareAddr.String() {
+		return "", fmt.Errorf("link %q in %s has index %d and address %q instead of %d and %q, "+
+			"sysfs belongs to another network namespace", ifName, sysClassNetPath, index, address,
+			link.Attrs().Index, link.Attrs().HardwareAddr.String())
+	}
+
+	return devicePath, nil
+}
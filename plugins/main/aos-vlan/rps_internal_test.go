@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan RPS", func() {
+	var originalCpuCount func() int
+
+	BeforeEach(func() {
+		originalCpuCount = hostCpuCount
+		hostCpuCount = func() int { return 40 }
+	})
+
+	AfterEach(func() {
+		hostCpuCount = originalCpuCount
+	})
+
+	It("parses CPU masks and lists", func() {
+		for cpus, expected := range map[string]string{
+			"0xf":                   "f",
+			"0XFF":                  "ff",
+			"0x80,00000001":         "80,00000001",
+			"0":                     "1",
+			"0-3,6":                 "4f",
+			"1, 39":                 "80,00000002",
+			"32-35":                 "f,00000000",
+			"0x0":                   "0",
+			"0x00000000,0000000001": "1",
+		} {
+			mask, err := parseRpsCpus(cpus)
+			Expect(err).NotTo(HaveOccurred(), cpus)
+			Expect(mask).To(Equal(expected), cpus)
+		}
+	})
+
+	It("rejects invalid CPUs", func() {
+		for _, cpus := range []string{"0x", "0xg", "0x-1", "a", "3-1", "1-", "-1", "40", "0x10000000000"} {
+			_, err := parseRpsCpus(cpus)
+			Expect(err).To(HaveOccurred(), cpus)
+		}
+
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "shared": true,
+			"rpsCpus": "0-1"}`))
+		Expect(err).To(MatchError(`"rpsCpus" is not supported in "shared" mode`))
+	})
+
+	It("writes mask to all receive queues", func() {
+		err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-rps0"}, PeerName: "aos-rps1"})
+		if err != nil {
+			Skip("veth is not supported: " + err.Error())
+		}
+
+		defer func() {
+			link, err := netlink.LinkByName("aos-rps0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkDel(link)).To(Succeed())
+		}()
+
+		link, err := netlink.LinkByName("aos-rps0")
+		Expect(err).NotTo(HaveOccurred())
+
+		tmpDir, err := os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
+		defer os.RemoveAll(tmpDir)
+
+		originalPath := sysClassNetPath
+		sysClassNetPath = tmpDir
+
+		defer func() { sysClassNetPath = originalPath }()
+
+		devicePath := filepath.Join(tmpDir, "aos-rps0")
+
+		for _, queue := range []string{"rx-0", "rx-1", "tx-0"} {
+			Expect(os.MkdirAll(filepath.Join(devicePath, "queues", queue), 0o755)).To(Succeed())
+		}
+
+		for _, queue := range []string{"rx-0", "rx-1"} {
+			Expect(os.WriteFile(filepath.Join(devicePath, "queues", queue, "rps_cpus"), []byte("0"),
+				0o644)).To(Succeed())
+		}
+
+		Expect(os.WriteFile(filepath.Join(devicePath, "ifindex"), []byte(fmt.Sprintf("%d\n", link.Attrs().Index)),
+			0o644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(devicePath, "address"), []byte(link.Attrs().HardwareAddr.String()+"\n"),
+			0o644)).To(Succeed())
+
+		Expect(setRpsCpus(link, "f")).To(Succeed())
+
+		for _, queue := range []string{"rx-0", "rx-1"} {
+			Expect(os.ReadFile(filepath.Join(devicePath, "queues", queue, "rps_cpus"))).To(BeEquivalentTo("f"))
+		}
+
+		Expect(filepath.Join(devicePath, "queues", "tx-0", "rps_cpus")).NotTo(BeAnExistingFile())
+
+		// Namesake of another network namespace
+		Expect(os.WriteFile(filepath.Join(devicePath, "ifindex"), []byte(fmt.Sprintf("%d\n", link.Attrs().Index+1)),
+			0o644)).To(Succeed())
+		Expect(setRpsCpus(link, "f")).To(MatchError(ContainSubstring("sysfs belongs to another network namespace")))
+	})
+
+	It("rejects sysfs of another network namespace", func() {
+		testNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(testNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		}()
+
+		// sysfs of the test mount namespace shows the devices of the original network namespace
+		err = testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-rps-ns0"}, PeerName: "aos-rps-ns1"})
+			if err != nil {
+				Skip("veth is not supported: " + err.Error())
+			}
+
+			link, err := netlink.LinkByName("aos-rps-ns0")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(setRpsCpus(link, "1")).To(MatchError(ContainSubstring(
+				"sysfs belongs to another network namespace")))
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("sets RPS CPUs of real link", func() {
+		err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-rps0"}, PeerName: "aos-rps1"})
+		if err != nil {
+			Skip("veth is not supported: " + err.Error())
+		}
+
+		defer func() {
+			link, err := netlink.LinkByName("aos-rps0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.LinkDel(link)).To(Succeed())
+		}()
+
+		path := filepath.Join(sysClassNetPath, "aos-rps0", "queues", "rx-0", "rps_cpus")
+		if _, err := os.Stat(path); err != nil {
+			Skip("RPS is not supported: " + err.Error())
+		}
+
+		link, err := netlink.LinkByName("aos-rps0")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(setRpsCpus(link, "1")).To(Succeed())
+
+		data, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(strings.TrimLeft(strings.TrimSpace(string(data)), "0,")).To(Equal("1"))
+	})
+})
@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// systemStateStopping is the systemd system state while the host is shutting down.
+const systemStateStopping = "stopping"
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// shutdownSentinel is a file created by the host shutdown sequence on systems without systemd, variable for testing.
+var shutdownSentinel = "/run/aos-vlan/shutdown"
+
+// systemState returns the systemd system state or an empty string if it's unknown, variable for testing.
+var systemState = func() string {
+	path, err := exec.LookPath("systemctl")
+	if err != nil {
+		return ""
+	}
+
+	// is-system-running exits non-zero for any state but "running", the state is printed anyway
+	output, _ := exec.Command(path, "is-system-running").Output()
+
+	return strings.TrimSpace(string(output))
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// checkHostShutdown fails with the "try again later" CNI error if the host is shutting down, so the runtime doesn't
+// wait for VLANs which are about to be removed anyway.
+func checkHostShutdown() error {
+	reason := ""
+
+	if _, err := os.Stat(shutdownSentinel); err == nil {
+		reason = fmt.Sprintf("shutdown sentinel %s exists", shutdownSentinel)
+	} else if state := systemState(); state == systemStateStopping {
+		reason = fmt.Sprintf("system state is %q", state)
+	}
+
+	if reason == "" {
+		return nil
+	}
+
+	return types.NewError(types.ErrTryAgainLater, "host is shutting down", reason)
+}
@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan shutdown", func() {
+	var (
+		tmpDir              string
+		originalSentinel    string
+		originalSystemState func() string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		tmpDir, err = os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
+		originalSentinel, originalSystemState = shutdownSentinel, systemState
+		shutdownSentinel = filepath.Join(tmpDir, "shutdown")
+		systemState = func() string { return "running" }
+	})
+
+	AfterEach(func() {
+		shutdownSentinel, systemState = originalSentinel, originalSystemState
+
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	expectTryAgainLater := func(err error, details string) {
+		var cniErr *types.Error
+
+		Expect(errors.As(err, &cniErr)).To(BeTrue())
+		Expect(cniErr.Code).To(BeEquivalentTo(types.ErrTryAgainLater))
+		Expect(cniErr.Msg).To(Equal("host is shutting down"))
+		Expect(cniErr.Details).To(Equal(details))
+	}
+
+	It("allows ADD while the host is running", func() {
+		Expect(checkHostShutdown()).To(Succeed())
+	})
+
+	It("refuses ADD with shutdown sentinel", func() {
+		Expect(os.WriteFile(shutdownSentinel, nil, 0o600)).To(Succeed())
+
+		expectTryAgainLater(checkHostShutdown(), "shutdown sentinel "+shutdownSentinel+" exists")
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       "dummy",
+			IfName:      "aos-vlan",
+			StdinData: []byte(`{"name": "mynet", "cniVersion": "0.4.0", "type": "aos-vlan", "master": "br0",
+				"vlanId": 100, "ifName": "aos-vlan", "refuseDuringShutdown": true}`),
+		}
+
+		expectTryAgainLater(cmdAdd(args), "shutdown sentinel "+shutdownSentinel+" exists")
+	})
+
+	It("refuses ADD while systemd is stopping", func() {
+		systemState = func() string { return systemStateStopping }
+
+		expectTryAgainLater(checkHostShutdown(), `system state is "stopping"`)
+	})
+})
@@ -0,0 +1,342 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/vishvananda/netlink"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Ancillary resources the plugin installs on the VLAN, recorded in the state.
+const (
+	resourceNft       = "nft"
+	resourceTc        = "tc"
+	resourceBandwidth = "bandwidth"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// vlanState is the attachment of a VLAN to a container recorded on ADD, so cleanup doesn't depend on netlink scans.
+type vlanState struct {
+	ContainerID string `json:"containerID"`
+	IfName      string `json:"ifName"`
+	Master      string `json:"master"`
+	Parent      string `json:"parent"`
+	VlanId      int    `json:"vlanId"`
+	Created     bool   `json:"created"`
+	// ConfigIfName is the configured ifName if the VLAN got another name, e.g. fallbackIfName.
+	ConfigIfName string `json:"configIfName,omitempty"`
+	// IfIndex is the VLAN ifindex, which may change if the VLAN is recreated, e.g. on reboot.
+	IfIndex int `json:"ifIndex,omitempty"`
+	// AttachmentIfName is the CNI ifName of the container attachment, GC matches it with the valid attachments.
+	AttachmentIfName string `json:"attachmentIfName,omitempty"`
+	// ContainerIfName is the VLAN name in the container namespace in inContainer mode. It differs from the CNI ifName if
+	// the VLAN got another name with renameOnConflict.
+	ContainerIfName string `json:"containerIfName,omitempty"`
+	// Resources are the ancillary resources installed on the VLAN, so DEL removes them even if the configuration
+	// doesn't enable them anymore, e.g. when the link is kept.
+	Resources []string `json:"resources,omitempty"`
+}
+
+// ifIndexReport compares the VLAN ifindex with the one recorded in the state.
+type ifIndexReport struct {
+	Recorded int  `json:"recorded"`
+	Current  int  `json:"current"`
+	Changed  bool `json:"changed"`
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// containerStatePath returns the state file of the container. The file holds the container VLAN states keyed by the
+// configured interface name, so DEL replayed with the ADD config finds the state without netlink scans.
+func containerStatePath(stateDir, containerID string) (string, error) {
+	if containerID == "" || containerID != filepath.Base(containerID) || containerID[0] == '.' {
+		return "", fmt.Errorf("invalid container ID %q", containerID)
+	}
+
+	return filepath.Join(stateDir, containerID+".json"), nil
+}
+
+// newVlanState returns the state of the VLAN added for the container.
+func newVlanState(conf *pluginConf, containerID string, vlan netlink.Link, created bool) (vlanState, error) {
+	parent, err := netlink.LinkByIndex(vlan.Attrs().ParentIndex)
+	if err != nil {
+		return vlanState{}, fmt.Errorf("failed to lookup parent link %d: %v", vlan.Attrs().ParentIndex, err)
+	}
+
+	return vlanState{
+		ContainerID: containerID,
+		IfName:      conf.IfName,
+		Master:      conf.Master,
+		Parent:      parent.Attrs().Name,
+		VlanId:      conf.VlanId,
+		Created:     created,
+		IfIndex:     vlan.Attrs().Index,
+		Resources:   vlanResources(conf),
+	}, nil
+}
+
+// vlanResources returns the ancillary resources the configuration installs on the VLAN.
+func vlanResources(conf *pluginConf) (resources []string) {
+	if conf.EgressSrcMac != "" || conf.MssClamp != nil {
+		resources = append(resources, resourceNft)
+	}
+
+	if conf.BpfProgram != "" || len(conf.DscpToPcp) != 0 || conf.MirrorTo != "" || conf.IPv6TrafficClass != nil {
+		resources = append(resources, resourceTc)
+	}
+
+	if conf.Bandwidth != nil {
+		resources = append(resources, resourceBandwidth)
+	}
+
+	return resources
+}
+
+// recordedVlanResources returns the ancillary resources recorded in the container state keyed by the VLAN name. The
+// configuration still drives the cleanup if the state is unreadable, so the error is ignored.
+func recordedVlanResources(conf *pluginConf, containerID string) map[string]map[string]bool {
+	if conf.StateDir == "" {
+		return nil
+	}
+
+	states, err := loadContainerState(conf.StateDir, containerID)
+	if err != nil {
+		return nil
+	}
+
+	recorded := make(map[string]map[string]bool)
+
+	for _, state := range states {
+		for _, resource := range state.Resources {
+			if recorded[state.IfName] == nil {
+				recorded[state.IfName] = make(map[string]bool)
+			}
+
+			recorded[state.IfName][resource] = true
+		}
+	}
+
+	return recorded
+}
+
+// reportStateIfIndex compares the VLAN ifindex with the one recorded for the configured interface name. Returns nil if
+// no ifindex is recorded. The report is diagnostic only, so an unreadable state is not an error.
+func reportStateIfIndex(stateDir, containerID, configIfName string, vlan netlink.Link) *ifIndexReport {
+	states, err := loadContainerState(stateDir, containerID)
+	if err != nil {
+		return nil
+	}
+
+	state, ok := states[configIfName]
+	if !ok || state.IfIndex == 0 {
+		return nil
+	}
+
+	report := &ifIndexReport{
+		Recorded: state.IfIndex,
+		Current:  vlan.Attrs().Index,
+		Changed:  state.IfIndex != vlan.Attrs().Index,
+	}
+
+	if report.Changed {
+		logWarn("vlan link ifindex changed", "name", vlan.Attrs().Name, "recorded", report.Recorded,
+			"current", report.Current)
+	}
+
+	return report
+}
+
+func saveVlanState(stateDir string, state vlanState) error {
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create state dir: %v", err)
+	}
+
+	path, err := containerStatePath(stateDir, state.ContainerID)
+	if err != nil {
+		return err
+	}
+
+	return updateJSONFile(path, func(states map[string]vlanState) {
+		states[state.key()] = state
+	})
+}
+
+func removeVlanState(stateDir, containerID, ifName string) error {
+	path, err := containerStatePath(stateDir, containerID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	return updateJSONFile(path, func(states map[string]vlanState) {
+		for key, state := range states {
+			if key == ifName || state.IfName == ifName {
+				delete(states, key)
+			}
+		}
+	})
+}
+
+// loadContainerState returns the container VLAN states keyed by the configured interface name.
+func loadContainerState(stateDir, containerID string) (map[string]vlanState, error) {
+	path, err := containerStatePath(stateDir, containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]vlanState)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return states, nil
+		}
+
+		return nil, fmt.Errorf("failed to read state file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %v", path, err)
+	}
+
+	return states, nil
+}
+
+// applyStateIfName switches the VLAN name to the one recorded on ADD for the configured name and reports whether the
+// state was found.
+func applyStateIfName(conf *pluginConf, containerID string) bool {
+	if conf.StateDir == "" {
+		return false
+	}
+
+	states, err := loadContainerState(conf.StateDir, containerID)
+	if err != nil {
+		return false
+	}
+
+	state, ok := states[conf.IfName]
+	if !ok {
+		return false
+	}
+
+	conf.IfName = state.IfName
+
+	return true
+}
+
+// isRecordedVlan reports whether the VLAN matches the name and VLAN ID of a VLAN recorded in the container state.
+func isRecordedVlan(conf *pluginConf, containerID string, vlan *netlink.Vlan) bool {
+	if conf.StateDir == "" {
+		return false
+	}
+
+	states, err := loadContainerState(conf.StateDir, containerID)
+	if err != nil {
+		return false
+	}
+
+	for _, state := range states {
+		if (state.IfName == vlan.Name || state.ContainerIfName == vlan.Name) && state.VlanId == vlan.VlanId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stateContainerIfName returns the VLAN name in the container namespace recorded on ADD, ifName if none is recorded.
+func stateContainerIfName(conf *pluginConf, containerID, ifName string) string {
+	if conf.StateDir == "" {
+		return ifName
+	}
+
+	states, err := loadContainerState(conf.StateDir, containerID)
+	if err != nil {
+		return ifName
+	}
+
+	for key, state := range states {
+		if (key == conf.IfName || state.IfName == conf.IfName) && state.ContainerIfName != "" {
+			return state.ContainerIfName
+		}
+	}
+
+	return ifName
+}
+
+func (state vlanState) key() string {
+	if state.ConfigIfName != "" {
+		return state.ConfigIfName
+	}
+
+	return state.IfName
+}
+
+// containerVlanConfs returns the configurations of the multi-VLAN configuration VLANs and of the VLANs recorded in the
+// container state, so DEL removes all VLANs of the container even if the configuration lists only some of them.
+func containerVlanConfs(conf *pluginConf, containerID string) ([]*pluginConf, error) {
+	confs := vlanConfs(conf)
+
+	if conf.StateDir == "" || len(conf.Vlans) == 0 {
+		return confs, nil
+	}
+
+	states, err := loadContainerState(conf.StateDir, containerID)
+	if err != nil {
+		return confs, err
+	}
+
+	listed := make(map[string]bool)
+
+	for _, entryConf := range confs {
+		listed[entryConf.IfName] = true
+	}
+
+	keys := make([]string, 0, len(states))
+
+	for key := range states {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if state := states[key]; !listed[state.IfName] {
+			confs = append(confs, vlanConf(conf, vlanEntry{VlanId: state.VlanId, IfName: state.IfName}))
+		}
+	}
+
+	return confs, nil
+}
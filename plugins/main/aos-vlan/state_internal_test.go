@@ -0,0 +1,178 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan state", func() {
+	var stateDir string
+
+	BeforeEach(func() {
+		tmpDir, err := os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
+		stateDir = filepath.Join(tmpDir, "state")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(filepath.Dir(stateDir))).To(Succeed())
+	})
+
+	It("saves, loads and removes container state", func() {
+		state100 := vlanState{
+			ContainerID: "dummy", IfName: "vlan100", Master: "br0", Parent: "eth0", VlanId: 100, Created: true,
+		}
+		state200 := vlanState{
+			ContainerID: "dummy", IfName: "vlan200", Master: "br0", Parent: "eth0", VlanId: 200,
+		}
+
+		Expect(saveVlanState(stateDir, state100)).To(Succeed())
+		Expect(saveVlanState(stateDir, state200)).To(Succeed())
+
+		states, err := loadContainerState(stateDir, "dummy")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(states).To(Equal(map[string]vlanState{"vlan100": state100, "vlan200": state200}))
+
+		Expect(removeVlanState(stateDir, "dummy", "vlan100")).To(Succeed())
+
+		states, err = loadContainerState(stateDir, "dummy")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(states).To(Equal(map[string]vlanState{"vlan200": state200}))
+
+		Expect(removeVlanState(stateDir, "dummy", "vlan200")).To(Succeed())
+		Expect(filepath.Join(stateDir, "dummy.json")).NotTo(BeAnExistingFile())
+
+		// The lock file is removed with the state file
+		entries, err := os.ReadDir(stateDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+
+		// Removing missing state is not an error
+		Expect(removeVlanState(stateDir, "dummy", "vlan200")).To(Succeed())
+	})
+
+	It("rejects unsafe container IDs", func() {
+		for _, containerID := range []string{"", "../dummy", "a/b", ".."} {
+			Expect(saveVlanState(stateDir, vlanState{ContainerID: containerID, IfName: "vlan100"})).NotTo(Succeed())
+		}
+	})
+
+	It("keys state by configured interface name", func() {
+		state := vlanState{ContainerID: "dummy", IfName: "vlan-fb", VlanId: 100, ConfigIfName: "vlan100"}
+
+		Expect(saveVlanState(stateDir, state)).To(Succeed())
+
+		conf := &pluginConf{IfName: "vlan100", StateDir: stateDir}
+
+		Expect(applyStateIfName(conf, "dummy")).To(BeTrue())
+		Expect(conf.IfName).To(Equal("vlan-fb"))
+
+		Expect(applyStateIfName(&pluginConf{IfName: "vlan200", StateDir: stateDir}, "dummy")).To(BeFalse())
+		Expect(applyStateIfName(&pluginConf{IfName: "vlan100"}, "dummy")).To(BeFalse())
+
+		Expect(removeVlanState(stateDir, "dummy", "vlan-fb")).To(Succeed())
+
+		states, err := loadContainerState(stateDir, "dummy")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(states).To(BeEmpty())
+	})
+
+	It("merges configured and recorded VLANs", func() {
+		for _, state := range []vlanState{
+			{ContainerID: "dummy", IfName: "vlan200", VlanId: 200},
+			{ContainerID: "dummy", IfName: "vlan100", VlanId: 100},
+			{ContainerID: "dummy", IfName: "vlan300", VlanId: 300},
+		} {
+			Expect(saveVlanState(stateDir, state)).To(Succeed())
+		}
+
+		conf := &pluginConf{
+			Master: "br0", StateDir: stateDir, Vlans: []vlanEntry{{VlanId: 200, IfName: "vlan200"}},
+		}
+
+		confs, err := containerVlanConfs(conf, "dummy")
+		Expect(err).NotTo(HaveOccurred())
+
+		var vlans []vlanEntry
+
+		for _, entryConf := range confs {
+			Expect(entryConf.Master).To(Equal("br0"))
+
+			vlans = append(vlans, vlanEntry{VlanId: entryConf.VlanId, IfName: entryConf.IfName})
+		}
+
+		Expect(vlans).To(Equal([]vlanEntry{
+			{VlanId: 200, IfName: "vlan200"}, {VlanId: 100, IfName: "vlan100"}, {VlanId: 300, IfName: "vlan300"},
+		}))
+
+		confs, err = containerVlanConfs(conf, "other")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(confs).To(HaveLen(1))
+	})
+
+	It("reports ifindex change", func() {
+		vlan := &netlink.Vlan{LinkAttrs: netlink.LinkAttrs{Name: "vlan100", Index: 12}}
+
+		// Nothing is recorded before the first ADD
+		Expect(reportStateIfIndex(stateDir, "dummy", "vlan100", vlan)).To(BeNil())
+
+		Expect(saveVlanState(stateDir, vlanState{ContainerID: "dummy", IfName: "vlan100", IfIndex: 12})).To(Succeed())
+		Expect(reportStateIfIndex(stateDir, "dummy", "vlan100", vlan)).To(Equal(
+			&ifIndexReport{Recorded: 12, Current: 12}))
+
+		// The VLAN is recreated, e.g. on reboot
+		vlan.Index = 15
+
+		Expect(reportStateIfIndex(stateDir, "dummy", "vlan100", vlan)).To(Equal(
+			&ifIndexReport{Recorded: 12, Current: 15, Changed: true}))
+
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", "reportIfIndex": true}`))
+		Expect(err).To(MatchError(`"reportIfIndex" requires "stateDir" and is not supported in "inContainer" mode`))
+	})
+
+	It("returns recorded container interface name", func() {
+		conf := &pluginConf{IfName: "vlan100", StateDir: stateDir}
+
+		// The CNI ifName is used if nothing is recorded
+		Expect(stateContainerIfName(conf, "dummy", "eth0")).To(Equal("eth0"))
+
+		Expect(saveVlanState(stateDir, vlanState{
+			ContainerID: "dummy", IfName: "vlan100", VlanId: 100, ContainerIfName: "eth01",
+		})).To(Succeed())
+
+		Expect(stateContainerIfName(conf, "dummy", "eth0")).To(Equal("eth01"))
+		Expect(stateContainerIfName(conf, "other", "eth0")).To(Equal("eth0"))
+		Expect(stateContainerIfName(&pluginConf{IfName: "vlan100"}, "dummy", "eth0")).To(Equal("eth0"))
+
+		Expect(isRecordedVlan(conf, "dummy", &netlink.Vlan{
+			LinkAttrs: netlink.LinkAttrs{Name: "eth01"}, VlanId: 100,
+		})).To(BeTrue())
+	})
+})
@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// validateStaticAddresses checks the static addresses parse and each static route gateway is on one of their subnets.
+func validateStaticAddresses(conf *pluginConf) error {
+	if len(conf.IPAddresses) == 0 && len(conf.Routes) == 0 {
+		return nil
+	}
+
+	if conf.IPAM.Type != "" || conf.IPAMV4 != nil || conf.IPAMV6 != nil || len(conf.Vlans) != 0 {
+		return fmt.Errorf("\"ipAddresses\" and \"routes\" can't be combined with \"ipam\" and \"vlans\"")
+	}
+
+	subnets := make([]*net.IPNet, 0, len(conf.IPAddresses))
+
+	for _, address := range conf.IPAddresses {
+		_, subnet, err := net.ParseCIDR(address)
+		if err != nil {
+			return fmt.Errorf("invalid IP address %q: %v", address, err)
+		}
+
+		subnets = append(subnets, subnet)
+	}
+
+	for _, route := range conf.Routes {
+		if route.Dst.IP == nil {
+			return fmt.Errorf("\"dst\" field is required for routes")
+		}
+
+		if route.GW == nil {
+			continue
+		}
+
+		if !subnetsContain(subnets, route.GW) {
+			return fmt.Errorf("route %s gateway %s is not on any of the \"ipAddresses\" subnets", route.Dst.String(),
+				route.GW)
+		}
+	}
+
+	return nil
+}
+
+func subnetsContain(subnets []*net.IPNet, ip net.IP) bool {
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// staticResult returns the static addresses and routes as an IPAM result.
+func staticResult(conf *pluginConf) (*current.Result, error) {
+	result := &current.Result{Routes: conf.Routes}
+
+	for _, address := range conf.IPAddresses {
+		ip, subnet, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address %q: %v", address, err)
+		}
+
+		result.IPs = append(result.IPs, &current.IPConfig{Address: net.IPNet{IP: ip, Mask: subnet.Mask}})
+	}
+
+	return result, nil
+}
+
+// addStaticResult configures the static addresses and routes on the link and adds them to the result referencing the
+// result interface at ifIndex.
+func addStaticResult(conf *pluginConf, args *skel.CmdArgs, linkName string, ifIndex int,
+	result *current.Result,
+) error {
+	static, err := staticResult(conf)
+	if err != nil {
+		return err
+	}
+
+	netnsPath := ""
+	if conf.InContainer {
+		netnsPath = args.Netns
+	}
+
+	logDebug("configuring static addresses", "name", linkName, "addresses", conf.IPAddresses,
+		"routes", len(conf.Routes))
+
+	if err := applyIPAMResult(netnsPath, linkName, static); err != nil {
+		return err
+	}
+
+	appendInterfaceIPs(result, ifIndex, static)
+
+	return nil
+}
+
+// removeStaticResult removes the static routes and addresses from the link. A missing link, namespace, route or
+// address is not an error.
+func removeStaticResult(conf *pluginConf, netnsPath, ifName string) error {
+	static, err := staticResult(conf)
+	if err != nil {
+		return err
+	}
+
+	if err := removeIPAMRoutes(netnsPath, ifName, static); err != nil {
+		return err
+	}
+
+	// The static addresses belong to the VLAN as the only result interface
+	static.Interfaces = []*current.Interface{{Name: ifName}}
+
+	for _, ip := range static.IPs {
+		ip.Interface = current.Int(0)
+	}
+
+	return removeIPAMAddresses(netnsPath, ifName, ifName, static)
+}
@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan static addresses", func() {
+	var testNS ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+
+		testNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(testNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(testNS)).To(Succeed())
+	})
+
+	It("aos-vlan configures and removes static addresses and routes", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}, PeerName: "aos-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, name := range []string{"aos-vlan", "aos-peer"} {
+				link, err := netlink.LinkByName(name)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(netlink.LinkSetUp(link)).To(Succeed())
+			}
+
+			conf, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+				"ipAddresses": ["192.168.100.10/24"],
+				"routes": [{"dst": "10.10.0.0/16", "gw": "192.168.100.1"}]}`))
+			Expect(err).NotTo(HaveOccurred())
+
+			result := &current.Result{Interfaces: []*current.Interface{{Name: "aos-vlan"}}}
+
+			Expect(addStaticResult(conf, &skel.CmdArgs{}, "aos-vlan", 0, result)).To(Succeed())
+
+			Expect(result.IPs).To(HaveLen(1))
+			Expect(result.IPs[0].Address.String()).To(Equal("192.168.100.10/24"))
+			Expect(*result.IPs[0].Interface).To(Equal(0))
+			Expect(result.Routes).To(HaveLen(1))
+			Expect(result.Routes[0].Dst.String()).To(Equal("10.10.0.0/16"))
+
+			link, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(addrs).To(HaveLen(1))
+			Expect(addrs[0].IPNet.String()).To(Equal("192.168.100.10/24"))
+
+			_, dst, _ := net.ParseCIDR("10.10.0.0/16")
+
+			routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Dst: dst},
+				netlink.RT_FILTER_DST)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(routes).To(HaveLen(1))
+			Expect(routes[0].Gw.String()).To(Equal("192.168.100.1"))
+
+			Expect(removeStaticResult(conf, "", "aos-vlan")).To(Succeed())
+			Expect(removeStaticResult(conf, "", "aos-vlan")).To(Succeed())
+
+			addrs, err = netlink.AddrList(link, netlink.FAMILY_V4)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(addrs).To(BeEmpty())
+
+			routes, err = netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Dst: dst}, netlink.RT_FILTER_DST)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(routes).To(BeEmpty())
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("aos-vlan validates static addresses and routes", func() {
+		for static, expected := range map[string]string{
+			`"ipAddresses": ["192.168.100.10"]`: `invalid IP address "192.168.100.10"`,
+			`"ipAddresses": ["192.168.100.10/24"], "routes": [{"dst": "10.10.0.0/16", "gw": "192.168.101.1"}]`: "" +
+				`route 10.10.0.0/16 gateway 192.168.101.1 is not on any of the "ipAddresses" subnets`,
+			`"routes": [{"gw": "192.168.100.1"}]`: `"dst" field is required for routes`,
+			`"ipAddresses": ["192.168.100.10/24"], "ipam": {"type": "host-local"}`: `"ipAddresses" and "routes" ` +
+				`can't be combined`,
+		} {
+			_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", ` + static + `}`))
+			Expect(err).To(MatchError(ContainSubstring(expected)), static)
+		}
+
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"ipAddresses": ["192.168.100.10/24", "fd00::10/64"],
+			"routes": [{"dst": "0.0.0.0/0", "gw": "192.168.100.1"}, {"dst": "::/0", "gw": "fd00::1"},
+				{"dst": "10.20.0.0/16"}]}`))
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/vishvananda/netlink"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const cniCommandStatus = "STATUS"
+
+// STATUS error codes defined by the CNI spec 1.1.
+const (
+	// errPluginNotAvailable reports the plugin can't service ADD requests.
+	errPluginNotAvailable uint = 50
+	// errLimitedConnectivity reports the plugin can't service ADD requests and the existing containers may have
+	// limited connectivity.
+	errLimitedConnectivity uint = 51
+)
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// cmdStatus reports whether the plugin is ready: the master bridge exists and is up and the parent link can be
+// resolved. Only the fields needed for the checks are parsed, so the configuration isn't fully validated.
+func cmdStatus(args *skel.CmdArgs) error {
+	conf := &pluginConf{}
+
+	if err := json.Unmarshal(args.StdinData, conf); err != nil {
+		return types.NewError(types.ErrDecodingFailure, "failed to parse network configuration", err.Error())
+	}
+
+	conf.InContainer = conf.InContainer || conf.ContainerNS
+
+	if err := checkNetlinkAccess(); err != nil {
+		return types.NewError(errPluginNotAvailable, "netlink is not accessible", err.Error())
+	}
+
+	if !conf.InContainer {
+		br, err := netlink.LinkByName(conf.Master)
+		if err != nil {
+			return types.NewError(errPluginNotAvailable, fmt.Sprintf("master bridge %q not found", conf.Master),
+				err.Error())
+		}
+
+		if br.Attrs().Flags&net.FlagUp == 0 {
+			return types.NewError(errLimitedConnectivity, fmt.Sprintf("master bridge %q is down", conf.Master), "")
+		}
+	}
+
+	if _, err := resolveParentIndex(conf); err != nil {
+		return types.NewError(errPluginNotAvailable, "parent link can't be resolved", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan status", func() {
+	var testNS ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+
+		testNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(testNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(testNS)).To(Succeed())
+	})
+
+	It("aos-vlan reports readiness", func() {
+		args := &skel.CmdArgs{StdinData: []byte(`{
+			"name": "mynet", "cniVersion": "1.1.0", "type": "aos-vlan", "master": "br0", "vlanId": 100}`)}
+
+		errCode := func() uint {
+			err := cmdStatus(args)
+
+			cniErr, ok := err.(*types.Error)
+			Expect(ok).To(BeTrue(), fmt.Sprint(err))
+
+			return cniErr.Code
+		}
+
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			Expect(errCode()).To(Equal(errPluginNotAvailable))
+
+			br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0"}}
+			Expect(netlink.LinkAdd(br)).To(Succeed())
+
+			Expect(errCode()).To(Equal(errLimitedConnectivity))
+
+			Expect(netlink.LinkSetUp(br)).To(Succeed())
+
+			// There is no default route to resolve the parent
+			Expect(errCode()).To(Equal(errPluginNotAvailable))
+
+			Expect(netlink.LinkAdd(&netlink.Veth{
+				LinkAttrs: netlink.LinkAttrs{Name: "eth0"}, PeerName: "eth1",
+			})).To(Succeed())
+			Expect(addTestAddr("eth0", "10.3.0.2/24")).To(Succeed())
+
+			parent, err := netlink.LinkByName("eth0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netlink.RouteAdd(&netlink.Route{
+				LinkIndex: parent.Attrs().Index, Gw: net.ParseIP("10.3.0.1"),
+			})).To(Succeed())
+
+			Expect(cmdStatus(args)).To(Succeed())
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		args.StdinData = []byte(`{"name": "mynet", "master": `)
+
+		Expect(errCode()).To(Equal(types.ErrDecodingFailure))
+	})
+})
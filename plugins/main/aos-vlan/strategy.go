@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Strategies of the parent link resolution.
+const (
+	// masterStrategyParent selects the parent by parentType.
+	masterStrategyParent = "parent"
+	// masterStrategyDefaultRoute selects the parent by the default route.
+	masterStrategyDefaultRoute = "defaultRoute"
+	// masterStrategySubnet selects the parent by masterSubnet.
+	masterStrategySubnet = "subnet"
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+var masterStrategies = map[string]func(conf *pluginConf) (int, error){
+	masterStrategyParent:       getParentIndexByType,
+	masterStrategyDefaultRoute: getMasterInterfaceIndex,
+	masterStrategySubnet:       getParentIndexBySubnet,
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// validateMasterStrategyOrder checks the strategies are known, not repeated and configured.
+func validateMasterStrategyOrder(conf *pluginConf) error {
+	used := make(map[string]bool)
+
+	for _, strategy := range conf.MasterStrategyOrder {
+		if _, ok := masterStrategies[strategy]; !ok {
+			return fmt.Errorf("invalid master strategy %q (must be %q, %q or %q)", strategy, masterStrategyParent,
+				masterStrategyDefaultRoute, masterStrategySubnet)
+		}
+
+		if used[strategy] {
+			return fmt.Errorf("master strategy %q is listed more than once", strategy)
+		}
+
+		used[strategy] = true
+
+		if strategy == masterStrategyParent && conf.ParentType == "" {
+			return fmt.Errorf("master strategy %q requires \"parentType\"", strategy)
+		}
+
+		if strategy == masterStrategySubnet && conf.MasterSubnet == "" {
+			return fmt.Errorf("master strategy %q requires \"masterSubnet\"", strategy)
+		}
+	}
+
+	return nil
+}
+
+// resolveParentIndexByStrategy tries the strategies in the configured order and returns the first usable parent.
+func resolveParentIndexByStrategy(conf *pluginConf) (int, error) {
+	var errs []string
+
+	for _, strategy := range conf.MasterStrategyOrder {
+		index, err := localParentIndex(conf, masterStrategies[strategy])
+		if err == nil {
+			err = checkParentIndex(index)
+		}
+
+		if err == nil {
+			return index, nil
+		}
+
+		errs = append(errs, fmt.Sprintf("%s: %v", strategy, err))
+	}
+
+	return 0, fmt.Errorf("no usable parent link found: %s", strings.Join(errs, "; "))
+}
+
+func checkParentIndex(index int) error {
+	parent, err := netlink.LinkByIndex(index)
+	if err != nil {
+		return fmt.Errorf("failed to lookup parent link %d: %v", index, err)
+	}
+
+	return validateParentLink(parent)
+}
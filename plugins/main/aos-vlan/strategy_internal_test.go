@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan master strategy", func() {
+	var testNS ns.NetNS
+
+	BeforeEach(func() {
+		var err error
+
+		testNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(testNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(testNS)).To(Succeed())
+	})
+
+	It("aos-vlan tries master strategies in the configured order", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			indices := make(map[string]int)
+
+			for name, cidr := range map[string]string{"eth0": "10.3.0.2/24", "eth1": "10.4.0.2/24"} {
+				err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: name + "p"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(addTestAddr(name, cidr)).To(Succeed())
+
+				link, err := netlink.LinkByName(name)
+				Expect(err).NotTo(HaveOccurred())
+
+				indices[name] = link.Attrs().Index
+			}
+
+			Expect(netlink.RouteAdd(&netlink.Route{
+				LinkIndex: indices["eth0"], Gw: net.ParseIP("10.3.0.1"),
+			})).To(Succeed())
+
+			// The first strategy fails, the next one is used
+			index, err := resolveParentIndex(&pluginConf{
+				ParentType: "bond", MasterSubnet: "10.4.0.0/16",
+				MasterStrategyOrder: []string{masterStrategyParent, masterStrategySubnet, masterStrategyDefaultRoute},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(indices["eth1"]))
+
+			index, err = resolveParentIndex(&pluginConf{
+				MasterSubnet:        "10.4.0.0/16",
+				MasterStrategyOrder: []string{masterStrategyDefaultRoute, masterStrategySubnet},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(indices["eth0"]))
+
+			index, err = resolveParentIndex(&pluginConf{
+				MasterSubnet:        "192.168.0.0/16",
+				MasterStrategyOrder: []string{masterStrategySubnet, masterStrategyDefaultRoute},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(indices["eth0"]))
+
+			_, err = resolveParentIndex(&pluginConf{
+				ParentType: "bond", MasterSubnet: "192.168.0.0/16",
+				MasterStrategyOrder: []string{masterStrategySubnet, masterStrategyParent},
+			})
+			Expect(err).To(MatchError(ContainSubstring(
+				"no usable parent link found: subnet: no link with an address in 192.168.0.0/16 found; parent: ")))
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("aos-vlan rejects invalid master strategy order", func() {
+		for conf, expected := range map[string]string{
+			`"masterStrategyOrder": ["gateway"]`: `invalid master strategy "gateway" ` +
+				`(must be "parent", "defaultRoute" or "subnet")`,
+			`"masterStrategyOrder": ["defaultRoute", "defaultRoute"]`: `master strategy "defaultRoute" is listed ` +
+				`more than once`,
+			`"masterStrategyOrder": ["parent"]`: `master strategy "parent" requires "parentType"`,
+			`"masterStrategyOrder": ["subnet"]`: `master strategy "subnet" requires "masterSubnet"`,
+			`"masterSubnet": "10.4.0.0/16", "parentType": "bond"`: `"masterSubnet" and "parentType" are mutually ` +
+				`exclusive`,
+		} {
+			_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan", ` + conf + `}`))
+			Expect(err).To(MatchError(expected), conf)
+		}
+
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"masterSubnet": "10.4.0.0/16", "parentType": "bond", "masterStrategyOrder": ["subnet", "parent"]}`))
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
@@ -0,0 +1,234 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// sysctlRoot is the procfs sysctl directory, variable for testing.
+var sysctlRoot = "/proc/sys"
+
+// ipv4DevconfIndices are the IFLA_INET_CONF attribute types of the IPv4 interface sysctls as defined in linux/ip.h.
+var ipv4DevconfIndices = map[string]int{
+	"forwarding":                         1,
+	"mc_forwarding":                      2,
+	"proxy_arp":                          3,
+	"accept_redirects":                   4,
+	"secure_redirects":                   5,
+	"send_redirects":                     6,
+	"shared_media":                       7,
+	"rp_filter":                          8,
+	"accept_source_route":                9,
+	"bootp_relay":                        10,
+	"log_martians":                       11,
+	"tag":                                12,
+	"arp_filter":                         13,
+	"medium_id":                          14,
+	"disable_xfrm":                       15,
+	"disable_policy":                     16,
+	"force_igmp_version":                 17,
+	"arp_announce":                       18,
+	"arp_ignore":                         19,
+	"promote_secondaries":                20,
+	"arp_accept":                         21,
+	"arp_notify":                         22,
+	"accept_local":                       23,
+	"src_valid_mark":                     24,
+	"proxy_arp_pvlan":                    25,
+	"route_localnet":                     26,
+	"igmpv2_unsolicited_report_interval": 27,
+	"igmpv3_unsolicited_report_interval": 28,
+	"ignore_routes_with_linkdown":        29,
+	"drop_unicast_in_l2_multicast":       30,
+	"drop_gratuitous_arp":                31,
+	"bc_forwarding":                      32,
+	"arp_evict_nocarrier":                33,
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// validateSysctls checks the sysctls are interface sysctls. The VLAN name is known only on ADD in inContainer and
+// autoName modes, so the sysctls are checked to be scoped to the VLAN interface when they are set.
+func validateSysctls(conf *pluginConf) error {
+	if len(conf.Sysctls) == 0 {
+		return nil
+	}
+
+	if len(conf.Vlans) != 0 {
+		return fmt.Errorf("\"sysctls\" can't be combined with \"vlans\"")
+	}
+
+	for name := range conf.Sysctls {
+		ifName := conf.IfName
+
+		if conf.InContainer || ifName == "" {
+			ifName = sysctlIfName(name)
+		}
+
+		if _, err := sysctlPath(name, ifName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setVlanSysctls sets the configured sysctls of the VLAN interface in the current network namespace. If the procfs
+// sysctls of the interface can't be used, the IPv4 sysctls are set with netlink instead.
+func setVlanSysctls(conf *pluginConf, ifName string) error {
+	names := make([]string, 0, len(conf.Sysctls))
+
+	for name := range conf.Sysctls {
+		if _, err := sysctlPath(name, ifName); err != nil {
+			return err
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		path, _ := sysctlPath(name, ifName)
+
+		logDebug("setting sysctl", "name", name, "value", conf.Sysctls[name])
+
+		if dirErr := checkSysctlDir(filepath.Dir(path)); dirErr != nil {
+			if err := setIPv4DevconfNetlink(name, ifName, conf.Sysctls[name]); err != nil {
+				return fmt.Errorf("failed to set sysctl %s to %q: %v, netlink fallback failed: %v", name,
+					conf.Sysctls[name], dirErr, err)
+			}
+
+			continue
+		}
+
+		if err := os.WriteFile(path, []byte(conf.Sysctls[name]), 0o644); err != nil {
+			return fmt.Errorf("failed to set sysctl %s to %q: %v", name, conf.Sysctls[name], err)
+		}
+	}
+
+	return nil
+}
+
+// checkSysctlDir checks the interface sysctl directory belongs to the current network namespace. procfs shows the
+// sysctls of the network namespace of the calling thread, but in another mount namespace /proc/sys may be missing or
+// masked by another filesystem, which would get the writes instead.
+func checkSysctlDir(dir string) error {
+	var stat unix.Statfs_t
+
+	if err := unix.Statfs(dir, &stat); err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return fmt.Errorf("%s not found, procfs belongs to another network namespace", dir)
+		}
+
+		return fmt.Errorf("failed to stat %s: %v", dir, err)
+	}
+
+	if stat.Type != unix.PROC_SUPER_MAGIC {
+		return fmt.Errorf("%s is not on procfs, it belongs to another mount namespace", dir)
+	}
+
+	return nil
+}
+
+// setIPv4DevconfNetlink sets the IPv4 interface sysctl with the IFLA_INET_CONF attribute of a link request, which
+// doesn't depend on the procfs mount. IPv6 interface sysctls can't be set this way.
+func setIPv4DevconfNetlink(name, ifName, value string) error {
+	key := strings.TrimPrefix(name, fmt.Sprintf("net.ipv4.conf.%s.", ifName))
+
+	index, ok := ipv4DevconfIndices[key]
+	if !ok || key == name {
+		return fmt.Errorf("sysctl %s can't be set with netlink", name)
+	}
+
+	number, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid sysctl %s value %q: %v", name, value, err)
+	}
+
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+
+	req := nl.NewNetlinkRequest(unix.RTM_SETLINK, unix.NLM_F_ACK)
+
+	msg := nl.NewIfInfomsg(unix.AF_UNSPEC)
+	msg.Index = int32(link.Attrs().Index)
+	req.AddData(msg)
+
+	afSpec := nl.NewRtAttr(unix.IFLA_AF_SPEC, nil)
+	afSpec.AddRtAttr(unix.AF_INET, nil).AddRtAttr(unix.IFLA_INET_CONF, nil).AddRtAttr(index,
+		nl.Uint32Attr(uint32(number)))
+	req.AddData(afSpec)
+
+	_, err = req.Execute(unix.NETLINK_ROUTE, 0)
+
+	return err
+}
+
+// sysctlPath returns the procfs path of the interface sysctl. Only net.ipv4.conf.<ifName>.<key> and
+// net.ipv6.conf.<ifName>.<key> sysctls of the VLAN interface are accepted, so host wide sysctls can't be changed.
+func sysctlPath(name, ifName string) (string, error) {
+	for _, family := range []string{"ipv4", "ipv6"} {
+		prefix := fmt.Sprintf("net.%s.conf.%s.", family, ifName)
+
+		if ifName == "" || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		key := strings.TrimPrefix(name, prefix)
+		if key == "" || strings.ContainsAny(key, "./") {
+			return "", fmt.Errorf("invalid sysctl %s key %q", name, key)
+		}
+
+		return filepath.Join(sysctlRoot, "net", family, "conf", ifName, key), nil
+	}
+
+	return "", fmt.Errorf("sysctl %s is not a net.ipv4.conf.%s or net.ipv6.conf.%s sysctl", name, ifName, ifName)
+}
+
+// sysctlIfName returns the interface name of the interface sysctl assuming the key has no dots.
+func sysctlIfName(name string) string {
+	for _, family := range []string{"ipv4", "ipv6"} {
+		prefix := fmt.Sprintf("net.%s.conf.", family)
+
+		if strings.HasPrefix(name, prefix) && strings.LastIndex(name, ".") > len(prefix) {
+			return name[len(prefix):strings.LastIndex(name, ".")]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan sysctls", func() {
+	It("aos-vlan accepts only sysctls of the VLAN interface", func() {
+		for _, name := range []string{
+			"net.ipv4.ip_forward",
+			"net.ipv4.conf.all.forwarding",
+			"net.ipv4.conf.eth0.proxy_arp",
+			"net.ipv6.conf.aos-vlan.",
+			"net.ipv6.conf.aos-vlan.neigh.base_reachable_time",
+			"kernel.core_pattern",
+		} {
+			_, err := sysctlPath(name, "aos-vlan")
+			Expect(err).To(HaveOccurred(), name)
+		}
+
+		Expect(sysctlPath("net.ipv4.conf.aos-vlan.proxy_arp", "aos-vlan")).To(Equal(
+			"/proc/sys/net/ipv4/conf/aos-vlan/proxy_arp"))
+		Expect(sysctlPath("net.ipv6.conf.eth0.100.autoconf", "eth0.100")).To(Equal(
+			"/proc/sys/net/ipv6/conf/eth0.100/autoconf"))
+
+		_, _, err := parseConfig([]byte(`{"master": "br0", "vlanId": 100, "ifName": "aos-vlan",
+			"sysctls": {"net.ipv4.conf.all.proxy_arp": "1"}}`))
+		Expect(err).To(MatchError(
+			"sysctl net.ipv4.conf.all.proxy_arp is not a net.ipv4.conf.aos-vlan or net.ipv6.conf.aos-vlan sysctl"))
+
+		// The container interface name is checked on ADD
+		_, _, err = parseConfig([]byte(`{"vlanId": 100, "ifName": "aos-vlan", "inContainer": true,
+			"sysctls": {"net.ipv4.conf.eth1.proxy_arp": "1"}}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, _, err = parseConfig([]byte(`{"vlanId": 100, "ifName": "aos-vlan", "inContainer": true,
+			"sysctls": {"net.ipv4.ip_forward": "1"}}`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("aos-vlan sets sysctls of the VLAN interface", func() {
+		testNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(testNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		}()
+
+		err = testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}, PeerName: "aos-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			conf := &pluginConf{Sysctls: map[string]string{"net.ipv4.conf.aos-vlan.proxy_arp": "1"}}
+
+			Expect(setVlanSysctls(conf, "aos-vlan")).To(Succeed())
+
+			value, err := os.ReadFile(filepath.Join(sysctlRoot, "net/ipv4/conf/aos-vlan/proxy_arp"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(value)).To(Equal("1\n"))
+
+			// The peer sysctl is untouched
+			value, err = os.ReadFile(filepath.Join(sysctlRoot, "net/ipv4/conf/aos-peer/proxy_arp"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(value)).To(Equal("0\n"))
+
+			Expect(setVlanSysctls(conf, "aos-peer")).NotTo(Succeed())
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("aos-vlan resolves sysctls masked in another mount namespace", func() {
+		testNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(testNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		}()
+
+		tmpDir, err := os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
+		defer os.RemoveAll(tmpDir)
+
+		originalRoot := sysctlRoot
+
+		defer func() { sysctlRoot = originalRoot }()
+
+		err = testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}, PeerName: "aos-peer"})
+			if err != nil {
+				Skip("veth is not supported: " + err.Error())
+			}
+
+			// The namesake directory is not on procfs, as if /proc/sys was masked in the plugin mount namespace
+			for _, family := range []string{"ipv4", "ipv6"} {
+				dir := filepath.Join(tmpDir, "net", family, "conf", "aos-vlan")
+
+				Expect(os.MkdirAll(dir, 0o755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(dir, "proxy_arp"), []byte("0\n"), 0o644)).To(Succeed())
+			}
+
+			sysctlRoot = tmpDir
+
+			Expect(setVlanSysctls(&pluginConf{Sysctls: map[string]string{"net.ipv4.conf.aos-vlan.proxy_arp": "1"}},
+				"aos-vlan")).To(Succeed())
+
+			// The IPv4 sysctl is set with netlink instead of the masked file
+			Expect(os.ReadFile(filepath.Join(tmpDir, "net/ipv4/conf/aos-vlan/proxy_arp"))).To(BeEquivalentTo("0\n"))
+			Expect(os.ReadFile(filepath.Join(originalRoot, "net/ipv4/conf/aos-vlan/proxy_arp"))).To(
+				BeEquivalentTo("1\n"))
+
+			Expect(setVlanSysctls(&pluginConf{Sysctls: map[string]string{"net.ipv6.conf.aos-vlan.autoconf": "0"}},
+				"aos-vlan")).To(MatchError(ContainSubstring("belongs to another mount namespace")))
+
+			// Missing interface directory
+			Expect(setVlanSysctls(&pluginConf{Sysctls: map[string]string{"net.ipv6.conf.aos-peer.autoconf": "0"}},
+				"aos-peer")).To(MatchError(ContainSubstring("procfs belongs to another network namespace")))
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
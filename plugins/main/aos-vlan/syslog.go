@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"net"
+	"os"
+	"time"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	syslogTag            = "aos-vlan"
+	syslogConnectTimeout = 500 * time.Millisecond
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// syslogSocketPath is the local syslog socket, variable for testing.
+var syslogSocketPath = "/dev/log"
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// sendSyslog reports the CNI command outcome to the local syslog. Errors are returned for diagnostic purposes only and
+// must never fail the CNI command.
+func sendSyslog(conf *pluginConf, event *pluginEvent, cmdErr error) error {
+	if conf == nil || !conf.Syslog {
+		return nil
+	}
+
+	priority := syslog.LOG_DAEMON | syslog.LOG_INFO
+	if cmdErr != nil {
+		priority = syslog.LOG_DAEMON | syslog.LOG_ERR
+	}
+
+	msg := commandSummary(event, cmdErr)
+
+	conn, err := net.DialTimeout("unixgram", syslogSocketPath, syslogConnectTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect syslog: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(syslogConnectTimeout)); err != nil {
+		return fmt.Errorf("failed to set syslog deadline: %v", err)
+	}
+
+	if _, err := fmt.Fprintf(conn, "<%d>%s %s[%d]: %s",
+		priority, time.Now().Format(time.Stamp), syslogTag, os.Getpid(), msg); err != nil {
+		return fmt.Errorf("failed to write syslog: %v", err)
+	}
+
+	return nil
+}
+
+// commandSummary returns the human-readable summary of the CNI command outcome.
+func commandSummary(event *pluginEvent, cmdErr error) string {
+	outcome := "success"
+	if cmdErr != nil {
+		outcome = cmdErr.Error()
+	}
+
+	return fmt.Sprintf("%s %s vlanId %d master %s container %s: %s",
+		event.Command, event.IfName, event.VlanId, event.Master, event.ContainerID, outcome)
+}
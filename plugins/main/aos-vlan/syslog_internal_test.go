@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan syslog", func() {
+	var (
+		tmpDir       string
+		listener     *net.UnixConn
+		originalPath string
+	)
+
+	conf := &pluginConf{Master: "br0", VlanId: 100, IfName: "aos-vlan", Syslog: true}
+	args := &skel.CmdArgs{ContainerID: "dummy"}
+
+	BeforeEach(func() {
+		var err error
+
+		tmpDir, err = os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
+		originalPath = syslogSocketPath
+		syslogSocketPath = filepath.Join(tmpDir, "log")
+
+		listener, err = net.ListenUnixgram("unixgram", &net.UnixAddr{Name: syslogSocketPath, Net: "unixgram"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		listener.Close()
+
+		syslogSocketPath = originalPath
+
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	receive := func() string {
+		buf := make([]byte, 1024)
+
+		Expect(listener.SetReadDeadline(time.Now().Add(time.Second))).To(Succeed())
+
+		n, err := listener.Read(buf)
+		Expect(err).NotTo(HaveOccurred())
+
+		return string(buf[:n])
+	}
+
+	It("sends info message on success", func() {
+		Expect(sendSyslog(conf, newPluginEvent("ADD", args, conf), nil)).To(Succeed())
+
+		msg := receive()
+		Expect(msg).To(HavePrefix("<30>"))
+		Expect(msg).To(ContainSubstring("aos-vlan["))
+		Expect(msg).To(HaveSuffix("ADD aos-vlan vlanId 100 master br0 container dummy: success"))
+	})
+
+	It("sends error message on failure", func() {
+		Expect(sendSyslog(conf, newPluginEvent("DEL", args, conf), errors.New("link busy"))).To(Succeed())
+
+		msg := receive()
+		Expect(msg).To(HavePrefix("<27>"))
+		Expect(msg).To(HaveSuffix("DEL aos-vlan vlanId 100 master br0 container dummy: link busy"))
+	})
+
+	It("reports unavailable syslog", func() {
+		listener.Close()
+		Expect(os.Remove(syslogSocketPath)).To(Succeed())
+
+		Expect(sendSyslog(conf, newPluginEvent("ADD", args, conf), nil)).NotTo(Succeed())
+	})
+})
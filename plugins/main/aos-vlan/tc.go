@@ -0,0 +1,370 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// tc filter preferences used by the plugin on the VLAN clsact hooks. Mirroring comes first, so the mirror gets the
+// packets as the VLAN sees them.
+const (
+	mirrorPref           = 5
+	ipv6TrafficClassPref = 10
+	bpfProgramPref       = 20
+	dscpToPcpPrefV4      = 30
+	dscpToPcpPrefV6      = 31
+)
+
+const bpfProgramHandle = 1
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func addClsactQdisc(link netlink.Link) error {
+	qdisc := &netlink.GenericQdisc{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_CLSACT,
+		},
+		QdiscType: "clsact",
+	}
+
+	if err := netlink.QdiscAdd(qdisc); err != nil && err != syscall.EEXIST {
+		return fmt.Errorf("failed to add clsact qdisc to %q: %v", link.Attrs().Name, err)
+	}
+
+	return nil
+}
+
+// setIPv6TrafficClass rewrites the traffic class of egress IPv6 packets. The vendored netlink library has no pedit
+// action support, so the filter is installed with the tc tool.
+func setIPv6TrafficClass(link netlink.Link, trafficClass int) error {
+	if err := addClsactQdisc(link); err != nil {
+		return err
+	}
+
+	if err := runTool("tc", "filter", "replace", "dev", link.Attrs().Name, "egress", "protocol", "ipv6",
+		"pref", strconv.Itoa(ipv6TrafficClassPref), "handle", "1", "matchall", "action", "pedit", "ex", "munge", "ip6",
+		"traffic_class", "set", strconv.Itoa(trafficClass)); err != nil {
+		return fmt.Errorf("failed to set IPv6 traffic class on %q: %v", link.Attrs().Name, err)
+	}
+
+	return nil
+}
+
+// attachBpfProgram attaches the pinned SCHED_CLS program to the ingress clsact hook of the link in direct action mode.
+func attachBpfProgram(link netlink.Link, programPath string) error {
+	if err := addClsactQdisc(link); err != nil {
+		return err
+	}
+
+	fd, err := bpfObjGet(programPath)
+	if err != nil {
+		return fmt.Errorf("failed to get pinned bpf program %q: %v", programPath, err)
+	}
+	defer unix.Close(fd)
+
+	filter := &netlink.BpfFilter{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: link.Attrs().Index,
+			Parent:    netlink.HANDLE_MIN_INGRESS,
+			Handle:    bpfProgramHandle,
+			Priority:  bpfProgramPref,
+			Protocol:  unix.ETH_P_ALL,
+		},
+		Fd:           fd,
+		Name:         filepath.Base(programPath),
+		DirectAction: true,
+	}
+
+	if err := netlink.FilterReplace(filter); err != nil {
+		return fmt.Errorf("failed to attach bpf program %q to %q: %v", programPath, link.Attrs().Name, err)
+	}
+
+	return nil
+}
+
+// teardownTc removes the configured plugin tc filters and the clsact qdisc. A missing link is not an error.
+func teardownTc(conf *pluginConf) error {
+	return removeTc(conf, false)
+}
+
+// teardownRecordedTc is teardownTc for tc resources recorded in the state: all plugin tc filters are removed whatever
+// the configuration enables now.
+func teardownRecordedTc(conf *pluginConf) error {
+	return removeTc(conf, true)
+}
+
+func removeTc(conf *pluginConf, all bool) error {
+	link, err := netlink.LinkByName(conf.IfName)
+	if err != nil {
+		if errors.As(err, new(netlink.LinkNotFoundError)) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to lookup %q: %v", conf.IfName, err)
+	}
+
+	if all || conf.BpfProgram != "" {
+		if err := deleteFilters(link, netlink.HANDLE_MIN_INGRESS, bpfProgramPref); err != nil {
+			return fmt.Errorf("failed to detach bpf program from %q: %v", conf.IfName, err)
+		}
+	}
+
+	if all || len(conf.DscpToPcp) != 0 {
+		if err := deleteFilters(link, netlink.HANDLE_MIN_EGRESS, dscpToPcpPrefV4, dscpToPcpPrefV6); err != nil {
+			return fmt.Errorf("failed to remove DSCP to PCP filters from %q: %v", conf.IfName, err)
+		}
+	}
+
+	if all || conf.MirrorTo != "" {
+		if err := deleteMirrorFilters(link); err != nil {
+			return err
+		}
+	}
+
+	if all || conf.IPv6TrafficClass != nil {
+		if err := deleteFilters(link, netlink.HANDLE_MIN_EGRESS, ipv6TrafficClassPref); err != nil {
+			return fmt.Errorf("failed to remove IPv6 traffic class filter from %q: %v", conf.IfName, err)
+		}
+	}
+
+	// The ingress filter of the bandwidth shaping uses the clsact qdisc as well
+	if conf.Bandwidth != nil && conf.Bandwidth.IngressRate != 0 {
+		return nil
+	}
+
+	return deleteClsactQdisc(link)
+}
+
+// deleteClsactQdisc deletes the clsact qdisc of the link, a missing qdisc is not an error.
+func deleteClsactQdisc(link netlink.Link) error {
+	qdisc := &netlink.GenericQdisc{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_CLSACT,
+		},
+		QdiscType: "clsact",
+	}
+
+	if err := netlink.QdiscDel(qdisc); err != nil && !errors.Is(err, syscall.ENOENT) &&
+		!errors.Is(err, syscall.EINVAL) {
+		return fmt.Errorf("failed to remove clsact qdisc from %q: %v", link.Attrs().Name, err)
+	}
+
+	return nil
+}
+
+// setMirror mirrors the ingress and egress packets of the link to the target link with match-all u32 filters. The
+// action result is unspecified, so the packets are passed on to the next filters.
+func setMirror(link netlink.Link, target string) error {
+	targetLink, err := netlink.LinkByName(target)
+	if err != nil {
+		return fmt.Errorf("failed to lookup mirror target %q: %v", target, err)
+	}
+
+	if err := addClsactQdisc(link); err != nil {
+		return err
+	}
+
+	// Filters are added without handles, remove the ones of the previous ADD
+	if err := deleteMirrorFilters(link); err != nil {
+		return err
+	}
+
+	for _, parent := range []uint32{netlink.HANDLE_MIN_INGRESS, netlink.HANDLE_MIN_EGRESS} {
+		mirror := netlink.NewMirredAction(targetLink.Attrs().Index)
+		mirror.MirredAction = netlink.TCA_EGRESS_MIRROR
+		mirror.Action = netlink.TC_ACT_UNSPEC
+
+		if err := netlink.FilterAdd(&netlink.U32{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: link.Attrs().Index,
+				Parent:    parent,
+				Priority:  mirrorPref,
+				Protocol:  unix.ETH_P_ALL,
+			},
+			Sel:     &nl.TcU32Sel{Flags: nl.TC_U32_TERMINAL, Keys: []nl.TcU32Key{{}}},
+			Actions: []netlink.Action{mirror},
+		}); err != nil {
+			return fmt.Errorf("failed to mirror %q to %q: %v", link.Attrs().Name, target, err)
+		}
+	}
+
+	return nil
+}
+
+// deleteMirrorFilters deletes the ingress and egress mirror filters of the link.
+func deleteMirrorFilters(link netlink.Link) error {
+	for _, parent := range []uint32{netlink.HANDLE_MIN_INGRESS, netlink.HANDLE_MIN_EGRESS} {
+		if err := deleteFilters(link, parent, mirrorPref); err != nil {
+			return fmt.Errorf("failed to remove mirror filters from %q: %v", link.Attrs().Name, err)
+		}
+	}
+
+	return nil
+}
+
+// setDscpToPcp maps the DSCP of egress IPv4 and IPv6 packets to the VLAN PCP: u32 filters set the skb priority to the
+// PCP and the VLAN egress QoS map maps the priority to the same PCP.
+func setDscpToPcp(link netlink.Link, dscpToPcp map[int]int) error {
+	if err := addDscpFilters(link, dscpToPcp); err != nil {
+		return err
+	}
+
+	pcps := make(map[uint32]uint32)
+
+	for _, pcp := range dscpToPcp {
+		pcps[uint32(pcp)] = uint32(pcp)
+	}
+
+	return setVlanEgressQosMap(link, pcps)
+}
+
+// addDscpFilters adds the egress filters setting the skb priority of IPv4 and IPv6 packets to the PCP of their DSCP.
+func addDscpFilters(link netlink.Link, dscpToPcp map[int]int) error {
+	if err := addClsactQdisc(link); err != nil {
+		return err
+	}
+
+	// Filters are added without handles, remove the ones of the previous ADD
+	if err := deleteFilters(link, netlink.HANDLE_MIN_EGRESS, dscpToPcpPrefV4, dscpToPcpPrefV6); err != nil {
+		return fmt.Errorf("failed to remove DSCP to PCP filters from %q: %v", link.Attrs().Name, err)
+	}
+
+	dscps := make([]int, 0, len(dscpToPcp))
+	for dscp := range dscpToPcp {
+		dscps = append(dscps, dscp)
+	}
+
+	sort.Ints(dscps)
+
+	for _, dscp := range dscps {
+		pcp := uint32(dscpToPcp[dscp])
+
+		for _, filter := range []*netlink.U32{
+			dscpFilter(link, dscpToPcpPrefV4, unix.ETH_P_IP, 0x00fc0000, uint32(dscp)<<18, pcp),
+			dscpFilter(link, dscpToPcpPrefV6, unix.ETH_P_IPV6, 0x0fc00000, uint32(dscp)<<22, pcp),
+		} {
+			if err := netlink.FilterAdd(filter); err != nil {
+				return fmt.Errorf("failed to add DSCP %d to PCP %d filter to %q: %v", dscp, pcp, link.Attrs().Name,
+					err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// dscpFilter returns the u32 filter matching DSCP in the first word of the IP header and setting the skb priority.
+func dscpFilter(link netlink.Link, pref uint16, protocol uint16, mask, value, priority uint32) *netlink.U32 {
+	return &netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: link.Attrs().Index,
+			Parent:    netlink.HANDLE_MIN_EGRESS,
+			Priority:  pref,
+			Protocol:  protocol,
+		},
+		Sel: &nl.TcU32Sel{
+			Flags: nl.TC_U32_TERMINAL,
+			Keys:  []nl.TcU32Key{{Mask: mask, Val: value}},
+		},
+		Actions: []netlink.Action{&netlink.SkbEditAction{
+			ActionAttrs: netlink.ActionAttrs{Action: netlink.TC_ACT_PIPE},
+			Priority:    &priority,
+		}},
+	}
+}
+
+// deleteFilters deletes the filters with the preferences from the hook.
+func deleteFilters(link netlink.Link, parent uint32, prefs ...uint16) error {
+	filters, err := netlink.FilterList(link, parent)
+	if err != nil {
+		return err
+	}
+
+	for _, filter := range filters {
+		for _, pref := range prefs {
+			if filter.Attrs().Priority != pref {
+				continue
+			}
+
+			if err := netlink.FilterDel(filter); err != nil && !errors.Is(err, syscall.ENOENT) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// bpfObjGet opens the bpf object pinned at path.
+func bpfObjGet(path string) (int, error) {
+	pathname, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return -1, err
+	}
+
+	// struct bpf_attr BPF_OBJ_GET layout: pathname, bpf_fd, file_flags
+	attr := struct {
+		pathname  uint64
+		bpfFd     uint32
+		fileFlags uint32
+	}{pathname: uint64(uintptr(unsafe.Pointer(pathname)))}
+
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_OBJ_GET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return -1, errno
+	}
+
+	return int(fd), nil
+}
+
+func runTool(bin string, args ...string) error {
+	path, err := exec.LookPath(bin)
+	if err != nil {
+		return fmt.Errorf("%s tool is not available: %v", bin, err)
+	}
+
+	output, err := exec.Command(path, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v: %s", bin, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
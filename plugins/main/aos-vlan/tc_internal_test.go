@@ -0,0 +1,292 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan tc", func() {
+	var (
+		tmpDir   string
+		bpfFSDir string
+		testNS   ns.NetNS
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		tmpDir, err = os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
+		bpfFSDir = filepath.Join(tmpDir, "bpf")
+		Expect(os.Mkdir(bpfFSDir, 0o755)).To(Succeed())
+
+		testNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		_ = unix.Unmount(bpfFSDir, 0)
+
+		Expect(testNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("aos-vlan attaches and detaches bpf program", func() {
+		programPath := filepath.Join(bpfFSDir, "prog")
+
+		if err := pinTestBpfProgram(bpfFSDir, programPath); err != nil {
+			Skip("bpf is not available: " + err.Error())
+		}
+
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}, PeerName: "aos-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			link, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(attachBpfProgram(link, programPath)).To(Succeed())
+			// Attaching again replaces the filter
+			Expect(attachBpfProgram(link, programPath)).To(Succeed())
+
+			filters, err := netlink.FilterList(link, netlink.HANDLE_MIN_INGRESS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(filters).To(HaveLen(1))
+
+			filter, ok := filters[0].(*netlink.BpfFilter)
+			Expect(ok).To(BeTrue())
+			Expect(filter.DirectAction).To(BeTrue())
+			Expect(filter.Attrs().Priority).To(Equal(uint16(bpfProgramPref)))
+
+			Expect(teardownTc(&pluginConf{IfName: "aos-vlan", BpfProgram: programPath})).To(Succeed())
+
+			filters, err = netlink.FilterList(link, netlink.HANDLE_MIN_INGRESS)
+			if err == nil {
+				Expect(filters).To(BeEmpty())
+			}
+
+			qdiscs, err := netlink.QdiscList(link)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, qdisc := range qdiscs {
+				Expect(qdisc.Type()).NotTo(Equal("clsact"))
+			}
+
+			// Missing link is not an error
+			Expect(teardownTc(&pluginConf{IfName: "missing", BpfProgram: programPath})).To(Succeed())
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("aos-vlan mirrors traffic to capture link", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			for _, name := range []string{"aos-vlan", "aos-cap"} {
+				err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: name + "-peer"})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			link, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			capture, err := netlink.LinkByName("aos-cap")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(setMirror(link, "aos-missing")).To(MatchError(ContainSubstring(
+				`failed to lookup mirror target "aos-missing"`)))
+
+			if err := setMirror(link, "aos-cap"); err != nil {
+				Skip("tc mirroring is not available: " + err.Error())
+			}
+
+			// Mirroring again replaces the filters
+			Expect(setMirror(link, "aos-cap")).To(Succeed())
+
+			// Some kernels dump the filters of both clsact hooks for either parent
+			mirrors := make(map[uint32]*netlink.MirredAction)
+
+			for _, parent := range []uint32{netlink.HANDLE_MIN_INGRESS, netlink.HANDLE_MIN_EGRESS} {
+				filters, err := netlink.FilterList(link, parent)
+				Expect(err).NotTo(HaveOccurred())
+
+				for _, filter := range filters {
+					u32, ok := filter.(*netlink.U32)
+					if !ok || u32.Sel == nil {
+						continue
+					}
+
+					Expect(u32.Attrs().Priority).To(Equal(uint16(mirrorPref)))
+					Expect(u32.Actions).To(HaveLen(1))
+
+					mirror, ok := u32.Actions[0].(*netlink.MirredAction)
+					Expect(ok).To(BeTrue())
+
+					mirrors[u32.Attrs().Handle] = mirror
+				}
+			}
+
+			Expect(mirrors).To(HaveLen(2))
+
+			for _, mirror := range mirrors {
+				Expect(mirror.MirredAction).To(Equal(netlink.TCA_EGRESS_MIRROR))
+				Expect(mirror.Ifindex).To(Equal(capture.Attrs().Index))
+			}
+
+			Expect(teardownTc(&pluginConf{IfName: "aos-vlan", MirrorTo: "aos-cap"})).To(Succeed())
+
+			qdiscs, err := netlink.QdiscList(link)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, qdisc := range qdiscs {
+				Expect(qdisc.Type()).NotTo(Equal("clsact"))
+			}
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("aos-vlan adds DSCP to PCP filters", func() {
+		err := testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}, PeerName: "aos-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			link, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			dscpToPcp := map[int]int{46: 5, 10: 1}
+
+			if err := addDscpFilters(link, dscpToPcp); err != nil {
+				Expect(err).To(MatchError(ContainSubstring(syscall.ENOENT.Error())))
+				Skip("u32 classifier or skbedit action is not available")
+			}
+			// Adding again replaces the filters
+			Expect(addDscpFilters(link, dscpToPcp)).To(Succeed())
+
+			filters, err := netlink.FilterList(link, netlink.HANDLE_MIN_EGRESS)
+			Expect(err).NotTo(HaveOccurred())
+
+			priorities := make(map[uint16][]uint32)
+
+			for _, filter := range filters {
+				u32, ok := filter.(*netlink.U32)
+				if !ok || u32.Sel == nil {
+					continue
+				}
+
+				for _, action := range u32.Actions {
+					if skbEdit, ok := action.(*netlink.SkbEditAction); ok && skbEdit.Priority != nil {
+						priorities[u32.Attrs().Priority] = append(priorities[u32.Attrs().Priority], *skbEdit.Priority)
+					}
+				}
+			}
+
+			Expect(priorities[dscpToPcpPrefV4]).To(ConsistOf(uint32(5), uint32(1)))
+			Expect(priorities[dscpToPcpPrefV6]).To(ConsistOf(uint32(5), uint32(1)))
+
+			Expect(teardownTc(&pluginConf{IfName: "aos-vlan", DscpToPcp: dscpToPcp})).To(Succeed())
+
+			qdiscs, err := netlink.QdiscList(link)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, qdisc := range qdiscs {
+				Expect(qdisc.Type()).NotTo(Equal("clsact"))
+			}
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// pinTestBpfProgram mounts bpffs at dir and pins a trivial SCHED_CLS program returning TC_ACT_OK to path.
+func pinTestBpfProgram(dir, path string) error {
+	if err := unix.Mount("bpf", dir, "bpf", 0, ""); err != nil {
+		return err
+	}
+
+	// mov r0, 0; exit
+	insns := []uint64{0x00000000000000b7, 0x0000000000000095}
+	license := []byte("ASL2\x00")
+
+	loadAttr := struct {
+		progType uint32
+		insnCnt  uint32
+		insns    uint64
+		license  uint64
+	}{
+		progType: uint32(netlink.BPF_PROG_TYPE_SCHED_CLS),
+		insnCnt:  uint32(len(insns)),
+		insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+	}
+
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_LOAD, uintptr(unsafe.Pointer(&loadAttr)),
+		unsafe.Sizeof(loadAttr))
+	if errno != 0 {
+		return errno
+	}
+	defer unix.Close(int(fd))
+
+	pathname, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+
+	pinAttr := struct {
+		pathname uint64
+		bpfFd    uint32
+		flags    uint32
+	}{pathname: uint64(uintptr(unsafe.Pointer(pathname))), bpfFd: uint32(fd)}
+
+	if _, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_OBJ_PIN, uintptr(unsafe.Pointer(&pinAttr)),
+		unsafe.Sizeof(pinAttr)); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,263 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// teardownStep undoes the resources of one kind created by cmdAdd.
+type teardownStep struct {
+	name string
+	run  func() error
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// teardownTrace is called before each teardown step, variable for testing.
+var teardownTrace = func(step string) {}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// teardownSteps returns the cmdDel steps in the teardown order, the reverse of the cmdAdd setup:
+//
+//  1. "routes": routes of prevResult via the VLAN, before the addresses they depend on;
+//  2. "addresses": prevResult addresses configured on the VLAN;
+//  3. "static": the static routes and addresses, which don't depend on prevResult;
+//  4. "ipam": IPAM allocations, released once no longer configured;
+//  5. "nft": the egress source MAC rewrite and MSS clamp chains;
+//  6. "tc": bpf, DSCP to PCP and mirror filters and clsact qdisc;
+//  7. "bandwidth": the tbf qdisc, the ingress redirect filter and the ifb device of the bandwidth shaping;
+//  8. "filter": the bridge port VLAN filter entries added by the plugin or configured by bridgeVlan;
+//  9. "bridge": detaching from the master bridge or removing the OVS port, before the VLAN is deleted;
+//  10. "link": the VLAN itself, unless keepOnDel is set;
+//  11. "group": the VLAN entry of the group policy file;
+//  12. "state": the state file.
+//
+// With "vlans" and stateDir, the steps cover all VLANs recorded in the container state, not only the configured ones.
+//
+// The "nft", "tc" and "bandwidth" steps also run for the resources recorded in the state with stateDir, so they are
+// removed even when the link is kept.
+//
+// Each step tolerates already absent resources.
+func teardownSteps(
+	conf *pluginConf, args *skel.CmdArgs, prevResult *current.Result, delegates []ipamDelegate,
+) (steps []teardownStep) {
+	netnsPath, ifName := "", conf.IfName
+	if conf.InContainer {
+		netnsPath, ifName = args.Netns, stateContainerIfName(conf, args.ContainerID, args.IfName)
+	}
+
+	resultName := ifName
+	if conf.LogicalName != "" {
+		resultName = conf.LogicalName
+	}
+
+	// Multi-VLAN DEL removes the VLANs recorded in the container state as well, an unreadable state is reported by the
+	// "state" step.
+	confs, stateErr := containerVlanConfs(conf, args.ContainerID)
+
+	if len(conf.Vlans) == 0 {
+		steps = append(steps,
+			teardownStep{"routes", func() error { return removeIPAMRoutes(netnsPath, ifName, prevResult) }},
+			teardownStep{"addresses", func() error {
+				return removeIPAMAddresses(netnsPath, ifName, resultName, prevResult)
+			}})
+	}
+
+	if len(conf.IPAddresses) != 0 || len(conf.Routes) != 0 {
+		steps = append(steps, teardownStep{"static", func() error {
+			return removeStaticResult(conf, netnsPath, ifName)
+		}})
+	}
+
+	steps = append(steps, teardownStep{"ipam", func() error { return ipamDel(conf, delegates) }})
+
+	// Resources recorded on ADD are removed even if the configuration doesn't enable them anymore
+	recorded := recordedVlanResources(conf, args.ContainerID)
+	configured := vlanResources(conf)
+
+	if hasVlanResource(configured, recorded, resourceNft) {
+		steps = append(steps, teardownStep{"nft", func() error {
+			return forEachVlan(confs, func(entryConf *pluginConf) error {
+				if entryConf.EgressSrcMac != "" || recorded[entryConf.IfName][resourceNft] {
+					if err := removeEgressSrcMac(entryConf); err != nil {
+						return err
+					}
+				}
+
+				if entryConf.MssClamp != nil || recorded[entryConf.IfName][resourceNft] {
+					return removeMssClamp(entryConf)
+				}
+
+				return nil
+			})
+		}})
+	}
+
+	if hasVlanResource(configured, recorded, resourceTc) {
+		steps = append(steps, teardownStep{"tc", func() error {
+			return forEachVlan(confs, func(entryConf *pluginConf) error {
+				if recorded[entryConf.IfName][resourceTc] {
+					return teardownRecordedTc(entryConf)
+				}
+
+				return teardownTc(entryConf)
+			})
+		}})
+	}
+
+	if hasVlanResource(configured, recorded, resourceBandwidth) {
+		steps = append(steps, teardownStep{"bandwidth", func() error {
+			return forEachVlan(confs, teardownBandwidth)
+		}})
+	}
+
+	if conf.VlanFilterPolicy != "" {
+		steps = append(steps, teardownStep{"filter", func() error {
+			return forEachVlan(confs, removeBridgeVlanFilter)
+		}})
+	}
+
+	if conf.BridgeVlan != nil {
+		steps = append(steps, teardownStep{"filter", func() error {
+			return forEachVlan(confs, removeBridgeVlans)
+		}})
+	}
+
+	if !conf.InContainer && (conf.Shared || !conf.KeepOnDel) {
+		detach := func(entryConf *pluginConf) error { return detachVlan(entryConf, args.ContainerID) }
+
+		if conf.BridgeType == bridgeTypeOvs {
+			detach = removeVlanFromOvsBridge
+		}
+
+		steps = append(steps, teardownStep{"bridge", func() error {
+			return forEachVlan(confs, detach)
+		}})
+	}
+
+	if !conf.Shared && !conf.KeepOnDel {
+		steps = append(steps, teardownStep{"link", func() error {
+			if len(conf.Vlans) != 0 {
+				return deleteVlans(confs, args.ContainerID)
+			}
+
+			return deleteVlan(conf, args)
+		}})
+	}
+
+	if conf.GroupPolicyFile != "" {
+		steps = append(steps, teardownStep{"group", func() error {
+			return removeGroupPolicy(conf.GroupPolicyFile, conf.IfName)
+		}})
+	}
+
+	if conf.StateDir != "" {
+		steps = append(steps, teardownStep{"state", func() error {
+			if stateErr != nil {
+				return stateErr
+			}
+
+			for _, entryConf := range confs {
+				if err := removeVlanState(conf.StateDir, args.ContainerID, entryConf.IfName); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}})
+	}
+
+	return steps
+}
+
+// runTeardown runs all steps in order, a failed step doesn't stop the following ones. The errors are aggregated.
+func runTeardown(steps []teardownStep) error {
+	var errs []string
+
+	for _, step := range steps {
+		teardownTrace(step.name)
+
+		if err := step.run(); err != nil {
+			logDebug("teardown step failed", "step", step.name, "error", err)
+
+			errs = append(errs, fmt.Sprintf("%s: %v", step.name, err))
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("teardown failed: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func teardownStepNames(steps []teardownStep) (names []string) {
+	for _, step := range steps {
+		names = append(names, step.name)
+	}
+
+	return names
+}
+
+// hasVlanResource reports whether the resource is configured or recorded for any VLAN.
+func hasVlanResource(configured []string, recorded map[string]map[string]bool, resource string) bool {
+	for _, configuredResource := range configured {
+		if configuredResource == resource {
+			return true
+		}
+	}
+
+	for _, resources := range recorded {
+		if resources[resource] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forEachVlan runs the function for each VLAN configuration and aggregates the errors.
+func forEachVlan(confs []*pluginConf, run func(conf *pluginConf) error) error {
+	var errs []string
+
+	for _, entryConf := range confs {
+		if err := run(entryConf); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
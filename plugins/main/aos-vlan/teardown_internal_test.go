@@ -0,0 +1,238 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/vishvananda/netlink"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan teardown", func() {
+	It("aos-vlan runs all teardown steps and aggregates errors", func() {
+		var run []string
+
+		step := func(name string, err error) teardownStep {
+			return teardownStep{name, func() error {
+				run = append(run, name)
+				return err
+			}}
+		}
+
+		err := runTeardown([]teardownStep{
+			step("routes", nil),
+			step("ipam", errors.New("ipam failed")),
+			step("link", errors.New("link busy")),
+			step("state", nil),
+		})
+		Expect(err).To(MatchError("teardown failed: ipam: ipam failed; link: link busy"))
+		Expect(run).To(Equal([]string{"routes", "ipam", "link", "state"}))
+	})
+
+	It("aos-vlan orders teardown steps", func() {
+		args := &skel.CmdArgs{ContainerID: "dummy"}
+		prevResult := &current.Result{}
+
+		Expect(teardownStepNames(teardownSteps(&pluginConf{IfName: "aos-vlan", KeepOnDel: true}, args, prevResult,
+			nil))).To(Equal([]string{"routes", "addresses", "ipam"}))
+
+		Expect(teardownStepNames(teardownSteps(&pluginConf{IfName: "aos-vlan"}, args, prevResult, nil))).To(Equal(
+			[]string{"routes", "addresses", "ipam", "bridge", "link"}))
+
+		Expect(teardownStepNames(teardownSteps(&pluginConf{
+			IfName: "aos-vlan", KeepOnDel: true, IPAddresses: []string{"192.168.100.10/24"},
+		}, args, prevResult, nil))).To(Equal([]string{"routes", "addresses", "static", "ipam"}))
+
+		Expect(teardownStepNames(teardownSteps(&pluginConf{
+			IfName: "aos-vlan", BpfProgram: "/sys/fs/bpf/prog", EgressSrcMac: "02:00:00:00:00:01", DeleteOnDel: true,
+			StateDir: "/run/aos-vlan", VlanFilterPolicy: vlanFilterMerge, Bandwidth: &bandwidthConf{EgressRate: 1000},
+		}, args, prevResult, nil))).To(Equal(
+			[]string{"routes", "addresses", "ipam", "nft", "tc", "bandwidth", "filter", "bridge", "link", "state"}))
+
+		Expect(teardownStepNames(teardownSteps(&pluginConf{
+			IfName: "aos-vlan", GroupPolicyFile: "/run/aos-vlan/groups.json", StateDir: "/run/aos-vlan",
+		}, args, prevResult, nil))).To(Equal([]string{"routes", "addresses", "ipam", "bridge", "link", "group", "state"}))
+
+		Expect(teardownStepNames(teardownSteps(&pluginConf{IfName: "aos-vlan", MirrorTo: "aos-capture"}, args,
+			prevResult, nil))).To(Equal([]string{"routes", "addresses", "ipam", "tc", "bridge", "link"}))
+
+		Expect(teardownStepNames(teardownSteps(&pluginConf{IfName: "aos-vlan", MssClamp: &mssClamp{Pmtu: true}},
+			args, prevResult, nil))).To(Equal([]string{"routes", "addresses", "ipam", "nft", "bridge", "link"}))
+		Expect(teardownStepNames(teardownSteps(&pluginConf{
+			IfName: "aos-vlan", KeepOnDel: true, BridgeVlan: &bridgeVlanConf{Pvid: 10},
+		}, args, prevResult, nil))).To(Equal([]string{"routes", "addresses", "ipam", "filter"}))
+
+		Expect(teardownStepNames(teardownSteps(&pluginConf{IfName: "aos-vlan", BridgeType: bridgeTypeOvs}, args,
+			prevResult, nil))).To(Equal([]string{"routes", "addresses", "ipam", "bridge", "link"}))
+
+		Expect(teardownStepNames(teardownSteps(&pluginConf{IfName: "aos-vlan", Shared: true, DeleteOnDel: true},
+			args, prevResult, nil))).To(Equal([]string{"routes", "addresses", "ipam", "bridge"}))
+
+		Expect(teardownStepNames(teardownSteps(&pluginConf{IfName: "aos-vlan", InContainer: true, DeleteOnDel: true},
+			args, prevResult, nil))).To(Equal([]string{"routes", "addresses", "ipam", "link"}))
+
+		Expect(teardownStepNames(teardownSteps(&pluginConf{
+			Vlans: []vlanEntry{{VlanId: 100, IfName: "aos-vlan0"}}, DeleteOnDel: true,
+		}, args, prevResult, nil))).To(Equal([]string{"ipam", "bridge", "link"}))
+	})
+
+	It("aos-vlan removes recorded tc resources of kept link", func() {
+		tmpDir, err := os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
+		defer os.RemoveAll(tmpDir)
+
+		testNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(testNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		}()
+
+		err = testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			for _, name := range []string{"aos-vlan", "aos-cap"} {
+				err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: name + "-peer"})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			link, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			if err := setMirror(link, "aos-cap"); err != nil {
+				Skip("tc mirroring is not available: " + err.Error())
+			}
+
+			// Shared mode rejects the plugin tc resources, so a kept link is the only one with tc resources to remove
+			conf := &pluginConf{IfName: "aos-vlan", MirrorTo: "aos-cap", StateDir: tmpDir}
+			Expect(saveVlanState(tmpDir, vlanState{
+				ContainerID: "dummy", IfName: "aos-vlan", VlanId: 100, Resources: vlanResources(conf),
+			})).To(Succeed())
+
+			// DEL config doesn't enable tc anymore and keeps the link
+			conf = &pluginConf{IfName: "aos-vlan", KeepOnDel: true, StateDir: tmpDir}
+			steps := teardownSteps(conf, &skel.CmdArgs{ContainerID: "dummy"}, &current.Result{}, nil)
+			Expect(teardownStepNames(steps)).To(Equal([]string{"routes", "addresses", "ipam", "tc", "state"}))
+			Expect(runTeardown(steps)).To(Succeed())
+
+			qdiscs, err := netlink.QdiscList(link)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, qdisc := range qdiscs {
+				Expect(qdisc.Type()).NotTo(Equal("clsact"))
+			}
+
+			_, err = netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			states, err := loadContainerState(tmpDir, "dummy")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(states).To(BeEmpty())
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+	It("aos-vlan removes IPv6 traffic class of kept link", func() {
+		if _, err := exec.LookPath("tc"); err != nil {
+			Skip("tc tool is not available")
+		}
+
+		tmpDir, err := os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
+		defer os.RemoveAll(tmpDir)
+
+		testNS, err := testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			Expect(testNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(testNS)).To(Succeed())
+		}()
+
+		err = testNS.Do(func(ns.NetNS) error {
+			defer GinkgoRecover()
+
+			err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "aos-vlan"}, PeerName: "aos-peer"})
+			Expect(err).NotTo(HaveOccurred())
+
+			link, err := netlink.LinkByName("aos-vlan")
+			Expect(err).NotTo(HaveOccurred())
+
+			if err := setIPv6TrafficClass(link, 184); err != nil {
+				Skip("tc pedit is not available: " + err.Error())
+			}
+
+			trafficClass := 184
+			conf := &pluginConf{IfName: "aos-vlan", IPv6TrafficClass: &trafficClass, KeepOnDel: true, StateDir: tmpDir}
+			Expect(saveVlanState(tmpDir, vlanState{
+				ContainerID: "dummy", IfName: "aos-vlan", VlanId: 100, Resources: vlanResources(conf),
+			})).To(Succeed())
+
+			steps := teardownSteps(conf, &skel.CmdArgs{ContainerID: "dummy"}, &current.Result{}, nil)
+			Expect(teardownStepNames(steps)).To(Equal([]string{"routes", "addresses", "ipam", "tc", "state"}))
+			Expect(runTeardown(steps)).To(Succeed())
+
+			filters, err := netlink.FilterList(link, netlink.HANDLE_MIN_EGRESS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(filters).To(BeEmpty())
+
+			qdiscs, err := netlink.QdiscList(link)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, qdisc := range qdiscs {
+				Expect(qdisc.Type()).NotTo(Equal("clsact"))
+			}
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("aos-vlan tears down recorded bandwidth shaping", func() {
+		tmpDir, err := os.MkdirTemp("", "aos-vlan-")
+		Expect(err).NotTo(HaveOccurred())
+
+		defer os.RemoveAll(tmpDir)
+
+		Expect(saveVlanState(tmpDir, vlanState{
+			ContainerID: "dummy", IfName: "aos-vlan", VlanId: 100,
+			Resources: vlanResources(&pluginConf{Bandwidth: &bandwidthConf{EgressRate: 1000, EgressBurst: 100}}),
+		})).To(Succeed())
+
+		Expect(teardownStepNames(teardownSteps(&pluginConf{IfName: "aos-vlan", KeepOnDel: true, StateDir: tmpDir},
+			&skel.CmdArgs{ContainerID: "dummy"}, &current.Result{}, nil))).To(Equal(
+			[]string{"routes", "addresses", "ipam", "bandwidth", "state"}))
+	})
+})
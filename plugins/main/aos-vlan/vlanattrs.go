@@ -0,0 +1,443 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	vlanlib "github.com/aoscloud/aos_cni_vlan/pkg/vlan"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// The vendored netlink library handles only the VLAN ID and protocol, the other VLAN attributes are requested and
+// parsed directly.
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// VLAN flags as defined in linux/if_vlan.h.
+const (
+	vlanFlagReorderHdr   = 0x1
+	vlanFlagGvrp         = 0x2
+	vlanFlagLooseBinding = 0x4
+	vlanFlagMvrp         = 0x8
+)
+
+// maxPcp is the maximum 802.1p priority code point.
+const maxPcp = 7
+
+// vlanFlagsDebugEnv enables reporting of the requested and effective VLAN flags on ADD.
+const vlanFlagsDebugEnv = "AOS_VLAN_DEBUG_FLAGS"
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// qosMapping is a VLAN QoS map entry: from PCP to skb priority for ingress, from skb priority to PCP for egress.
+type qosMapping struct {
+	From uint32 `json:"from"`
+	To   uint32 `json:"to"`
+}
+
+// vlanAttrReport is a requested VLAN attribute and its effective value read back after creation.
+type vlanAttrReport struct {
+	Name      string `json:"name"`
+	Requested string `json:"requested"`
+	Effective string `json:"effective"`
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// vlanFlagNames are the configuration names of the VLAN flags in the order they are checked.
+var vlanFlagNames = []struct {
+	flag uint32
+	name string
+}{
+	{vlanFlagReorderHdr, "reorderHeaders"},
+	{vlanFlagGvrp, "gvrp"},
+	{vlanFlagLooseBinding, "looseBinding"},
+}
+
+var qosMapNames = map[int]string{
+	nl.IFLA_VLAN_INGRESS_QOS: "ingress",
+	nl.IFLA_VLAN_EGRESS_QOS:  "egress",
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// vlanInfoData returns the IFLA_INFO_DATA attributes of the VLAN link.
+func vlanInfoData(link netlink.Link) (map[uint16][]byte, error) {
+	req := nl.NewNetlinkRequest(unix.RTM_GETLINK, unix.NLM_F_ACK)
+
+	msg := nl.NewIfInfomsg(unix.AF_UNSPEC)
+	msg.Index = int32(link.Attrs().Index)
+	req.AddData(msg)
+
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWLINK)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range msgs {
+		ans := nl.DeserializeIfInfomsg(m)
+
+		attrs, err := nl.ParseRouteAttr(m[ans.Len():])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, attr := range attrs {
+			if attr.Attr.Type&^unix.NLA_F_NESTED != unix.IFLA_LINKINFO {
+				continue
+			}
+
+			infos, err := nl.ParseRouteAttr(attr.Value)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, info := range infos {
+				if info.Attr.Type&^unix.NLA_F_NESTED != nl.IFLA_INFO_DATA {
+					continue
+				}
+
+				datas, err := nl.ParseRouteAttr(info.Value)
+				if err != nil {
+					return nil, err
+				}
+
+				vlanAttrs := make(map[uint16][]byte)
+
+				for _, data := range datas {
+					vlanAttrs[data.Attr.Type] = data.Value
+				}
+
+				return vlanAttrs, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("link %q has no VLAN info", link.Attrs().Name)
+}
+
+// setVlanInfoData changes the VLAN link with the IFLA_INFO_DATA attributes added by addData.
+func setVlanInfoData(link netlink.Link, addData func(data *nl.RtAttr)) error {
+	req := nl.NewNetlinkRequest(unix.RTM_NEWLINK, unix.NLM_F_ACK)
+
+	msg := nl.NewIfInfomsg(unix.AF_UNSPEC)
+	msg.Index = int32(link.Attrs().Index)
+	req.AddData(msg)
+
+	linkInfo := nl.NewRtAttr(unix.IFLA_LINKINFO, nil)
+	linkInfo.AddRtAttr(nl.IFLA_INFO_KIND, nl.NonZeroTerminated("vlan"))
+	addData(linkInfo.AddRtAttr(nl.IFLA_INFO_DATA, nil))
+	req.AddData(linkInfo)
+
+	_, err := req.Execute(unix.NETLINK_ROUTE, 0)
+
+	return err
+}
+
+// getVlanFlags returns the VLAN flags of the link.
+func getVlanFlags(link netlink.Link) (uint32, error) {
+	vlanAttrs, err := vlanInfoData(link)
+	if err != nil {
+		return 0, err
+	}
+
+	// struct ifla_vlan_flags { __u32 flags; __u32 mask; }
+	value, ok := vlanAttrs[nl.IFLA_VLAN_FLAGS]
+	if !ok || len(value) < 4 {
+		return 0, fmt.Errorf("VLAN flags of %q are not reported", link.Attrs().Name)
+	}
+
+	return nl.NativeEndian().Uint32(value), nil
+}
+
+// setVlanFlags sets the VLAN flags selected by mask to flags.
+func setVlanFlags(link netlink.Link, flags, mask uint32) error {
+	value := make([]byte, 8)
+
+	nl.NativeEndian().PutUint32(value[0:], flags)
+	nl.NativeEndian().PutUint32(value[4:], mask)
+
+	if err := setVlanInfoData(link, func(data *nl.RtAttr) {
+		data.AddRtAttr(nl.IFLA_VLAN_FLAGS, value)
+	}); err != nil {
+		return fmt.Errorf("failed to set VLAN flags of %q: %v", link.Attrs().Name, err)
+	}
+
+	return nil
+}
+
+// setVlanEgressQosMap sets the VLAN egress QoS map entries from skb priority to PCP.
+func setVlanEgressQosMap(link netlink.Link, mapping map[uint32]uint32) error {
+	return setVlanQosMap(link, nl.IFLA_VLAN_EGRESS_QOS, mapping)
+}
+
+// setVlanIngressQosMap sets the VLAN ingress QoS map entries from PCP to skb priority.
+func setVlanIngressQosMap(link netlink.Link, mapping map[uint32]uint32) error {
+	return setVlanQosMap(link, nl.IFLA_VLAN_INGRESS_QOS, mapping)
+}
+
+func setVlanQosMap(link netlink.Link, qosType int, mapping map[uint32]uint32) error {
+	if err := setVlanInfoData(link, func(data *nl.RtAttr) {
+		qos := data.AddRtAttr(qosType, nil)
+
+		for from, to := range mapping {
+			// struct ifla_vlan_qos_mapping { __u32 from; __u32 to; }
+			value := make([]byte, 8)
+
+			nl.NativeEndian().PutUint32(value[0:], from)
+			nl.NativeEndian().PutUint32(value[4:], to)
+
+			qos.AddRtAttr(unix.IFLA_VLAN_QOS_MAPPING, value)
+		}
+	}); err != nil {
+		return fmt.Errorf("failed to set VLAN %s QoS map of %q: %v", qosMapNames[qosType], link.Attrs().Name, err)
+	}
+
+	return nil
+}
+
+// getVlanQosMap returns the VLAN QoS map entries of the link. The kernel doesn't report entries mapped to 0.
+func getVlanQosMap(link netlink.Link, qosType int) (map[uint32]uint32, error) {
+	vlanAttrs, err := vlanInfoData(link)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := make(map[uint32]uint32)
+
+	value, ok := vlanAttrs[uint16(qosType)]
+	if !ok {
+		value, ok = vlanAttrs[uint16(qosType)|unix.NLA_F_NESTED]
+	}
+
+	if !ok {
+		return mapping, nil
+	}
+
+	entries, err := nl.ParseRouteAttr(value)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if len(entry.Value) < 8 {
+			continue
+		}
+
+		mapping[nl.NativeEndian().Uint32(entry.Value[0:])] = nl.NativeEndian().Uint32(entry.Value[4:])
+	}
+
+	return mapping, nil
+}
+
+// setVlanQos sets the configured ingress and egress QoS maps.
+func setVlanQos(conf *pluginConf, link netlink.Link) error {
+	if len(conf.IngressQos) != 0 {
+		if err := setVlanIngressQosMap(link, qosMap(conf.IngressQos)); err != nil {
+			return err
+		}
+	}
+
+	if len(conf.EgressQos) != 0 {
+		if err := setVlanEgressQosMap(link, qosMap(conf.EgressQos)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkVlanQos compares the configured QoS map entries with the live link.
+func checkVlanQos(conf *pluginConf, link netlink.Link) error {
+	for qosType, mappings := range map[int][]qosMapping{
+		nl.IFLA_VLAN_INGRESS_QOS: conf.IngressQos,
+		nl.IFLA_VLAN_EGRESS_QOS:  conf.EgressQos,
+	} {
+		if len(mappings) == 0 {
+			continue
+		}
+
+		current, err := getVlanQosMap(link, qosType)
+		if err != nil {
+			return err
+		}
+
+		for from, to := range qosMap(mappings) {
+			if current[from] != to {
+				return fmt.Errorf("vlan link %s %s QoS map of %d configured %d, current value is %d",
+					link.Attrs().Name, qosMapNames[qosType], from, to, current[from])
+			}
+		}
+	}
+
+	return nil
+}
+
+func qosMap(mappings []qosMapping) map[uint32]uint32 {
+	mapping := make(map[uint32]uint32)
+
+	for _, entry := range mappings {
+		mapping[entry.From] = entry.To
+	}
+
+	return mapping
+}
+
+// validateVlanQos checks the PCP values of the QoS maps are in range: ingress maps from PCP, egress maps to PCP.
+func validateVlanQos(conf *pluginConf) error {
+	for _, entry := range conf.IngressQos {
+		if entry.From > maxPcp {
+			return fmt.Errorf("invalid ingress QoS PCP %d (must be between 0 and %d inclusive)", entry.From, maxPcp)
+		}
+	}
+
+	for _, entry := range conf.EgressQos {
+		if entry.To > maxPcp {
+			return fmt.Errorf("invalid egress QoS PCP %d (must be between 0 and %d inclusive)", entry.To, maxPcp)
+		}
+	}
+
+	if len(conf.EgressQos) != 0 && len(conf.DscpToPcp) != 0 {
+		return fmt.Errorf("\"egressQos\" and \"dscpToPcp\" are mutually exclusive")
+	}
+
+	return nil
+}
+
+// configuredVlanFlags returns the VLAN flags set by the configuration and the mask of the configured flags. The flags
+// which are not configured keep the kernel defaults.
+func configuredVlanFlags(conf *pluginConf) (flags, mask uint32) {
+	if conf.ReorderHeaders != nil {
+		mask |= vlanFlagReorderHdr
+
+		if *conf.ReorderHeaders {
+			flags |= vlanFlagReorderHdr
+		}
+	}
+
+	if conf.Gvrp {
+		flags, mask = flags|vlanFlagGvrp, mask|vlanFlagGvrp
+	}
+
+	if conf.LooseBinding {
+		flags, mask = flags|vlanFlagLooseBinding, mask|vlanFlagLooseBinding
+	}
+
+	return flags, mask
+}
+
+// checkVlanFlags compares the configured VLAN flags with the live link and reports each drifted flag.
+func checkVlanFlags(conf *pluginConf, link netlink.Link) error {
+	flags, mask := configuredVlanFlags(conf)
+	if mask == 0 {
+		return nil
+	}
+
+	current, err := getVlanFlags(link)
+	if err != nil {
+		return err
+	}
+
+	var drifted []string
+
+	for _, entry := range vlanFlagNames {
+		if mask&entry.flag == 0 || (current^flags)&entry.flag == 0 {
+			continue
+		}
+
+		drifted = append(drifted, fmt.Sprintf("flag %s configured %t, current value is %t", entry.name,
+			flags&entry.flag != 0, current&entry.flag != 0))
+	}
+
+	if len(drifted) != 0 {
+		return fmt.Errorf("vlan link %s %s", link.Attrs().Name, strings.Join(drifted, "; "))
+	}
+
+	return nil
+}
+
+// reportVlanFlags logs the requested and the effective VLAN flags and protocol if enabled by the AOS_VLAN_DEBUG_FLAGS
+// environment variable, so flags silently clamped by the kernel can be spotted, and returns them for the event. It is
+// diagnostic only, so nil is returned if the values can't be read.
+func reportVlanFlags(conf *pluginConf, link netlink.Link) []vlanAttrReport {
+	if os.Getenv(vlanFlagsDebugEnv) == "" {
+		return nil
+	}
+
+	reports, err := effectiveVlanFlags(conf, link)
+	if err != nil {
+		logWarn("VLAN flags are not reported", "name", link.Attrs().Name, "error", err)
+		return nil
+	}
+
+	for _, report := range reports {
+		logDebug("vlan link flag", "name", link.Attrs().Name, "flag", report.Name, "requested", report.Requested,
+			"effective", report.Effective)
+	}
+
+	return reports
+}
+
+// effectiveVlanFlags returns the configured VLAN flags and the protocol with the values read from the live link.
+func effectiveVlanFlags(conf *pluginConf, link netlink.Link) ([]vlanAttrReport, error) {
+	vlan, err := vlanlib.ByName(link.Attrs().Name)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := getVlanFlags(vlan)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []vlanAttrReport
+
+	flags, mask := configuredVlanFlags(conf)
+
+	for _, entry := range vlanFlagNames {
+		if mask&entry.flag == 0 {
+			continue
+		}
+
+		reports = append(reports, vlanAttrReport{
+			Name:      entry.name,
+			Requested: strconv.FormatBool(flags&entry.flag != 0),
+			Effective: strconv.FormatBool(current&entry.flag != 0),
+		})
+	}
+
+	return append(reports, vlanAttrReport{
+		Name:      "vlanProtocol",
+		Requested: netlink.StringToVlanProtocol(conf.VlanProtocol).String(),
+		Effective: vlan.VlanProtocol.String(),
+	}), nil
+}
@@ -0,0 +1,333 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	vlanlib "github.com/aoscloud/aos_cni_vlan/pkg/vlan"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Multi-VLAN policies applied when some of the VLANs can't be added.
+const (
+	// multiVlanPolicyAtomic fails the command and removes the VLANs created by it.
+	multiVlanPolicyAtomic = "atomic"
+	// multiVlanPolicyBestEffort skips the failed VLANs and reports the added ones.
+	multiVlanPolicyBestEffort = "bestEffort"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// vlanEntry is a single VLAN of the multi-VLAN configuration.
+type vlanEntry struct {
+	VlanId int    `json:"vlanId"`
+	IfName string `json:"ifName"`
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// vlanConf returns the configuration of a single VLAN of the multi-VLAN configuration.
+func vlanConf(conf *pluginConf, entry vlanEntry) *pluginConf {
+	entryConf := *conf
+
+	entryConf.VlanId = entry.VlanId
+	entryConf.IfName = entry.IfName
+	entryConf.Vlans = nil
+
+	return &entryConf
+}
+
+// addVlans creates all configured VLANs, attaches them to the master bridge and adds them to the result according to
+// the multi-VLAN policy. The VLANs are recorded for the container attachment with the CNI ifName.
+func addVlans(conf *pluginConf, containerID, ifName string, result *current.Result) error {
+	var (
+		created []*pluginConf
+		states  []vlanState
+		links   []*netlink.Vlan
+		addErrs []error
+		added   int
+	)
+
+	confs := vlanConfs(conf)
+
+	if conf.BatchCreate {
+		links, addErrs = batchAddVlans(confs)
+	}
+
+	for i, entryConf := range confs {
+		var (
+			vlanInterface *current.Interface
+			isNew         bool
+			err           error
+		)
+
+		if conf.BatchCreate {
+			vlanInterface, isNew, err = addBatchedVlan(entryConf, links[i], addErrs[i])
+		} else {
+			vlanInterface, isNew, err = addVlan(entryConf)
+		}
+
+		if err != nil {
+			err = prefixError(err, fmt.Sprintf("failed to add VLAN %d (%s)", entryConf.VlanId, entryConf.IfName))
+
+			if conf.MultiVlanPolicy == multiVlanPolicyBestEffort {
+				logWarn("skipping VLAN", "error", err)
+				continue
+			}
+
+			// Batched VLANs not handled yet are created as well and are rolled back too
+			if conf.BatchCreate {
+				err = rollbackBatched(confs[i+1:], links[i+1:], addErrs[i+1:], err)
+			}
+
+			return rollbackVlans(created, containerID, err)
+		}
+
+		if isNew {
+			created = append(created, entryConf)
+		}
+
+		if conf.StateDir != "" {
+			state, err := vlanStateByName(entryConf, containerID, isNew)
+			if err != nil {
+				return rollbackVlans(created, containerID, err)
+			}
+
+			state.AttachmentIfName = ifName
+			states = append(states, state)
+		}
+
+		if _, err := addResultInterface(conf, result, vlanInterface); err != nil {
+			return rollbackVlans(created, containerID, err)
+		}
+
+		added++
+	}
+
+	// The result may already hold the interfaces of prevResult, so the VLANs added here are counted
+	if added == 0 {
+		return fmt.Errorf("none of the VLANs could be added")
+	}
+
+	if conf.ReportTopology {
+		if err := addVlansTopology(conf, result); err != nil {
+			return rollbackVlans(created, containerID, err)
+		}
+	}
+
+	for _, state := range states {
+		if err := saveVlanState(conf.StateDir, state); err != nil {
+			return rollbackVlans(created, containerID, fmt.Errorf("failed to save state: %v", err))
+		}
+	}
+
+	return nil
+}
+
+// addVlansTopology adds the parent and the master bridge shared by all VLANs to the result.
+func addVlansTopology(conf *pluginConf, result *current.Result) error {
+	parentIndex, err := resolveParentIndex(conf)
+	if err != nil {
+		return fmt.Errorf("failed to lookup master index %v", err)
+	}
+
+	return addTopologyInterfaces(conf, result, parentIndex)
+}
+
+// rollbackVlans deletes the created VLANs after the failure.
+func rollbackVlans(created []*pluginConf, containerID string, err error) error {
+	if rollbackErr := deleteVlans(created, containerID); rollbackErr != nil {
+		return fmt.Errorf("%v, rollback failed: %v", err, rollbackErr)
+	}
+
+	return err
+}
+
+// rollbackVlan deletes the VLAN created by the failed ADD at linkName, in the netnsPath namespace if it was already
+// moved to the container.
+func rollbackVlan(conf *pluginConf, containerID, netnsPath, linkName string, err error) error {
+	linkConf := *conf
+	linkConf.IfName = linkName
+
+	if netnsPath == "" {
+		return rollbackVlans([]*pluginConf{&linkConf}, containerID, err)
+	}
+
+	if nsErr := ns.WithNetNSPath(netnsPath, func(ns.NetNS) error {
+		err = rollbackVlans([]*pluginConf{&linkConf}, containerID, err)
+
+		return nil
+	}); nsErr != nil {
+		return fmt.Errorf("%v, rollback failed: %v", err, nsErr)
+	}
+
+	return err
+}
+
+// vlanStateByName returns the state of the multi-VLAN configuration VLAN added for the container.
+func vlanStateByName(conf *pluginConf, containerID string, created bool) (vlanState, error) {
+	vlan, err := vlanlib.ByName(conf.IfName)
+	if err != nil {
+		return vlanState{}, err
+	}
+
+	return newVlanState(conf, containerID, vlan, created)
+}
+
+// addVlan creates a single VLAN of the multi-VLAN configuration and attaches it to the master bridge.
+func addVlan(conf *pluginConf) (vlanInterface *current.Interface, created bool, err error) {
+	vlan, vlanInterface, created, err := createVlan(conf)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return attachVlan(conf, vlan, vlanInterface, created)
+}
+
+// attachVlan attaches the VLAN to the master bridge. The VLAN is deleted if it was created but can't be attached.
+func attachVlan(
+	conf *pluginConf, vlan *netlink.Vlan, vlanInterface *current.Interface, created bool,
+) (*current.Interface, bool, error) {
+	if err := addVlanToBridge(conf, vlan); err != nil {
+		if created {
+			if delErr := deleteLinkWithRetry(conf, vlan); delErr != nil {
+				err = fmt.Errorf("%v, rollback failed: %v", err, delErr)
+			}
+		}
+
+		return nil, false, err
+	}
+
+	return vlanInterface, created, nil
+}
+
+// rollbackBatched deletes the VLANs created by batchAddVlans which are not configured yet. They don't carry the plugin
+// marker, so they are deleted by the added link instead of by name.
+func rollbackBatched(confs []*pluginConf, links []*netlink.Vlan, addErrs []error, err error) error {
+	for i, entryConf := range confs {
+		if addErrs[i] != nil {
+			continue
+		}
+
+		if delErr := deleteLinkWithRetry(entryConf, links[i]); delErr != nil {
+			err = fmt.Errorf("%v, rollback failed: %v", err, delErr)
+		}
+	}
+
+	return err
+}
+
+// deleteVlans deletes the VLANs set up by the plugin and returns the aggregated errors. Missing links, links which are
+// not VLANs and foreign VLANs are left intact.
+func deleteVlans(confs []*pluginConf, containerID string) error {
+	var errs []string
+
+	for _, entryConf := range confs {
+		if err := deleteLinkByName(entryConf, containerID, entryConf.IfName); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// vlanConfs returns the configurations of all configured VLANs, the configuration itself for the single VLAN
+// configuration.
+func vlanConfs(conf *pluginConf) []*pluginConf {
+	if len(conf.Vlans) == 0 {
+		return []*pluginConf{conf}
+	}
+
+	confs := make([]*pluginConf, 0, len(conf.Vlans))
+
+	for _, entry := range conf.Vlans {
+		confs = append(confs, vlanConf(conf, entry))
+	}
+
+	return confs
+}
+
+func validateVlans(conf *pluginConf) error {
+	switch conf.MultiVlanPolicy {
+	case "", multiVlanPolicyAtomic, multiVlanPolicyBestEffort:
+
+	default:
+		return fmt.Errorf("invalid multi-VLAN policy %q (must be %q or %q)", conf.MultiVlanPolicy,
+			multiVlanPolicyAtomic, multiVlanPolicyBestEffort)
+	}
+
+	if len(conf.Vlans) == 0 {
+		return nil
+	}
+
+	// Per VLAN artifacts are supported only for the single VLAN configuration
+	if conf.Shared || conf.InContainer || conf.IPAM.Type != "" || conf.IPAMV4 != nil || conf.IPAMV6 != nil ||
+		conf.MacFile != "" || conf.Mac != "" || conf.LogicalName != "" || conf.CheckGateway || conf.ReportIfIndex {
+		return fmt.Errorf("\"vlans\" can't be combined with \"shared\", \"inContainer\", \"ipam\", \"macFile\", " +
+			"\"mac\", \"logicalName\", \"checkGateway\" and \"reportIfIndex\"")
+	}
+
+	ifNames := make(map[string]bool)
+	vlanIds := make(map[int]bool)
+
+	for _, entry := range conf.Vlans {
+		if entry.IfName == "" {
+			return fmt.Errorf("\"ifName\" field is required for VLAN %d", entry.VlanId)
+		}
+
+		if err := validateIfName(entry.IfName); err != nil {
+			return ifNameError("ifName", entry.IfName, err)
+		}
+
+		if entry.VlanId < 0 || entry.VlanId > vlanlib.MaxVlanId {
+			return types.NewError(errVlanIdOutOfRange, fmt.Sprintf(
+				"invalid VLAN ID %d of %s (must be between 0 and %d inclusive)", entry.VlanId, entry.IfName,
+				vlanlib.MaxVlanId), "")
+		}
+
+		if ifNames[entry.IfName] {
+			return fmt.Errorf("duplicate VLAN ifName %s", entry.IfName)
+		}
+
+		if vlanIds[entry.VlanId] {
+			return fmt.Errorf("duplicate VLAN ID %d of %s", entry.VlanId, entry.IfName)
+		}
+
+		ifNames[entry.IfName] = true
+		vlanIds[entry.VlanId] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2023 Renesas Electronics Corporation.
+// Copyright (C) 2023 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	current "github.com/containernetworking/cni/pkg/types/100"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+var _ = Describe("Aos Vlan multi-VLAN", func() {
+	It("aos-vlan parses multi-VLAN configuration", func() {
+		conf, _, err := parseConfig([]byte(`{
+			"name": "mynet",
+			"cniVersion": "0.4.0",
+			"type": "aos-vlan",
+			"master": "br0",
+			"vlans": [{"vlanId": 100, "ifName": "aos-vlan0"}, {"vlanId": 101, "ifName": "aos-vlan1"}],
+			"multiVlanPolicy": "bestEffort"
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		confs := vlanConfs(conf)
+		Expect(confs).To(HaveLen(2))
+		Expect(confs[1].VlanId).To(Equal(101))
+		Expect(confs[1].IfName).To(Equal("aos-vlan1"))
+		Expect(confs[1].Master).To(Equal("br0"))
+		Expect(confs[1].Vlans).To(BeNil())
+	})
+
+	It("aos-vlan fails if none of the VLANs is added to prevResult interfaces", func() {
+		conf := &pluginConf{
+			Master: "aos-missing", MultiVlanPolicy: multiVlanPolicyBestEffort,
+			Vlans: []vlanEntry{{VlanId: 100, IfName: "aos-vlan0"}},
+		}
+		result := &current.Result{Interfaces: []*current.Interface{{Name: "eth0"}}}
+
+		Expect(addVlans(conf, "dummy", "eth0", result)).To(MatchError("none of the VLANs could be added"))
+		Expect(result.Interfaces).To(HaveLen(1))
+	})
+
+	It("aos-vlan single VLAN configuration is a shorthand", func() {
+		conf := &pluginConf{Master: "br0", VlanId: 100, IfName: "aos-vlan"}
+
+		Expect(vlanConfs(conf)).To(Equal([]*pluginConf{conf}))
+	})
+
+	It("aos-vlan rejects invalid multi-VLAN configuration", func() {
+		for _, conf := range []string{
+			`"vlans": [{"vlanId": 100, "ifName": "aos-vlan0"}], "multiVlanPolicy": "some"`,
+			`"vlans": [{"vlanId": 100}]`,
+			`"vlans": [{"vlanId": 4095, "ifName": "aos-vlan0"}]`,
+			`"vlans": [{"vlanId": 100, "ifName": "aos-vlan0"}], "inContainer": true`,
+			`"vlans": [{"vlanId": 100, "ifName": "aos-vlan0"}], "ipam": {"type": "host-local"}`,
+		} {
+			_, _, err := parseConfig([]byte(`{"name": "mynet", "type": "aos-vlan", "master": "br0", ` + conf + `}`))
+			Expect(err).To(HaveOccurred(), conf)
+		}
+	})
+
+	It("aos-vlan rejects duplicate VLANs", func() {
+		_, _, err := parseConfig([]byte(`{"name": "mynet", "type": "aos-vlan", "master": "br0",
+			"vlans": [{"vlanId": 100, "ifName": "aos-vlan0"}, {"vlanId": 101, "ifName": "aos-vlan0"}]}`))
+		Expect(err).To(MatchError(ContainSubstring("duplicate VLAN ifName aos-vlan0")))
+
+		_, _, err = parseConfig([]byte(`{"name": "mynet", "type": "aos-vlan", "master": "br0",
+			"vlans": [{"vlanId": 100, "ifName": "aos-vlan0"}, {"vlanId": 100, "ifName": "aos-vlan1"}]}`))
+		Expect(err).To(MatchError(ContainSubstring("duplicate VLAN ID 100 of aos-vlan1")))
+	})
+})